@@ -23,17 +23,35 @@ import (
 	"time"
 
 	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/common/hexutil"
 	"github.com/utchain/go-utchain/common/math"
 	"github.com/utchain/go-utchain/core/vm"
 )
 
+// JSONLogger is an EVM tracer emitting a line of JSON for every executed opcode and
+// a summary line once execution ends, in the format standardized by EIP-3155. This
+// allows diffing the execution against other EVM implementations.
 type JSONLogger struct {
 	encoder *json.Encoder
 	cfg     *vm.LogConfig
+	summary jsonSummary
 }
 
+// jsonSummary is the line closing an EIP-3155 trace. The execution results are
+// gathered by the tracer, the post state and verdict are filled in by the runner.
+type jsonSummary struct {
+	StateRoot common.Hash         `json:"stateRoot"`
+	Output    hexutil.Bytes       `json:"output"`
+	GasUsed   math.HexOrDecimal64 `json:"gasUsed"`
+	Pass      bool                `json:"pass"`
+	Time      time.Duration       `json:"time"`
+	Fork      string              `json:"fork,omitempty"`
+	Err       string              `json:"error,omitempty"`
+}
+
+// NewJSONLogger creates a new EIP-3155 tracer writing into the given writer.
 func NewJSONLogger(cfg *vm.LogConfig, writer io.Writer) *JSONLogger {
-	return &JSONLogger{json.NewEncoder(writer), cfg}
+	return &JSONLogger{encoder: json.NewEncoder(writer), cfg: cfg}
 }
 
 func (l *JSONLogger) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
@@ -43,18 +61,21 @@ func (l *JSONLogger) CaptureStart(from common.Address, to common.Address, create
 // CaptureState outputs state information on the logger.
 func (l *JSONLogger) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
 	log := vm.StructLog{
-		Pc:         pc,
-		Op:         op,
-		Gas:        gas,
-		GasCost:    cost,
-		MemorySize: memory.Len(),
-		Storage:    nil,
-		Depth:      depth,
-		Err:        err,
+		Pc:            pc,
+		Op:            op,
+		Gas:           gas,
+		GasCost:       cost,
+		MemorySize:    memory.Len(),
+		Storage:       nil,
+		Depth:         depth,
+		RefundCounter: env.StateDB.GetRefund(),
+		Err:           err,
 	}
 	if !l.cfg.DisableMemory {
 		log.Memory = memory.Data()
 	}
+	// The stack is mandatory in the trace format, only its contents may be left out
+	log.Stack = []*big.Int{}
 	if !l.cfg.DisableStack {
 		log.Stack = stack.Data()
 	}
@@ -66,16 +87,23 @@ func (l *JSONLogger) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cos
 	return nil
 }
 
-// CaptureEnd is triggered at end of execution.
+// CaptureEnd is triggered at end of execution, gathering the results for the
+// summary line.
 func (l *JSONLogger) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
-	type endLog struct {
-		Output  string              `json:"output"`
-		GasUsed math.HexOrDecimal64 `json:"gasUsed"`
-		Time    time.Duration       `json:"time"`
-		Err     string              `json:"error,omitempty"`
-	}
+	l.summary = jsonSummary{Output: output, GasUsed: math.HexOrDecimal64(gasUsed), Time: t}
 	if err != nil {
-		return l.encoder.Encode(endLog{common.Bytes2Hex(output), math.HexOrDecimal64(gasUsed), t, err.Error()})
+		l.summary.Err = err.Error()
 	}
-	return l.encoder.Encode(endLog{common.Bytes2Hex(output), math.HexOrDecimal64(gasUsed), t, ""})
+	return nil
+}
+
+// Summarize outputs the summary line of the last execution, completed with the
+// post state root, whether the execution passed and the fork it ran on (empty
+// if not applicable).
+func (l *JSONLogger) Summarize(root common.Hash, pass bool, fork string) error {
+	summary := l.summary
+	summary.StateRoot, summary.Pass, summary.Fork = root, pass, fork
+
+	l.summary = jsonSummary{}
+	return l.encoder.Encode(summary)
 }
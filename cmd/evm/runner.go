@@ -81,13 +81,15 @@ func runCmd(ctx *cli.Context) error {
 	var (
 		tracer      vm.Tracer
 		debugLogger *vm.StructLogger
+		machine     *JSONLogger
 		statedb     *state.StateDB
 		chainConfig *params.ChainConfig
 		sender      = common.StringToAddress("sender")
 		receiver    = common.StringToAddress("receiver")
 	)
 	if ctx.GlobalBool(MachineFlag.Name) {
-		tracer = NewJSONLogger(logconfig, os.Stdout)
+		machine = NewJSONLogger(logconfig, os.Stdout)
+		tracer = machine
 	} else if ctx.GlobalBool(DebugFlag.Name) {
 		debugLogger = vm.NewStructLogger(logconfig)
 		tracer = debugLogger
@@ -234,9 +236,12 @@ Gas used:           %d
 
 `, execTime, mem.HeapObjects, mem.Alloc, mem.TotalAlloc, mem.NumGC, initialGas-leftOverGas)
 	}
-	if tracer != nil {
-		tracer.CaptureEnd(ret, initialGas-leftOverGas, execTime, err)
-	} else {
+	// Machine readable traces are closed by the summary line, others are
+	// notified of the end of execution by the EVM itself
+	if machine != nil {
+		machine.Summarize(statedb.IntermediateRoot(true), err == nil, "")
+	}
+	if tracer == nil {
 		fmt.Printf("0x%x\n", ret)
 		if err != nil {
 			fmt.Printf(" error: %v\n", err)
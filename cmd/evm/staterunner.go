@@ -63,10 +63,12 @@ func stateTestCmd(ctx *cli.Context) error {
 	var (
 		tracer   vm.Tracer
 		debugger *vm.StructLogger
+		machine  *JSONLogger
 	)
 	switch {
 	case ctx.GlobalBool(MachineFlag.Name):
-		tracer = NewJSONLogger(config, os.Stderr)
+		machine = NewJSONLogger(config, os.Stderr)
+		tracer = machine
 
 	case ctx.GlobalBool(DebugFlag.Name):
 		debugger = vm.NewStructLogger(config)
@@ -103,9 +105,10 @@ func stateTestCmd(ctx *cli.Context) error {
 					result.State = &dump
 				}
 			}
-			// print state root for evmlab tracing (already committed above, so no need to delete objects again
-			if ctx.GlobalBool(MachineFlag.Name) && state != nil {
-				fmt.Fprintf(os.Stderr, "{\"stateRoot\": \"%x\"}\n", state.IntermediateRoot(false))
+			// Close the trace with the summary line for evmlab tracing (already
+			// committed above, so no need to delete objects again)
+			if machine != nil && state != nil {
+				machine.Summarize(state.IntermediateRoot(false), result.Pass, st.Fork)
 			}
 
 			results = append(results, *result)
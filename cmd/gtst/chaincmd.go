@@ -36,6 +36,7 @@ import (
 	"github.com/utchain/go-utchain/event"
 	"github.com/utchain/go-utchain/log"
 	"github.com/utchain/go-utchain/trie"
+	"github.com/olekukonko/tablewriter"
 	"github.com/syndtr/goleveldb/leveldb/util"
 	"gopkg.in/urfave/cli.v1"
 )
@@ -141,6 +142,36 @@ Remove blockchain and state databases`,
 The arguments are interpreted as block numbers or hashes.
 Use "utereum dump 0" to dump the genesis block.`,
 	}
+	dbCommand = cli.Command{
+		Name:     "db",
+		Usage:    "Low level database operations",
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+Offline tools to examine the contents of the chain database.`,
+		Subcommands: []cli.Command{
+			{
+				Action:    utils.MigrateFlags(inspectDB),
+				Name:      "inspect",
+				Usage:     "Inspect the storage size for each type of data in the database",
+				ArgsUsage: " ",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.CacheFlag,
+					utils.LightModeFlag,
+					utils.AncientFlag,
+					utils.AncientDirFlag,
+				},
+				Category: "BLOCKCHAIN COMMANDS",
+				Description: `
+gtst db inspect
+
+will iterate over the entire chain database and report the number of items
+and their total size (keys and values) for each category of data, such as
+headers, bodies, receipts, transaction lookups, trie nodes and preimages.
+The contents of the ancient store are reported too if it is enabled.`,
+			},
+		},
+	}
 )
 
 // initGenesis will initialise the given JSON format genesis file and writes it as
@@ -218,13 +249,14 @@ func importChain(ctx *cli.Context) error {
 	fmt.Printf("Import done in %v.\n\n", time.Since(start))
 
 	// Output pre-compaction stats mostly to see the import trashing
-	db := chainDb.(*tstdb.LDBDatabase)
-
-	stats, err := db.LDB().GetProperty("leveldb.stats")
-	if err != nil {
-		utils.Fatalf("Failed to read database stats: %v", err)
+	db, isLevelDB := chainDb.(*tstdb.LDBDatabase)
+	if isLevelDB {
+		stats, err := db.LDB().GetProperty("leveldb.stats")
+		if err != nil {
+			utils.Fatalf("Failed to read database stats: %v", err)
+		}
+		fmt.Println(stats)
 	}
-	fmt.Println(stats)
 	fmt.Printf("Trie cache misses:  %d\n", trie.CacheMisses())
 	fmt.Printf("Trie cache unloads: %d\n\n", trie.CacheUnloads())
 
@@ -237,19 +269,19 @@ func importChain(ctx *cli.Context) error {
 	fmt.Printf("Allocations:   %.3f million\n", float64(mem.Mallocs)/1000000)
 	fmt.Printf("GC pause:      %v\n\n", time.Duration(mem.PauseTotalNs))
 
-	if ctx.GlobalIsSet(utils.NoCompactionFlag.Name) {
+	if ctx.GlobalIsSet(utils.NoCompactionFlag.Name) || !isLevelDB {
 		return nil
 	}
 
 	// Compact the entire database to more accurately measure disk io and print the stats
 	start = time.Now()
 	fmt.Println("Compacting entire database...")
-	if err = db.LDB().CompactRange(util.Range{}); err != nil {
+	if err := db.LDB().CompactRange(util.Range{}); err != nil {
 		utils.Fatalf("Compaction failed: %v", err)
 	}
 	fmt.Printf("Compaction done in %v.\n\n", time.Since(start))
 
-	stats, err = db.LDB().GetProperty("leveldb.stats")
+	stats, err := db.LDB().GetProperty("leveldb.stats")
 	if err != nil {
 		utils.Fatalf("Failed to read database stats: %v", err)
 	}
@@ -328,12 +360,14 @@ func copyDb(ctx *cli.Context) error {
 	fmt.Printf("Database copy done in %v\n", time.Since(start))
 
 	// Compact the entire database to remove any sync overhead
-	start = time.Now()
-	fmt.Println("Compacting entire database...")
-	if err = chainDb.(*tstdb.LDBDatabase).LDB().CompactRange(util.Range{}); err != nil {
-		utils.Fatalf("Compaction failed: %v", err)
+	if db, ok := chainDb.(*tstdb.LDBDatabase); ok {
+		start = time.Now()
+		fmt.Println("Compacting entire database...")
+		if err = db.LDB().CompactRange(util.Range{}); err != nil {
+			utils.Fatalf("Compaction failed: %v", err)
+		}
+		fmt.Printf("Compaction done in %v.\n\n", time.Since(start))
 	}
-	fmt.Printf("Compaction done in %v.\n\n", time.Since(start))
 
 	return nil
 }
@@ -393,6 +427,34 @@ func dump(ctx *cli.Context) error {
 	return nil
 }
 
+// inspectDB iterates over the chain database and prints the number and size
+// of the stored items per data category.
+func inspectDB(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	chainDb := utils.MakeChainDatabase(ctx, stack)
+	defer chainDb.Close()
+
+	stats, err := core.InspectDatabase(chainDb)
+	if err != nil {
+		utils.Fatalf("Failed to inspect database: %v", err)
+	}
+	var (
+		count uint64
+		total common.StorageSize
+	)
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader([]string{"Category", "Items", "Size"})
+	for _, stat := range stats {
+		table.Append([]string{stat.Category, strconv.FormatUint(stat.Count, 10), stat.Size.String()})
+		count += stat.Count
+		total += stat.Size
+	}
+	table.SetFooter([]string{"Total", strconv.FormatUint(count, 10), total.String()})
+	table.Render()
+	return nil
+}
+
 // hashish returns true for strings that look like hashes.
 func hashish(x string) bool {
 	_, err := strconv.Atoi(x)
@@ -75,6 +75,8 @@ var (
 		utils.TxPoolNoLocalsFlag,
 		utils.TxPoolJournalFlag,
 		utils.TxPoolRejournalFlag,
+		utils.TxPoolSnapshotFlag,
+		utils.TxPoolSnapshotCapFlag,
 		utils.TxPoolPriceLimitFlag,
 		utils.TxPoolPriceBumpFlag,
 		utils.TxPoolAccountSlotsFlag,
@@ -86,6 +88,12 @@ var (
 		utils.LightModeFlag,
 		utils.SyncModeFlag,
 		utils.GCModeFlag,
+		utils.SnapshotFlag,
+		utils.TransferIndexFlag,
+		utils.DBEngineFlag,
+		utils.AncientFlag,
+		utils.AncientDirFlag,
+		utils.AncientDepthFlag,
 		utils.LightServFlag,
 		utils.LightPeersFlag,
 		utils.LightKDFFlag,
@@ -122,6 +130,8 @@ var (
 		utils.GpoBlocksFlag,
 		utils.GpoPercentileFlag,
 		utils.ExtraDataFlag,
+		utils.MinerOrderingFlag,
+		utils.MinerPrioritySendersFlag,
 		configFileFlag,
 	}
 
@@ -159,6 +169,9 @@ func init() {
 		copydbCommand,
 		removedbCommand,
 		dumpCommand,
+		dbCommand,
+		// See snapshotcmd.go:
+		snapshotCommand,
 		// See monitorcmd.go:
 		monitorCommand,
 		// See accountcmd.go:
@@ -0,0 +1,95 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of go-utchain.
+//
+// go-utchain is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-utchain is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-utchain. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"time"
+
+	"github.com/utchain/go-utchain/cmd/utils"
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/state/pruner"
+	"github.com/utchain/go-utchain/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	bloomFilterSizeFlag = cli.Uint64Flag{
+		Name:  "bloomfilter.size",
+		Usage: "Megabytes of memory allocated to the bloom filter tracking live state",
+		Value: 2048,
+	}
+	pruneRecentFlag = cli.Uint64Flag{
+		Name:  "prune.recent",
+		Usage: "Number of blocks before the head whose state to retain",
+		Value: 127,
+	}
+
+	snapshotCommand = cli.Command{
+		Name:     "snapshot",
+		Usage:    "Manage the state of the chain database",
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+Offline tools to operate on the state stored in the chain database.`,
+		Subcommands: []cli.Command{
+			{
+				Name:      "prune-state",
+				Usage:     "Delete stale state trie nodes from the database",
+				ArgsUsage: " ",
+				Action:    utils.MigrateFlags(pruneState),
+				Category:  "BLOCKCHAIN COMMANDS",
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.CacheFlag,
+					utils.CacheDatabaseFlag,
+					utils.AncientFlag,
+					utils.AncientDirFlag,
+					bloomFilterSizeFlag,
+					pruneRecentFlag,
+				},
+				Description: `
+gtst snapshot prune-state
+
+will iterate the state of the head block and of the requested number of its
+ancestors, and delete every trie node and contract code not reachable from
+them. The state of older blocks becomes unavailable afterwards.
+
+The node must be stopped while pruning. The bloom filter tracking the live
+state may produce false positives, which only result in some stale data not
+being deleted; the higher its size, the more thorough the pruning.`,
+			},
+		},
+	}
+)
+
+// pruneState deletes all the state not reachable from the recent blocks from an
+// existing chain database.
+func pruneState(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	chaindb := utils.MakeChainDatabase(ctx, stack)
+	defer chaindb.Close()
+
+	roots, err := pruner.RecentRoots(chaindb, ctx.GlobalUint64(pruneRecentFlag.Name))
+	if err != nil {
+		utils.Fatalf("Failed to find state to retain: %v", err)
+	}
+	start := time.Now()
+	if err := pruner.NewPruner(chaindb, ctx.GlobalUint64(bloomFilterSizeFlag.Name)).Prune(roots); err != nil {
+		utils.Fatalf("Failed to prune state: %v", err)
+	}
+	log.Info("State pruning successful", "roots", len(roots), "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
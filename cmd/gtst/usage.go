@@ -76,6 +76,12 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.RinkebyFlag,
 			utils.SyncModeFlag,
 			utils.GCModeFlag,
+			utils.SnapshotFlag,
+			utils.TransferIndexFlag,
+			utils.DBEngineFlag,
+			utils.AncientFlag,
+			utils.AncientDirFlag,
+			utils.AncientDepthFlag,
 			utils.TstStatsURLFlag,
 			utils.IdentityFlag,
 			utils.LightServFlag,
@@ -116,6 +122,8 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.TxPoolNoLocalsFlag,
 			utils.TxPoolJournalFlag,
 			utils.TxPoolRejournalFlag,
+			utils.TxPoolSnapshotFlag,
+			utils.TxPoolSnapshotCapFlag,
 			utils.TxPoolPriceLimitFlag,
 			utils.TxPoolPriceBumpFlag,
 			utils.TxPoolAccountSlotsFlag,
@@ -188,6 +196,8 @@ var AppHelpFlagGroups = []flagGroup{
 			utils.TargetGasLimitFlag,
 			utils.GasPriceFlag,
 			utils.ExtraDataFlag,
+			utils.MinerOrderingFlag,
+			utils.MinerPrioritySendersFlag,
 		},
 	},
 	{
@@ -167,7 +167,7 @@ var (
 	defaultSyncMode = tst.DefaultConfig.SyncMode
 	SyncModeFlag    = TextMarshalerFlag{
 		Name:  "syncmode",
-		Usage: `Blockchain sync mode ("fast", "full", or "light")`,
+		Usage: `Blockchain sync mode ("fast", "full", "light" or "snap")`,
 		Value: &defaultSyncMode,
 	}
 	GCModeFlag = cli.StringFlag{
@@ -175,6 +175,32 @@ var (
 		Usage: `Blockchain garbage collection mode ("full", "archive")`,
 		Value: "full",
 	}
+	SnapshotFlag = cli.BoolFlag{
+		Name:  "snapshot",
+		Usage: "Maintain a flat snapshot of the state for faster state access",
+	}
+	TransferIndexFlag = cli.BoolFlag{
+		Name:  "transferindex",
+		Usage: "Index the value transfers made by contracts for lookups by address (keeps an extra full copy of the state on disk)",
+	}
+	DBEngineFlag = cli.StringFlag{
+		Name:  "db.engine",
+		Usage: `Backing database engine of new databases ("leveldb" or "bolt"), existing ones keep theirs`,
+		Value: "leveldb",
+	}
+	AncientFlag = cli.BoolFlag{
+		Name:  "ancient",
+		Usage: "Move finalized chain data into an append-only ancient store",
+	}
+	AncientDirFlag = DirectoryFlag{
+		Name:  "datadir.ancient",
+		Usage: "Data directory for the ancient store (default = inside chaindata)",
+	}
+	AncientDepthFlag = cli.Uint64Flag{
+		Name:  "ancient.depth",
+		Usage: fmt.Sprintf("Number of recent blocks to keep out of the ancient store (min %d)", core.MinAncientDepth),
+		Value: tst.DefaultConfig.AncientDepth,
+	}
 	LightServFlag = cli.IntFlag{
 		Name:  "lightserv",
 		Usage: "Maximum percentage of time allowed for serving LES requests (0-90)",
@@ -259,6 +285,15 @@ var (
 		Usage: "Time interval to regenerate the local transaction journal",
 		Value: core.DefaultTxPoolConfig.Rejournal,
 	}
+	TxPoolSnapshotFlag = cli.StringFlag{
+		Name:  "txpool.snapshot",
+		Usage: "Disk snapshot of remote transactions to survive node restarts (default = disabled)",
+	}
+	TxPoolSnapshotCapFlag = cli.Uint64Flag{
+		Name:  "txpool.snapshotcap",
+		Usage: "Maximum number of remote transactions to store in the snapshot",
+		Value: core.DefaultTxPoolConfig.SnapshotCap,
+	}
 	TxPoolPriceLimitFlag = cli.Uint64Flag{
 		Name:  "txpool.pricelimit",
 		Usage: "Minimum gas price limit to enforce for acceptance into the pool",
@@ -344,6 +379,15 @@ var (
 		Name:  "extradata",
 		Usage: "Block extra data set by the miner (default = client version)",
 	}
+	MinerOrderingFlag = cli.StringFlag{
+		Name:  "miner.ordering",
+		Usage: "Order of the transactions in mined blocks (price, fifo, priority)",
+		Value: "price",
+	}
+	MinerPrioritySendersFlag = cli.StringFlag{
+		Name:  "miner.prioritysenders",
+		Usage: "Comma separated list of senders whose transactions are mined first (priority ordering)",
+	}
 	// Account settings
 	UnlockedAccountFlag = cli.StringFlag{
 		Name:  "unlock",
@@ -889,6 +933,14 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 		cfg.DataDir = filepath.Join(node.DefaultDataDir(), "rinkeby")
 	}
 
+	if ctx.GlobalIsSet(DBEngineFlag.Name) {
+		engine := ctx.GlobalString(DBEngineFlag.Name)
+		if engine != "leveldb" && engine != "bolt" {
+			Fatalf("--%s must be either 'leveldb' or 'bolt'", DBEngineFlag.Name)
+		}
+		cfg.DBEngine = engine
+	}
+
 	if ctx.GlobalIsSet(KeyStoreDirFlag.Name) {
 		cfg.KeyStoreDir = ctx.GlobalString(KeyStoreDirFlag.Name)
 	}
@@ -919,6 +971,12 @@ func setTxPool(ctx *cli.Context, cfg *core.TxPoolConfig) {
 	if ctx.GlobalIsSet(TxPoolRejournalFlag.Name) {
 		cfg.Rejournal = ctx.GlobalDuration(TxPoolRejournalFlag.Name)
 	}
+	if ctx.GlobalIsSet(TxPoolSnapshotFlag.Name) {
+		cfg.Snapshot = ctx.GlobalString(TxPoolSnapshotFlag.Name)
+	}
+	if ctx.GlobalIsSet(TxPoolSnapshotCapFlag.Name) {
+		cfg.SnapshotCap = ctx.GlobalUint64(TxPoolSnapshotCapFlag.Name)
+	}
 	if ctx.GlobalIsSet(TxPoolPriceLimitFlag.Name) {
 		cfg.PriceLimit = ctx.GlobalUint64(TxPoolPriceLimitFlag.Name)
 	}
@@ -1052,6 +1110,19 @@ func SetTstConfig(ctx *cli.Context, stack *node.Node, cfg *tst.Config) {
 		Fatalf("--%s must be either 'full' or 'archive'", GCModeFlag.Name)
 	}
 	cfg.NoPruning = ctx.GlobalString(GCModeFlag.Name) == "archive"
+	cfg.Snapshot = ctx.GlobalBool(SnapshotFlag.Name)
+	cfg.TransferIndex = ctx.GlobalBool(TransferIndexFlag.Name)
+
+	cfg.Ancient = ctx.GlobalBool(AncientFlag.Name)
+	if ctx.GlobalIsSet(AncientDirFlag.Name) {
+		cfg.AncientDir = ctx.GlobalString(AncientDirFlag.Name)
+	}
+	if ctx.GlobalIsSet(AncientDepthFlag.Name) {
+		cfg.AncientDepth = ctx.GlobalUint64(AncientDepthFlag.Name)
+		if cfg.AncientDepth < core.MinAncientDepth {
+			Fatalf("--%s must be at least %d", AncientDepthFlag.Name, core.MinAncientDepth)
+		}
+	}
 
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheGCFlag.Name) {
 		cfg.TrieCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheGCFlag.Name) / 100
@@ -1068,6 +1139,17 @@ func SetTstConfig(ctx *cli.Context, stack *node.Node, cfg *tst.Config) {
 	if ctx.GlobalIsSet(GasPriceFlag.Name) {
 		cfg.GasPrice = GlobalBig(ctx, GasPriceFlag.Name)
 	}
+	if ctx.GlobalIsSet(MinerOrderingFlag.Name) {
+		cfg.MinerOrdering = ctx.GlobalString(MinerOrderingFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerPrioritySendersFlag.Name) {
+		for _, sender := range strings.Split(ctx.GlobalString(MinerPrioritySendersFlag.Name), ",") {
+			if sender = strings.TrimSpace(sender); !common.IsHexAddress(sender) {
+				Fatalf("Invalid priority sender address: %q", sender)
+			}
+			cfg.MinerPrioritySenders = append(cfg.MinerPrioritySenders, common.HexToAddress(sender))
+		}
+	}
 	if ctx.GlobalIsSet(VMEnableDebugFlag.Name) {
 		// TODO(fjl): force-enable this in --dev mode
 		cfg.EnablePreimageRecording = ctx.GlobalBool(VMEnableDebugFlag.Name)
@@ -1194,7 +1276,15 @@ func MakeChainDatabase(ctx *cli.Context, stack *node.Node) tstdb.Database {
 	if ctx.GlobalBool(LightModeFlag.Name) {
 		name = "lightchaindata"
 	}
-	chainDb, err := stack.OpenDatabase(name, cache, handles)
+	var (
+		chainDb tstdb.Database
+		err     error
+	)
+	if ctx.GlobalBool(AncientFlag.Name) {
+		chainDb, err = stack.OpenDatabaseWithFreezer(name, cache, handles, ctx.GlobalString(AncientDirFlag.Name))
+	} else {
+		chainDb, err = stack.OpenDatabase(name, cache, handles)
+	}
 	if err != nil {
 		Fatalf("Could not open database: %v", err)
 	}
@@ -1246,6 +1336,10 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 		Disabled:      ctx.GlobalString(GCModeFlag.Name) == "archive",
 		TrieNodeLimit: tst.DefaultConfig.TrieCache,
 		TrieTimeLimit: tst.DefaultConfig.TrieTimeout,
+		Snapshot:      ctx.GlobalBool(SnapshotFlag.Name),
+	}
+	if ctx.GlobalBool(AncientFlag.Name) {
+		cache.AncientDepth = ctx.GlobalUint64(AncientDepthFlag.Name)
 	}
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheGCFlag.Name) {
 		cache.TrieNodeLimit = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheGCFlag.Name) / 100
@@ -0,0 +1,99 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package misc
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/params"
+)
+
+var (
+	// errMissingBaseFee is returned if a header after the EIP1559 fork does not
+	// contain a base fee.
+	errMissingBaseFee = errors.New("header is missing baseFee")
+
+	// errUnexpectedBaseFee is returned if a header before the EIP1559 fork
+	// contains a base fee.
+	errUnexpectedBaseFee = errors.New("header has baseFee before the EIP1559 fork")
+)
+
+// VerifyEIP1559Header verifies that the base fee of the header is present
+// exactly from the EIP1559 fork on and that it matches the value derived from
+// the parent header. From the fork on it also verifies the gas limit bounds.
+func VerifyEIP1559Header(config *params.ChainConfig, parent, header *types.Header) error {
+	if !config.IsEIP1559(header.Number) {
+		if header.BaseFee != nil {
+			return errUnexpectedBaseFee
+		}
+		return nil
+	}
+	if err := VerifyGaslimit(parent.GasLimit, header.GasLimit); err != nil {
+		return err
+	}
+	if header.BaseFee == nil {
+		return errMissingBaseFee
+	}
+	if expected := CalcBaseFee(config, parent); header.BaseFee.Cmp(expected) != 0 {
+		return fmt.Errorf("invalid baseFee: have %v, want %v, parentBaseFee %v, parentGasUsed %d", header.BaseFee, expected, parent.BaseFee, parent.GasUsed)
+	}
+	return nil
+}
+
+// CalcBaseFee calculates the base fee of the header following the given parent.
+// The fee rises if the parent used more than its gas target, half of the gas
+// limit, and drops if it used less, by at most 1/8th per block.
+func CalcBaseFee(config *params.ChainConfig, parent *types.Header) *big.Int {
+	// The fork block starts out with the initial base fee
+	if !config.IsEIP1559(parent.Number) || parent.BaseFee == nil {
+		return new(big.Int).SetUint64(params.InitialBaseFee)
+	}
+	target := parent.GasLimit / params.ElasticityMultiplier
+	if parent.GasUsed == target || target == 0 {
+		return new(big.Int).Set(parent.BaseFee)
+	}
+	var (
+		targetBig      = new(big.Int).SetUint64(target)
+		denominatorBig = new(big.Int).SetUint64(params.BaseFeeChangeDenominator)
+	)
+	if parent.GasUsed > target {
+		// Block was fuller than the target, increase the base fee by at least 1
+		delta := new(big.Int).SetUint64(parent.GasUsed - target)
+		delta.Mul(delta, parent.BaseFee)
+		delta.Div(delta, targetBig)
+		delta.Div(delta, denominatorBig)
+		if delta.Cmp(common.Big1) < 0 {
+			delta.Set(common.Big1)
+		}
+		return delta.Add(delta, parent.BaseFee)
+	}
+	// Block was emptier than the target, decrease the base fee down to zero
+	delta := new(big.Int).SetUint64(target - parent.GasUsed)
+	delta.Mul(delta, parent.BaseFee)
+	delta.Div(delta, targetBig)
+	delta.Div(delta, denominatorBig)
+
+	fee := new(big.Int).Sub(parent.BaseFee, delta)
+	if fee.Sign() < 0 {
+		fee.SetUint64(0)
+	}
+	return fee
+}
@@ -0,0 +1,116 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package misc
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/params"
+)
+
+// eip1559Config returns a chain config with the EIP1559 fork at block 5.
+func eip1559Config() *params.ChainConfig {
+	config := *params.TestChainConfig
+	config.BerlinBlock = big.NewInt(0)
+	config.EIP1559Block = big.NewInt(5)
+	return &config
+}
+
+// Tests that the base fee follows the gas used by the parent block.
+func TestCalcBaseFee(t *testing.T) {
+	tests := []struct {
+		number  int64
+		baseFee int64 // Parent base fee, 0 for none
+		used    uint64
+		limit   uint64
+		want    int64
+	}{
+		{4, 0, 10000000, 20000000, params.InitialBaseFee},               // fork block
+		{5, 1000000000, 10000000, 20000000, 1000000000},                 // at the target
+		{5, 1000000000, 15000000, 20000000, 1062500000},                 // above the target
+		{5, 1000000000, 20000000, 20000000, 1125000000},                 // full block
+		{5, 1000000000, 5000000, 20000000, 937500000},                   // below the target
+		{5, 1000000000, 0, 20000000, 875000000},                         // empty block
+		{5, 7, 10000001, 20000000, 8},                                   // minimum delta of 1
+		{5, 7, 9999999, 20000000, 7},                                    // rounded down decrease
+		{5, 1000000000, 0, params.ElasticityMultiplier - 1, 1000000000}, // no target
+	}
+	config := eip1559Config()
+	for i, tt := range tests {
+		parent := &types.Header{
+			Number:   big.NewInt(tt.number),
+			GasUsed:  tt.used,
+			GasLimit: tt.limit,
+		}
+		if tt.baseFee != 0 {
+			parent.BaseFee = big.NewInt(tt.baseFee)
+		}
+		if have := CalcBaseFee(config, parent); have.Cmp(big.NewInt(tt.want)) != 0 {
+			t.Errorf("test %d: base fee mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
+
+// Tests that headers are checked for the presence and value of the base fee and
+// that the gas limit bounds are enforced from the fork on.
+func TestVerifyEIP1559Header(t *testing.T) {
+	var (
+		config     = eip1559Config()
+		preFork    = &types.Header{Number: big.NewInt(3), GasLimit: 20000000, GasUsed: 10000000}
+		forkParent = &types.Header{Number: big.NewInt(4), GasLimit: 20000000, GasUsed: 10000000}
+		postFork   = &types.Header{Number: big.NewInt(5), GasLimit: 20000000, GasUsed: 15000000, BaseFee: big.NewInt(1000000000)}
+		bound      = postFork.GasLimit / params.GasLimitBoundDivisor
+	)
+	tests := []struct {
+		parent   *types.Header
+		number   int64
+		limit    uint64
+		baseFee  *big.Int
+		err      error
+		anyError bool
+	}{
+		{preFork, 4, 20000000, nil, nil, false},
+		{preFork, 4, 20000000, big.NewInt(params.InitialBaseFee), errUnexpectedBaseFee, false},
+		{preFork, 4, 40000000, nil, nil, false}, // gas limit checked by the engine before the fork
+		{forkParent, 5, 20000000, big.NewInt(params.InitialBaseFee), nil, false},
+		{forkParent, 5, 20000000, nil, errMissingBaseFee, false},
+		{forkParent, 5, 20000000, big.NewInt(params.InitialBaseFee + 1), nil, true},
+		{postFork, 6, 20000000, big.NewInt(1062500000), nil, false},
+		{postFork, 6, 20000000, big.NewInt(1000000000), nil, true},
+		{postFork, 6, 20000000, nil, errMissingBaseFee, false},
+		{postFork, 6, postFork.GasLimit + bound - 1, big.NewInt(1062500000), nil, false},
+		{postFork, 6, postFork.GasLimit + bound, big.NewInt(1062500000), nil, true},
+		{postFork, 6, postFork.GasLimit - bound, big.NewInt(1062500000), nil, true},
+		{&types.Header{Number: big.NewInt(5), GasLimit: params.MinGasLimit, BaseFee: big.NewInt(1000000000)}, 6, params.MinGasLimit - 1, big.NewInt(875000000), nil, true},
+	}
+	for i, tt := range tests {
+		header := &types.Header{
+			Number:   big.NewInt(tt.number),
+			GasLimit: tt.limit,
+			BaseFee:  tt.baseFee,
+		}
+		err := VerifyEIP1559Header(config, tt.parent, header)
+		switch {
+		case tt.anyError && err == nil:
+			t.Errorf("test %d: invalid header accepted", i)
+		case !tt.anyError && err != tt.err:
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package misc
+
+import (
+	"fmt"
+
+	"github.com/utchain/go-utchain/params"
+)
+
+// VerifyGaslimit verifies that the header gas limit stays within the allowed
+// bounds of the parent gas limit and above the minimum.
+func VerifyGaslimit(parentGasLimit, headerGasLimit uint64) error {
+	diff := int64(parentGasLimit) - int64(headerGasLimit)
+	if diff < 0 {
+		diff *= -1
+	}
+	limit := parentGasLimit / params.GasLimitBoundDivisor
+
+	if uint64(diff) >= limit || headerGasLimit < params.MinGasLimit {
+		return fmt.Errorf("invalid gas limit: have %d, want %d += %d", headerGasLimit, parentGasLimit, limit)
+	}
+	return nil
+}
@@ -31,6 +31,7 @@ import (
 	"github.com/utchain/go-utchain/common/mclock"
 	"github.com/utchain/go-utchain/consensus"
 	"github.com/utchain/go-utchain/core/state"
+	"github.com/utchain/go-utchain/core/state/snapshot"
 	"github.com/utchain/go-utchain/core/types"
 	"github.com/utchain/go-utchain/core/vm"
 	"github.com/utchain/go-utchain/crypto"
@@ -58,9 +59,16 @@ const (
 	maxTimeFutureBlocks = 30
 	badBlockLimit       = 10
 	triesInMemory       = 128
+	snapshotLayers      = 127   // Recent states kept as diff layers on top of the persistent snapshot
+	freezerBatchLimit   = 1024  // Blocks to freeze while holding the chain lock
+	freezerRoundLimit   = 30000 // Blocks to freeze in one round, between the batches
 
 	// BlockChainVersion ensures that an incompatible database forces a resync from scratch.
 	BlockChainVersion = 3
+
+	// MinAncientDepth is the minimum number of recent blocks to keep out of the
+	// ancient store, ensuring reorgs never reach into the frozen chain segment.
+	MinAncientDepth = 10000
 )
 
 // CacheConfig contains the configuration values for the trie caching/pruning
@@ -69,6 +77,8 @@ type CacheConfig struct {
 	Disabled      bool          // Whtster to disable trie write caching (archive node)
 	TrieNodeLimit int           // Memory limit (MB) at which to flush the current in-memory trie to disk
 	TrieTimeLimit time.Duration // Time limit after which to flush the current in-memory trie to disk
+	Snapshot      bool          // Maintain a flat snapshot of the state for faster access
+	AncientDepth  uint64        // Number of recent blocks to keep out of the ancient store (0 = never freeze)
 }
 
 // BlockChain represents the canonical chain given a database with a genesis
@@ -111,6 +121,7 @@ type BlockChain struct {
 	currentFastBlock atomic.Value // Current head of the fast-sync chain (may be above the block chain!)
 
 	stateCache   state.Database // State database to reuse between imports (contains state cache)
+	snaps        *snapshot.Tree // Flat snapshot of the recent states, nil if disabled
 	bodyCache    *lru.Cache     // Cache for the most recent block bodies
 	bodyRLPCache *lru.Cache     // Cache for the most recent block bodies in RLP encoded format
 	blockCache   *lru.Cache     // Cache for the most recent entire blocks
@@ -140,6 +151,10 @@ func NewBlockChain(db tstdb.Database, cacheConfig *CacheConfig, chainConfig *par
 			TrieTimeLimit: 5 * time.Minute,
 		}
 	}
+	if cacheConfig.AncientDepth != 0 && cacheConfig.AncientDepth < MinAncientDepth {
+		log.Warn("Ancient depth too low, raising", "provided", cacheConfig.AncientDepth, "updated", MinAncientDepth)
+		cacheConfig.AncientDepth = MinAncientDepth
+	}
 	bodyCache, _ := lru.New(bodyCacheLimit)
 	bodyRLPCache, _ := lru.New(bodyCacheLimit)
 	blockCache, _ := lru.New(blockCacheLimit)
@@ -189,6 +204,10 @@ func NewBlockChain(db tstdb.Database, cacheConfig *CacheConfig, chainConfig *par
 			}
 		}
 	}
+	// Load any existing snapshot, regenerating it if loading failed
+	if bc.cacheConfig.Snapshot {
+		bc.snaps = snapshot.New(db, bc.stateCache.TrieDB(), bc.CurrentBlock().Root())
+	}
 	// Take ownership of this particular state
 	go bc.update()
 	return bc, nil
@@ -274,6 +293,15 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	bc.hc.SetHead(head, delFn)
 	currentHeader := bc.hc.CurrentHeader()
 
+	// Drop any frozen blocks above the new head from the ancient store
+	if store, ok := bc.db.(tstdb.AncientStore); ok {
+		if frozen, err := store.Ancients(); err == nil && frozen > head+1 {
+			if err := store.TruncateAncients(head + 1); err != nil {
+				log.Crit("Failed to truncate ancient store", "err", err)
+			}
+		}
+	}
+
 	// Clear out any stale content from the caches
 	bc.bodyCache.Purge()
 	bc.bodyRLPCache.Purge()
@@ -309,7 +337,11 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	if err := WriteHeadFastBlockHash(bc.db, currentFastBlock.Hash()); err != nil {
 		log.Crit("Failed to reset head fast block", "err", err)
 	}
-	return bc.loadLastState()
+	if err := bc.loadLastState(); err != nil {
+		return err
+	}
+	bc.ensureSnapshot(bc.CurrentBlock().Root())
+	return nil
 }
 
 // FastSyncCommitHead sets the current head block to the one defined by the hash
@@ -326,6 +358,7 @@ func (bc *BlockChain) FastSyncCommitHead(hash common.Hash) error {
 	// If all checks out, manually set the head block
 	bc.mu.Lock()
 	bc.currentBlock.Store(block)
+	bc.ensureSnapshot(block.Root())
 	bc.mu.Unlock()
 
 	log.Info("Committed new head block", "number", block.Number(), "hash", hash)
@@ -384,7 +417,19 @@ func (bc *BlockChain) State() (*state.StateDB, error) {
 
 // StateAt returns a new mutable state based on a particular point in time.
 func (bc *BlockChain) StateAt(root common.Hash) (*state.StateDB, error) {
-	return state.New(root, bc.stateCache)
+	return state.NewWithSnapshot(root, bc.stateCache, bc.snaps)
+}
+
+// ensureSnapshot disables the state snapshot if it doesn't cover the given (head)
+// state root anymore, e.g. after a rewind or a reorg deeper than the diff layers.
+// Instead of stalling the chain on regenerating it, the state is served from the
+// tries until the next startup regenerates the snapshot in the background.
+func (bc *BlockChain) ensureSnapshot(root common.Hash) {
+	if bc.snaps == nil || bc.snaps.Disabled() || bc.snaps.Snapshot(root) != nil {
+		return
+	}
+	log.Warn("State snapshot lost track of the head, disabling until restart", "root", root)
+	bc.snaps.Disable()
 }
 
 // Reset purges the entire blockchain, restoring it to its genesis state.
@@ -494,6 +539,8 @@ func (bc *BlockChain) insert(block *types.Block) {
 		}
 		bc.currentFastBlock.Store(block)
 	}
+	// Make sure the snapshot keeps tracking the head state
+	bc.ensureSnapshot(block.Root())
 }
 
 // Genesis retrieves the chain's genesis block.
@@ -539,8 +586,10 @@ func (bc *BlockChain) HasBlock(hash common.Hash, number uint64) bool {
 	if bc.blockCache.Contains(hash) {
 		return true
 	}
-	ok, _ := bc.db.Has(blockBodyKey(hash, number))
-	return ok
+	if ok, _ := bc.db.Has(blockBodyKey(hash, number)); ok {
+		return true
+	}
+	return hasAncient(bc.db, hash, number)
 }
 
 // HasState checks if state trie is fully present in the database or not.
@@ -623,6 +672,11 @@ func (bc *BlockChain) GetUnclesInChain(block *types.Block, length int) []*types.
 	return uncles
 }
 
+// StateCache returns the caching database underpinning the blockchain instance.
+func (bc *BlockChain) StateCache() state.Database {
+	return bc.stateCache
+}
+
 // TrieNode retrieves a blob of data associated with a trie node (or code hash)
 // either from ephemeral in-memory cache, or from persistent storage.
 func (bc *BlockChain) TrieNode(hash common.Hash) ([]byte, error) {
@@ -642,6 +696,16 @@ func (bc *BlockChain) Stop() {
 
 	bc.wg.Wait()
 
+	// Flatten the entire snapshot tree into the disk layer, so the snapshot of the
+	// head state can be reused after a restart.
+	if bc.snaps != nil {
+		if !bc.snaps.Disabled() {
+			if err := bc.snaps.Cap(bc.CurrentBlock().Root(), 0); err != nil {
+				log.Error("Failed to journal state snapshot", "err", err)
+			}
+		}
+		bc.snaps.Release()
+	}
 	// Ensure the state of a recent block is also stored to disk before exiting.
 	// We're writing three different states to catch different restart scenarios:
 	//  - HEAD:     So we don't need to reprocess any blocks in the general case
@@ -895,6 +959,10 @@ func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.
 	if err != nil {
 		return NonStatTy, err
 	}
+	// Track the state of side chains in the snapshot too, they may become canonical
+	if err := state.UpdateSnapshot(root); err != nil {
+		log.Warn("Failed to update snapshot tree", "root", root, "err", err)
+	}
 	triedb := bc.stateCache.TrieDB()
 
 	// If we're running an archive node, always flush
@@ -985,6 +1053,14 @@ func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.
 	// Set new head.
 	if status == CanonStatTy {
 		bc.insert(block)
+
+		// Flatten the snapshot layers too old to be reorged away into the disk
+		// layer, from the canonical head only so side chains never reach it
+		if bc.snaps != nil && bc.snaps.Snapshot(root) != nil {
+			if err := bc.snaps.Cap(root, snapshotLayers); err != nil {
+				log.Warn("Failed to cap snapshot tree", "root", root, "layers", snapshotLayers, "err", err)
+			}
+		}
 	}
 	bc.futureBlocks.Remove(block.Hash())
 	return status, nil
@@ -1133,7 +1209,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []interface{}, []*ty
 		} else {
 			parent = chain[i-1]
 		}
-		state, err := state.New(parent.Root(), bc.stateCache)
+		state, err := state.NewWithSnapshot(parent.Root(), bc.stateCache, bc.snaps)
 		if err != nil {
 			return i, events, coalescedLogs, err
 		}
@@ -1376,12 +1452,65 @@ func (bc *BlockChain) update() {
 		select {
 		case <-futureTimer.C:
 			bc.procFutureBlocks()
+			bc.freeze()
 		case <-bc.quit:
 			return
 		}
 	}
 }
 
+// freeze moves the finalized part of the canonical chain out of the key-value
+// store into the ancient store, if one is attached and freezing is enabled.
+func (bc *BlockChain) freeze() {
+	if bc.cacheConfig.AncientDepth == 0 {
+		return
+	}
+	store, ok := bc.db.(tstdb.AncientStore)
+	if !ok {
+		return
+	}
+	if _, err := store.Ancients(); err != nil {
+		return
+	}
+	var (
+		start = time.Now()
+		total uint64
+	)
+	for total < freezerRoundLimit {
+		// Bail out if the chain is being stopped
+		select {
+		case <-bc.quit:
+			return
+		default:
+		}
+		frozen, err := bc.freezeBatch()
+		total += frozen
+		if err != nil {
+			log.Error("Failed to freeze ancient blocks", "err", err)
+			break
+		}
+		if frozen < freezerBatchLimit {
+			break
+		}
+	}
+	if total > 0 {
+		log.Info("Moved blocks into ancient store", "count", total, "elapsed", common.PrettyDuration(time.Since(start)))
+	}
+}
+
+// freezeBatch moves a limited batch of finalized blocks into the ancient store,
+// holding the chain locks only for the duration of the batch to not block block
+// imports for long.
+func (bc *BlockChain) freezeBatch() (uint64, error) {
+	// Make sure the chain isn't rewound or reorged while moving blocks
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return FreezeAncients(bc.db, bc.CurrentBlock().NumberU64(), bc.cacheConfig.AncientDepth, freezerBatchLimit)
+}
+
 // BadBlockArgs represents the entries in the list returned when bad blocks are queried.
 type BadBlockArgs struct {
 	Hash   common.Hash   `json:"hash"`
@@ -18,8 +18,11 @@ package core
 
 import (
 	"fmt"
+	"io/ioutil"
 	"math/big"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -1338,3 +1341,277 @@ func TestLargeReorgTrieGC(t *testing.T) {
 		}
 	}
 }
+
+// Tests that a chain maintaining a state snapshot serves the same state as the
+// tries, both while importing and after a restart.
+func TestSnapshotImport(t *testing.T) {
+	var (
+		db, _   = tstdb.NewMemDatabase()
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc:  GenesisAlloc{address: {Balance: big.NewInt(1000000000)}},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainId)
+	)
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 2*triesInMemory, func(i int, block *BlockGen) {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(address), common.Address{byte(i % 16)}, big.NewInt(1000), params.TxGas, nil, nil), signer, key)
+		if err != nil {
+			panic(err)
+		}
+		block.AddTx(tx)
+	})
+	cacheConfig := &CacheConfig{TrieNodeLimit: 256 * 1024 * 1024, TrieTimeLimit: 5 * time.Minute, Snapshot: true}
+
+	chain, err := NewBlockChain(db, cacheConfig, gspec.Config, ethash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to process block %d: %v", n, err)
+	}
+	check := func(chain *BlockChain) {
+		root := chain.CurrentBlock().Root()
+		if chain.snaps.Snapshot(root) == nil {
+			t.Fatalf("snapshot missing for head state")
+		}
+		snapState, _ := chain.StateAt(root)
+		trieState, _ := state.New(root, chain.stateCache)
+		for _, addr := range []common.Address{address, {0x00}, {0x0f}, {0x10}} {
+			if have, want := snapState.GetBalance(addr), trieState.GetBalance(addr); have.Cmp(want) != 0 {
+				t.Errorf("account %x: balance mismatch: have %v, want %v", addr, have, want)
+			}
+			if have, want := snapState.GetNonce(addr), trieState.GetNonce(addr); have != want {
+				t.Errorf("account %x: nonce mismatch: have %v, want %v", addr, have, want)
+			}
+		}
+	}
+	check(chain)
+	chain.Stop()
+
+	// Reopen the chain, the snapshot must have been persisted for the head
+	chain, err = NewBlockChain(db, cacheConfig, gspec.Config, ethash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to recreate chain: %v", err)
+	}
+	defer chain.Stop()
+	check(chain)
+}
+
+// Tests that the snapshot is disabled rather than regenerated when the head moves
+// to a state not covered by it anymore, the chain carrying on from the tries.
+func TestSnapshotDisableOnRewind(t *testing.T) {
+	db, _ := tstdb.NewMemDatabase()
+	gspec := &Genesis{Config: params.TestChainConfig}
+	genesis := gspec.MustCommit(db)
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 2*triesInMemory, nil)
+
+	cacheConfig := &CacheConfig{TrieNodeLimit: 256 * 1024 * 1024, TrieTimeLimit: 5 * time.Minute, Snapshot: true}
+	chain, err := NewBlockChain(db, cacheConfig, gspec.Config, ethash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to process block %d: %v", n, err)
+	}
+	if chain.snaps.Disabled() || chain.snaps.Snapshot(chain.CurrentBlock().Root()) == nil {
+		t.Fatalf("snapshot missing for head state")
+	}
+	// Rewind below the persistent snapshot layer and reimport the chain
+	if err := chain.SetHead(1); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	if !chain.snaps.Disabled() {
+		t.Fatalf("snapshot not disabled after rewinding past it")
+	}
+	if n, err := chain.InsertChain(blocks[chain.CurrentBlock().NumberU64():]); err != nil {
+		t.Fatalf("failed to reprocess block %d: %v", n, err)
+	}
+	if head := chain.CurrentBlock().NumberU64(); head != uint64(len(blocks)) {
+		t.Fatalf("head mismatch: have %d, want %d", head, len(blocks))
+	}
+}
+
+// Tests that finalized blocks moved into the ancient store remain accessible
+// through the regular accessors, and that rewinding the chain truncates them.
+func TestAncientStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ancient")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := tstdb.NewLDBDatabaseWithFreezer(filepath.Join(dir, "chaindata"), 0, 0, filepath.Join(dir, "ancient"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	gspec := &Genesis{Config: params.TestChainConfig}
+	genesis := gspec.MustCommit(db)
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 20, nil)
+
+	chain, err := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to process block %d: %v", n, err)
+	}
+	// Freeze everything but the last 5 blocks, in two rounds
+	if frozen, err := FreezeAncients(db, 20, 5, 10); err != nil || frozen != 10 {
+		t.Fatalf("first freeze mismatch: have %d (%v), want %d", frozen, err, 10)
+	}
+	if frozen, err := FreezeAncients(db, 20, 5, 10); err != nil || frozen != 6 {
+		t.Fatalf("second freeze mismatch: have %d (%v), want %d", frozen, err, 6)
+	}
+	if frozen, err := FreezeAncients(db, 20, 5, 10); err != nil || frozen != 0 {
+		t.Fatalf("third freeze mismatch: have %d (%v), want %d", frozen, err, 0)
+	}
+	// Check that frozen blocks left the key-value store but are still accessible
+	for _, block := range append([]*types.Block{genesis}, blocks...) {
+		hash, number := block.Hash(), block.NumberU64()
+
+		if ok, _ := db.Has(blockBodyKey(hash, number)); ok != (number == 0 || number > 15) {
+			t.Errorf("block #%d: key-value store presence mismatch: have %v, want %v", number, ok, number == 0 || number > 15)
+		}
+		if have := GetCanonicalHash(db, number); have != hash {
+			t.Errorf("block #%d: canonical hash mismatch: have %x, want %x", number, have, hash)
+		}
+		if have := GetBlock(db, hash, number); have == nil || have.Hash() != hash {
+			t.Errorf("block #%d: block mismatch: have %v", number, have)
+		}
+		if have := GetTd(db, hash, number); have == nil || have.Cmp(chain.GetTd(hash, number)) != 0 {
+			t.Errorf("block #%d: total difficulty mismatch: have %v", number, have)
+		}
+		if have := GetBlockReceipts(db, hash, number); have == nil {
+			t.Errorf("block #%d: receipts missing", number)
+		}
+		if !chain.HasBlock(hash, number) || !chain.HasHeader(hash, number) {
+			t.Errorf("block #%d: reported missing", number)
+		}
+	}
+	// Frozen data must not be served for non-canonical hashes
+	if header := GetHeader(db, common.Hash{0x01}, 5); header != nil {
+		t.Errorf("non-canonical header returned: %v", header)
+	}
+	// Rewind the chain into the frozen range and check the ancients were dropped
+	if err := chain.SetHead(10); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	if frozen, _ := db.Ancients(); frozen != 11 {
+		t.Errorf("frozen count mismatch: have %d, want %d", frozen, 11)
+	}
+	if block := GetBlock(db, blocks[12].Hash(), 13); block != nil {
+		t.Errorf("rewound block still accessible")
+	}
+}
+
+// Tests that blocks past the EIP1559 fork carry a base fee, that dynamic fee
+// transactions are accepted, and that only the tip is paid to the miner while
+// the base fee is burnt.
+func TestEIP1559Transition(t *testing.T) {
+	var (
+		key, _    = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address   = crypto.PubkeyToAddress(key.PublicKey)
+		coinbase  = common.Address{0xcb}
+		recipient = common.Address{0xaa}
+		funds     = big.NewInt(1000000000000000000)
+		db, _     = tstdb.NewMemDatabase()
+	)
+	config := *params.AllTstashProtocolChanges
+	config.BerlinBlock = big.NewInt(2)
+	config.EIP1559Block = big.NewInt(2)
+
+	gspec := &Genesis{Config: &config, Alloc: GenesisAlloc{address: {Balance: funds}}}
+	genesis := gspec.MustCommit(db)
+	signer := types.MakeSigner(&config, config.EIP1559Block)
+
+	tip, feeCap := big.NewInt(2*params.Shannon), big.NewInt(5*params.Shannon)
+	blocks, _ := GenerateChain(&config, genesis, ethash.NewFaker(), db, 2, func(i int, block *BlockGen) {
+		block.SetCoinbase(coinbase)
+		if i == 1 {
+			tx, err := types.SignTx(types.NewDynamicFeeTransaction(config.ChainId, block.TxNonce(address), &recipient, big.NewInt(1000), params.TxGas, tip, feeCap, nil, nil), signer, key)
+			if err != nil {
+				panic(err)
+			}
+			block.AddTx(tx)
+		}
+	})
+	chain, _ := NewBlockChain(db, nil, &config, ethash.NewFaker(), vm.Config{})
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	if fee := blocks[0].BaseFee(); fee != nil {
+		t.Errorf("pre-fork block has base fee %v", fee)
+	}
+	baseFee := blocks[1].BaseFee()
+	if baseFee == nil || baseFee.Cmp(big.NewInt(params.InitialBaseFee)) != 0 {
+		t.Fatalf("fork block base fee mismatch: have %v, want %d", baseFee, params.InitialBaseFee)
+	}
+	statedb, _ := chain.State()
+
+	// The sender pays the base fee plus the tip, the miner only gets the tip
+	gasUsed := new(big.Int).SetUint64(blocks[1].GasUsed())
+	paid := new(big.Int).Mul(gasUsed, new(big.Int).Add(baseFee, tip))
+	if have, want := statedb.GetBalance(address), new(big.Int).Sub(funds, new(big.Int).Add(paid, big.NewInt(1000))); have.Cmp(want) != 0 {
+		t.Errorf("sender balance mismatch: have %v, want %v", have, want)
+	}
+	reward := new(big.Int).Mul(ethash.ByzantiumBlockReward, big.NewInt(2))
+	if have, want := statedb.GetBalance(coinbase), new(big.Int).Add(reward, new(big.Int).Mul(gasUsed, tip)); have.Cmp(want) != 0 {
+		t.Errorf("miner balance mismatch: have %v, want %v", have, want)
+	}
+}
+
+// Tests that past the EIP1559 fork the sender has to be able to cover both the
+// fee cap of all the gas and the transferred value upfront.
+func TestEIP1559BalanceCheck(t *testing.T) {
+	var (
+		key, _    = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address   = crypto.PubkeyToAddress(key.PublicKey)
+		recipient = common.Address{0xaa}
+		feeCap    = big.NewInt(5 * params.Shannon)
+		value     = big.NewInt(1000)
+		db, _     = tstdb.NewMemDatabase()
+	)
+	config := *params.AllTstashProtocolChanges
+	config.BerlinBlock = big.NewInt(0)
+	config.EIP1559Block = big.NewInt(0)
+
+	// Fund the sender with just too little to cover the value on top of the fee cap
+	funds := new(big.Int).Mul(big.NewInt(int64(params.TxGas)), feeCap)
+	funds.Add(funds, value)
+	funds.Sub(funds, big.NewInt(1))
+
+	gspec := &Genesis{Config: &config, Alloc: GenesisAlloc{address: {Balance: funds}}}
+	genesis := gspec.MustCommit(db)
+	chain, _ := NewBlockChain(db, nil, &config, ethash.NewFaker(), vm.Config{})
+	defer chain.Stop()
+
+	signer := types.MakeSigner(&config, common.Big0)
+	tx, err := types.SignTx(types.NewDynamicFeeTransaction(config.ChainId, 0, &recipient, value, params.TxGas, big.NewInt(params.Shannon), feeCap, nil, nil), signer, key)
+	if err != nil {
+		t.Fatalf("failed to sign transaction: %v", err)
+	}
+	header := &types.Header{
+		ParentHash: genesis.Hash(),
+		Number:     big.NewInt(1),
+		GasLimit:   genesis.GasLimit(),
+		BaseFee:    big.NewInt(params.InitialBaseFee),
+		Difficulty: big.NewInt(1),
+		Time:       new(big.Int).Add(genesis.Time(), common.Big1),
+	}
+	statedb, _ := chain.State()
+	_, _, err = ApplyTransaction(&config, chain, nil, new(GasPool).AddGas(header.GasLimit), statedb, header, tx, new(uint64), vm.Config{})
+	if err != errInsufficientBalanceForGas {
+		t.Errorf("error mismatch: have %v, want %v", err, errInsufficientBalanceForGas)
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/utchain/go-utchain/common"
 	"github.com/utchain/go-utchain/core/types"
@@ -58,12 +59,15 @@ var (
 	blockReceiptsPrefix = []byte("r") // blockReceiptsPrefix + num (uint64 big endian) + hash -> block receipts
 	lookupPrefix        = []byte("l") // lookupPrefix + hash -> transaction/receipt lookup metadata
 	bloomBitsPrefix     = []byte("B") // bloomBitsPrefix + bit (uint16 big endian) + section (uint64 big endian) + hash -> bloom bits
+	transferPrefix      = []byte("T") // transferPrefix + address + num (uint64 big endian) + hash -> internal transfers
 
 	preimagePrefix = "secure-key-"              // preimagePrefix + hash -> preimage
 	configPrefix   = []byte("utereum-config-") // config prefix for the db
 
 	// Chain index prefixes (use `i` + single byte to avoid mixing data types).
 	BloomBitsIndexPrefix = []byte("iB") // BloomBitsIndexPrefix is the data table of a chain indexer to track its progress
+	TransferIndexPrefix  = []byte("iT") // TransferIndexPrefix is the data table of the internal transfer indexer to track its progress
+	TransferStatePrefix  = []byte("iS") // TransferStatePrefix is the data table of the state regenerated by the internal transfer indexer
 
 	// used by old db, now only used for conversion
 	oldReceiptsPrefix = []byte("receipts-")
@@ -94,11 +98,42 @@ func encodeBlockNumber(number uint64) []byte {
 func GetCanonicalHash(db DatabaseReader, number uint64) common.Hash {
 	data, _ := db.Get(append(append(headerPrefix, encodeBlockNumber(number)...), numSuffix...))
 	if len(data) == 0 {
-		return common.Hash{}
+		if store, ok := db.(tstdb.AncientReader); ok {
+			data, _ = store.Ancient(tstdb.FreezerHashTable, number)
+		}
+		if len(data) == 0 {
+			return common.Hash{}
+		}
 	}
 	return common.BytesToHash(data)
 }
 
+// getAncient retrieves a block's data of the given kind from the ancient store
+// backing the database, if there's one. As only the canonical chain is frozen,
+// the data is only returned if the requested hash is the canonical one.
+func getAncient(db DatabaseReader, kind string, hash common.Hash, number uint64) []byte {
+	store, ok := db.(tstdb.AncientReader)
+	if !ok {
+		return nil
+	}
+	if canon, _ := store.Ancient(tstdb.FreezerHashTable, number); common.BytesToHash(canon) != hash {
+		return nil
+	}
+	data, _ := store.Ancient(kind, number)
+	return data
+}
+
+// hasAncient checks whether the canonical block with the given hash and number
+// has been moved into the ancient store backing the database.
+func hasAncient(db DatabaseReader, hash common.Hash, number uint64) bool {
+	store, ok := db.(tstdb.AncientReader)
+	if !ok {
+		return false
+	}
+	canon, _ := store.Ancient(tstdb.FreezerHashTable, number)
+	return len(canon) > 0 && common.BytesToHash(canon) == hash
+}
+
 // missingNumber is returned by GetBlockNumber if no header with the
 // given block hash has been stored in the database
 const missingNumber = uint64(0xffffffffffffffff)
@@ -161,6 +196,9 @@ func GetTrieSyncProgress(db DatabaseReader) uint64 {
 // if the header's not found.
 func GetHeaderRLP(db DatabaseReader, hash common.Hash, number uint64) rlp.RawValue {
 	data, _ := db.Get(headerKey(hash, number))
+	if len(data) == 0 {
+		data = getAncient(db, tstdb.FreezerHeaderTable, hash, number)
+	}
 	return data
 }
 
@@ -182,6 +220,9 @@ func GetHeader(db DatabaseReader, hash common.Hash, number uint64) *types.Header
 // GetBodyRLP retrieves the block body (transactions and uncles) in RLP encoding.
 func GetBodyRLP(db DatabaseReader, hash common.Hash, number uint64) rlp.RawValue {
 	data, _ := db.Get(blockBodyKey(hash, number))
+	if len(data) == 0 {
+		data = getAncient(db, tstdb.FreezerBodiesTable, hash, number)
+	}
 	return data
 }
 
@@ -212,6 +253,9 @@ func GetBody(db DatabaseReader, hash common.Hash, number uint64) *types.Body {
 // none found.
 func GetTd(db DatabaseReader, hash common.Hash, number uint64) *big.Int {
 	data, _ := db.Get(append(append(append(headerPrefix, encodeBlockNumber(number)...), hash[:]...), tdSuffix...))
+	if len(data) == 0 {
+		data = getAncient(db, tstdb.FreezerDifficultyTable, hash, number)
+	}
 	if len(data) == 0 {
 		return nil
 	}
@@ -247,6 +291,9 @@ func GetBlock(db DatabaseReader, hash common.Hash, number uint64) *types.Block {
 // in a block given by its hash.
 func GetBlockReceipts(db DatabaseReader, hash common.Hash, number uint64) types.Receipts {
 	data, _ := db.Get(append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash[:]...))
+	if len(data) == 0 {
+		data = getAncient(db, tstdb.FreezerReceiptTable, hash, number)
+	}
 	if len(data) == 0 {
 		return nil
 	}
@@ -352,6 +399,47 @@ func GetBloomBits(db DatabaseReader, bit uint, section uint64, head common.Hash)
 	return db.Get(key)
 }
 
+// transferKey = transferPrefix + address + num (uint64 big endian) + hash
+func transferKey(addr common.Address, number uint64, hash common.Hash) []byte {
+	key := make([]byte, 0, len(transferPrefix)+common.AddressLength+8+common.HashLength)
+	key = append(key, transferPrefix...)
+	key = append(key, addr.Bytes()...)
+	key = append(key, encodeBlockNumber(number)...)
+	return append(key, hash.Bytes()...)
+}
+
+// IterateInternalTransfers calls fn with the value transfers made by contracts
+// that the given address took part in, for every canonical block of the range
+// [from, to] containing any, in ascending order.
+func IterateInternalTransfers(db tstdb.Database, addr common.Address, from, to uint64, fn func(number uint64, hash common.Hash, transfers []*types.InternalTransfer)) error {
+	prefix := transferKey(addr, 0, common.Hash{})[:len(transferPrefix)+common.AddressLength]
+
+	it := db.NewIterator(prefix, encodeBlockNumber(from))
+	defer it.Release()
+
+	for it.Next() {
+		key := it.Key()
+		if len(key) != len(prefix)+8+common.HashLength {
+			continue
+		}
+		number := binary.BigEndian.Uint64(key[len(prefix):])
+		if number > to {
+			break
+		}
+		// Skip the transfers of blocks reorged out since they were indexed
+		hash := common.BytesToHash(key[len(prefix)+8:])
+		if GetCanonicalHash(db, number) != hash {
+			continue
+		}
+		var transfers []*types.InternalTransfer
+		if err := rlp.DecodeBytes(it.Value(), &transfers); err != nil {
+			return err
+		}
+		fn(number, hash, transfers)
+	}
+	return it.Error()
+}
+
 // WriteCanonicalHash stores the canonical hash for the given block number.
 func WriteCanonicalHash(db tstdb.Putter, hash common.Hash, number uint64) error {
 	key := append(append(headerPrefix, encodeBlockNumber(number)...), numSuffix...)
@@ -513,6 +601,19 @@ func WriteBloomBits(db tstdb.Putter, bit uint, section uint64, head common.Hash,
 	}
 }
 
+// WriteInternalTransfers stores the value transfers made by contracts in a block
+// that the given address took part in.
+func WriteInternalTransfers(db tstdb.Putter, addr common.Address, number uint64, hash common.Hash, transfers []*types.InternalTransfer) error {
+	data, err := rlp.EncodeToBytes(transfers)
+	if err != nil {
+		return err
+	}
+	if err := db.Put(transferKey(addr, number, hash), data); err != nil {
+		log.Crit("Failed to store internal transfers", "err", err)
+	}
+	return nil
+}
+
 // DeleteCanonicalHash removes the number to hash canonical mapping.
 func DeleteCanonicalHash(db DatabaseDeleter, number uint64) {
 	db.Delete(append(append(headerPrefix, encodeBlockNumber(number)...), numSuffix...))
@@ -552,6 +653,224 @@ func DeleteTxLookupEntry(db DatabaseDeleter, hash common.Hash) {
 	db.Delete(append(lookupPrefix, hash.Bytes()...))
 }
 
+// FreezeAncients moves the canonical chain data (hashes, headers, bodies,
+// receipts and total difficulties) of all blocks at least threshold blocks below
+// head from the key-value store into the ancient store backing the database.
+// At most limit blocks are moved in one go, the number of which is returned.
+//
+// The data is only deleted from the key-value store after the ancient store was
+// flushed to disk, so a crash midway leaves at worst duplicated data behind. The
+// genesis block is kept in the key-value store too, as it identifies the chain.
+func FreezeAncients(db tstdb.Database, head, threshold, limit uint64) (uint64, error) {
+	store, ok := db.(tstdb.AncientStore)
+	if !ok {
+		return 0, errors.New("database has no ancient store")
+	}
+	frozen, err := store.Ancients()
+	if err != nil {
+		return 0, err
+	}
+	if head < threshold || frozen > head-threshold {
+		return 0, nil
+	}
+	last := head - threshold
+	if last-frozen >= limit {
+		last = frozen + limit - 1
+	}
+	// Copy all the finalized blocks into the ancient store
+	hashes := make([]common.Hash, 0, last-frozen+1)
+	for number := frozen; number <= last; number++ {
+		hash, _ := db.Get(append(append(headerPrefix, encodeBlockNumber(number)...), numSuffix...))
+		if len(hash) == 0 {
+			return uint64(len(hashes)), fmt.Errorf("canonical hash #%d missing", number)
+		}
+		h := common.BytesToHash(hash)
+
+		header, _ := db.Get(headerKey(h, number))
+		body, _ := db.Get(blockBodyKey(h, number))
+		receipts, _ := db.Get(append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash...))
+		td, _ := db.Get(append(headerKey(h, number), tdSuffix...))
+		if len(header) == 0 || len(body) == 0 || len(receipts) == 0 || len(td) == 0 {
+			return uint64(len(hashes)), fmt.Errorf("block #%d [%x…] incomplete", number, hash[:4])
+		}
+		if err := store.AppendAncient(number, hash, header, body, receipts, td); err != nil {
+			return uint64(len(hashes)), err
+		}
+		hashes = append(hashes, h)
+	}
+	if err := store.Sync(); err != nil {
+		return 0, err
+	}
+	// Ancient store flushed, drop the duplicates from the key-value store. The
+	// hash to number mappings are retained, the lookups rely on them.
+	batch := db.NewBatch()
+	for i, hash := range hashes {
+		number := frozen + uint64(i)
+		if number == 0 {
+			continue
+		}
+
+		DeleteCanonicalHash(batch, number)
+		batch.Delete(headerKey(hash, number))
+		DeleteBody(batch, hash, number)
+		DeleteBlockReceipts(batch, hash, number)
+		DeleteTd(batch, hash, number)
+
+		if batch.ValueSize() >= tstdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return uint64(len(hashes)), err
+			}
+			batch.Reset()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return uint64(len(hashes)), err
+	}
+	return uint64(len(hashes)), nil
+}
+
+// DatabaseStat is the accumulated count and size of a category of database items.
+type DatabaseStat struct {
+	Category string
+	Count    uint64
+	Size     common.StorageSize
+}
+
+// Key prefixes of the data items stored by other packages, duplicated here to
+// avoid import cycles. They must be kept in sync with their originals.
+var (
+	snapshotAccountPrefix = []byte("a")        // core/state/snapshot: accountPrefix + account hash
+	snapshotStoragePrefix = []byte("o")        // core/state/snapshot: storagePrefix + account hash + storage hash
+	chtTablePrefix        = []byte("cht-")     // light: ChtTablePrefix
+	chtRootPrefix         = []byte("chtRoot-") // light: chtPrefix
+	bloomTrieTablePrefix  = []byte("blt-")     // light: BloomTrieTablePrefix
+	bloomTrieRootPrefix   = []byte("bltRoot-") // light: bloomTriePrefix
+)
+
+// databaseMetadataKeys are the singleton keys tracking the chain and sync
+// progress, reported together as metadata.
+var databaseMetadataKeys = [][]byte{
+	headHeaderKey, headBlockKey, headFastKey, trieSyncKey,
+	[]byte("BlockchainVersion"), []byte("SnapshotRoot"), []byte("SnapshotGenerator"),
+	tstdb.FreezerDirKey,
+}
+
+// InspectDatabase iterates over the entire key-value store and accumulates the
+// number and size of the stored items, categorized by their key schema. If the
+// database has an attached ancient store, its contents are reported too.
+func InspectDatabase(db tstdb.Database) ([]*DatabaseStat, error) {
+	var (
+		headers     = &DatabaseStat{Category: "Headers"}
+		bodies      = &DatabaseStat{Category: "Bodies"}
+		receipts    = &DatabaseStat{Category: "Receipts"}
+		tds         = &DatabaseStat{Category: "Difficulties"}
+		numHashes   = &DatabaseStat{Category: "Block number->hash"}
+		hashNums    = &DatabaseStat{Category: "Block hash->number"}
+		lookups     = &DatabaseStat{Category: "Transaction lookups"}
+		bloomBits   = &DatabaseStat{Category: "Bloombit index"}
+		transfers   = &DatabaseStat{Category: "Internal transfer index"}
+		tries       = &DatabaseStat{Category: "Trie nodes and contract codes"}
+		preimages   = &DatabaseStat{Category: "Trie preimages"}
+		accounts    = &DatabaseStat{Category: "Snapshot accounts"}
+		storages    = &DatabaseStat{Category: "Snapshot storage"}
+		chtTries    = &DatabaseStat{Category: "Light CHT tries"}
+		bloomTries  = &DatabaseStat{Category: "Light bloom tries"}
+		indexers    = &DatabaseStat{Category: "Chain indexers"}
+		configs     = &DatabaseStat{Category: "Chain configs"}
+		metadata    = &DatabaseStat{Category: "Metadata"}
+		unaccounted = &DatabaseStat{Category: "Unaccounted"}
+
+		start  = time.Now()
+		logged = time.Now()
+		count  uint64
+	)
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+
+	for it.Next() {
+		var (
+			key  = it.Key()
+			size = common.StorageSize(len(key) + len(it.Value()))
+			stat = unaccounted
+		)
+		switch {
+		case bytes.HasPrefix(key, headerPrefix) && len(key) == len(headerPrefix)+8+common.HashLength:
+			stat = headers
+		case bytes.HasPrefix(key, headerPrefix) && len(key) == len(headerPrefix)+8+common.HashLength+len(tdSuffix) && bytes.HasSuffix(key, tdSuffix):
+			stat = tds
+		case bytes.HasPrefix(key, headerPrefix) && len(key) == len(headerPrefix)+8+len(numSuffix) && bytes.HasSuffix(key, numSuffix):
+			stat = numHashes
+		case bytes.HasPrefix(key, blockHashPrefix) && len(key) == len(blockHashPrefix)+common.HashLength:
+			stat = hashNums
+		case bytes.HasPrefix(key, bodyPrefix) && len(key) == len(bodyPrefix)+8+common.HashLength:
+			stat = bodies
+		case bytes.HasPrefix(key, blockReceiptsPrefix) && len(key) == len(blockReceiptsPrefix)+8+common.HashLength:
+			stat = receipts
+		case bytes.HasPrefix(key, lookupPrefix) && len(key) == len(lookupPrefix)+common.HashLength:
+			stat = lookups
+		case bytes.HasPrefix(key, bloomBitsPrefix) && len(key) == len(bloomBitsPrefix)+2+8+common.HashLength:
+			stat = bloomBits
+		case bytes.HasPrefix(key, transferPrefix) && len(key) == len(transferPrefix)+common.AddressLength+8+common.HashLength:
+			stat = transfers
+		case bytes.HasPrefix(key, snapshotAccountPrefix) && len(key) == len(snapshotAccountPrefix)+common.HashLength:
+			stat = accounts
+		case bytes.HasPrefix(key, snapshotStoragePrefix) && len(key) == len(snapshotStoragePrefix)+2*common.HashLength:
+			stat = storages
+		case bytes.HasPrefix(key, []byte(preimagePrefix)) && len(key) == len(preimagePrefix)+common.HashLength:
+			stat = preimages
+		case bytes.HasPrefix(key, configPrefix) && len(key) == len(configPrefix)+common.HashLength:
+			stat = configs
+		case bytes.HasPrefix(key, chtTablePrefix) || bytes.HasPrefix(key, chtRootPrefix):
+			stat = chtTries
+		case bytes.HasPrefix(key, bloomTrieTablePrefix) || bytes.HasPrefix(key, bloomTrieRootPrefix):
+			stat = bloomTries
+		case bytes.HasPrefix(key, BloomBitsIndexPrefix) || bytes.HasPrefix(key, TransferIndexPrefix) || bytes.HasPrefix(key, TransferStatePrefix):
+			stat = indexers
+		case len(key) == common.HashLength:
+			stat = tries
+		default:
+			for _, meta := range databaseMetadataKeys {
+				if bytes.Equal(key, meta) {
+					stat = metadata
+					break
+				}
+			}
+		}
+		stat.Count++
+		stat.Size += size
+
+		count++
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Inspecting database", "count", count, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	stats := []*DatabaseStat{
+		headers, bodies, receipts, tds, numHashes, hashNums, lookups, bloomBits, transfers, tries, preimages,
+		accounts, storages, chtTries, bloomTries, indexers, configs, metadata,
+	}
+	// Append the contents of the ancient store, if there's any
+	if store, ok := db.(tstdb.AncientReader); ok {
+		frozen, err := store.Ancients()
+		if err == nil {
+			for _, kind := range []string{tstdb.FreezerHeaderTable, tstdb.FreezerBodiesTable, tstdb.FreezerReceiptTable, tstdb.FreezerDifficultyTable, tstdb.FreezerHashTable} {
+				size, err := store.AncientSize(kind)
+				if err != nil {
+					return nil, err
+				}
+				stats = append(stats, &DatabaseStat{Category: "Ancient " + kind, Count: frozen, Size: common.StorageSize(size)})
+			}
+		}
+	}
+	stats = append(stats, unaccounted)
+
+	log.Info("Inspected database", "count", count, "elapsed", common.PrettyDuration(time.Since(start)))
+	return stats, nil
+}
+
 // PreimageTable returns a Database instance with the key prefix for preimage entries.
 func PreimageTable(db tstdb.Database) tstdb.Database {
 	return tstdb.NewTable(db, preimagePrefix)
@@ -386,3 +386,83 @@ func TestBlockReceiptStorage(t *testing.T) {
 		t.Fatalf("deleted receipts returned: %v", rs)
 	}
 }
+
+// Tests that internal transfers are stored by address and block, and iterated
+// in order within the requested range of canonical blocks only.
+func TestInternalTransferStorage(t *testing.T) {
+	db, _ := tstdb.NewMemDatabase()
+
+	var (
+		addr   = common.Address{0x01}
+		other  = common.Address{0x02}
+		hashes = []common.Hash{{0x11}, {0x12}, {0x13}}
+	)
+	for i, hash := range hashes {
+		number := uint64(i + 1)
+		WriteCanonicalHash(db, hash, number)
+
+		transfers := []*types.InternalTransfer{{TxHash: common.Hash{byte(i)}, Type: "call", From: addr, To: other, Value: big.NewInt(int64(i + 1))}}
+		WriteInternalTransfers(db, addr, number, hash, transfers)
+		WriteInternalTransfers(db, other, number, hash, transfers)
+	}
+	// Transfers of a reorged block should be skipped
+	WriteInternalTransfers(db, addr, 2, common.Hash{0xff}, []*types.InternalTransfer{{Type: "call", Value: big.NewInt(100)}})
+
+	var numbers []uint64
+	err := IterateInternalTransfers(db, addr, 2, 3, func(number uint64, hash common.Hash, transfers []*types.InternalTransfer) {
+		if hash != hashes[number-1] {
+			t.Errorf("block #%d: hash mismatch: have %x, want %x", number, hash, hashes[number-1])
+		}
+		if len(transfers) != 1 || transfers[0].From != addr || transfers[0].Value.Uint64() != number {
+			t.Errorf("block #%d: transfers mismatch: have %v", number, transfers)
+		}
+		numbers = append(numbers, number)
+	})
+	if err != nil {
+		t.Fatalf("failed to iterate internal transfers: %v", err)
+	}
+	if len(numbers) != 2 || numbers[0] != 2 || numbers[1] != 3 {
+		t.Errorf("iterated blocks mismatch: have %v, want [2 3]", numbers)
+	}
+}
+
+// Tests that the database inspection categorizes the stored items by their key
+// schema and reports their total sizes.
+func TestInspectDatabase(t *testing.T) {
+	db, _ := tstdb.NewMemDatabase()
+
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Extra: []byte("test block")})
+	if err := WriteBlock(db, block); err != nil {
+		t.Fatalf("failed to write block: %v", err)
+	}
+	WriteCanonicalHash(db, block.Hash(), 1)
+	WriteHeadBlockHash(db, block.Hash())
+	db.Put(common.Hash{0x01}.Bytes(), []byte("trie node"))
+	db.Put([]byte(preimagePrefix+string(common.Hash{0x02}.Bytes())), []byte("preimage"))
+	WriteInternalTransfers(db, common.Address{0x03}, 1, block.Hash(), []*types.InternalTransfer{{Type: "call", Value: big.NewInt(1)}})
+	db.Put([]byte("unknown"), []byte("junk"))
+
+	stats, err := InspectDatabase(db)
+	if err != nil {
+		t.Fatalf("failed to inspect database: %v", err)
+	}
+	want := map[string]uint64{
+		"Headers":                       1,
+		"Bodies":                        1,
+		"Block number->hash":            1,
+		"Block hash->number":            1,
+		"Trie nodes and contract codes": 1,
+		"Trie preimages":                1,
+		"Internal transfer index":       1,
+		"Metadata":                      1,
+		"Unaccounted":                   1,
+	}
+	for _, stat := range stats {
+		if stat.Count != want[stat.Category] {
+			t.Errorf("%s: item count mismatch: have %d, want %d", stat.Category, stat.Count, want[stat.Category])
+		}
+		if stat.Count > 0 && stat.Size == 0 {
+			t.Errorf("%s: missing size", stat.Category)
+		}
+	}
+}
@@ -32,4 +32,8 @@ var (
 	// ErrNonceTooHigh is returned if the nonce of a transaction is higher than the
 	// next one expected based on the local chain.
 	ErrNonceTooHigh = errors.New("nonce too high")
+
+	// ErrFeeCapTooLow is returned if the fee cap of a transaction is lower than
+	// the base fee of the block it is executed in.
+	ErrFeeCapTooLow = errors.New("max fee per gas less than block base fee")
 )
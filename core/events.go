@@ -17,6 +17,8 @@
 package core
 
 import (
+	"time"
+
 	"github.com/utchain/go-utchain/common"
 	"github.com/utchain/go-utchain/core/types"
 )
@@ -24,6 +26,16 @@ import (
 // TxPreEvent is posted when a transaction enters the transaction pool.
 type TxPreEvent struct{ Tx *types.Transaction }
 
+// TxDropEvent is posted when a transaction is removed from the transaction pool
+// without being included in the chain.
+type TxDropEvent struct {
+	Tx          *types.Transaction
+	Reason      TxDropReason
+	Replacement *types.Transaction // Transaction replacing the dropped one, if any
+	Time        time.Time
+	Private     bool // Whether the dropped transaction was withheld from the network
+}
+
 // PendingLogsEvent is posted pre mining and notifies of pending logs.
 type PendingLogsEvent struct {
 	Logs []*types.Log
@@ -0,0 +1,179 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package forkid
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/params"
+	"github.com/utchain/go-utchain/rlp"
+)
+
+// forkedConfig is a chain config with distinct, staggered fork blocks to test
+// the fork checksums against.
+var forkedConfig = &params.ChainConfig{
+	ChainId:             big.NewInt(1),
+	HomesteadBlock:      big.NewInt(1150000),
+	EIP150Block:         big.NewInt(2463000),
+	EIP155Block:         big.NewInt(2675000),
+	EIP158Block:         big.NewInt(2675000),
+	ByzantiumBlock:      big.NewInt(4370000),
+	ConstantinopleBlock: big.NewInt(7280000),
+}
+
+// Tests that fork IDs are correctly calculated for various chain configs and
+// head positions.
+func TestCreation(t *testing.T) {
+	type testcase struct {
+		head uint64
+		want ID
+	}
+	tests := []struct {
+		config  *params.ChainConfig
+		genesis common.Hash
+		cases   []testcase
+	}{
+		// Mainnet activates all its forks at genesis, so there's nothing to wait for
+		{
+			params.MainnetChainConfig,
+			params.MainnetGenesisHash,
+			[]testcase{
+				{0, ID{Hash: checksumToBytes(0x38c6fd1c), Next: 0}},
+				{10000000, ID{Hash: checksumToBytes(0x38c6fd1c), Next: 0}},
+			},
+		},
+		// Staggered forks, with two of them colliding on the same block
+		{
+			forkedConfig,
+			params.MainnetGenesisHash,
+			[]testcase{
+				{0, ID{Hash: checksumToBytes(0x38c6fd1c), Next: 1150000}},       // Unsynced
+				{1149999, ID{Hash: checksumToBytes(0x38c6fd1c), Next: 1150000}}, // Last Frontier block
+				{1150000, ID{Hash: checksumToBytes(0xb1748056), Next: 2463000}}, // First Homestead block
+				{2462999, ID{Hash: checksumToBytes(0xb1748056), Next: 2463000}}, // Last Homestead block
+				{2463000, ID{Hash: checksumToBytes(0xe2b5c3ab), Next: 2675000}}, // First EIP150 block
+				{2675000, ID{Hash: checksumToBytes(0xf19cec44), Next: 4370000}}, // First EIP155/158 block
+				{4370000, ID{Hash: checksumToBytes(0x3bf4e9f5), Next: 7280000}}, // First Byzantium block
+				{7279999, ID{Hash: checksumToBytes(0x3bf4e9f5), Next: 7280000}}, // Last Byzantium block
+				{7280000, ID{Hash: checksumToBytes(0x85e51d32), Next: 0}},       // First Constantinople block
+				{10000000, ID{Hash: checksumToBytes(0x85e51d32), Next: 0}},      // Future Constantinople block
+			},
+		},
+	}
+	for i, tt := range tests {
+		for j, ttt := range tt.cases {
+			if have := newID(tt.config, tt.genesis, ttt.head); have != ttt.want {
+				t.Errorf("test %d, case %d: fork ID mismatch: have %x, want %x", i, j, have, ttt.want)
+			}
+		}
+	}
+}
+
+// Tests that fork IDs are validated correctly against the local chain state.
+func TestValidation(t *testing.T) {
+	tests := []struct {
+		head uint64
+		id   ID
+		err  error
+	}{
+		// Local is Byzantium, remote announces the same. No future fork is announced.
+		{4370000, ID{Hash: checksumToBytes(0x3bf4e9f5), Next: 0}, nil},
+
+		// Local is Byzantium, remote announces the same. Remote also announces a next
+		// fork at block 0xffffffff, but that is uncertain.
+		{4370000, ID{Hash: checksumToBytes(0x3bf4e9f5), Next: math.MaxUint64}, nil},
+
+		// Local is Byzantium, remote announces the same and Constantinople. Remote
+		// is simply out of sync, accept.
+		{4370000, ID{Hash: checksumToBytes(0x3bf4e9f5), Next: 7280000}, nil},
+
+		// Local is Homestead, remote announces the Byzantium checksum. Local is
+		// out of sync, accept.
+		{1150000, ID{Hash: checksumToBytes(0x3bf4e9f5), Next: 7280000}, nil},
+
+		// Local is Byzantium, remote announces Homestead with EIP150 next. Remote
+		// is out of sync, accept.
+		{4370000, ID{Hash: checksumToBytes(0xb1748056), Next: 2463000}, nil},
+
+		// Local is Constantinople, remote announces Frontier with Homestead next.
+		// Remote is out of sync, accept.
+		{7280000, ID{Hash: checksumToBytes(0x38c6fd1c), Next: 1150000}, nil},
+
+		// Local is Byzantium, remote announces Homestead with a different next
+		// fork. Remote is stale, reject.
+		{4370000, ID{Hash: checksumToBytes(0xb1748056), Next: 2000000}, ErrRemoteStale},
+
+		// Local is Byzantium, remote announces an unknown checksum. The chains
+		// diverged at some point, reject.
+		{4370000, ID{Hash: checksumToBytes(0xafec6b27), Next: 0}, ErrLocalIncompatibleOrStale},
+
+		// Local is Byzantium, remote announces the same with a next fork already
+		// passed locally. Local is incompatible or stale, reject.
+		{7279999, ID{Hash: checksumToBytes(0x3bf4e9f5), Next: 5000000}, ErrLocalIncompatibleOrStale},
+	}
+	for i, tt := range tests {
+		filter := newFilter(forkedConfig, params.MainnetGenesisHash, func() uint64 { return tt.head })
+		if err := filter(tt.id); err != tt.err {
+			t.Errorf("test %d: validation error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+// Tests that fork IDs are RLP encoded as a two item list.
+func TestEncoding(t *testing.T) {
+	tests := []struct {
+		id   ID
+		want []byte
+	}{
+		{ID{Hash: checksumToBytes(0), Next: 0}, common.Hex2Bytes("c6840000000080")},
+		{ID{Hash: checksumToBytes(0xdeadbeef), Next: 0xBADDCAFE}, common.Hex2Bytes("ca84deadbeef84baddcafe")},
+	}
+	for i, tt := range tests {
+		have, err := rlp.EncodeToBytes(tt.id)
+		if err != nil {
+			t.Errorf("test %d: failed to encode forkid: %v", i, err)
+			continue
+		}
+		if !bytes.Equal(have, tt.want) {
+			t.Errorf("test %d: RLP mismatch: have %x, want %x", i, have, tt.want)
+		}
+		var id ID
+		if err := rlp.DecodeBytes(have, &id); err != nil {
+			t.Errorf("test %d: failed to decode forkid: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(id, tt.id) {
+			t.Errorf("test %d: decoded mismatch: have %x, want %x", i, id, tt.id)
+		}
+	}
+}
+
+// Tests that fork blocks are gathered, sorted and deduplicated from the config.
+func TestGatherForks(t *testing.T) {
+	if forks := gatherForks(params.MainnetChainConfig); len(forks) != 0 {
+		t.Errorf("mainnet forks mismatch: have %v, want none", forks)
+	}
+	want := []uint64{1150000, 2463000, 2675000, 4370000, 7280000}
+	if forks := gatherForks(forkedConfig); !reflect.DeepEqual(forks, want) {
+		t.Errorf("staggered forks mismatch: have %v, want %v", forks, want)
+	}
+}
@@ -153,6 +153,11 @@ func SetupGenesisBlock(db tstdb.Database, genesis *Genesis) (*params.ChainConfig
 	if genesis != nil && genesis.Config == nil {
 		return params.AllTstashProtocolChanges, common.Hash{}, errGenesisNoConfig
 	}
+	if genesis != nil {
+		if err := genesis.Config.CheckConfigForkOrder(); err != nil {
+			return genesis.Config, common.Hash{}, err
+		}
+	}
 
 	// Just commit the new block if there is no stored genesis block.
 	stored := GetCanonicalHash(db, 0)
@@ -254,6 +259,9 @@ func (g *Genesis) ToBlock(db tstdb.Database) *types.Block {
 	if g.Difficulty == nil {
 		head.Difficulty = params.GenesisDifficulty
 	}
+	if g.Config != nil && g.Config.IsEIP1559(head.Number) {
+		head.BaseFee = new(big.Int).SetUint64(params.InitialBaseFee)
+	}
 	statedb.Commit(false)
 	statedb.Database().TrieDB().Commit(root, true)
 
@@ -289,6 +297,9 @@ func (g *Genesis) Commit(db tstdb.Database) (*types.Block, error) {
 	if config == nil {
 		config = params.AllTstashProtocolChanges
 	}
+	if err := config.CheckConfigForkOrder(); err != nil {
+		return nil, err
+	}
 	return block, WriteChainConfig(db, block.Hash(), config)
 }
 
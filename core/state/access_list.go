@@ -0,0 +1,119 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"github.com/utchain/go-utchain/common"
+)
+
+// accessList tracks the accounts and storage slots already accessed within a
+// transaction, which are charged the cheaper warm gas prices from Berlin on.
+type accessList struct {
+	addresses map[common.Address]int     // Accessed accounts, mapped to their slot set index (-1 = no slots)
+	slots     []map[common.Hash]struct{} // Accessed storage slots of the accounts
+}
+
+// newAccessList creates an empty access list.
+func newAccessList() *accessList {
+	return &accessList{
+		addresses: make(map[common.Address]int),
+	}
+}
+
+// ContainsAddress returns whether the address is in the access list.
+func (al *accessList) ContainsAddress(address common.Address) bool {
+	_, ok := al.addresses[address]
+	return ok
+}
+
+// Contains checks whether a slot within an account is in the access list,
+// also reporting whether the account itself is.
+func (al *accessList) Contains(address common.Address, slot common.Hash) (addressPresent bool, slotPresent bool) {
+	idx, ok := al.addresses[address]
+	if !ok {
+		return false, false
+	}
+	if idx == -1 {
+		return true, false
+	}
+	_, slotPresent = al.slots[idx][slot]
+	return true, slotPresent
+}
+
+// Copy creates an independent copy of the access list.
+func (al *accessList) Copy() *accessList {
+	cpy := newAccessList()
+	for addr, idx := range al.addresses {
+		cpy.addresses[addr] = idx
+	}
+	cpy.slots = make([]map[common.Hash]struct{}, len(al.slots))
+	for i, slots := range al.slots {
+		cpy.slots[i] = make(map[common.Hash]struct{}, len(slots))
+		for slot := range slots {
+			cpy.slots[i][slot] = struct{}{}
+		}
+	}
+	return cpy
+}
+
+// AddAddress adds an address to the access list, returning whether it was
+// not yet present.
+func (al *accessList) AddAddress(address common.Address) bool {
+	if _, present := al.addresses[address]; present {
+		return false
+	}
+	al.addresses[address] = -1
+	return true
+}
+
+// AddSlot adds the address and slot to the access list, returning whether
+// each of them was not yet present.
+func (al *accessList) AddSlot(address common.Address, slot common.Hash) (addrChange bool, slotChange bool) {
+	idx, addrPresent := al.addresses[address]
+	if !addrPresent || idx == -1 {
+		// Create the slot set of the account, adding the account if needed
+		al.addresses[address] = len(al.slots)
+		al.slots = append(al.slots, map[common.Hash]struct{}{slot: {}})
+		return !addrPresent, true
+	}
+	if _, ok := al.slots[idx][slot]; ok {
+		return false, false
+	}
+	al.slots[idx][slot] = struct{}{}
+	return false, true
+}
+
+// DeleteSlot removes a slot of an account from the access list. It is only
+// meant to be used by the journal, which reverts additions in reverse order,
+// so the slot set of the account is dropped if it becomes empty.
+func (al *accessList) DeleteSlot(address common.Address, slot common.Hash) {
+	idx, ok := al.addresses[address]
+	if !ok || idx == -1 {
+		panic("reverting slot change, address not present in list")
+	}
+	delete(al.slots[idx], slot)
+	if len(al.slots[idx]) == 0 {
+		al.slots = al.slots[:idx]
+		al.addresses[address] = -1
+	}
+}
+
+// DeleteAddress removes an account from the access list. It is only meant to
+// be used by the journal, after all the slots of the account were removed.
+func (al *accessList) DeleteAddress(address common.Address) {
+	delete(al.addresses, address)
+}
@@ -0,0 +1,69 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"encoding/binary"
+
+	"github.com/utchain/go-utchain/common"
+)
+
+// bloomHashes is the number of bit positions set for each inserted item.
+const bloomHashes = 4
+
+// stateBloom is a bloom filter of the trie node and contract code hashes that
+// are reachable from the retained state roots. False positives only result in
+// some stale data surviving the pruning, whereas false negatives are impossible,
+// so live state is never deleted.
+//
+// Since the tracked keys are Keccak256 hashes already, the bit positions are
+// derived directly from non-overlapping chunks of the key instead of rehashing.
+type stateBloom struct {
+	bits []uint64
+	size uint64 // Number of bits in the filter
+}
+
+// newStateBloom creates a bloom filter of the given size in megabytes.
+func newStateBloom(megabytes uint64) *stateBloom {
+	if megabytes == 0 {
+		megabytes = 1
+	}
+	words := megabytes * 1024 * 1024 / 8
+	return &stateBloom{
+		bits: make([]uint64, words),
+		size: words * 64,
+	}
+}
+
+// add inserts a hash into the bloom filter.
+func (b *stateBloom) add(hash common.Hash) {
+	for i := 0; i < bloomHashes; i++ {
+		bit := binary.BigEndian.Uint64(hash[i*8:]) % b.size
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// contains reports if a hash might have been inserted into the filter.
+func (b *stateBloom) contains(hash common.Hash) bool {
+	for i := 0; i < bloomHashes; i++ {
+		bit := binary.BigEndian.Uint64(hash[i*8:]) % b.size
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
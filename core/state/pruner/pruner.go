@@ -0,0 +1,183 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package pruner implements offline deletion of stale state from a chain database.
+package pruner
+
+import (
+	"errors"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core"
+	"github.com/utchain/go-utchain/core/state"
+	"github.com/utchain/go-utchain/log"
+	"github.com/utchain/go-utchain/tstdb"
+)
+
+// errNoRetainedState is returned if none of the requested recent blocks have
+// their state available in the database, so there's nothing to prune against.
+var errNoRetainedState = errors.New("no recent state available to retain")
+
+// Pruner is an offline tool to delete all the trie nodes and contract codes that
+// are not reachable from a set of retained state roots. It must not be run on a
+// database that is concurrently used by a live node.
+type Pruner struct {
+	db    tstdb.Database
+	bloom *stateBloom
+}
+
+// NewPruner creates a state pruner for the given database, using a bloom filter
+// of the given size (in megabytes) to track the live state.
+func NewPruner(db tstdb.Database, bloomSize uint64) *Pruner {
+	return &Pruner{
+		db:    db,
+		bloom: newStateBloom(bloomSize),
+	}
+}
+
+// RecentRoots returns the state roots of the current head block and the given
+// number of its ancestors, skipping any whose state is not available on disk.
+func RecentRoots(db tstdb.Database, count uint64) ([]common.Hash, error) {
+	hash := core.GetHeadBlockHash(db)
+	header := core.GetHeader(db, hash, core.GetBlockNumber(db, hash))
+	if header == nil {
+		return nil, errors.New("head block missing")
+	}
+	var roots []common.Hash
+	for i := uint64(0); i <= count && header != nil; i++ {
+		if ok, _ := db.Has(header.Root[:]); ok {
+			roots = append(roots, header.Root)
+		} else {
+			log.Debug("Skipping unavailable state", "number", header.Number, "root", header.Root)
+		}
+		if header.Number.Sign() == 0 {
+			break
+		}
+		header = core.GetHeader(db, header.ParentHash, header.Number.Uint64()-1)
+	}
+	if len(roots) == 0 {
+		return nil, errNoRetainedState
+	}
+	return roots, nil
+}
+
+// Prune marks every trie node and contract code reachable from the given state
+// roots as live, then deletes all other trie nodes from the database and compacts
+// it to reclaim the freed space.
+//
+// Interrupting the pruning is safe, since live state is never deleted; it merely
+// leaves some of the stale data around until the next run.
+func (p *Pruner) Prune(roots []common.Hash) error {
+	if len(roots) == 0 {
+		return errNoRetainedState
+	}
+	// Mark all the state reachable from the retained roots
+	start := time.Now()
+	for _, root := range roots {
+		if err := p.mark(root); err != nil {
+			return err
+		}
+	}
+	log.Info("Marked live state", "roots", len(roots), "elapsed", common.PrettyDuration(time.Since(start)))
+
+	// Sweep all the trie nodes not marked live from the database
+	start = time.Now()
+	var (
+		batch   = p.db.NewBatch()
+		it      = p.db.NewIterator(nil, nil)
+		logged  = time.Now()
+		count   int
+		deleted int
+		size    common.StorageSize
+	)
+	defer it.Release()
+
+	for it.Next() {
+		count++
+
+		// Trie nodes and contract codes are the only entries keyed by bare hashes
+		key := it.Key()
+		if len(key) != common.HashLength || p.bloom.contains(common.BytesToHash(key)) {
+			continue
+		}
+		size += common.StorageSize(len(key) + len(it.Value()))
+		deleted++
+
+		batch.Delete(common.CopyBytes(key))
+		if batch.ValueSize() >= tstdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Pruning stale state", "scanned", count, "deleted", deleted, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	log.Info("Pruned stale state", "scanned", count, "deleted", deleted, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+
+	// Compact the database to actually release the disk space, if it's LevelDB
+	if db, ok := p.db.(*tstdb.LDBDatabase); ok {
+		start = time.Now()
+		log.Info("Compacting database")
+		if err := db.LDB().CompactRange(util.Range{}); err != nil {
+			return err
+		}
+		log.Info("Compacted database", "elapsed", common.PrettyDuration(time.Since(start)))
+	}
+	return nil
+}
+
+// mark iterates over the entire state of the given root, inserting all the trie
+// nodes and contract codes encountered into the bloom filter.
+func (p *Pruner) mark(root common.Hash) error {
+	statedb, err := state.New(root, state.NewDatabase(p.db))
+	if err != nil {
+		return err
+	}
+	var (
+		it     = state.NewNodeIterator(statedb)
+		logged = time.Now()
+		nodes  int
+	)
+	for it.Next() {
+		// Embedded trie nodes have no hash and are stored as part of their parents
+		if it.Hash == (common.Hash{}) {
+			continue
+		}
+		p.bloom.add(it.Hash)
+		nodes++
+
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Marking live state", "root", root, "nodes", nodes)
+			logged = time.Now()
+		}
+	}
+	if it.Error != nil {
+		return it.Error
+	}
+	log.Debug("Marked live state root", "root", root, "nodes", nodes)
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/state"
+	"github.com/utchain/go-utchain/tstdb"
+)
+
+// Tests that pruning deletes the state only reachable from stale roots, while
+// keeping the retained states fully intact.
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pruner-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := tstdb.NewLDBDatabase(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	// Create a stale and a live state, sharing some of their nodes
+	sdb := state.NewDatabase(db)
+	statedb, _ := state.New(common.Hash{}, sdb)
+	for i := byte(0); i < 64; i++ {
+		addr := common.BytesToAddress([]byte{i})
+		statedb.SetBalance(addr, big.NewInt(int64(i)+1))
+		statedb.SetState(addr, common.Hash{i}, common.Hash{i + 1})
+		statedb.SetCode(addr, []byte{i, i, i})
+	}
+	stale, _ := statedb.Commit(false)
+	sdb.TrieDB().Commit(stale, false)
+
+	statedb, _ = state.New(stale, sdb)
+	for i := byte(0); i < 32; i++ {
+		addr := common.BytesToAddress([]byte{i})
+		statedb.SetBalance(addr, big.NewInt(int64(i)+1000))
+		statedb.SetState(addr, common.Hash{i}, common.Hash{i + 2})
+	}
+	live, _ := statedb.Commit(false)
+	sdb.TrieDB().Commit(live, false)
+
+	if err := NewPruner(db, 1).Prune([]common.Hash{live}); err != nil {
+		t.Fatalf("failed to prune state: %v", err)
+	}
+	// The stale root must be gone, whereas the live state must be fully accessible
+	if ok, _ := db.Has(stale[:]); ok {
+		t.Errorf("stale state root not pruned")
+	}
+	statedb, err = state.New(live, state.NewDatabase(db))
+	if err != nil {
+		t.Fatalf("failed to open live state: %v", err)
+	}
+	it := state.NewNodeIterator(statedb)
+	for it.Next() {
+	}
+	if it.Error != nil {
+		t.Fatalf("live state corrupted: %v", it.Error)
+	}
+	for i := byte(0); i < 64; i++ {
+		addr := common.BytesToAddress([]byte{i})
+		if code := statedb.GetCode(addr); len(code) != 3 || code[0] != i {
+			t.Errorf("account %d: code mismatch: have %x", i, code)
+		}
+	}
+}
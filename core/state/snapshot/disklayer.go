@@ -0,0 +1,208 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/log"
+	"github.com/utchain/go-utchain/trie"
+	"github.com/utchain/go-utchain/tstdb"
+)
+
+// diskLayer is a low level persistent snapshot built on top of a key-value store.
+type diskLayer struct {
+	diskdb tstdb.Database // Key-value store containing the base snapshot
+	triedb *trie.Database // Trie node cache for reconstructing uncovered data
+	root   common.Hash    // Root hash of the base snapshot
+	stale  bool           // Signals that the layer became stale (state progressed)
+
+	genMarker []byte           // Last account hash covered by the generator (nil = done, empty = nothing)
+	genAbort  chan chan []byte // Notification channel to abort generating the snapshot in this layer
+
+	lock sync.RWMutex
+}
+
+// Root returns the root hash for which this snapshot was made.
+func (dl *diskLayer) Root() common.Hash {
+	return dl.root
+}
+
+// Parent always returns nil as there's no layer below the disk.
+func (dl *diskLayer) Parent() snapshot {
+	return nil
+}
+
+// Stale returns true if this layer has become stale.
+func (dl *diskLayer) Stale() bool {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.stale
+}
+
+// markStale flags the layer as stale, rejecting any further reads.
+func (dl *diskLayer) markStale() {
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	dl.stale = true
+}
+
+// covered returns true if the data of the given account is already present in
+// the persistent snapshot, i.e. the generator has already passed over it.
+func covered(marker []byte, accountHash common.Hash) bool {
+	return marker == nil || bytes.Compare(accountHash[:], marker) <= 0
+}
+
+// Account directly retrieves the RLP encoded account associated with a
+// particular hash in the snapshot.
+func (dl *diskLayer) Account(hash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if dl.stale {
+		return nil, ErrSnapshotStale
+	}
+	if !covered(dl.genMarker, hash) {
+		return nil, ErrNotCoveredYet
+	}
+	blob, _ := dl.diskdb.Get(accountKey(hash))
+	return blob, nil
+}
+
+// Storage directly retrieves the RLP encoded storage slot associated with a
+// particular hash within a particular account.
+func (dl *diskLayer) Storage(accountHash, storageHash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if dl.stale {
+		return nil, ErrSnapshotStale
+	}
+	if !covered(dl.genMarker, accountHash) {
+		return nil, ErrNotCoveredYet
+	}
+	blob, _ := dl.diskdb.Get(storageKey(accountHash, storageHash))
+	return blob, nil
+}
+
+// stopGeneration aborts the background snapshot generation of the layer, if
+// it is still running, and returns the last account hash covered.
+func (dl *diskLayer) stopGeneration() []byte {
+	dl.lock.RLock()
+	abort := dl.genAbort
+	dl.lock.RUnlock()
+
+	if abort != nil {
+		stop := make(chan []byte)
+		abort <- stop
+		marker := <-stop
+
+		dl.lock.Lock()
+		dl.genMarker, dl.genAbort = marker, nil
+		dl.lock.Unlock()
+	}
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.genMarker
+}
+
+// diffToDisk merges a bottom-most diff layer into the persistent disk layer
+// below it, invalidating the old one and returning the new disk layer. Data not
+// yet covered by an in-progress generation is skipped, as the resumed generator
+// will pick it up from the new state root.
+func diffToDisk(base *diskLayer, bottom *diffLayer) *diskLayer {
+	marker := base.stopGeneration()
+	base.markStale()
+
+	bottom.lock.RLock()
+	defer bottom.lock.RUnlock()
+
+	batch := base.diskdb.NewBatch()
+	flush := func() {
+		if batch.ValueSize() >= tstdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				log.Crit("Failed to write snapshot", "err", err)
+			}
+			batch.Reset()
+		}
+	}
+	// Drop all the destructed accounts along with their storage
+	for hash := range bottom.destructSet {
+		if !covered(marker, hash) {
+			continue
+		}
+		batch.Delete(accountKey(hash))
+
+		it := base.diskdb.NewIterator(append(append([]byte{}, storagePrefix...), hash[:]...), nil)
+		for it.Next() {
+			batch.Delete(common.CopyBytes(it.Key()))
+			flush()
+		}
+		it.Release()
+	}
+	// Push all updated accounts and storage slots into the database
+	for hash, data := range bottom.accountData {
+		if !covered(marker, hash) {
+			continue
+		}
+		if len(data) == 0 {
+			batch.Delete(accountKey(hash))
+		} else {
+			batch.Put(accountKey(hash), data)
+		}
+		flush()
+	}
+	for accountHash, storage := range bottom.storageData {
+		if !covered(marker, accountHash) {
+			continue
+		}
+		for storageHash, data := range storage {
+			if len(data) == 0 {
+				batch.Delete(storageKey(accountHash, storageHash))
+			} else {
+				batch.Put(storageKey(accountHash, storageHash), data)
+			}
+		}
+		flush()
+	}
+	// Update the snapshot metadata and persist the whole lot
+	batch.Put(snapshotRootKey, bottom.root[:])
+	if marker == nil {
+		batch.Delete(snapshotGeneratorKey)
+	} else {
+		batch.Put(snapshotGeneratorKey, marker)
+	}
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to write snapshot", "err", err)
+	}
+	res := &diskLayer{
+		diskdb:    base.diskdb,
+		triedb:    base.triedb,
+		root:      bottom.root,
+		genMarker: marker,
+	}
+	if marker != nil {
+		res.genAbort = make(chan chan []byte)
+		go res.generate(common.CopyBytes(marker), res.genAbort)
+	}
+	return res
+}
@@ -0,0 +1,215 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"math/big"
+	"time"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/log"
+	"github.com/utchain/go-utchain/rlp"
+	"github.com/utchain/go-utchain/trie"
+	"github.com/utchain/go-utchain/tstdb"
+)
+
+// emptyRoot is the known root hash of an empty trie.
+var emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+// account is the consensus representation of an account, mirroring state.Account,
+// needed to find the storage trie of each account during generation.
+type account struct {
+	Nonce    uint64
+	Balance  *big.Int
+	Root     common.Hash
+	CodeHash []byte
+}
+
+// generateSnapshot regenerates a brand new snapshot based on an existing state
+// database and head block asynchronously. The snapshot is returned immediately
+// and generation is continued in the background until done.
+func generateSnapshot(diskdb tstdb.Database, triedb *trie.Database, root common.Hash) *diskLayer {
+	// Persist the new snapshot root with an empty generator marker, so that an
+	// interruption restarts the generation from scratch
+	batch := diskdb.NewBatch()
+	batch.Put(snapshotRootKey, root[:])
+	batch.Put(snapshotGeneratorKey, []byte{})
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to write snapshot generator", "err", err)
+	}
+	base := &diskLayer{
+		diskdb:    diskdb,
+		triedb:    triedb,
+		root:      root,
+		genMarker: []byte{},
+		genAbort:  make(chan chan []byte),
+	}
+	go base.generate([]byte{}, base.genAbort)
+	return base
+}
+
+// wipeSnapshot deletes all the snapshot accounts and storage slots from the
+// database.
+func wipeSnapshot(diskdb tstdb.Database) error {
+	batch := diskdb.NewBatch()
+	for _, prefix := range [][]byte{accountPrefix, storagePrefix} {
+		it := diskdb.NewIterator(prefix, nil)
+		for it.Next() {
+			// Only delete the snapshot entries, skip anything sharing the prefix
+			if key := it.Key(); len(key) == len(prefix)+common.HashLength || len(key) == len(prefix)+2*common.HashLength {
+				batch.Delete(common.CopyBytes(key))
+			}
+			if batch.ValueSize() >= tstdb.IdealBatchSize {
+				if err := batch.Write(); err != nil {
+					it.Release()
+					return err
+				}
+				batch.Reset()
+			}
+		}
+		it.Release()
+	}
+	return batch.Write()
+}
+
+// generate is a background thread that iterates over the state and storage tries
+// of the disk layer, constructing the flat snapshot from the account following
+// the given marker onwards. It keeps running until done or aborted, at which
+// point the last covered account is reported back on the abort channel.
+func (dl *diskLayer) generate(marker []byte, abort chan chan []byte) {
+	var (
+		start  = time.Now()
+		logged = time.Now()
+		count  int
+	)
+	// Flushing persists the generated data along with the progress marker, after
+	// which it becomes readable through the disk layer
+	batch := dl.diskdb.NewBatch()
+	flush := func(marker []byte) {
+		batch.Put(snapshotGeneratorKey, marker)
+		if err := batch.Write(); err != nil {
+			log.Crit("Failed to write snapshot", "err", err)
+		}
+		batch.Reset()
+
+		dl.lock.Lock()
+		dl.genMarker = marker
+		dl.lock.Unlock()
+	}
+	// park waits for the abort signal once no further progress can be made
+	park := func() {
+		dl.lock.RLock()
+		marker := dl.genMarker
+		dl.lock.RUnlock()
+
+		stop := <-abort
+		stop <- marker
+	}
+	if len(marker) == 0 {
+		if err := wipeSnapshot(dl.diskdb); err != nil {
+			log.Error("Failed to wipe stale snapshot", "err", err)
+			park()
+			return
+		}
+	}
+	accTrie, err := trie.NewSecure(dl.root, dl.triedb, 0)
+	if err != nil {
+		log.Error("Snapshot generator missing state", "root", dl.root, "err", err)
+		park()
+		return
+	}
+	log.Info("Generating state snapshot", "root", dl.root, "at", common.BytesToHash(marker))
+
+	it := trie.NewIterator(accTrie.NodeIterator(marker))
+	for it.Next() {
+		// The account at the marker itself was already covered
+		if bytes.Equal(it.Key, marker) {
+			continue
+		}
+		accountHash := common.BytesToHash(it.Key)
+		batch.Put(accountKey(accountHash), it.Value)
+
+		var acc account
+		if err := rlp.DecodeBytes(it.Value, &acc); err != nil {
+			log.Error("Invalid account encountered during snapshot generation", "hash", accountHash, "err", err)
+			park()
+			return
+		}
+		if acc.Root != emptyRoot {
+			storeTrie, err := trie.NewSecure(acc.Root, dl.triedb, 0)
+			if err != nil {
+				log.Error("Snapshot generator missing storage", "hash", accountHash, "root", acc.Root, "err", err)
+				park()
+				return
+			}
+			storeIt := trie.NewIterator(storeTrie.NodeIterator(nil))
+			for storeIt.Next() {
+				batch.Put(storageKey(accountHash, common.BytesToHash(storeIt.Key)), storeIt.Value)
+				if batch.ValueSize() >= tstdb.IdealBatchSize {
+					if err := batch.Write(); err != nil {
+						log.Crit("Failed to write snapshot", "err", err)
+					}
+					batch.Reset()
+				}
+			}
+			if storeIt.Err != nil {
+				log.Error("Snapshot generator failed to iterate storage", "hash", accountHash, "err", storeIt.Err)
+				park()
+				return
+			}
+		}
+		count++
+		marker = common.CopyBytes(it.Key)
+
+		// Account fully written, make it available if the batch grew large enough
+		if batch.ValueSize() >= tstdb.IdealBatchSize {
+			flush(marker)
+		}
+		select {
+		case stop := <-abort:
+			flush(marker)
+			log.Info("Aborted state snapshot generation", "root", dl.root, "accounts", count, "at", accountHash, "elapsed", common.PrettyDuration(time.Since(start)))
+			stop <- marker
+			return
+		default:
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Generating state snapshot", "root", dl.root, "accounts", count, "at", accountHash, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if it.Err != nil {
+		log.Error("Snapshot generator failed to iterate state", "root", dl.root, "err", it.Err)
+		flush(marker)
+		park()
+		return
+	}
+	// Snapshot fully generated, drop the marker and wait for termination
+	batch.Delete(snapshotGeneratorKey)
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to write snapshot", "err", err)
+	}
+	dl.lock.Lock()
+	dl.genMarker = nil
+	dl.lock.Unlock()
+
+	log.Info("Generated state snapshot", "root", dl.root, "accounts", count, "elapsed", common.PrettyDuration(time.Since(start)))
+
+	stop := <-abort
+	stop <- nil
+}
@@ -0,0 +1,288 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package snapshot implements a flat, layered view of the UTChain state.
+//
+// The bottom layer is a flat key/value copy of the accounts and storage slots of
+// a single state root persisted into the database. On top of it, in-memory diff
+// layers track the changes of each recent block, allowing reads of any recent
+// state to skip the trie traversal altogether.
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/log"
+	"github.com/utchain/go-utchain/trie"
+	"github.com/utchain/go-utchain/tstdb"
+)
+
+var (
+	// ErrSnapshotStale is returned from data accessors if the underlying snapshot
+	// layer had been invalidated due to the chain progressing forward far enough
+	// to not maintain the layer's original state.
+	ErrSnapshotStale = errors.New("snapshot stale")
+
+	// ErrNotCoveredYet is returned from data accessors if the underlying snapshot
+	// is being generated currently and the requested data item is not yet in the
+	// range of accounts covered.
+	ErrNotCoveredYet = errors.New("not covered yet")
+
+	// errSnapshotCycle is returned if a snapshot is attempted to be inserted
+	// that forms a cycle in the snapshot tree.
+	errSnapshotCycle = errors.New("snapshot cycle")
+)
+
+var (
+	// Snapshot database schema (single byte prefixes that don't collide with the
+	// chain data ones in core/database_util.go).
+	accountPrefix = []byte("a") // accountPrefix + account hash -> account trie value
+	storagePrefix = []byte("o") // storagePrefix + account hash + storage hash -> storage trie value
+
+	snapshotRootKey      = []byte("SnapshotRoot")      // State root the persisted snapshot belongs to
+	snapshotGeneratorKey = []byte("SnapshotGenerator") // Present while the persisted snapshot is incomplete
+)
+
+// accountKey = accountPrefix + hash
+func accountKey(hash common.Hash) []byte {
+	return append(append([]byte{}, accountPrefix...), hash[:]...)
+}
+
+// storageKey = storagePrefix + account hash + storage hash
+func storageKey(accountHash, storageHash common.Hash) []byte {
+	return append(append(append([]byte{}, storagePrefix...), accountHash[:]...), storageHash[:]...)
+}
+
+// Snapshot represents the functionality supported by a snapshot storage layer.
+type Snapshot interface {
+	// Root returns the root hash for which this snapshot was made.
+	Root() common.Hash
+
+	// Account directly retrieves the RLP encoded account associated with a
+	// particular hash in the snapshot. A nil blob means the account is missing.
+	Account(hash common.Hash) ([]byte, error)
+
+	// Storage directly retrieves the RLP encoded storage slot associated with a
+	// particular hash within a particular account. A nil blob means the slot is
+	// missing.
+	Storage(accountHash, storageHash common.Hash) ([]byte, error)
+}
+
+// snapshot is the internal version of the snapshot data layer that supports some
+// additional methods compared to the public API.
+type snapshot interface {
+	Snapshot
+
+	// Parent returns the subsequent layer of a snapshot, or nil if the base was
+	// reached.
+	Parent() snapshot
+
+	// Stale returns true if this layer has become stale, i.e. it was flattened
+	// into the disk layer or discarded as part of a side chain.
+	Stale() bool
+}
+
+// Tree is a layered view of recent states. The bottom is a single persistent
+// disk layer, on top of which a tree of in-memory diff layers tracks the state
+// changes of the recent blocks, including any side chains.
+type Tree struct {
+	diskdb tstdb.Database           // Persistent store for the bottom layer
+	triedb *trie.Database           // Trie database to generate the snapshot from
+	layers map[common.Hash]snapshot // Collection of all known layers
+	lock   sync.RWMutex
+}
+
+// New attempts to load an already existing snapshot from a persistent database.
+// If the snapshot is missing, incomplete or belongs to a different state root,
+// it is wiped and regenerated in the background from the state trie. Until then,
+// reads not yet covered return ErrNotCoveredYet. Interrupted generation of the
+// requested root is resumed.
+func New(diskdb tstdb.Database, triedb *trie.Database, root common.Hash) *Tree {
+	var base *diskLayer
+	if stored, _ := diskdb.Get(snapshotRootKey); len(stored) == common.HashLength && common.BytesToHash(stored) == root {
+		base = &diskLayer{diskdb: diskdb, triedb: triedb, root: root}
+		if generating, _ := diskdb.Has(snapshotGeneratorKey); generating {
+			// Snapshot generation was interrupted, resume where it left off
+			marker, _ := diskdb.Get(snapshotGeneratorKey)
+			log.Info("Resuming state snapshot generation", "root", root, "at", common.BytesToHash(marker))
+
+			base.genMarker, base.genAbort = common.CopyBytes(marker), make(chan chan []byte)
+			if base.genMarker == nil {
+				base.genMarker = []byte{}
+			}
+			go base.generate(common.CopyBytes(base.genMarker), base.genAbort)
+		} else {
+			log.Info("Loaded state snapshot", "root", root)
+		}
+	} else {
+		log.Info("Regenerating state snapshot", "root", root)
+		base = generateSnapshot(diskdb, triedb, root)
+	}
+	return &Tree{
+		diskdb: diskdb,
+		triedb: triedb,
+		layers: map[common.Hash]snapshot{root: base},
+	}
+}
+
+// Snapshot retrieves a snapshot belonging to the given state root, or nil if no
+// snapshot is maintained for that state.
+func (t *Tree) Snapshot(root common.Hash) Snapshot {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if layer, ok := t.layers[root]; ok {
+		return layer
+	}
+	return nil
+}
+
+// Update adds a new snapshot into the tree, if that can be linked to an existing
+// old parent. It is disallowed to insert a disk layer (the origin of all).
+func (t *Tree) Update(blockRoot common.Hash, parentRoot common.Hash, destructs map[common.Hash]struct{}, accounts map[common.Hash][]byte, storage map[common.Hash]map[common.Hash][]byte) error {
+	// Reject noop updates to avoid self-loops in the snapshot tree
+	if blockRoot == parentRoot {
+		return errSnapshotCycle
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// The same state may be committed multiple times (e.g. mined and imported)
+	if _, ok := t.layers[blockRoot]; ok {
+		return nil
+	}
+	parent, ok := t.layers[parentRoot]
+	if !ok {
+		return fmt.Errorf("parent [%#x] snapshot missing", parentRoot)
+	}
+	t.layers[blockRoot] = newDiffLayer(parent, blockRoot, destructs, accounts, storage)
+	return nil
+}
+
+// Cap traverses downwards the snapshot tree from a head block hash until the
+// number of allowed layers are crossed. All layers beyond the permitted number
+// are flattened downwards into the disk layer. If layers is zero, the entire
+// tree is flattened, making the given root the new disk layer.
+func (t *Tree) Cap(root common.Hash, layers int) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	snap, ok := t.layers[root]
+	if !ok {
+		return fmt.Errorf("snapshot [%#x] missing", root)
+	}
+	diff, ok := snap.(*diffLayer)
+	if !ok {
+		return nil // Already the disk layer, nothing to cap
+	}
+	// Find the lowest diff layer to retain (or the one to flatten if layers == 0)
+	var chain []*diffLayer
+	if layers == 0 {
+		chain = append(chain, diff)
+	}
+	for i := 0; i < layers-1; i++ {
+		parent, ok := diff.Parent().(*diffLayer)
+		if !ok {
+			return nil // Fewer diff layers than the limit
+		}
+		diff = parent
+	}
+	// Collect all the diff layers below the retained ones, bottom-most last
+	for parent, ok := diff.Parent().(*diffLayer); ok; parent, ok = parent.Parent().(*diffLayer) {
+		chain = append(chain, parent)
+	}
+	if len(chain) == 0 {
+		return nil
+	}
+	// Flatten the collected layers into the disk one by one, starting at the bottom
+	base := chain[len(chain)-1].Parent().(*diskLayer)
+	for i := len(chain) - 1; i >= 0; i-- {
+		base = diffToDisk(base, chain[i])
+	}
+	if layers > 0 {
+		diff.lock.Lock()
+		diff.parent = base
+		diff.lock.Unlock()
+	}
+	// Drop all the layers that are not descendants of the new disk layer anymore
+	t.layers[base.root] = base
+	for root, layer := range t.layers {
+		if !t.linked(layer, base) {
+			if diff, ok := layer.(*diffLayer); ok {
+				diff.markStale()
+			}
+			delete(t.layers, root)
+		}
+	}
+	return nil
+}
+
+// linked returns true if the given layer is built on top of the given disk layer.
+func (t *Tree) linked(layer snapshot, base *diskLayer) bool {
+	for ; layer != nil; layer = layer.Parent() {
+		if layer.Stale() {
+			return false
+		}
+		if disk, ok := layer.(*diskLayer); ok {
+			return disk == base
+		}
+	}
+	return false
+}
+
+// Disable stops any background snapshot generation and discards all the layers,
+// leaving an empty tree that serves no state. The persisted snapshot is kept, New
+// resuming or regenerating it on the next startup.
+func (t *Tree) Disable() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.release()
+	t.layers = make(map[common.Hash]snapshot)
+}
+
+// Disabled returns whether the tree was emptied by Disable.
+func (t *Tree) Disabled() bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return len(t.layers) == 0
+}
+
+// Release stops any background snapshot generation. The tree must not be used
+// afterwards.
+func (t *Tree) Release() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.release()
+}
+
+// release is the internal version of Release, assuming the lock is held.
+func (t *Tree) release() {
+	for _, layer := range t.layers {
+		switch layer := layer.(type) {
+		case *diskLayer:
+			layer.stopGeneration()
+			layer.markStale()
+		case *diffLayer:
+			layer.markStale()
+		}
+	}
+}
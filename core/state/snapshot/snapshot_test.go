@@ -0,0 +1,223 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/crypto"
+	"github.com/utchain/go-utchain/rlp"
+	"github.com/utchain/go-utchain/trie"
+	"github.com/utchain/go-utchain/tstdb"
+)
+
+// waitGeneration blocks until the background generation of a disk layer is done.
+func waitGeneration(t *testing.T, layer snapshot) {
+	dl := layer.(*diskLayer)
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		dl.lock.RLock()
+		done := dl.genMarker == nil
+		dl.lock.RUnlock()
+		if done {
+			return
+		}
+	}
+	t.Fatalf("snapshot generation timed out")
+}
+
+// Tests that a snapshot is generated from the account and storage tries, and
+// that it is loaded without regeneration afterwards.
+func TestGeneration(t *testing.T) {
+	var (
+		diskdb, _ = tstdb.NewMemDatabase()
+		triedb    = trie.NewDatabase(diskdb)
+	)
+	// Create a storage trie and two accounts, one of them with storage
+	storage, _ := trie.NewSecure(common.Hash{}, triedb, 0)
+	slots := make(map[common.Hash][]byte)
+	for i := byte(1); i <= 3; i++ {
+		key := common.Hash{i}
+		val, _ := rlp.EncodeToBytes([]byte{i})
+		storage.Update(key[:], val)
+		slots[crypto.Keccak256Hash(key[:])] = val
+	}
+	storageRoot, _ := storage.Commit(nil)
+
+	accTrie, _ := trie.NewSecure(common.Hash{}, triedb, 0)
+	accounts := make(map[common.Hash][]byte)
+	for i, root := range []common.Hash{emptyRoot, storageRoot} {
+		addr := common.Address{byte(i + 1)}
+		blob, _ := rlp.EncodeToBytes(&account{Nonce: uint64(i), Balance: big.NewInt(int64(i)), Root: root, CodeHash: crypto.Keccak256(nil)})
+		accTrie.Update(addr[:], blob)
+		accounts[crypto.Keccak256Hash(addr[:])] = blob
+	}
+	root, _ := accTrie.Commit(nil)
+	if err := triedb.Commit(root, false); err != nil {
+		t.Fatalf("failed to commit tries: %v", err)
+	}
+	// Generate the snapshot and verify its content
+	snaps := New(diskdb, triedb, root)
+	waitGeneration(t, snaps.layers[root])
+
+	snap := snaps.Snapshot(root)
+	for hash, want := range accounts {
+		if blob, err := snap.Account(hash); err != nil || !bytes.Equal(blob, want) {
+			t.Errorf("account %x: have %x, %v, want %x", hash, blob, err, want)
+		}
+	}
+	accHash := crypto.Keccak256Hash(common.Address{2}.Bytes())
+	for hash, want := range slots {
+		if blob, err := snap.Storage(accHash, hash); err != nil || !bytes.Equal(blob, want) {
+			t.Errorf("slot %x: have %x, %v, want %x", hash, blob, err, want)
+		}
+	}
+	if blob, err := snap.Account(common.Hash{0xff}); err != nil || blob != nil {
+		t.Errorf("missing account: have %x, %v, want nil", blob, err)
+	}
+	snaps.Release()
+
+	// Reload the snapshot, it must not need regeneration
+	snaps = New(diskdb, triedb, root)
+	if marker := snaps.layers[root].(*diskLayer).genMarker; marker != nil {
+		t.Fatalf("complete snapshot regenerated, marker %x", marker)
+	}
+	if _, err := snaps.Snapshot(root).Account(accHash); err != nil {
+		t.Errorf("failed to read reloaded snapshot: %v", err)
+	}
+	snaps.Release()
+}
+
+// Tests that diff layers resolve reads through their parents, honouring any
+// deletions and account destructions.
+func TestDiffLayerReads(t *testing.T) {
+	diskdb, _ := tstdb.NewMemDatabase()
+	diskdb.Put(accountKey(common.Hash{0x01}), []byte{0x01})
+	diskdb.Put(accountKey(common.Hash{0x02}), []byte{0x02})
+	diskdb.Put(storageKey(common.Hash{0x02}, common.Hash{0x01}), []byte{0x21})
+
+	base := common.Hash{0xb0}
+	diskdb.Put(snapshotRootKey, base[:])
+	snaps := New(diskdb, trie.NewDatabase(diskdb), base)
+	defer snaps.Release()
+
+	// Modify the first account, destruct and recreate the second one
+	first, second := common.Hash{0xb1}, common.Hash{0xb2}
+	if err := snaps.Update(first, base, nil, map[common.Hash][]byte{{0x01}: {0x11}}, nil); err != nil {
+		t.Fatalf("failed to add first diff: %v", err)
+	}
+	if err := snaps.Update(second, first, map[common.Hash]struct{}{{0x02}: {}}, map[common.Hash][]byte{{0x02}: {0x12}}, map[common.Hash]map[common.Hash][]byte{{0x02}: {{0x02}: {0x22}}}); err != nil {
+		t.Fatalf("failed to add second diff: %v", err)
+	}
+	if err := snaps.Update(common.Hash{0xff}, common.Hash{0xee}, nil, nil, nil); err == nil {
+		t.Errorf("dangling diff layer accepted")
+	}
+	tests := []struct {
+		root    common.Hash
+		account common.Hash
+		slot    *common.Hash
+		want    []byte
+	}{
+		{base, common.Hash{0x01}, nil, []byte{0x01}},
+		{first, common.Hash{0x01}, nil, []byte{0x11}},
+		{second, common.Hash{0x01}, nil, []byte{0x11}},
+		{first, common.Hash{0x02}, nil, []byte{0x02}},
+		{second, common.Hash{0x02}, nil, []byte{0x12}},
+		{first, common.Hash{0x02}, &common.Hash{0x01}, []byte{0x21}},
+		{second, common.Hash{0x02}, &common.Hash{0x01}, nil},
+		{second, common.Hash{0x02}, &common.Hash{0x02}, []byte{0x22}},
+		{second, common.Hash{0x03}, nil, nil},
+	}
+	for i, tt := range tests {
+		var (
+			blob []byte
+			err  error
+		)
+		if tt.slot == nil {
+			blob, err = snaps.Snapshot(tt.root).Account(tt.account)
+		} else {
+			blob, err = snaps.Snapshot(tt.root).Storage(tt.account, *tt.slot)
+		}
+		if err != nil || !bytes.Equal(blob, tt.want) {
+			t.Errorf("test %d: have %x, %v, want %x", i, blob, err, tt.want)
+		}
+	}
+}
+
+// Tests that capping the snapshot tree flattens the old diff layers into the
+// disk layer, and discards the layers not built on top of the retained ones.
+func TestCap(t *testing.T) {
+	diskdb, _ := tstdb.NewMemDatabase()
+	diskdb.Put(storageKey(common.Hash{0x01}, common.Hash{0x01}), []byte{0x01})
+
+	base := common.Hash{0xb0}
+	diskdb.Put(snapshotRootKey, base[:])
+	snaps := New(diskdb, trie.NewDatabase(diskdb), base)
+	defer snaps.Release()
+
+	// Create a chain of three diffs, with a side chain forking off the first one
+	roots := []common.Hash{base, {0xb1}, {0xb2}, {0xb3}}
+	for i := 1; i < len(roots); i++ {
+		accounts := map[common.Hash][]byte{{byte(i)}: {byte(i)}}
+		if err := snaps.Update(roots[i], roots[i-1], nil, accounts, nil); err != nil {
+			t.Fatalf("failed to add diff %d: %v", i, err)
+		}
+	}
+	side := common.Hash{0xc2}
+	if err := snaps.Update(side, roots[1], map[common.Hash]struct{}{{0x01}: {}}, nil, nil); err != nil {
+		t.Fatalf("failed to add side diff: %v", err)
+	}
+	// Retain a single diff layer, flattening the rest
+	stale := snaps.Snapshot(roots[1])
+	if err := snaps.Cap(roots[3], 1); err != nil {
+		t.Fatalf("failed to cap snapshot tree: %v", err)
+	}
+	if len(snaps.layers) != 2 {
+		t.Errorf("layer count mismatch: have %d, want 2", len(snaps.layers))
+	}
+	if _, err := stale.Account(common.Hash{0x01}); err != ErrSnapshotStale {
+		t.Errorf("flattened layer error mismatch: have %v, want %v", err, ErrSnapshotStale)
+	}
+	if snaps.Snapshot(side) != nil {
+		t.Errorf("side chain layer retained")
+	}
+	disk, ok := snaps.Snapshot(roots[2]).(*diskLayer)
+	if !ok {
+		t.Fatalf("flattened layer is not the disk layer")
+	}
+	for i := byte(1); i <= 2; i++ {
+		if blob, _ := disk.Account(common.Hash{i}); !bytes.Equal(blob, []byte{i}) {
+			t.Errorf("account %d: have %x, want %x", i, blob, []byte{i})
+		}
+	}
+	if blob, _ := snaps.Snapshot(roots[3]).Account(common.Hash{0x03}); !bytes.Equal(blob, []byte{0x03}) {
+		t.Errorf("retained diff account: have %x, want %x", blob, []byte{0x03})
+	}
+	if stored, _ := diskdb.Get(snapshotRootKey); common.BytesToHash(stored) != roots[2] {
+		t.Errorf("persisted root mismatch: have %x, want %x", stored, roots[2])
+	}
+	// Flatten everything, the storage of the untouched account must survive
+	if err := snaps.Cap(roots[3], 0); err != nil {
+		t.Fatalf("failed to flatten snapshot tree: %v", err)
+	}
+	if blob, _ := snaps.Snapshot(roots[3]).Storage(common.Hash{0x01}, common.Hash{0x01}); !bytes.Equal(blob, []byte{0x01}) {
+		t.Errorf("storage slot: have %x, want %x", blob, []byte{0x01})
+	}
+}
@@ -27,11 +27,16 @@ import (
 	"strings"
 	"testing"
 	"testing/quick"
+	"time"
 
 	check "gopkg.in/check.v1"
 
 	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/state/snapshot"
 	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/crypto"
+	"github.com/utchain/go-utchain/rlp"
+	"github.com/utchain/go-utchain/trie"
 	"github.com/utchain/go-utchain/tstdb"
 )
 
@@ -421,3 +426,202 @@ func (s *StateSuite) TestTouchDelete(c *check.C) {
 		c.Fatal("expected no dirty state object")
 	}
 }
+
+// Tests that account and storage proofs generated from a state can be verified
+// against its root hash.
+func TestStateProofs(t *testing.T) {
+	db, _ := tstdb.NewMemDatabase()
+	state, _ := New(common.Hash{}, NewDatabase(db))
+
+	addr := common.BytesToAddress([]byte{0x01})
+	for i := byte(0); i < 16; i++ {
+		state.AddBalance(common.BytesToAddress([]byte{i}), big.NewInt(int64(i)+1))
+		state.SetState(addr, common.Hash{i}, common.Hash{i + 1})
+	}
+	root, _ := state.Commit(false)
+
+	// Verify the account proof and check the contained storage root
+	proof, err := state.GetProof(addr)
+	if err != nil {
+		t.Fatalf("failed to create account proof: %v", err)
+	}
+	blob, err, _ := trie.VerifyProof(root, crypto.Keccak256(addr.Bytes()), proofDatabase(proof))
+	if err != nil {
+		t.Fatalf("failed to verify account proof: %v", err)
+	}
+	var account Account
+	if err := rlp.DecodeBytes(blob, &account); err != nil {
+		t.Fatalf("failed to decode proven account: %v", err)
+	}
+	if account.Balance.Cmp(big.NewInt(2)) != 0 {
+		t.Errorf("proven balance mismatch: have %v, want %v", account.Balance, 2)
+	}
+	// Verify the storage proof of every slot against the proven storage root
+	for i := byte(0); i < 16; i++ {
+		proof, err := state.GetStorageProof(addr, common.Hash{i})
+		if err != nil {
+			t.Fatalf("slot %d: failed to create storage proof: %v", i, err)
+		}
+		blob, err, _ := trie.VerifyProof(account.Root, crypto.Keccak256(common.Hash{i}.Bytes()), proofDatabase(proof))
+		if err != nil {
+			t.Fatalf("slot %d: failed to verify storage proof: %v", i, err)
+		}
+		var value []byte
+		if err := rlp.DecodeBytes(blob, &value); err != nil {
+			t.Fatalf("slot %d: failed to decode proven value: %v", i, err)
+		}
+		if have, want := common.BytesToHash(value), (common.Hash{i + 1}); have != want {
+			t.Errorf("slot %d: proven value mismatch: have %x, want %x", i, have, want)
+		}
+	}
+	// Storage proofs of non-existent accounts should be rejected
+	if _, err := state.GetStorageProof(common.BytesToAddress([]byte{0xff}), common.Hash{}); err == nil {
+		t.Errorf("storage proof of missing account succeeded")
+	}
+}
+
+// proofDatabase inserts a list of proof nodes into a database keyed by hash.
+func proofDatabase(proof [][]byte) *tstdb.MemDatabase {
+	db, _ := tstdb.NewMemDatabase()
+	for _, node := range proof {
+		db.Put(crypto.Keccak256(node), node)
+	}
+	return db
+}
+
+// Tests that replacing the storage of an account drops all the previous slots
+// and that the new ones end up in the storage root.
+func TestSetStorage(t *testing.T) {
+	db, _ := tstdb.NewMemDatabase()
+	sdb := NewDatabase(db)
+	state, _ := New(common.Hash{}, sdb)
+
+	addr := common.BytesToAddress([]byte{0x01})
+	state.SetState(addr, common.Hash{1}, common.Hash{1})
+	state.SetState(addr, common.Hash{2}, common.Hash{2})
+	root, _ := state.Commit(false)
+
+	state, _ = New(root, sdb)
+	state.SetStorage(addr, map[common.Hash]common.Hash{{2}: {3}, {4}: {4}})
+
+	for key, want := range map[common.Hash]common.Hash{{1}: {}, {2}: {3}, {4}: {4}} {
+		if have := state.GetState(addr, key); have != want {
+			t.Errorf("slot %x: value mismatch: have %x, want %x", key, have, want)
+		}
+	}
+	// Ensure the storage root matches a freshly created account with the same slots
+	fresh, _ := New(common.Hash{}, sdb)
+	fresh.SetState(addr, common.Hash{2}, common.Hash{3})
+	fresh.SetState(addr, common.Hash{4}, common.Hash{4})
+
+	if have, want := state.IntermediateRoot(false), fresh.IntermediateRoot(false); have != want {
+		t.Errorf("state root mismatch: have %x, want %x", have, want)
+	}
+}
+
+// Tests that access list additions are reverted along with the snapshots they
+// were made in and that the list is reset for every transaction.
+func TestAccessListRevert(t *testing.T) {
+	db, _ := tstdb.NewMemDatabase()
+	state, _ := New(common.Hash{}, NewDatabase(db))
+
+	sender, a, b := common.Address{0x01}, common.Address{0x02}, common.Address{0x03}
+	state.PrepareAccessList(sender, &a, nil, types.AccessList{{Address: a, StorageKeys: []common.Hash{{1}}}})
+
+	if !state.AddressInAccessList(sender) || !state.AddressInAccessList(a) {
+		t.Fatalf("sender or recipient missing from access list")
+	}
+	if _, slotOk := state.SlotInAccessList(a, common.Hash{1}); !slotOk {
+		t.Fatalf("declared slot missing from access list")
+	}
+	snap := state.Snapshot()
+	state.AddSlotToAccessList(a, common.Hash{2})
+	state.AddSlotToAccessList(b, common.Hash{1})
+	state.AddAddressToAccessList(common.Address{0x04})
+
+	if addrOk, slotOk := state.SlotInAccessList(b, common.Hash{1}); !addrOk || !slotOk {
+		t.Fatalf("added slot missing from access list")
+	}
+	state.RevertToSnapshot(snap)
+
+	if _, slotOk := state.SlotInAccessList(a, common.Hash{1}); !slotOk {
+		t.Errorf("declared slot reverted")
+	}
+	if _, slotOk := state.SlotInAccessList(a, common.Hash{2}); slotOk {
+		t.Errorf("added slot not reverted")
+	}
+	if state.AddressInAccessList(b) || state.AddressInAccessList(common.Address{0x04}) {
+		t.Errorf("added accounts not reverted")
+	}
+	// The next transaction must start from a fresh list
+	state.PrepareAccessList(b, nil, nil, nil)
+	if state.AddressInAccessList(a) || state.AddressInAccessList(sender) {
+		t.Errorf("access list not reset between transactions")
+	}
+}
+
+func TestSnapshotState(t *testing.T) {
+	db, _ := tstdb.NewMemDatabase()
+	sdb := NewDatabase(db)
+	state, _ := New(common.Hash{}, sdb)
+
+	a, b, c := common.Address{0x01}, common.Address{0x02}, common.Address{0x03}
+	state.SetBalance(a, big.NewInt(1))
+	state.SetState(a, common.Hash{1}, common.Hash{1})
+	state.SetState(a, common.Hash{2}, common.Hash{2})
+	state.SetBalance(b, big.NewInt(2))
+	state.SetState(b, common.Hash{1}, common.Hash{1})
+	root, _ := state.Commit(false)
+
+	// Wait until the snapshot of the committed state is fully generated
+	snaps := snapshot.New(db, sdb.TrieDB(), root)
+	defer snaps.Release()
+
+	last := common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := snaps.Snapshot(root).Account(last); err == nil {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("snapshot generation timed out")
+		}
+	}
+	// Modify the state through the snapshot and commit it as a new diff layer
+	state, _ = NewWithSnapshot(root, sdb, snaps)
+	if have := state.GetState(a, common.Hash{2}); have != (common.Hash{2}) {
+		t.Fatalf("snapshot slot mismatch: have %x, want %x", have, common.Hash{2})
+	}
+	state.SetState(a, common.Hash{1}, common.Hash{})
+	state.SetState(a, common.Hash{3}, common.Hash{3})
+	state.Suicide(b)
+	state.SetBalance(c, big.NewInt(3))
+	root, _ = state.Commit(false)
+	if snaps.Snapshot(root) != nil {
+		t.Fatalf("snapshot layer pushed by a plain commit")
+	}
+	if err := state.UpdateSnapshot(root); err != nil {
+		t.Fatalf("failed to update snapshot: %v", err)
+	}
+	if snaps.Snapshot(root) == nil {
+		t.Fatalf("snapshot layer missing for committed state")
+	}
+	if blob, err := snaps.Snapshot(root).Account(crypto.Keccak256Hash(b[:])); err != nil || blob != nil {
+		t.Errorf("destructed account in snapshot: have %x, %v", blob, err)
+	}
+	// Ensure reads through the snapshot match the ones through the trie
+	snapState, _ := NewWithSnapshot(root, sdb, snaps)
+	trieState, _ := New(root, sdb)
+	for _, addr := range []common.Address{a, b, c} {
+		if have, want := snapState.Exist(addr), trieState.Exist(addr); have != want {
+			t.Errorf("account %x: existence mismatch: have %v, want %v", addr, have, want)
+		}
+		if have, want := snapState.GetBalance(addr), trieState.GetBalance(addr); have.Cmp(want) != 0 {
+			t.Errorf("account %x: balance mismatch: have %v, want %v", addr, have, want)
+		}
+		for i := byte(1); i <= 3; i++ {
+			if have, want := snapState.GetState(addr, common.Hash{i}), trieState.GetState(addr, common.Hash{i}); have != want {
+				t.Errorf("account %x: slot %d mismatch: have %x, want %x", addr, i, have, want)
+			}
+		}
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"math/big"
 
 	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/types"
 	"github.com/utchain/go-utchain/core/vm"
 	"github.com/utchain/go-utchain/log"
 	"github.com/utchain/go-utchain/params"
@@ -67,16 +68,20 @@ type Message interface {
 	To() *common.Address
 
 	GasPrice() *big.Int
+	GasFeeCap() *big.Int
+	GasTipCap() *big.Int
 	Gas() uint64
 	Value() *big.Int
 
 	Nonce() uint64
 	CheckNonce() bool
 	Data() []byte
+	AccessList() types.AccessList
 }
 
-// IntrinsicGas computes the 'intrinsic gas' for a message with the given data.
-func IntrinsicGas(data []byte, contractCreation, homestead bool) (uint64, error) {
+// IntrinsicGas computes the 'intrinsic gas' for a message with the given data
+// and access list.
+func IntrinsicGas(data []byte, accessList types.AccessList, contractCreation, homestead bool) (uint64, error) {
 	// Set the starting gas for the raw transaction
 	var gas uint64
 	if contractCreation && homestead {
@@ -105,16 +110,35 @@ func IntrinsicGas(data []byte, contractCreation, homestead bool) (uint64, error)
 		}
 		gas += z * params.TxDataZeroGas
 	}
+	// Bump the required gas by the accounts and slots of the access list
+	if accessList != nil {
+		gas += uint64(len(accessList)) * params.TxAccessListAddressGas
+		gas += uint64(accessList.StorageKeys()) * params.TxAccessListStorageKeyGas
+	}
 	return gas, nil
 }
 
+// effectiveGasPrice returns the price per gas a message pays in a block with
+// the given base fee: the base fee plus the tip, capped by the fee cap. Without
+// a base fee it is simply the gas price.
+func effectiveGasPrice(msg Message, baseFee *big.Int) *big.Int {
+	if baseFee == nil || msg.GasFeeCap() == nil {
+		return new(big.Int).Set(msg.GasPrice())
+	}
+	price := new(big.Int).Add(baseFee, msg.GasTipCap())
+	if price.Cmp(msg.GasFeeCap()) > 0 {
+		price.Set(msg.GasFeeCap())
+	}
+	return price
+}
+
 // NewStateTransition initialises and returns a new state transition object.
 func NewStateTransition(evm *vm.EVM, msg Message, gp *GasPool) *StateTransition {
 	return &StateTransition{
 		gp:       gp,
 		evm:      evm,
 		msg:      msg,
-		gasPrice: msg.GasPrice(),
+		gasPrice: effectiveGasPrice(msg, evm.BaseFee),
 		value:    msg.Value(),
 		data:     msg.Data(),
 		state:    evm.StateDB,
@@ -171,7 +195,15 @@ func (st *StateTransition) buyGas() error {
 		sender = st.from()
 	)
 	mgval := new(big.Int).Mul(new(big.Int).SetUint64(st.msg.Gas()), st.gasPrice)
-	if state.GetBalance(sender.Address()).Cmp(mgval) < 0 {
+
+	// The balance has to cover the fee cap even if the effective price is lower,
+	// along with the transferred value
+	balanceCheck := mgval
+	if st.evm.BaseFee != nil {
+		balanceCheck = new(big.Int).Mul(new(big.Int).SetUint64(st.msg.Gas()), st.feeCap())
+		balanceCheck.Add(balanceCheck, st.msg.Value())
+	}
+	if state.GetBalance(sender.Address()).Cmp(balanceCheck) < 0 {
 		return errInsufficientBalanceForGas
 	}
 	if err := st.gp.SubGas(st.msg.Gas()); err != nil {
@@ -197,9 +229,21 @@ func (st *StateTransition) preCheck() error {
 			return ErrNonceTooLow
 		}
 	}
+	// Make sure the transaction pays at least the base fee
+	if st.evm.BaseFee != nil && st.feeCap().Cmp(st.evm.BaseFee) < 0 {
+		return ErrFeeCapTooLow
+	}
 	return st.buyGas()
 }
 
+// feeCap returns the maximum price per gas the message is willing to pay.
+func (st *StateTransition) feeCap() *big.Int {
+	if feeCap := st.msg.GasFeeCap(); feeCap != nil {
+		return feeCap
+	}
+	return st.msg.GasPrice()
+}
+
 // TransitionDb will transition the state by applying the current message and
 // returning the result including the the used gas. It returns an error if it
 // failed. An error indicates a consensus issue.
@@ -214,7 +258,7 @@ func (st *StateTransition) TransitionDb() (ret []byte, usedGas uint64, failed bo
 	contractCreation := msg.To() == nil
 
 	// Pay intrinsic gas
-	gas, err := IntrinsicGas(st.data, contractCreation, homestead)
+	gas, err := IntrinsicGas(st.data, msg.AccessList(), contractCreation, homestead)
 	if err != nil {
 		return nil, 0, false, err
 	}
@@ -229,6 +273,10 @@ func (st *StateTransition) TransitionDb() (ret []byte, usedGas uint64, failed bo
 		// error.
 		vmerr error
 	)
+	// Warm up the accounts and slots accessible from the start of the transaction
+	if rules := evm.ChainConfig().Rules(evm.BlockNumber); rules.IsBerlin {
+		st.state.PrepareAccessList(sender.Address(), msg.To(), vm.ActivePrecompiles(rules), msg.AccessList())
+	}
 	if contractCreation {
 		ret, _, st.gas, vmerr = evm.Create(sender, st.data, st.gas, st.value)
 	} else {
@@ -246,7 +294,13 @@ func (st *StateTransition) TransitionDb() (ret []byte, usedGas uint64, failed bo
 		}
 	}
 	st.refundGas()
-	st.state.AddBalance(st.evm.Coinbase, new(big.Int).Mul(new(big.Int).SetUint64(st.gasUsed()), st.gasPrice))
+
+	// Pay the miner, burning the base fee part of the price if there is one
+	tip := st.gasPrice
+	if st.evm.BaseFee != nil {
+		tip = new(big.Int).Sub(st.gasPrice, st.evm.BaseFee)
+	}
+	st.state.AddBalance(st.evm.Coinbase, new(big.Int).Mul(new(big.Int).SetUint64(st.gasUsed()), tip))
 
 	return ret, st.gasUsed(), vmerr != nil, err
 }
@@ -0,0 +1,231 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"time"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/event"
+)
+
+// droppedTxCacheSize is the number of recently dropped transactions the pool
+// remembers the drop reasons of.
+const droppedTxCacheSize = 4096
+
+// TxDropReason describes why a transaction was removed from the pool without
+// being included in the chain.
+type TxDropReason string
+
+const (
+	// TxDropUnderpriced is used if the transaction was evicted from a full pool
+	// to make room for better paying ones, or its price fell below the minimum.
+	TxDropUnderpriced TxDropReason = "underpriced"
+
+	// TxDropReplaced is used if the transaction was replaced by another one with
+	// the same nonce and a sufficient price bump (or lost against an already
+	// pending one with the same nonce).
+	TxDropReplaced TxDropReason = "replaced"
+
+	// TxDropNonceTooLow is used if the nonce of the transaction was consumed by
+	// a different transaction included in the chain.
+	TxDropNonceTooLow TxDropReason = "nonce too low"
+
+	// TxDropDeepReorg is used if the nonce of the transaction was consumed during
+	// a reorg too deep for the pool to track, so it cannot tell whether it was
+	// the transaction itself or a different one that got included.
+	TxDropDeepReorg TxDropReason = "nonce consumed by deep reorg"
+
+	// TxDropUnpayable is used if the sender cannot pay for the transaction any
+	// more, or its gas exceeds the block gas limit.
+	TxDropUnpayable TxDropReason = "unpayable"
+
+	// TxDropAccountQueueFull is used if the account had more non-executable
+	// transactions than permitted by TxPoolConfig.AccountQueue.
+	TxDropAccountQueueFull TxDropReason = "account queue full"
+
+	// TxDropGlobalQueueFull is used if all accounts together had more
+	// non-executable transactions than permitted by TxPoolConfig.GlobalQueue.
+	TxDropGlobalQueueFull TxDropReason = "global queue full"
+
+	// TxDropPendingFull is used if the executable transactions exceeded
+	// TxPoolConfig.GlobalSlots and the account was above TxPoolConfig.AccountSlots.
+	TxDropPendingFull TxDropReason = "pending pool full"
+
+	// TxDropLifetime is used if the account had no activity for longer than
+	// TxPoolConfig.Lifetime while having non-executable transactions.
+	TxDropLifetime TxDropReason = "lifetime expired"
+
+	// TxDropPrivateExpired is used if a private transaction was not included
+	// until its expiry block and was not allowed to fall back to public gossip.
+	TxDropPrivateExpired TxDropReason = "private expired"
+)
+
+// TxExplanation describes why a transaction is in the state it is in the pool.
+type TxExplanation struct {
+	Hash    common.Hash        // Hash of the explained transaction
+	Tx      *types.Transaction // Transaction explained, nil if unknown
+	From    common.Address     // Sender of the transaction
+	Status  TxStatus           // Current status of the transaction in the pool
+	Local   bool               // Whether the sender is exempt from the eviction rules
+	Private bool               // Whether the transaction is withheld from the network
+
+	// Fields of pooled transactions
+	StateNonce    uint64        // Nonce of the sender in the current head state
+	PendingNonce  uint64        // Next nonce after the executable transactions of the sender
+	MissingNonces []uint64      // Nonces missing to make a queued transaction executable
+	Queued        uint64        // Number of non-executable transactions of the sender
+	QueueLimit    uint64        // Maximum number of non-executable transactions of the sender (0 = unlimited)
+	Expiry        time.Duration // Time left until the queued transactions of the sender expire
+
+	// Fields of dropped transactions
+	Dropped     bool         // Whether the transaction was recently dropped
+	DropReason  TxDropReason // Reason the transaction was dropped for
+	DropTime    time.Time    // Time the transaction was dropped at
+	Replacement common.Hash  // Hash of the replacing transaction, if replaced
+}
+
+// Explain describes the status of a transaction in the pool: why it is queued
+// if it is not executable, or why it was removed if it was dropped recently.
+func (pool *TxPool) Explain(hash common.Hash) *TxExplanation {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	// If the transaction was dropped recently, report the reason
+	tx := pool.all[hash]
+	if tx == nil {
+		cached, ok := pool.dropped.Get(hash)
+		if !ok {
+			return &TxExplanation{Hash: hash, Status: TxStatusUnknown}
+		}
+		ev := cached.(*TxDropEvent)
+		expl := &TxExplanation{
+			Hash:       hash,
+			Tx:         ev.Tx,
+			Status:     TxStatusUnknown,
+			Private:    ev.Private,
+			Dropped:    true,
+			DropReason: ev.Reason,
+			DropTime:   ev.Time,
+		}
+		expl.From, _ = types.Sender(pool.signer, ev.Tx) // already validated
+		if ev.Replacement != nil {
+			expl.Replacement = ev.Replacement.Hash()
+		}
+		return expl
+	}
+	// Transaction still pooled, gather the state of its sender
+	from, _ := types.Sender(pool.signer, tx) // already validated
+
+	expl := &TxExplanation{
+		Hash:         hash,
+		Tx:           tx,
+		From:         from,
+		Status:       TxStatusQueued,
+		Local:        pool.locals.contains(from),
+		Private:      pool.private[hash] != nil,
+		StateNonce:   pool.currentState.GetNonce(from),
+		PendingNonce: pool.pendingState.GetNonce(from),
+	}
+	if list := pool.pending[from]; list != nil && list.txs.Get(tx.Nonce()) != nil {
+		expl.Status = TxStatusPending
+	}
+	if list := pool.queue[from]; list != nil {
+		expl.Queued = uint64(list.Len())
+
+		// Queued transactions wait for the nonces between the executable ones
+		// and themselves to arrive
+		if expl.Status == TxStatusQueued {
+			for nonce := expl.PendingNonce; nonce < tx.Nonce(); nonce++ {
+				if list.txs.Get(nonce) == nil {
+					expl.MissingNonces = append(expl.MissingNonces, nonce)
+				}
+			}
+		}
+		if !expl.Local {
+			expl.QueueLimit = pool.config.AccountQueue
+			if expl.Expiry = pool.config.Lifetime - time.Since(pool.beats[from]); expl.Expiry < 0 {
+				expl.Expiry = 0
+			}
+		}
+	}
+	return expl
+}
+
+// SubscribeTxDropEvent registers a subscription of TxDropEvent and starts
+// sending event to the given channel.
+func (pool *TxPool) SubscribeTxDropEvent(ch chan<- TxDropEvent) event.Subscription {
+	return pool.scope.Track(pool.dropFeed.Subscribe(ch))
+}
+
+// markDropped records that a transaction left the pool without being included,
+// remembering the reason for later explanations and queueing the notification
+// of any subscribers until the pool lock is released.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) markDropped(tx *types.Transaction, reason TxDropReason, replacement *types.Transaction) {
+	ev := TxDropEvent{Tx: tx, Reason: reason, Replacement: replacement, Time: time.Now(), Private: pool.private[tx.Hash()] != nil}
+	pool.dropped.Add(tx.Hash(), &ev)
+
+	pool.dropMu.Lock()
+	pool.drops = append(pool.drops, ev)
+	pool.dropMu.Unlock()
+}
+
+// nonceDropReason returns the reason to report for the transactions whose nonce
+// was consumed by the chain without them being seen included by the new head.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) nonceDropReason() TxDropReason {
+	if pool.deepReorg {
+		return TxDropDeepReorg
+	}
+	return TxDropNonceTooLow
+}
+
+// unlock releases the pool lock and then notifies the subscribers of the
+// transactions dropped while it was held. Notifications are sent in the order
+// of the drops, with the pool already available to the subscribers.
+func (pool *TxPool) unlock() {
+	pool.mu.Unlock()
+
+	pool.sendMu.Lock()
+	defer pool.sendMu.Unlock()
+
+	pool.dropMu.Lock()
+	drops := pool.drops
+	pool.drops = nil
+	pool.dropMu.Unlock()
+
+	for _, ev := range drops {
+		pool.dropFeed.Send(ev)
+	}
+}
+
+// markIncluded tracks a batch of transactions included by the new chain head
+// during a pool reset, so they are not reported as dropped when removed.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) markIncluded(txs types.Transactions) {
+	if pool.included == nil {
+		pool.included = make(map[common.Hash]struct{}, len(txs))
+	}
+	for _, tx := range txs {
+		pool.included[tx.Hash()] = struct{}{}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/types"
+)
+
+// Tests that the pool explains the status of pending, queued, replaced and
+// unknown transactions correctly.
+func TestTransactionExplain(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	account, _ := deriveSender(transaction(0, 0, key))
+	pool.currentState.AddBalance(account, big.NewInt(1000000))
+
+	// Add an executable transaction and one with a nonce gap in front
+	pending, queued := transaction(0, 100000, key), transaction(3, 100000, key)
+	if err := pool.AddRemotes([]*types.Transaction{pending, queued}); err[0] != nil || err[1] != nil {
+		t.Fatalf("failed to add transactions: %v", err)
+	}
+	expl := pool.Explain(pending.Hash())
+	if expl.Status != TxStatusPending || expl.Dropped {
+		t.Errorf("pending status mismatch: have %v (dropped %v), want %v", expl.Status, expl.Dropped, TxStatusPending)
+	}
+	expl = pool.Explain(queued.Hash())
+	if expl.Status != TxStatusQueued {
+		t.Errorf("queued status mismatch: have %v, want %v", expl.Status, TxStatusQueued)
+	}
+	if expl.From != account {
+		t.Errorf("queued sender mismatch: have %x, want %x", expl.From, account)
+	}
+	if expl.StateNonce != 0 || expl.PendingNonce != 1 {
+		t.Errorf("nonce mismatch: have state %d pending %d, want state 0 pending 1", expl.StateNonce, expl.PendingNonce)
+	}
+	if want := []uint64{1, 2}; !reflect.DeepEqual(expl.MissingNonces, want) {
+		t.Errorf("missing nonces mismatch: have %v, want %v", expl.MissingNonces, want)
+	}
+	if expl.Queued != 1 || expl.QueueLimit != testTxPoolConfig.AccountQueue {
+		t.Errorf("queue usage mismatch: have %d/%d, want %d/%d", expl.Queued, expl.QueueLimit, 1, testTxPoolConfig.AccountQueue)
+	}
+	if expl.Expiry <= 0 || expl.Expiry > testTxPoolConfig.Lifetime {
+		t.Errorf("expiry out of bounds: have %v, want (0, %v]", expl.Expiry, testTxPoolConfig.Lifetime)
+	}
+	// Replace the queued transaction and check that the old one is explained
+	replacement := pricedTransaction(3, 100000, big.NewInt(2), key)
+	if err := pool.AddRemote(replacement); err != nil {
+		t.Fatalf("failed to replace transaction: %v", err)
+	}
+	expl = pool.Explain(queued.Hash())
+	if !expl.Dropped || expl.DropReason != TxDropReplaced {
+		t.Errorf("replaced drop mismatch: have %v (%v), want %v", expl.Dropped, expl.DropReason, TxDropReplaced)
+	}
+	if expl.Replacement != replacement.Hash() {
+		t.Errorf("replacement mismatch: have %x, want %x", expl.Replacement, replacement.Hash())
+	}
+	// Unknown transactions should be reported as such
+	expl = pool.Explain(common.Hash{0x01})
+	if expl.Status != TxStatusUnknown || expl.Dropped || expl.Tx != nil {
+		t.Errorf("unknown transaction explained: %+v", expl)
+	}
+}
+
+// Tests that transactions dropped due to the pool limits and state changes are
+// reported on the drop event feed with the correct reasons.
+func TestTransactionDropEvents(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	account, _ := deriveSender(transaction(0, 0, key))
+	pool.currentState.AddBalance(account, big.NewInt(1000000))
+
+	events := make(chan TxDropEvent, 16)
+	sub := pool.SubscribeTxDropEvent(events)
+	defer sub.Unsubscribe()
+
+	expect := func(hash common.Hash, reason TxDropReason) {
+		select {
+		case ev := <-events:
+			if ev.Tx.Hash() != hash || ev.Reason != reason {
+				t.Fatalf("drop event mismatch: have %x (%v), want %x (%v)", ev.Tx.Hash(), ev.Reason, hash, reason)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("drop event for %x (%v) not fired", hash, reason)
+		}
+	}
+	// Overflow the account queue, the highest nonce should be dropped
+	var last *types.Transaction
+	for i := uint64(1); i <= testTxPoolConfig.AccountQueue+1; i++ {
+		last = transaction(i, 100000, key)
+		if err := pool.AddRemote(last); err != nil {
+			t.Fatalf("tx %d: failed to add transaction: %v", i, err)
+		}
+	}
+	expect(last.Hash(), TxDropAccountQueueFull)
+
+	// Execute the first queued transaction externally, it should be dropped
+	first := pool.queue[account].txs.Get(1)
+	pool.currentState.SetNonce(account, 2)
+	pool.lockedReset(nil, nil)
+
+	expect(first.Hash(), TxDropNonceTooLow)
+	if expl := pool.Explain(first.Hash()); expl.DropReason != TxDropNonceTooLow {
+		t.Errorf("explained drop reason mismatch: have %v, want %v", expl.DropReason, TxDropNonceTooLow)
+	}
+	// Execute the next queued transaction during a reorg too deep to track
+	second := pool.pending[account].txs.Get(2)
+	pool.currentState.SetNonce(account, 3)
+	pool.lockedReset(&types.Header{Number: big.NewInt(100)}, &types.Header{Number: big.NewInt(1), GasLimit: 1000000})
+
+	expect(second.Hash(), TxDropDeepReorg)
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected drop event: %x (%v)", ev.Tx.Hash(), ev.Reason)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -252,11 +252,16 @@ func (l *txList) Add(tx *types.Transaction, priceBump uint64) (bool, *types.Tran
 	// If there's an older better transaction, abort
 	old := l.txs.Get(tx.Nonce())
 	if old != nil {
-		threshold := new(big.Int).Div(new(big.Int).Mul(old.GasPrice(), big.NewInt(100+int64(priceBump))), big.NewInt(100))
-		// Have to ensure that the new gas price is higher than the old gas
-		// price as well as checking the percentage threshold to ensure that
-		// this is accurate for low (Wei-level) gas price replacements
-		if old.GasPrice().Cmp(tx.GasPrice()) >= 0 || threshold.Cmp(tx.GasPrice()) > 0 {
+		// Have to ensure that the new fee cap and tip are higher than the old
+		// ones as well as checking the percentage threshold to ensure that
+		// this is accurate for low (Wei-level) gas price replacements. For
+		// legacy transactions both equal the gas price.
+		if old.GasFeeCap().Cmp(tx.GasFeeCap()) >= 0 || old.GasTipCap().Cmp(tx.GasTipCap()) >= 0 {
+			return false, nil
+		}
+		feeThreshold := new(big.Int).Div(new(big.Int).Mul(old.GasFeeCap(), big.NewInt(100+int64(priceBump))), big.NewInt(100))
+		tipThreshold := new(big.Int).Div(new(big.Int).Mul(old.GasTipCap(), big.NewInt(100+int64(priceBump))), big.NewInt(100))
+		if feeThreshold.Cmp(tx.GasFeeCap()) > 0 || tipThreshold.Cmp(tx.GasTipCap()) > 0 {
 			return false, nil
 		}
 	}
@@ -364,22 +369,40 @@ func (l *txList) Flatten() types.Transactions {
 }
 
 // priceHeap is a heap.Interface implementation over transactions for retrieving
-// price-sorted transactions to discard when the pool fills up.
-type priceHeap []*types.Transaction
-
-func (h priceHeap) Len() int           { return len(h) }
-func (h priceHeap) Less(i, j int) bool { return h[i].GasPrice().Cmp(h[j].GasPrice()) < 0 }
-func (h priceHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+// price-sorted transactions to discard when the pool fills up. If a base fee is
+// set, transactions are sorted by the effective tip they would pay the miner.
+type priceHeap struct {
+	baseFee *big.Int // Base fee of the next block, nil before EIP1559
+	list    []*types.Transaction
+}
+
+func (h *priceHeap) Len() int           { return len(h.list) }
+func (h *priceHeap) Less(i, j int) bool { return h.cmp(h.list[i], h.list[j]) < 0 }
+func (h *priceHeap) Swap(i, j int)      { h.list[i], h.list[j] = h.list[j], h.list[i] }
+
+// cmp compares two transactions by the effective tip they pay, falling back to
+// their fee caps and tip caps to break ties.
+func (h *priceHeap) cmp(a, b *types.Transaction) int {
+	if h.baseFee != nil {
+		if c := a.EffectiveGasTip(h.baseFee).Cmp(b.EffectiveGasTip(h.baseFee)); c != 0 {
+			return c
+		}
+	}
+	if c := a.GasFeeCap().Cmp(b.GasFeeCap()); c != 0 {
+		return c
+	}
+	return a.GasTipCap().Cmp(b.GasTipCap())
+}
 
 func (h *priceHeap) Push(x interface{}) {
-	*h = append(*h, x.(*types.Transaction))
+	h.list = append(h.list, x.(*types.Transaction))
 }
 
 func (h *priceHeap) Pop() interface{} {
-	old := *h
+	old := h.list
 	n := len(old)
 	x := old[n-1]
-	*h = old[0 : n-1]
+	h.list = old[0 : n-1]
 	return x
 }
 
@@ -410,15 +433,28 @@ func (l *txPricedList) Put(tx *types.Transaction) {
 func (l *txPricedList) Removed() {
 	// Bump the stale counter, but exit if still too low (< 25%)
 	l.stales++
-	if l.stales <= len(*l.items)/4 {
+	if l.stales <= l.items.Len()/4 {
 		return
 	}
 	// Seems we've reached a critical number of stale transactions, reheap
-	reheap := make(priceHeap, 0, len(*l.all))
+	l.reheap()
+}
+
+// SetBaseFee updates the base fee the transactions are ordered by and rebuilds
+// the heap accordingly.
+func (l *txPricedList) SetBaseFee(baseFee *big.Int) {
+	l.items.baseFee = baseFee
+	l.reheap()
+}
+
+// reheap rebuilds the heap from the transactions currently in the pool, dropping
+// all the stale entries.
+func (l *txPricedList) reheap() {
+	reheap := &priceHeap{baseFee: l.items.baseFee, list: make([]*types.Transaction, 0, len(*l.all))}
 
-	l.stales, l.items = 0, &reheap
+	l.stales, l.items = 0, reheap
 	for _, tx := range *l.all {
-		*l.items = append(*l.items, tx)
+		l.items.list = append(l.items.list, tx)
 	}
 	heap.Init(l.items)
 }
@@ -429,7 +465,7 @@ func (l *txPricedList) Cap(threshold *big.Int, local *accountSet) types.Transact
 	drop := make(types.Transactions, 0, 128) // Remote underpriced transactions to drop
 	save := make(types.Transactions, 0, 64)  // Local underpriced transactions to keep
 
-	for len(*l.items) > 0 {
+	for l.items.Len() > 0 {
 		// Discard stale transactions if found during cleanup
 		tx := heap.Pop(l.items).(*types.Transaction)
 		if _, ok := (*l.all)[tx.Hash()]; !ok {
@@ -437,7 +473,7 @@ func (l *txPricedList) Cap(threshold *big.Int, local *accountSet) types.Transact
 			continue
 		}
 		// Stop the discards if we've reached the threshold
-		if tx.GasPrice().Cmp(threshold) >= 0 {
+		if tx.GasTipCap().Cmp(threshold) >= 0 {
 			save = append(save, tx)
 			break
 		}
@@ -462,8 +498,8 @@ func (l *txPricedList) Underpriced(tx *types.Transaction, local *accountSet) boo
 		return false
 	}
 	// Discard stale price points if found at the heap start
-	for len(*l.items) > 0 {
-		head := []*types.Transaction(*l.items)[0]
+	for l.items.Len() > 0 {
+		head := l.items.list[0]
 		if _, ok := (*l.all)[head.Hash()]; !ok {
 			l.stales--
 			heap.Pop(l.items)
@@ -472,12 +508,12 @@ func (l *txPricedList) Underpriced(tx *types.Transaction, local *accountSet) boo
 		break
 	}
 	// Check if the transaction is underpriced or not
-	if len(*l.items) == 0 {
+	if l.items.Len() == 0 {
 		log.Error("Pricing query for empty pool") // This cannot happen, print to catch programming errors
 		return false
 	}
-	cheapest := []*types.Transaction(*l.items)[0]
-	return cheapest.GasPrice().Cmp(tx.GasPrice()) >= 0
+	cheapest := l.items.list[0]
+	return l.items.cmp(cheapest, tx) >= 0
 }
 
 // Discard finds a number of most underpriced transactions, removes them from the
@@ -486,7 +522,7 @@ func (l *txPricedList) Discard(count int, local *accountSet) types.Transactions
 	drop := make(types.Transactions, 0, count) // Remote underpriced transactions to drop
 	save := make(types.Transactions, 0, 64)    // Local underpriced transactions to keep
 
-	for len(*l.items) > 0 && count > 0 {
+	for l.items.Len() > 0 && count > 0 {
 		// Discard stale transactions if found during cleanup
 		tx := heap.Pop(l.items).(*types.Transaction)
 		if _, ok := (*l.all)[tx.Hash()]; !ok {
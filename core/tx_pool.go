@@ -25,7 +25,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/hashicorp/golang-lru"
 	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/consensus/misc"
 	"github.com/utchain/go-utchain/core/state"
 	"github.com/utchain/go-utchain/core/types"
 	"github.com/utchain/go-utchain/event"
@@ -78,6 +80,10 @@ var (
 	// than some meaningful limit a user might use. This is not a consensus error
 	// making the transaction invalid, rather a DOS protection.
 	ErrOversizedData = errors.New("oversized data")
+
+	// ErrTipAboveFeeCap is a sanity error to ensure no one is able to specify a
+	// transaction with a tip higher than the total fee cap.
+	ErrTipAboveFeeCap = errors.New("max priority fee per gas higher than max fee per gas")
 )
 
 var (
@@ -129,6 +135,9 @@ type TxPoolConfig struct {
 	Journal   string        // Journal of local transactions to survive node restarts
 	Rejournal time.Duration // Time interval to regenerate the local transaction journal
 
+	Snapshot    string // Snapshot of the remote transactions to survive node restarts (empty = disabled)
+	SnapshotCap uint64 // Maximum number of remote transactions to store in the snapshot
+
 	PriceLimit uint64 // Minimum gas price to enforce for acceptance into the pool
 	PriceBump  uint64 // Minimum price bump percentage to replace an already existing transaction (nonce)
 
@@ -146,6 +155,8 @@ var DefaultTxPoolConfig = TxPoolConfig{
 	Journal:   "transactions.rlp",
 	Rejournal: time.Hour,
 
+	SnapshotCap: 4096,
+
 	PriceLimit: 1,
 	PriceBump:  10,
 
@@ -165,6 +176,10 @@ func (config *TxPoolConfig) sanitize() TxPoolConfig {
 		log.Warn("Sanitizing invalid txpool journal time", "provided", conf.Rejournal, "updated", time.Second)
 		conf.Rejournal = time.Second
 	}
+	if conf.Snapshot != "" && conf.SnapshotCap < 1 {
+		log.Warn("Sanitizing invalid txpool snapshot cap", "provided", conf.SnapshotCap, "updated", DefaultTxPoolConfig.SnapshotCap)
+		conf.SnapshotCap = DefaultTxPoolConfig.SnapshotCap
+	}
 	if conf.PriceLimit < 1 {
 		log.Warn("Sanitizing invalid txpool price limit", "provided", conf.PriceLimit, "updated", DefaultTxPoolConfig.PriceLimit)
 		conf.PriceLimit = DefaultTxPoolConfig.PriceLimit
@@ -189,6 +204,7 @@ type TxPool struct {
 	chain        blockChain
 	gasPrice     *big.Int
 	txFeed       event.Feed
+	dropFeed     event.Feed
 	scope        event.SubscriptionScope
 	chainHeadCh  chan ChainHeadEvent
 	chainHeadSub event.Subscription
@@ -207,10 +223,21 @@ type TxPool struct {
 	beats   map[common.Address]time.Time       // Last heartbeat from each known account
 	all     map[common.Hash]*types.Transaction // All transactions to allow lookups
 	priced  *txPricedList                      // All transactions sorted by price
+	dropped *lru.Cache                         // Recently dropped transactions with their drop reasons
+
+	included  map[common.Hash]struct{}   // Transactions included by the new head during a reset, not reported as dropped
+	deepReorg bool                       // Whether the current reset skipped a reorg too deep to track the included transactions
+	private   map[common.Hash]*privateTx // Transactions withheld from the network until their expiry
+
+	drops  []TxDropEvent // Drop notifications collected under the pool lock, sent after releasing it
+	dropMu sync.Mutex    // Protects the collected drop notifications, never held while sending
+	sendMu sync.Mutex    // Serializes sending the drop notifications, keeping them in order
 
 	wg sync.WaitGroup // for shutdown sync
 
 	homestead bool
+	berlin    bool // Whether the next block is past the Berlin fork
+	eip1559   bool // Whether the next block is past the EIP1559 fork
 }
 
 // NewTxPool creates a new transaction pool to gather, sort and filter inbound
@@ -224,15 +251,17 @@ func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, chain block
 		config:      config,
 		chainconfig: chainconfig,
 		chain:       chain,
-		signer:      types.NewEIP155Signer(chainconfig.ChainId),
+		signer:      types.NewEIP1559Signer(chainconfig.ChainId),
 		pending:     make(map[common.Address]*txList),
 		queue:       make(map[common.Address]*txList),
 		beats:       make(map[common.Address]time.Time),
 		all:         make(map[common.Hash]*types.Transaction),
+		private:     make(map[common.Hash]*privateTx),
 		chainHeadCh: make(chan ChainHeadEvent, chainHeadChanSize),
 		gasPrice:    new(big.Int).SetUint64(config.PriceLimit),
 	}
 	pool.locals = newAccountSet(pool.signer)
+	pool.dropped, _ = lru.New(droppedTxCacheSize)
 	pool.priced = newTxPricedList(&pool.all)
 	pool.reset(nil, chain.CurrentBlock().Header())
 
@@ -247,6 +276,12 @@ func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, chain block
 			log.Warn("Failed to rotate transaction journal", "err", err)
 		}
 	}
+	// If the remote transaction snapshot is enabled, reload it from disk
+	if config.Snapshot != "" {
+		if err := pool.loadSnapshot(); err != nil {
+			log.Warn("Failed to load transaction pool snapshot", "err", err)
+		}
+	}
 	// Subscribe events from blockchain
 	pool.chainHeadSub = pool.chain.SubscribeChainHeadEvent(pool.chainHeadCh)
 
@@ -291,7 +326,7 @@ func (pool *TxPool) loop() {
 				pool.reset(head.Header(), ev.Block.Header())
 				head = ev.Block
 
-				pool.mu.Unlock()
+				pool.unlock()
 			}
 		// Be unsubscribed due to system stopped
 		case <-pool.chainHeadSub.Err():
@@ -320,11 +355,12 @@ func (pool *TxPool) loop() {
 				// Any non-locals old enough should be removed
 				if time.Since(pool.beats[addr]) > pool.config.Lifetime {
 					for _, tx := range pool.queue[addr].Flatten() {
+						pool.markDropped(tx, TxDropLifetime, nil)
 						pool.removeTx(tx.Hash())
 					}
 				}
 			}
-			pool.mu.Unlock()
+			pool.unlock()
 
 		// Handle local transaction journal rotation
 		case <-journal.C:
@@ -343,7 +379,7 @@ func (pool *TxPool) loop() {
 // manner. This method is only ever used in the tester!
 func (pool *TxPool) lockedReset(oldHead, newHead *types.Header) {
 	pool.mu.Lock()
-	defer pool.mu.Unlock()
+	defer pool.unlock()
 
 	pool.reset(oldHead, newHead)
 }
@@ -361,6 +397,7 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 
 		if depth := uint64(math.Abs(float64(oldNum) - float64(newNum))); depth > 64 {
 			log.Debug("Skipping deep transaction reorg", "depth", depth)
+			pool.deepReorg = true
 		} else {
 			// Reorg seems shallow enough to pull in all transactions into memory
 			var discarded, included types.Transactions
@@ -396,8 +433,16 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 				}
 			}
 			reinject = types.TxDifference(discarded, included)
+			pool.markIncluded(included)
+		}
+	} else if oldHead != nil {
+		// Plain chain extension, track the newly included transactions
+		if block := pool.chain.GetBlock(newHead.Hash(), newHead.Number.Uint64()); block != nil {
+			pool.markIncluded(block.Transactions())
 		}
 	}
+	defer func() { pool.included, pool.deepReorg = nil, false }()
+
 	// Initialize the internal state to the current head
 	if newHead == nil {
 		newHead = pool.chain.CurrentBlock().Header() // Special case during testing
@@ -411,6 +456,14 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 	pool.pendingState = state.ManageState(statedb)
 	pool.currentMaxGas = newHead.GasLimit
 
+	// Track the typed transaction forks and order the pool by the tips paid in
+	// the next block
+	next := new(big.Int).Add(newHead.Number, common.Big1)
+	pool.berlin = pool.chainconfig.IsBerlin(next)
+	if pool.eip1559 = pool.chainconfig.IsEIP1559(next); pool.eip1559 {
+		pool.priced.SetBaseFee(misc.CalcBaseFee(pool.chainconfig, newHead))
+	}
+
 	// Inject any transactions discarded due to reorgs
 	log.Debug("Reinjecting stale transactions", "count", len(reinject))
 	pool.addTxsLocked(reinject, false)
@@ -429,6 +482,9 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 	// Check the queue and move transactions over to the pending if possible
 	// or remove those that have become invalid
 	pool.promoteExecutables(nil)
+
+	// Release or drop any private transactions past their expiry
+	pool.expirePrivate(newHead.Number.Uint64())
 }
 
 // Stop terminates the transaction pool.
@@ -443,6 +499,11 @@ func (pool *TxPool) Stop() {
 	if pool.journal != nil {
 		pool.journal.close()
 	}
+	if pool.config.Snapshot != "" {
+		if err := pool.saveSnapshot(); err != nil {
+			log.Warn("Failed to save transaction pool snapshot", "err", err)
+		}
+	}
 	log.Info("Transaction pool stopped")
 }
 
@@ -464,10 +525,11 @@ func (pool *TxPool) GasPrice() *big.Int {
 // new transaction, and drops all transactions below this threshold.
 func (pool *TxPool) SetGasPrice(price *big.Int) {
 	pool.mu.Lock()
-	defer pool.mu.Unlock()
+	defer pool.unlock()
 
 	pool.gasPrice = price
 	for _, tx := range pool.priced.Cap(price, pool.locals) {
+		pool.markDropped(tx, TxDropUnderpriced, nil)
 		pool.removeTx(tx.Hash())
 	}
 	log.Info("Transaction pool price threshold updated", "price", price)
@@ -537,15 +599,16 @@ func (pool *TxPool) Pending() (map[common.Address]types.Transactions, error) {
 
 // local retrieves all currently known local transactions, groupped by origin
 // account and sorted by nonce. The returned transaction set is a copy and can be
-// freely modified by calling code.
+// freely modified by calling code. Private transactions are not included, as
+// they are never journaled.
 func (pool *TxPool) local() map[common.Address]types.Transactions {
 	txs := make(map[common.Address]types.Transactions)
 	for addr := range pool.locals.accounts {
 		if pending := pool.pending[addr]; pending != nil {
-			txs[addr] = append(txs[addr], pending.Flatten()...)
+			txs[addr] = append(txs[addr], pool.public(pending.Flatten())...)
 		}
 		if queued := pool.queue[addr]; queued != nil {
-			txs[addr] = append(txs[addr], queued.Flatten()...)
+			txs[addr] = append(txs[addr], pool.public(queued.Flatten())...)
 		}
 	}
 	return txs
@@ -554,6 +617,13 @@ func (pool *TxPool) local() map[common.Address]types.Transactions {
 // validateTx checks whtster a transaction is valid according to the consensus
 // rules and adheres to some heuristic limits of the local node (price and size).
 func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
+	// Typed transactions are only accepted once the fork introducing them is active
+	if tx.Type() == types.AccessListTxType && !pool.berlin {
+		return types.ErrTxTypeNotSupported
+	}
+	if tx.Type() == types.DynamicFeeTxType && !pool.eip1559 {
+		return types.ErrTxTypeNotSupported
+	}
 	// Heuristic limit, reject transactions over 32KB to prevent DOS attacks
 	if tx.Size() > 32*1024 {
 		return ErrOversizedData
@@ -563,6 +633,10 @@ func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
 	if tx.Value().Sign() < 0 {
 		return ErrNegativeValue
 	}
+	// Ensure the tip paid to the miner is covered by the fee cap
+	if tx.GasTipCap().Cmp(tx.GasFeeCap()) > 0 {
+		return ErrTipAboveFeeCap
+	}
 	// Ensure the transaction doesn't exceed the current block limit gas.
 	if pool.currentMaxGas < tx.Gas() {
 		return ErrGasLimit
@@ -572,9 +646,9 @@ func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
 	if err != nil {
 		return ErrInvalidSender
 	}
-	// Drop non-local transactions under our own minimal accepted gas price
+	// Drop non-local transactions under our own minimal accepted gas tip
 	local = local || pool.locals.contains(from) // account may be local even if the transaction arrived from the network
-	if !local && pool.gasPrice.Cmp(tx.GasPrice()) > 0 {
+	if !local && pool.gasPrice.Cmp(tx.GasTipCap()) > 0 {
 		return ErrUnderpriced
 	}
 	// Ensure the transaction adheres to nonce ordering
@@ -586,7 +660,7 @@ func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
 	if pool.currentState.GetBalance(from).Cmp(tx.Cost()) < 0 {
 		return ErrInsufficientFunds
 	}
-	intrGas, err := IntrinsicGas(tx.Data(), tx.To() == nil, pool.homestead)
+	intrGas, err := IntrinsicGas(tx.Data(), tx.AccessList(), tx.To() == nil, pool.homestead)
 	if err != nil {
 		return err
 	}
@@ -630,6 +704,7 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 		for _, tx := range drop {
 			log.Trace("Discarding freshly underpriced transaction", "hash", tx.Hash(), "price", tx.GasPrice())
 			underpricedTxCounter.Inc(1)
+			pool.markDropped(tx, TxDropUnderpriced, nil)
 			pool.removeTx(tx.Hash())
 		}
 	}
@@ -647,6 +722,7 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 			delete(pool.all, old.Hash())
 			pool.priced.Removed()
 			pendingReplaceCounter.Inc(1)
+			pool.markDropped(old, TxDropReplaced, tx)
 		}
 		pool.all[tx.Hash()] = tx
 		pool.priced.Put(tx)
@@ -694,6 +770,7 @@ func (pool *TxPool) enqueueTx(hash common.Hash, tx *types.Transaction) (bool, er
 		delete(pool.all, old.Hash())
 		pool.priced.Removed()
 		queuedReplaceCounter.Inc(1)
+		pool.markDropped(old, TxDropReplaced, tx)
 	}
 	pool.all[hash] = tx
 	pool.priced.Put(tx)
@@ -707,6 +784,10 @@ func (pool *TxPool) journalTx(from common.Address, tx *types.Transaction) {
 	if pool.journal == nil || !pool.locals.contains(from) {
 		return
 	}
+	// Private transactions must not resurface as public ones after a restart
+	if _, ok := pool.private[tx.Hash()]; ok {
+		return
+	}
 	if err := pool.journal.insert(tx); err != nil {
 		log.Warn("Failed to journal local transaction", "err", err)
 	}
@@ -729,6 +810,7 @@ func (pool *TxPool) promoteTx(addr common.Address, hash common.Hash, tx *types.T
 		pool.priced.Removed()
 
 		pendingDiscardCounter.Inc(1)
+		pool.markDropped(tx, TxDropReplaced, list.txs.Get(tx.Nonce()))
 		return
 	}
 	// Otherwise discard any previous transaction and mark this
@@ -737,6 +819,7 @@ func (pool *TxPool) promoteTx(addr common.Address, hash common.Hash, tx *types.T
 		pool.priced.Removed()
 
 		pendingReplaceCounter.Inc(1)
+		pool.markDropped(old, TxDropReplaced, tx)
 	}
 	// Failsafe to work around direct pending inserts (tests)
 	if pool.all[hash] == nil {
@@ -781,7 +864,7 @@ func (pool *TxPool) AddRemotes(txs []*types.Transaction) []error {
 // addTx enqueues a single transaction into the pool if it is valid.
 func (pool *TxPool) addTx(tx *types.Transaction, local bool) error {
 	pool.mu.Lock()
-	defer pool.mu.Unlock()
+	defer pool.unlock()
 
 	// Try to inject the transaction and update any state
 	replace, err := pool.add(tx, local)
@@ -799,7 +882,7 @@ func (pool *TxPool) addTx(tx *types.Transaction, local bool) error {
 // addTxs attempts to queue a batch of transactions if they are valid.
 func (pool *TxPool) addTxs(txs []*types.Transaction, local bool) []error {
 	pool.mu.Lock()
-	defer pool.mu.Unlock()
+	defer pool.unlock()
 
 	return pool.addTxsLocked(txs, local)
 }
@@ -926,6 +1009,9 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) {
 			log.Trace("Removed old queued transaction", "hash", hash)
 			delete(pool.all, hash)
 			pool.priced.Removed()
+			if _, ok := pool.included[hash]; !ok {
+				pool.markDropped(tx, pool.nonceDropReason(), nil)
+			}
 		}
 		// Drop all transactions that are too costly (low balance or out of gas)
 		drops, _ := list.Filter(pool.currentState.GetBalance(addr), pool.currentMaxGas)
@@ -935,6 +1021,7 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) {
 			delete(pool.all, hash)
 			pool.priced.Removed()
 			queuedNofundsCounter.Inc(1)
+			pool.markDropped(tx, TxDropUnpayable, nil)
 		}
 		// Gather all executable transactions and promote them
 		for _, tx := range list.Ready(pool.pendingState.GetNonce(addr)) {
@@ -949,6 +1036,7 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) {
 				delete(pool.all, hash)
 				pool.priced.Removed()
 				queuedRateLimitCounter.Inc(1)
+				pool.markDropped(tx, TxDropAccountQueueFull, nil)
 				log.Trace("Removed cap-exceeding queued transaction", "hash", hash)
 			}
 		}
@@ -993,6 +1081,7 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) {
 							hash := tx.Hash()
 							delete(pool.all, hash)
 							pool.priced.Removed()
+							pool.markDropped(tx, TxDropPendingFull, nil)
 
 							// Update the account nonce to the dropped transaction
 							if nonce := tx.Nonce(); pool.pendingState.GetNonce(offenders[i]) > nonce {
@@ -1015,6 +1104,7 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) {
 						hash := tx.Hash()
 						delete(pool.all, hash)
 						pool.priced.Removed()
+						pool.markDropped(tx, TxDropPendingFull, nil)
 
 						// Update the account nonce to the dropped transaction
 						if nonce := tx.Nonce(); pool.pendingState.GetNonce(addr) > nonce {
@@ -1053,6 +1143,7 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) {
 			// Drop all transactions if they are less than the overflow
 			if size := uint64(list.Len()); size <= drop {
 				for _, tx := range list.Flatten() {
+					pool.markDropped(tx, TxDropGlobalQueueFull, nil)
 					pool.removeTx(tx.Hash())
 				}
 				drop -= size
@@ -1062,6 +1153,7 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) {
 			// Otherwise drop only last few transactions
 			txs := list.Flatten()
 			for i := len(txs) - 1; i >= 0 && drop > 0; i-- {
+				pool.markDropped(txs[i], TxDropGlobalQueueFull, nil)
 				pool.removeTx(txs[i].Hash())
 				drop--
 				queuedRateLimitCounter.Inc(1)
@@ -1084,6 +1176,9 @@ func (pool *TxPool) demoteUnexecutables() {
 			log.Trace("Removed old pending transaction", "hash", hash)
 			delete(pool.all, hash)
 			pool.priced.Removed()
+			if _, ok := pool.included[hash]; !ok {
+				pool.markDropped(tx, pool.nonceDropReason(), nil)
+			}
 		}
 		// Drop all transactions that are too costly (low balance or out of gas), and queue any invalids back for later
 		drops, invalids := list.Filter(pool.currentState.GetBalance(addr), pool.currentMaxGas)
@@ -1093,6 +1188,7 @@ func (pool *TxPool) demoteUnexecutables() {
 			delete(pool.all, hash)
 			pool.priced.Removed()
 			pendingNofundsCounter.Inc(1)
+			pool.markDropped(tx, TxDropUnpayable, nil)
 		}
 		for _, tx := range invalids {
 			hash := tx.Hash()
@@ -52,6 +52,7 @@ type testBlockChain struct {
 
 func (bc *testBlockChain) CurrentBlock() *types.Block {
 	return types.NewBlock(&types.Header{
+		Number:   new(big.Int),
 		GasLimit: bc.gasLimit,
 	}, nil, nil, nil)
 }
@@ -261,6 +262,44 @@ func TestInvalidTransactions(t *testing.T) {
 	}
 }
 
+// Tests that dynamic fee transactions are only accepted once the EIP1559 fork
+// is active, and that their fee fields are validated.
+func TestDynamicFeeTransactions(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	pool.currentState.AddBalance(from, big.NewInt(1000000000))
+
+	signer := types.NewEIP1559Signer(params.TestChainConfig.ChainId)
+	tx, _ := types.SignTx(types.NewDynamicFeeTransaction(params.TestChainConfig.ChainId, 0, &common.Address{}, big.NewInt(100), 100000, big.NewInt(1), big.NewInt(2), nil, nil), signer, key)
+	if err := pool.AddRemote(tx); err != types.ErrTxTypeNotSupported {
+		t.Fatalf("pre-fork error mismatch: have %v, want %v", err, types.ErrTxTypeNotSupported)
+	}
+	// Activate the fork and ensure the transaction is accepted
+	config := *params.TestChainConfig
+	config.BerlinBlock = big.NewInt(0)
+	config.EIP1559Block = big.NewInt(0)
+
+	pool.mu.Lock()
+	pool.chainconfig = &config
+	pool.mu.Unlock()
+	pool.lockedReset(nil, nil)
+
+	if err := pool.AddRemote(tx); err != nil {
+		t.Fatalf("failed to add dynamic fee transaction: %v", err)
+	}
+	tx, _ = types.SignTx(types.NewDynamicFeeTransaction(params.TestChainConfig.ChainId, 1, &common.Address{}, big.NewInt(100), 100000, big.NewInt(3), big.NewInt(2), nil, nil), signer, key)
+	if err := pool.AddRemote(tx); err != ErrTipAboveFeeCap {
+		t.Fatalf("tip above fee cap error mismatch: have %v, want %v", err, ErrTipAboveFeeCap)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
 func TestTransactionQueue(t *testing.T) {
 	t.Parallel()
 
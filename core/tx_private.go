@@ -0,0 +1,119 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/log"
+)
+
+// ErrPrivateExpired is returned if a private transaction is submitted with an
+// expiry block that is already part of the chain.
+var ErrPrivateExpired = errors.New("private transaction expiry passed")
+
+// privateTx is the tracking metadata of a transaction withheld from the network.
+type privateTx struct {
+	expiry   uint64 // Last block number the transaction is withheld until
+	fallback bool   // Whether to gossip the transaction after expiry instead of dropping it
+}
+
+// AddPrivate enqueues a single transaction into the pool as a local one, but
+// withholds it from the network until the given expiry block. Afterwards the
+// transaction is either released for normal gossip, or dropped from the pool,
+// depending on the fallback flag.
+func (pool *TxPool) AddPrivate(tx *types.Transaction, expiry uint64, fallback bool) error {
+	pool.mu.Lock()
+	defer pool.unlock()
+
+	hash := tx.Hash()
+	if pool.all[hash] != nil {
+		return fmt.Errorf("known transaction: %x", hash)
+	}
+	if expiry <= pool.chain.CurrentBlock().NumberU64() {
+		return ErrPrivateExpired
+	}
+	// Mark the transaction private before insertion, as the network is notified
+	// of new transactions asynchronously by the insertion itself
+	pool.private[hash] = &privateTx{expiry: expiry, fallback: fallback}
+
+	replace, err := pool.add(tx, !pool.config.NoLocals)
+	if err != nil {
+		delete(pool.private, hash)
+		return err
+	}
+	if !replace {
+		from, _ := types.Sender(pool.signer, tx) // already validated
+		pool.promoteExecutables([]common.Address{from})
+	}
+	return nil
+}
+
+// IsPrivate returns whether a pooled transaction is withheld from the network.
+func (pool *TxPool) IsPrivate(hash common.Hash) bool {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	_, ok := pool.private[hash]
+	return ok
+}
+
+// expirePrivate releases or drops the private transactions whose expiry block
+// passed, and forgets the ones already removed from the pool.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) expirePrivate(head uint64) {
+	for hash, meta := range pool.private {
+		tx := pool.all[hash]
+		if tx == nil {
+			delete(pool.private, hash)
+			continue
+		}
+		if head <= meta.expiry {
+			continue
+		}
+		if meta.fallback {
+			log.Trace("Releasing expired private transaction", "hash", hash)
+			delete(pool.private, hash)
+			go pool.txFeed.Send(TxPreEvent{tx})
+			continue
+		}
+		log.Trace("Dropping expired private transaction", "hash", hash)
+		pool.markDropped(tx, TxDropPrivateExpired, nil)
+		pool.removeTx(hash)
+		delete(pool.private, hash)
+	}
+}
+
+// public filters the private transactions out of a transaction list.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) public(txs types.Transactions) types.Transactions {
+	if len(pool.private) == 0 {
+		return txs
+	}
+	filtered := txs[:0]
+	for _, tx := range txs {
+		if _, ok := pool.private[tx.Hash()]; !ok {
+			filtered = append(filtered, tx)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/crypto"
+)
+
+// Tests that private transactions are tracked until their expiry block, after
+// which they are either released to the network or dropped.
+func TestPrivateTransactionExpiry(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	other, _ := crypto.GenerateKey()
+	dropped, released := transaction(0, 100000, key), transaction(0, 100000, other)
+	for _, tx := range []*types.Transaction{dropped, released} {
+		from, _ := deriveSender(tx)
+		pool.currentState.AddBalance(from, big.NewInt(1000000))
+	}
+	events := make(chan TxPreEvent, 16)
+	sub := pool.SubscribeTxPreEvent(events)
+	defer sub.Unsubscribe()
+
+	// Private transactions with past expiries should be rejected
+	if err := pool.AddPrivate(dropped, 0, false); err != ErrPrivateExpired {
+		t.Fatalf("expired private transaction error mismatch: have %v, want %v", err, ErrPrivateExpired)
+	}
+	if err := pool.AddPrivate(dropped, 2, false); err != nil {
+		t.Fatalf("failed to add private transaction: %v", err)
+	}
+	if err := pool.AddPrivate(released, 2, true); err != nil {
+		t.Fatalf("failed to add private transaction: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-events:
+		case <-time.After(time.Second):
+			t.Fatalf("insertion event %d missing", i)
+		}
+	}
+	for _, tx := range []*types.Transaction{dropped, released} {
+		if !pool.IsPrivate(tx.Hash()) {
+			t.Errorf("transaction %x not private", tx.Hash())
+		}
+		if expl := pool.Explain(tx.Hash()); !expl.Private {
+			t.Errorf("transaction %x not explained as private", tx.Hash())
+		}
+	}
+	// Private transactions must not end up in the journal
+	for addr, txs := range pool.local() {
+		if len(txs) != 0 {
+			t.Errorf("private transactions of %x journaled: %v", addr, txs)
+		}
+	}
+	// Reaching the expiry block should not release the transactions yet
+	pool.lockedReset(nil, &types.Header{Number: big.NewInt(2), GasLimit: 1000000})
+	if !pool.IsPrivate(dropped.Hash()) || !pool.IsPrivate(released.Hash()) {
+		t.Fatalf("private transactions released at expiry block")
+	}
+	// Passing the expiry block should drop one and release the other
+	pool.lockedReset(nil, &types.Header{Number: big.NewInt(3), GasLimit: 1000000})
+
+	if pool.Get(dropped.Hash()) != nil {
+		t.Errorf("expired private transaction not dropped")
+	}
+	if expl := pool.Explain(dropped.Hash()); expl.DropReason != TxDropPrivateExpired {
+		t.Errorf("drop reason mismatch: have %v, want %v", expl.DropReason, TxDropPrivateExpired)
+	} else if !expl.Private {
+		t.Errorf("expired private transaction not explained as private")
+	}
+	if pool.Get(released.Hash()) == nil || pool.IsPrivate(released.Hash()) {
+		t.Errorf("expired fallback transaction not released")
+	}
+	select {
+	case ev := <-events:
+		if ev.Tx.Hash() != released.Hash() {
+			t.Errorf("release event mismatch: have %x, want %x", ev.Tx.Hash(), released.Hash())
+		}
+	case <-time.After(time.Second):
+		t.Errorf("release event missing")
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/log"
+	"github.com/utchain/go-utchain/rlp"
+)
+
+// snapshotTx is a remote transaction as stored in the pool snapshot, along with
+// the time it was first seen, so arrival ordering survives a restart.
+type snapshotTx struct {
+	Tx   *types.Transaction
+	Time uint64 // Unix time in nanoseconds
+}
+
+// remotes retrieves all currently known remote transactions, grouped by origin
+// account and sorted by nonce. Private transactions are not included. The
+// returned transaction set is a copy and can be freely modified by calling code.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) remotes() map[common.Address]types.Transactions {
+	txs := make(map[common.Address]types.Transactions)
+	for addr, pending := range pool.pending {
+		if !pool.locals.contains(addr) {
+			txs[addr] = append(txs[addr], pool.public(pending.Flatten())...)
+		}
+	}
+	for addr, queued := range pool.queue {
+		if !pool.locals.contains(addr) {
+			txs[addr] = append(txs[addr], pool.public(queued.Flatten())...)
+		}
+	}
+	return txs
+}
+
+// saveSnapshot writes the remote transactions of the pool into the snapshot
+// file, so they survive a node restart. If there are more transactions than the
+// snapshot cap permits, the accounts with the best paying next transactions are
+// preferred, retaining a nonce-contiguous prefix of the last account stored.
+func (pool *TxPool) saveSnapshot() error {
+	pool.mu.RLock()
+	remotes := pool.remotes()
+	baseFee := pool.priced.items.baseFee
+	pool.mu.RUnlock()
+
+	// Order the accounts by the tip their next transaction pays in the next block
+	accounts := make([]common.Address, 0, len(remotes))
+	for addr, txs := range remotes {
+		if len(txs) > 0 {
+			accounts = append(accounts, addr)
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return remotes[accounts[i]][0].EffectiveGasTip(baseFee).Cmp(remotes[accounts[j]][0].EffectiveGasTip(baseFee)) > 0
+	})
+	// Write the transactions into a temporary file and move it in place
+	output, err := os.OpenFile(pool.config.Snapshot+".new", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var saved, written uint64
+	for _, addr := range accounts {
+		txs := remotes[addr]
+		if left := pool.config.SnapshotCap - saved; uint64(len(txs)) > left {
+			txs = txs[:left]
+		}
+		for _, tx := range txs {
+			if err := rlp.Encode(output, &snapshotTx{Tx: tx, Time: uint64(tx.Time().UnixNano())}); err != nil {
+				output.Close()
+				return err
+			}
+		}
+		written++
+		if saved += uint64(len(txs)); saved >= pool.config.SnapshotCap {
+			break
+		}
+	}
+	if err := output.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(pool.config.Snapshot+".new", pool.config.Snapshot); err != nil {
+		return err
+	}
+	log.Info("Saved transaction pool snapshot", "transactions", saved, "accounts", written)
+	return nil
+}
+
+// loadSnapshot injects the remote transactions of a previous snapshot into the
+// pool, revalidating them against the current state, and deletes the snapshot
+// so a later crash cannot reload stale transactions.
+func (pool *TxPool) loadSnapshot() error {
+	input, err := os.Open(pool.config.Snapshot)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var (
+		stream  = rlp.NewStream(input, 0)
+		txs     []*types.Transaction
+		failure error
+	)
+	for {
+		entry := new(snapshotTx)
+		if err := stream.Decode(entry); err != nil {
+			if err != io.EOF {
+				failure = err
+			}
+			break
+		}
+		entry.Tx.SetTime(time.Unix(0, int64(entry.Time)))
+		txs = append(txs, entry.Tx)
+	}
+	input.Close()
+
+	dropped := 0
+	for _, err := range pool.AddRemotes(txs) {
+		if err != nil {
+			log.Debug("Failed to add snapshotted transaction", "err", err)
+			dropped++
+		}
+	}
+	log.Info("Loaded transaction pool snapshot", "transactions", len(txs), "dropped", dropped)
+
+	if err := os.Remove(pool.config.Snapshot); err != nil {
+		return err
+	}
+	return failure
+}
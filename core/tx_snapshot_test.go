@@ -0,0 +1,135 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"crypto/ecdsa"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/state"
+	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/crypto"
+	"github.com/utchain/go-utchain/event"
+	"github.com/utchain/go-utchain/params"
+	"github.com/utchain/go-utchain/tstdb"
+)
+
+// Tests that remote transactions are snapshotted on shutdown and reloaded on
+// startup, revalidated against the current state and capped in number.
+func TestTransactionSnapshot(t *testing.T) {
+	t.Parallel()
+
+	// Create a temporary file path for the snapshot
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary snapshot: %v", err)
+	}
+	snapshot := file.Name()
+	defer os.Remove(snapshot)
+
+	file.Close()
+	os.Remove(snapshot)
+
+	// Create the original pool to inject transaction into the snapshot
+	db, _ := tstdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	blockchain := &testBlockChain{statedb, 1000000, new(event.Feed)}
+
+	config := testTxPoolConfig
+	config.Snapshot = snapshot
+	config.SnapshotCap = 4
+
+	pool := NewTxPool(config, params.TestChainConfig, blockchain)
+
+	// Create a local and a few remote accounts with differently priced transactions
+	local, _ := crypto.GenerateKey()
+	rich, _ := crypto.GenerateKey()
+	poor, _ := crypto.GenerateKey()
+	cheap, _ := crypto.GenerateKey()
+
+	for _, key := range []*ecdsa.PrivateKey{local, rich, poor, cheap} {
+		pool.currentState.AddBalance(crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+	}
+	if err := pool.AddLocal(pricedTransaction(0, 100000, big.NewInt(1), local)); err != nil {
+		t.Fatalf("failed to add local transaction: %v", err)
+	}
+	var (
+		richTxs = []*types.Transaction{
+			pricedTransaction(0, 100000, big.NewInt(3), rich),
+			pricedTransaction(1, 100000, big.NewInt(3), rich),
+			pricedTransaction(3, 100000, big.NewInt(3), rich), // queued
+		}
+		poorTxs = []*types.Transaction{
+			pricedTransaction(0, 100000, big.NewInt(2), poor),
+		}
+		cheapTxs = []*types.Transaction{
+			pricedTransaction(0, 100000, big.NewInt(1), cheap), // over the snapshot cap
+		}
+	)
+	for _, txs := range [][]*types.Transaction{richTxs, poorTxs, cheapTxs} {
+		for _, err := range pool.AddRemotes(txs) {
+			if err != nil {
+				t.Fatalf("failed to add remote transaction: %v", err)
+			}
+		}
+	}
+	if pending, queued := pool.Stats(); pending != 5 || queued != 1 {
+		t.Fatalf("pool stats mismatch: have %d/%d, want 5/1", pending, queued)
+	}
+	arrivals := make(map[common.Hash]time.Time)
+	for _, tx := range richTxs {
+		arrivals[tx.Hash()] = tx.Time()
+	}
+	pool.Stop()
+
+	// Make the poor account unable to pay, and reload the snapshot
+	statedb.SetBalance(crypto.PubkeyToAddress(poor.PublicKey), new(big.Int))
+
+	pool = NewTxPool(config, params.TestChainConfig, blockchain)
+	defer pool.Stop()
+
+	for _, tx := range richTxs {
+		reloaded := pool.Get(tx.Hash())
+		if reloaded == nil {
+			t.Errorf("snapshotted transaction %x missing", tx.Hash())
+			continue
+		}
+		if !reloaded.Time().Equal(arrivals[tx.Hash()]) {
+			t.Errorf("snapshotted transaction %x arrival time mismatch: have %v, want %v", tx.Hash(), reloaded.Time(), arrivals[tx.Hash()])
+		}
+	}
+	if pool.Get(poorTxs[0].Hash()) != nil {
+		t.Errorf("unpayable transaction reloaded")
+	}
+	if pool.Get(cheapTxs[0].Hash()) != nil {
+		t.Errorf("transaction over the snapshot cap reloaded")
+	}
+	if pending, queued := pool.Stats(); pending != 2 || queued != 1 {
+		t.Fatalf("pool stats mismatch: have %d/%d, want 2/1", pending, queued)
+	}
+	if _, err := os.Stat(snapshot); !os.IsNotExist(err) {
+		t.Errorf("snapshot not deleted after loading: %v", err)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
@@ -17,12 +17,14 @@
 package types
 
 import (
+	"bytes"
 	"container/heap"
 	"errors"
 	"fmt"
 	"io"
 	"math/big"
 	"sync/atomic"
+	"time"
 
 	"github.com/utchain/go-utchain/common"
 	"github.com/utchain/go-utchain/common/hexutil"
@@ -33,8 +35,17 @@ import (
 //go:generate gencodec -type txdata -field-override txdataMarshaling -out gen_tx_json.go
 
 var (
-	ErrInvalidSig = errors.New("invalid transaction v, r, s values")
-	errNoSigner   = errors.New("missing signing methods")
+	ErrInvalidSig         = errors.New("invalid transaction v, r, s values")
+	ErrTxTypeNotSupported = errors.New("transaction type not supported")
+	errNoSigner           = errors.New("missing signing methods")
+	errEmptyTypedTx       = errors.New("empty typed transaction bytes")
+)
+
+// Transaction types.
+const (
+	LegacyTxType     = 0x00
+	AccessListTxType = 0x01
+	DynamicFeeTxType = 0x02
 )
 
 // deriveSigner makes a *best* guess about which signer to use.
@@ -48,6 +59,7 @@ func deriveSigner(V *big.Int) Signer {
 
 type Transaction struct {
 	data txdata
+	time time.Time // Time first seen locally, used for arrival ordering
 	// caches
 	hash atomic.Value
 	size atomic.Value
@@ -67,11 +79,38 @@ type txdata struct {
 	R *big.Int `json:"r" gencodec:"required"`
 	S *big.Int `json:"s" gencodec:"required"`
 
+	// Typed transaction fields, these are not part of the legacy encoding. For
+	// dynamic fee transactions Price mirrors GasFeeCap.
+	Type       uint8      `json:"type"                           rlp:"-"`
+	ChainID    *big.Int   `json:"chainId,omitempty"              rlp:"-"`
+	GasTipCap  *big.Int   `json:"maxPriorityFeePerGas,omitempty" rlp:"-"`
+	GasFeeCap  *big.Int   `json:"maxFeePerGas,omitempty"         rlp:"-"`
+	AccessList AccessList `json:"accessList,omitempty"           rlp:"-"`
+
 	// This is only used when marshaling to JSON.
 	Hash *common.Hash `json:"hash" rlp:"-"`
 }
 
+// dynamicFeeTx is the consensus encoding of an EIP-1559 transaction payload,
+// which is wrapped into the typed transaction envelope.
+type dynamicFeeTx struct {
+	ChainID    *big.Int
+	Nonce      uint64
+	GasTipCap  *big.Int
+	GasFeeCap  *big.Int
+	Gas        uint64
+	To         *common.Address `rlp:"nil"`
+	Value      *big.Int
+	Data       []byte
+	AccessList AccessList
+	V, R, S    *big.Int
+}
+
 type txdataMarshaling struct {
+	Type         hexutil.Uint64
+	ChainID      *hexutil.Big
+	GasTipCap    *hexutil.Big
+	GasFeeCap    *hexutil.Big
 	AccountNonce hexutil.Uint64
 	Price        *hexutil.Big
 	GasLimit     hexutil.Uint64
@@ -112,16 +151,65 @@ func newTransaction(nonce uint64, to *common.Address, amount *big.Int, gasLimit
 		d.Price.Set(gasPrice)
 	}
 
-	return &Transaction{data: d}
+	return &Transaction{data: d, time: time.Now()}
+}
+
+// NewAccessListTransaction creates an unsigned EIP-2930 transaction declaring
+// the accounts and storage slots it is going to access. A nil recipient creates
+// a contract.
+func NewAccessListTransaction(chainId *big.Int, nonce uint64, to *common.Address, amount *big.Int, gasLimit uint64, gasPrice *big.Int, data []byte, accessList AccessList) *Transaction {
+	return newTypedTransaction(AccessListTxType, chainId, nonce, to, amount, gasLimit, gasPrice, data, accessList)
+}
+
+// NewDynamicFeeTransaction creates an unsigned EIP-1559 transaction paying at
+// most gasFeeCap per gas, of which up to gasTipCap goes to the miner and the
+// rest is burnt as base fee. A nil recipient creates a contract.
+func NewDynamicFeeTransaction(chainId *big.Int, nonce uint64, to *common.Address, amount *big.Int, gasLimit uint64, gasTipCap, gasFeeCap *big.Int, data []byte, accessList AccessList) *Transaction {
+	tx := newTypedTransaction(DynamicFeeTxType, chainId, nonce, to, amount, gasLimit, gasFeeCap, data, accessList)
+	tx.data.GasTipCap = new(big.Int)
+	if gasTipCap != nil {
+		tx.data.GasTipCap.Set(gasTipCap)
+	}
+	tx.data.GasFeeCap = new(big.Int).Set(tx.data.Price)
+	return tx
+}
+
+func newTypedTransaction(txType uint8, chainId *big.Int, nonce uint64, to *common.Address, amount *big.Int, gasLimit uint64, gasPrice *big.Int, data []byte, accessList AccessList) *Transaction {
+	tx := newTransaction(nonce, to, amount, gasLimit, gasPrice, data)
+	tx.data.Type = txType
+	tx.data.ChainID = new(big.Int)
+	if chainId != nil {
+		tx.data.ChainID.Set(chainId)
+	}
+	tx.data.AccessList = copyAccessList(accessList)
+	return tx
+}
+
+// copyAccessList returns a deep copy of the access list.
+func copyAccessList(al AccessList) AccessList {
+	if al == nil {
+		return nil
+	}
+	cpy := make(AccessList, len(al))
+	for i, tuple := range al {
+		cpy[i] = AccessTuple{Address: tuple.Address, StorageKeys: append([]common.Hash{}, tuple.StorageKeys...)}
+	}
+	return cpy
 }
 
 // ChainId returns which chain id this transaction was signed for (if at all)
 func (tx *Transaction) ChainId() *big.Int {
+	if tx.data.Type != LegacyTxType {
+		return new(big.Int).Set(tx.data.ChainID)
+	}
 	return deriveChainId(tx.data.V)
 }
 
-// Protected returns whtster the transaction is protected from replay protection.
+// Protected returns whether the transaction is protected from replay protection.
 func (tx *Transaction) Protected() bool {
+	if tx.data.Type != LegacyTxType {
+		return true
+	}
 	return isProtectedV(tx.data.V)
 }
 
@@ -134,22 +222,168 @@ func isProtectedV(V *big.Int) bool {
 	return true
 }
 
-// EncodeRLP implements rlp.Encoder
+// EncodeRLP implements rlp.Encoder. Legacy transactions are encoded as an RLP
+// list, typed transactions as an RLP string containing their envelope.
 func (tx *Transaction) EncodeRLP(w io.Writer) error {
-	return rlp.Encode(w, &tx.data)
+	if tx.data.Type == LegacyTxType {
+		return rlp.Encode(w, &tx.data)
+	}
+	enc, err := tx.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return rlp.Encode(w, enc)
 }
 
 // DecodeRLP implements rlp.Decoder
 func (tx *Transaction) DecodeRLP(s *rlp.Stream) error {
-	_, size, _ := s.Kind()
-	err := s.Decode(&tx.data)
+	kind, size, err := s.Kind()
+	switch {
+	case err != nil:
+		return err
+	case kind == rlp.List:
+		err = s.Decode(&tx.data)
+	case kind == rlp.String:
+		var enc []byte
+		if enc, err = s.Bytes(); err == nil {
+			tx.data, err = decodeTyped(enc)
+		}
+	default:
+		return rlp.ErrExpectedList
+	}
 	if err == nil {
 		tx.size.Store(common.StorageSize(rlp.ListSize(size)))
+		tx.time = time.Now()
 	}
-
 	return err
 }
 
+// MarshalBinary returns the canonical encoding of the transaction. For legacy
+// transactions this is the RLP encoding, typed transactions are encoded as the
+// type byte followed by the RLP encoding of their payload.
+func (tx *Transaction) MarshalBinary() ([]byte, error) {
+	if tx.data.Type == LegacyTxType {
+		return rlp.EncodeToBytes(&tx.data)
+	}
+	payload, err := tx.typedPayload()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(tx.data.Type)
+	if err := rlp.Encode(&buf, payload); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes the canonical encoding of a transaction, accepting
+// both legacy and typed transactions.
+func (tx *Transaction) UnmarshalBinary(b []byte) error {
+	var (
+		data txdata
+		err  error
+	)
+	if len(b) > 0 && b[0] > 0x7f {
+		err = rlp.DecodeBytes(b, &data)
+	} else {
+		data, err = decodeTyped(b)
+	}
+	if err != nil {
+		return err
+	}
+	*tx = Transaction{data: data, time: time.Now()}
+	return nil
+}
+
+// typedPayload returns the consensus payload of a typed transaction.
+func (tx *Transaction) typedPayload() (interface{}, error) {
+	switch tx.data.Type {
+	case AccessListTxType:
+		return &accessListTx{
+			ChainID:    tx.data.ChainID,
+			Nonce:      tx.data.AccountNonce,
+			GasPrice:   tx.data.Price,
+			Gas:        tx.data.GasLimit,
+			To:         tx.data.Recipient,
+			Value:      tx.data.Amount,
+			Data:       tx.data.Payload,
+			AccessList: tx.data.AccessList,
+			V:          tx.data.V,
+			R:          tx.data.R,
+			S:          tx.data.S,
+		}, nil
+	case DynamicFeeTxType:
+		return &dynamicFeeTx{
+			ChainID:    tx.data.ChainID,
+			Nonce:      tx.data.AccountNonce,
+			GasTipCap:  tx.data.GasTipCap,
+			GasFeeCap:  tx.data.GasFeeCap,
+			Gas:        tx.data.GasLimit,
+			To:         tx.data.Recipient,
+			Value:      tx.data.Amount,
+			Data:       tx.data.Payload,
+			AccessList: tx.data.AccessList,
+			V:          tx.data.V,
+			R:          tx.data.R,
+			S:          tx.data.S,
+		}, nil
+	default:
+		return nil, ErrTxTypeNotSupported
+	}
+}
+
+// decodeTyped decodes a typed transaction envelope.
+func decodeTyped(b []byte) (txdata, error) {
+	if len(b) == 0 {
+		return txdata{}, errEmptyTypedTx
+	}
+	switch b[0] {
+	case AccessListTxType:
+		var dec accessListTx
+		if err := rlp.DecodeBytes(b[1:], &dec); err != nil {
+			return txdata{}, err
+		}
+		return txdata{
+			Type:         AccessListTxType,
+			ChainID:      dec.ChainID,
+			AccountNonce: dec.Nonce,
+			Price:        dec.GasPrice,
+			GasLimit:     dec.Gas,
+			Recipient:    dec.To,
+			Amount:       dec.Value,
+			Payload:      dec.Data,
+			AccessList:   dec.AccessList,
+			V:            dec.V,
+			R:            dec.R,
+			S:            dec.S,
+		}, nil
+	case DynamicFeeTxType:
+		var dec dynamicFeeTx
+		if err := rlp.DecodeBytes(b[1:], &dec); err != nil {
+			return txdata{}, err
+		}
+		return txdata{
+			Type:         DynamicFeeTxType,
+			ChainID:      dec.ChainID,
+			AccountNonce: dec.Nonce,
+			GasTipCap:    dec.GasTipCap,
+			GasFeeCap:    dec.GasFeeCap,
+			Price:        new(big.Int).Set(dec.GasFeeCap),
+			GasLimit:     dec.Gas,
+			Recipient:    dec.To,
+			Amount:       dec.Value,
+			Payload:      dec.Data,
+			AccessList:   dec.AccessList,
+			V:            dec.V,
+			R:            dec.R,
+			S:            dec.S,
+		}, nil
+	default:
+		return txdata{}, ErrTxTypeNotSupported
+	}
+}
+
 // MarshalJSON encodes the web3 RPC transaction format.
 func (tx *Transaction) MarshalJSON() ([]byte, error) {
 	hash := tx.Hash()
@@ -165,16 +399,29 @@ func (tx *Transaction) UnmarshalJSON(input []byte) error {
 		return err
 	}
 	var V byte
-	if isProtectedV(dec.V) {
+	switch {
+	case dec.Type == AccessListTxType:
+		if dec.ChainID == nil {
+			return errors.New("missing chain id in transaction")
+		}
+		V = byte(dec.V.Uint64())
+	case dec.Type == DynamicFeeTxType:
+		if dec.ChainID == nil || dec.GasTipCap == nil || dec.GasFeeCap == nil {
+			return errors.New("missing dynamic fee fields in transaction")
+		}
+		V = byte(dec.V.Uint64())
+	case dec.Type != LegacyTxType:
+		return ErrTxTypeNotSupported
+	case isProtectedV(dec.V):
 		chainID := deriveChainId(dec.V).Uint64()
 		V = byte(dec.V.Uint64() - 35 - 2*chainID)
-	} else {
+	default:
 		V = byte(dec.V.Uint64() - 27)
 	}
 	if !crypto.ValidateSignatureValues(V, dec.R, dec.S, false) {
 		return ErrInvalidSig
 	}
-	*tx = Transaction{data: dec}
+	*tx = Transaction{data: dec, time: time.Now()}
 	return nil
 }
 
@@ -185,6 +432,52 @@ func (tx *Transaction) Value() *big.Int    { return new(big.Int).Set(tx.data.Amo
 func (tx *Transaction) Nonce() uint64      { return tx.data.AccountNonce }
 func (tx *Transaction) CheckNonce() bool   { return true }
 
+// Type returns the transaction type, LegacyTxType for untyped transactions.
+func (tx *Transaction) Type() uint8 { return tx.data.Type }
+
+// AccessList returns the access list of the transaction, nil for legacy ones.
+func (tx *Transaction) AccessList() AccessList { return tx.data.AccessList }
+
+// GasTipCap returns the maximum priority fee per gas the sender is willing to
+// pay to the miner. It equals the gas price for non dynamic fee transactions.
+func (tx *Transaction) GasTipCap() *big.Int {
+	if tx.data.Type != DynamicFeeTxType {
+		return new(big.Int).Set(tx.data.Price)
+	}
+	return new(big.Int).Set(tx.data.GasTipCap)
+}
+
+// GasFeeCap returns the maximum total fee per gas the sender is willing to pay.
+// It equals the gas price for non dynamic fee transactions.
+func (tx *Transaction) GasFeeCap() *big.Int {
+	if tx.data.Type != DynamicFeeTxType {
+		return new(big.Int).Set(tx.data.Price)
+	}
+	return new(big.Int).Set(tx.data.GasFeeCap)
+}
+
+// EffectiveGasTip returns the per gas reward the miner receives for including
+// the transaction in a block with the given base fee. The result is negative if
+// the fee cap does not cover the base fee. A nil base fee returns the tip cap.
+func (tx *Transaction) EffectiveGasTip(baseFee *big.Int) *big.Int {
+	tip := tx.GasTipCap()
+	if baseFee == nil {
+		return tip
+	}
+	if gap := new(big.Int).Sub(tx.GasFeeCap(), baseFee); gap.Cmp(tip) < 0 {
+		return gap
+	}
+	return tip
+}
+
+// Time returns the time the transaction was first seen locally, i.e. when it was
+// created or decoded.
+func (tx *Transaction) Time() time.Time { return tx.time }
+
+// SetTime overrides the time the transaction was first seen locally. It is used
+// to restore the arrival time of transactions reloaded from disk.
+func (tx *Transaction) SetTime(t time.Time) { tx.time = t }
+
 // To returns the recipient address of the transaction.
 // It returns nil if the transaction is a contract creation.
 func (tx *Transaction) To() *common.Address {
@@ -201,7 +494,13 @@ func (tx *Transaction) Hash() common.Hash {
 	if hash := tx.hash.Load(); hash != nil {
 		return hash.(common.Hash)
 	}
-	v := rlpHash(tx)
+	var v common.Hash
+	if tx.data.Type == LegacyTxType {
+		v = rlpHash(tx)
+	} else {
+		payload, _ := tx.typedPayload()
+		v = prefixedRlpHash(tx.data.Type, payload)
+	}
 	tx.hash.Store(v)
 	return v
 }
@@ -213,7 +512,7 @@ func (tx *Transaction) Size() common.StorageSize {
 		return size.(common.StorageSize)
 	}
 	c := writeCounter(0)
-	rlp.Encode(&c, &tx.data)
+	rlp.Encode(&c, tx)
 	tx.size.Store(common.StorageSize(c))
 	return common.StorageSize(c)
 }
@@ -228,9 +527,12 @@ func (tx *Transaction) AsMessage(s Signer) (Message, error) {
 		nonce:      tx.data.AccountNonce,
 		gasLimit:   tx.data.GasLimit,
 		gasPrice:   new(big.Int).Set(tx.data.Price),
+		gasFeeCap:  tx.GasFeeCap(),
+		gasTipCap:  tx.GasTipCap(),
 		to:         tx.data.Recipient,
 		amount:     tx.data.Amount,
 		data:       tx.data.Payload,
+		accessList: tx.data.AccessList,
 		checkNonce: true,
 	}
 
@@ -246,7 +548,7 @@ func (tx *Transaction) WithSignature(signer Signer, sig []byte) (*Transaction, e
 	if err != nil {
 		return nil, err
 	}
-	cpy := &Transaction{data: tx.data}
+	cpy := &Transaction{data: tx.data, time: tx.time}
 	cpy.data.R, cpy.data.S, cpy.data.V = r, s, v
 	return cpy, nil
 }
@@ -268,6 +570,9 @@ func (tx *Transaction) String() string {
 		// make a best guess about the signer and use that to derive
 		// the sender.
 		signer := deriveSigner(tx.data.V)
+		if tx.data.Type != LegacyTxType {
+			signer = NewEIP1559Signer(tx.data.ChainID)
+		}
 		if f, err := Sender(signer, tx); err != nil { // derive but don't cache
 			from = "[invalid sender: invalid sig]"
 		} else {
@@ -282,9 +587,10 @@ func (tx *Transaction) String() string {
 	} else {
 		to = fmt.Sprintf("%x", tx.data.Recipient[:])
 	}
-	enc, _ := rlp.EncodeToBytes(&tx.data)
+	enc, _ := tx.MarshalBinary()
 	return fmt.Sprintf(`
 	TX(%x)
+	Type:     %d
 	Contract: %v
 	From:     %s
 	To:       %s
@@ -299,6 +605,7 @@ func (tx *Transaction) String() string {
 	Hex:      %x
 `,
 		tx.Hash(),
+		tx.data.Type,
 		tx.data.Recipient == nil,
 		from,
 		to,
@@ -323,9 +630,10 @@ func (s Transactions) Len() int { return len(s) }
 // Swap swaps the i'th and the j'th element in s.
 func (s Transactions) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 
-// GetRlp implements Rlpable and returns the i'th element of s in rlp.
+// GetRlp implements Rlpable and returns the i'th element of s in its canonical
+// encoding, the RLP for legacy and the typed envelope for typed transactions.
 func (s Transactions) GetRlp(i int) []byte {
-	enc, _ := rlp.EncodeToBytes(s[i])
+	enc, _ := s[i].MarshalBinary()
 	return enc
 }
 
@@ -376,25 +684,60 @@ func (s *TxByPrice) Pop() interface{} {
 	return x
 }
 
+// txWithTip is a transaction along with the miner tip it pays under the base
+// fee of the block being assembled.
+type txWithTip struct {
+	tx  *Transaction
+	tip *big.Int
+}
+
+// txsByTip implements the heap interface, ordering transactions by the tip the
+// miner receives.
+type txsByTip []*txWithTip
+
+func (s txsByTip) Len() int           { return len(s) }
+func (s txsByTip) Less(i, j int) bool { return s[i].tip.Cmp(s[j].tip) > 0 }
+func (s txsByTip) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (s *txsByTip) Push(x interface{}) {
+	*s = append(*s, x.(*txWithTip))
+}
+
+func (s *txsByTip) Pop() interface{} {
+	old := *s
+	n := len(old)
+	x := old[n-1]
+	*s = old[0 : n-1]
+	return x
+}
+
 // TransactionsByPriceAndNonce represents a set of transactions that can return
 // transactions in a profit-maximizing sorted order, while supporting removing
 // entire batches of transactions for non-executable accounts.
 type TransactionsByPriceAndNonce struct {
-	txs    map[common.Address]Transactions // Per account nonce-sorted list of transactions
-	heads  TxByPrice                       // Next transaction for each unique account (price heap)
-	signer Signer                          // Signer for the set of transactions
+	txs     map[common.Address]Transactions // Per account nonce-sorted list of transactions
+	heads   txsByTip                        // Next transaction for each unique account (tip heap)
+	signer  Signer                          // Signer for the set of transactions
+	baseFee *big.Int                        // Base fee of the block being assembled, nil before EIP-1559
 }
 
 // NewTransactionsByPriceAndNonce creates a transaction set that can retrieve
-// price sorted transactions in a nonce-honouring way.
+// price sorted transactions in a nonce-honouring way. With a non-nil base fee
+// transactions are sorted by the effective tip paid to the miner, and those not
+// covering the base fee are left out.
 //
 // Note, the input map is reowned so the caller should not interact any more with
 // if after providing it to the constructor.
-func NewTransactionsByPriceAndNonce(signer Signer, txs map[common.Address]Transactions) *TransactionsByPriceAndNonce {
+func NewTransactionsByPriceAndNonce(signer Signer, txs map[common.Address]Transactions, baseFee *big.Int) *TransactionsByPriceAndNonce {
 	// Initialize a price based heap with the head transactions
-	heads := make(TxByPrice, 0, len(txs))
-	for _, accTxs := range txs {
-		heads = append(heads, accTxs[0])
+	heads := make(txsByTip, 0, len(txs))
+	for from, accTxs := range txs {
+		tip := accTxs[0].EffectiveGasTip(baseFee)
+		if tip.Sign() < 0 {
+			delete(txs, from)
+			continue
+		}
+		heads = append(heads, &txWithTip{tx: accTxs[0], tip: tip})
 		// Ensure the sender address is from the signer
 		acc, _ := Sender(signer, accTxs[0])
 		txs[acc] = accTxs[1:]
@@ -403,9 +746,10 @@ func NewTransactionsByPriceAndNonce(signer Signer, txs map[common.Address]Transa
 
 	// Assemble and return the transaction set
 	return &TransactionsByPriceAndNonce{
-		txs:    txs,
-		heads:  heads,
-		signer: signer,
+		txs:     txs,
+		heads:   heads,
+		signer:  signer,
+		baseFee: baseFee,
 	}
 }
 
@@ -414,18 +758,20 @@ func (t *TransactionsByPriceAndNonce) Peek() *Transaction {
 	if len(t.heads) == 0 {
 		return nil
 	}
-	return t.heads[0]
+	return t.heads[0].tx
 }
 
 // Shift replaces the current best head with the next one from the same account.
 func (t *TransactionsByPriceAndNonce) Shift() {
-	acc, _ := Sender(t.signer, t.heads[0])
+	acc, _ := Sender(t.signer, t.heads[0].tx)
 	if txs, ok := t.txs[acc]; ok && len(txs) > 0 {
-		t.heads[0], t.txs[acc] = txs[0], txs[1:]
-		heap.Fix(&t.heads, 0)
-	} else {
-		heap.Pop(&t.heads)
+		if tip := txs[0].EffectiveGasTip(t.baseFee); tip.Sign() >= 0 {
+			t.heads[0], t.txs[acc] = &txWithTip{tx: txs[0], tip: tip}, txs[1:]
+			heap.Fix(&t.heads, 0)
+			return
+		}
 	}
+	heap.Pop(&t.heads)
 }
 
 // Pop removes the best transaction, *not* replacing it with the next one from
@@ -445,11 +791,14 @@ type Message struct {
 	amount     *big.Int
 	gasLimit   uint64
 	gasPrice   *big.Int
+	gasFeeCap  *big.Int
+	gasTipCap  *big.Int
 	data       []byte
+	accessList AccessList
 	checkNonce bool
 }
 
-func NewMessage(from common.Address, to *common.Address, nonce uint64, amount *big.Int, gasLimit uint64, gasPrice *big.Int, data []byte, checkNonce bool) Message {
+func NewMessage(from common.Address, to *common.Address, nonce uint64, amount *big.Int, gasLimit uint64, gasPrice *big.Int, data []byte, accessList AccessList, checkNonce bool) Message {
 	return Message{
 		from:       from,
 		to:         to,
@@ -457,16 +806,22 @@ func NewMessage(from common.Address, to *common.Address, nonce uint64, amount *b
 		amount:     amount,
 		gasLimit:   gasLimit,
 		gasPrice:   gasPrice,
+		gasFeeCap:  gasPrice,
+		gasTipCap:  gasPrice,
 		data:       data,
+		accessList: accessList,
 		checkNonce: checkNonce,
 	}
 }
 
-func (m Message) From() common.Address { return m.from }
-func (m Message) To() *common.Address  { return m.to }
-func (m Message) GasPrice() *big.Int   { return m.gasPrice }
-func (m Message) Value() *big.Int      { return m.amount }
-func (m Message) Gas() uint64          { return m.gasLimit }
-func (m Message) Nonce() uint64        { return m.nonce }
-func (m Message) Data() []byte         { return m.data }
-func (m Message) CheckNonce() bool     { return m.checkNonce }
+func (m Message) From() common.Address   { return m.from }
+func (m Message) To() *common.Address    { return m.to }
+func (m Message) GasPrice() *big.Int     { return m.gasPrice }
+func (m Message) GasFeeCap() *big.Int    { return m.gasFeeCap }
+func (m Message) GasTipCap() *big.Int    { return m.gasTipCap }
+func (m Message) Value() *big.Int        { return m.amount }
+func (m Message) Gas() uint64            { return m.gasLimit }
+func (m Message) Nonce() uint64          { return m.nonce }
+func (m Message) Data() []byte           { return m.data }
+func (m Message) AccessList() AccessList { return m.accessList }
+func (m Message) CheckNonce() bool       { return m.checkNonce }
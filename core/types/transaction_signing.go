@@ -42,6 +42,10 @@ type sigCache struct {
 func MakeSigner(config *params.ChainConfig, blockNumber *big.Int) Signer {
 	var signer Signer
 	switch {
+	case config.IsEIP1559(blockNumber):
+		signer = NewEIP1559Signer(config.ChainId)
+	case config.IsBerlin(blockNumber):
+		signer = NewEIP2930Signer(config.ChainId)
 	case config.IsEIP155(blockNumber):
 		signer = NewEIP155Signer(config.ChainId)
 	case config.IsHomestead(blockNumber):
@@ -102,6 +106,139 @@ type Signer interface {
 	Equal(Signer) bool
 }
 
+// EIP1559Signer implements Signer using the EIP1559 rules. It accepts dynamic
+// fee transactions as well as all transactions accepted by the EIP2930 signer.
+type EIP1559Signer struct{ EIP2930Signer }
+
+func NewEIP1559Signer(chainId *big.Int) EIP1559Signer {
+	return EIP1559Signer{NewEIP2930Signer(chainId)}
+}
+
+func (s EIP1559Signer) Equal(s2 Signer) bool {
+	eip1559, ok := s2.(EIP1559Signer)
+	return ok && eip1559.chainId.Cmp(s.chainId) == 0
+}
+
+func (s EIP1559Signer) Sender(tx *Transaction) (common.Address, error) {
+	if tx.Type() != DynamicFeeTxType {
+		return s.EIP2930Signer.Sender(tx)
+	}
+	return typedSender(s.chainId, s.Hash(tx), tx)
+}
+
+// SignatureValues returns signature values. This signature
+// needs to be in the [R || S || V] format where V is 0 or 1.
+func (s EIP1559Signer) SignatureValues(tx *Transaction, sig []byte) (R, S, V *big.Int, err error) {
+	if tx.Type() != DynamicFeeTxType {
+		return s.EIP2930Signer.SignatureValues(tx, sig)
+	}
+	return typedSignatureValues(s.chainId, tx, sig)
+}
+
+// Hash returns the hash to be signed by the sender.
+// It does not uniquely identify the transaction.
+func (s EIP1559Signer) Hash(tx *Transaction) common.Hash {
+	if tx.Type() != DynamicFeeTxType {
+		return s.EIP2930Signer.Hash(tx)
+	}
+	return prefixedRlpHash(tx.Type(), []interface{}{
+		s.chainId,
+		tx.data.AccountNonce,
+		tx.data.GasTipCap,
+		tx.data.GasFeeCap,
+		tx.data.GasLimit,
+		tx.data.Recipient,
+		tx.data.Amount,
+		tx.data.Payload,
+		tx.data.AccessList,
+	})
+}
+
+// EIP2930Signer implements Signer using the EIP2930 rules. It accepts access
+// list transactions as well as legacy EIP155 and homestead ones.
+type EIP2930Signer struct{ EIP155Signer }
+
+func NewEIP2930Signer(chainId *big.Int) EIP2930Signer {
+	return EIP2930Signer{NewEIP155Signer(chainId)}
+}
+
+func (s EIP2930Signer) Equal(s2 Signer) bool {
+	eip2930, ok := s2.(EIP2930Signer)
+	return ok && eip2930.chainId.Cmp(s.chainId) == 0
+}
+
+func (s EIP2930Signer) Sender(tx *Transaction) (common.Address, error) {
+	switch tx.Type() {
+	case LegacyTxType:
+		return s.EIP155Signer.Sender(tx)
+	case AccessListTxType:
+		return typedSender(s.chainId, s.Hash(tx), tx)
+	default:
+		return common.Address{}, ErrTxTypeNotSupported
+	}
+}
+
+// SignatureValues returns signature values. This signature
+// needs to be in the [R || S || V] format where V is 0 or 1.
+func (s EIP2930Signer) SignatureValues(tx *Transaction, sig []byte) (R, S, V *big.Int, err error) {
+	switch tx.Type() {
+	case LegacyTxType:
+		return s.EIP155Signer.SignatureValues(tx, sig)
+	case AccessListTxType:
+		return typedSignatureValues(s.chainId, tx, sig)
+	default:
+		return nil, nil, nil, ErrTxTypeNotSupported
+	}
+}
+
+// Hash returns the hash to be signed by the sender.
+// It does not uniquely identify the transaction.
+func (s EIP2930Signer) Hash(tx *Transaction) common.Hash {
+	switch tx.Type() {
+	case LegacyTxType:
+		return s.EIP155Signer.Hash(tx)
+	case AccessListTxType:
+		return prefixedRlpHash(tx.Type(), []interface{}{
+			s.chainId,
+			tx.data.AccountNonce,
+			tx.data.Price,
+			tx.data.GasLimit,
+			tx.data.Recipient,
+			tx.data.Amount,
+			tx.data.Payload,
+			tx.data.AccessList,
+		})
+	default:
+		// Unsupported types can't be signed, return an empty hash instead of
+		// panicking on malformed input
+		return common.Hash{}
+	}
+}
+
+// typedSender recovers the sender of a typed transaction signed over sighash,
+// ensuring it was signed for the given chain.
+func typedSender(chainId *big.Int, sighash common.Hash, tx *Transaction) (common.Address, error) {
+	if tx.data.ChainID.Cmp(chainId) != 0 {
+		return common.Address{}, ErrInvalidChainId
+	}
+	// Typed transactions carry the plain recovery id in V
+	V := new(big.Int).Add(tx.data.V, big.NewInt(27))
+	return recoverPlain(sighash, tx.data.R, tx.data.S, V, true)
+}
+
+// typedSignatureValues converts a [R || S || V] signature into the values of a
+// typed transaction, which carries the plain recovery id in V.
+func typedSignatureValues(chainId *big.Int, tx *Transaction, sig []byte) (R, S, V *big.Int, err error) {
+	if tx.data.ChainID.Sign() != 0 && tx.data.ChainID.Cmp(chainId) != 0 {
+		return nil, nil, nil, ErrInvalidChainId
+	}
+	R, S, _, err = HomesteadSigner{}.SignatureValues(tx, sig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return R, S, big.NewInt(int64(sig[64])), nil
+}
+
 // EIP155Transaction implements Signer using the EIP155 rules.
 type EIP155Signer struct {
 	chainId, chainIdMul *big.Int
@@ -125,6 +262,9 @@ func (s EIP155Signer) Equal(s2 Signer) bool {
 var big8 = big.NewInt(8)
 
 func (s EIP155Signer) Sender(tx *Transaction) (common.Address, error) {
+	if tx.Type() != LegacyTxType {
+		return common.Address{}, ErrTxTypeNotSupported
+	}
 	if !tx.Protected() {
 		return HomesteadSigner{}.Sender(tx)
 	}
@@ -180,6 +320,9 @@ func (hs HomesteadSigner) SignatureValues(tx *Transaction, sig []byte) (r, s, v
 }
 
 func (hs HomesteadSigner) Sender(tx *Transaction) (common.Address, error) {
+	if tx.Type() != LegacyTxType {
+		return common.Address{}, ErrTxTypeNotSupported
+	}
 	return recoverPlain(hs.Hash(tx), tx.data.R, tx.data.S, tx.data.V, true)
 }
 
@@ -216,6 +359,9 @@ func (fs FrontierSigner) Hash(tx *Transaction) common.Hash {
 }
 
 func (fs FrontierSigner) Sender(tx *Transaction) (common.Address, error) {
+	if tx.Type() != LegacyTxType {
+		return common.Address{}, ErrTxTypeNotSupported
+	}
 	return recoverPlain(fs.Hash(tx), tx.data.R, tx.data.S, tx.data.V, false)
 }
 
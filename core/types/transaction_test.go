@@ -144,7 +144,7 @@ func TestTransactionPriceNonceSort(t *testing.T) {
 		}
 	}
 	// Sort the transactions and cross check the nonce ordering
-	txset := NewTransactionsByPriceAndNonce(signer, groups)
+	txset := NewTransactionsByPriceAndNonce(signer, groups, nil)
 
 	txs := Transactions{}
 	for tx := txset.Peek(); tx != nil; tx = txset.Peek() {
@@ -233,3 +233,152 @@ func TestTransactionJSON(t *testing.T) {
 		}
 	}
 }
+
+// Tests that dynamic fee transactions survive the round trip through their
+// canonical, RLP and JSON encodings and that only the EIP1559 signer accepts
+// them.
+func TestDynamicFeeTransactionEncoding(t *testing.T) {
+	key, addr := defaultTestKey()
+	signer := NewEIP1559Signer(common.Big1)
+
+	to := common.HexToAddress("b94f5374fce5edbc8e2a8697c15331677e6ebf0b")
+	tx, err := SignTx(NewDynamicFeeTransaction(common.Big1, 3, &to, big.NewInt(10), 21000, big.NewInt(1), big.NewInt(5), []byte("abcdef"), AccessList{{Address: to, StorageKeys: []common.Hash{{0x01}}}}), signer, key)
+	if err != nil {
+		t.Fatalf("could not sign transaction: %v", err)
+	}
+	if tx.Type() != DynamicFeeTxType {
+		t.Fatalf("transaction type mismatch: have %d, want %d", tx.Type(), DynamicFeeTxType)
+	}
+	if from, err := Sender(signer, tx); err != nil || from != addr {
+		t.Fatalf("sender mismatch: have %x (%v), want %x", from, err, addr)
+	}
+	// Check the canonical encoding
+	enc, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatalf("binary encoding failed: %v", err)
+	}
+	if enc[0] != DynamicFeeTxType {
+		t.Fatalf("envelope type mismatch: have %d, want %d", enc[0], DynamicFeeTxType)
+	}
+	if tx.Hash() != crypto.Keccak256Hash(enc) {
+		t.Errorf("transaction hash mismatch: have %x, want %x", tx.Hash(), crypto.Keccak256Hash(enc))
+	}
+	parsed := new(Transaction)
+	if err := parsed.UnmarshalBinary(enc); err != nil {
+		t.Fatalf("binary decoding failed: %v", err)
+	}
+	if parsed.Hash() != tx.Hash() {
+		t.Errorf("binary decoded hash mismatch: have %x, want %x", parsed.Hash(), tx.Hash())
+	}
+	// Check the RLP encoding, nested alongside a legacy transaction
+	legacy, _ := SignTx(NewTransaction(4, to, big.NewInt(10), 21000, big.NewInt(1), nil), signer, key)
+
+	blob, err := rlp.EncodeToBytes(Transactions{tx, legacy})
+	if err != nil {
+		t.Fatalf("rlp encoding failed: %v", err)
+	}
+	var txs Transactions
+	if err := rlp.DecodeBytes(blob, &txs); err != nil {
+		t.Fatalf("rlp decoding failed: %v", err)
+	}
+	if len(txs) != 2 || txs[0].Hash() != tx.Hash() || txs[1].Hash() != legacy.Hash() {
+		t.Errorf("rlp decoded transactions mismatch")
+	}
+	if txs[0].GasTipCap().Cmp(big.NewInt(1)) != 0 || txs[0].GasFeeCap().Cmp(big.NewInt(5)) != 0 {
+		t.Errorf("rlp decoded fees mismatch: have tip %v cap %v, want 1 and 5", txs[0].GasTipCap(), txs[0].GasFeeCap())
+	}
+	// Check the JSON encoding
+	data, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatalf("json encoding failed: %v", err)
+	}
+	parsed = new(Transaction)
+	if err := json.Unmarshal(data, parsed); err != nil {
+		t.Fatalf("json decoding failed: %v", err)
+	}
+	if parsed.Hash() != tx.Hash() {
+		t.Errorf("json decoded hash mismatch: have %x, want %x", parsed.Hash(), tx.Hash())
+	}
+	// Legacy signers must reject typed transactions
+	for _, legacySigner := range []Signer{NewEIP155Signer(common.Big1), HomesteadSigner{}, FrontierSigner{}} {
+		if _, err := Sender(legacySigner, parsed); err != ErrTxTypeNotSupported {
+			t.Errorf("%T: sender error mismatch: have %v, want %v", legacySigner, err, ErrTxTypeNotSupported)
+		}
+	}
+	// Signatures must be bound to the chain
+	if _, err := Sender(NewEIP1559Signer(common.Big2), parsed); err != ErrInvalidChainId {
+		t.Errorf("foreign chain sender error mismatch: have %v, want %v", err, ErrInvalidChainId)
+	}
+}
+
+// Tests that access list transactions survive the round trip through their
+// canonical encoding and that only the Berlin era signers accept them.
+func TestAccessListTransactionEncoding(t *testing.T) {
+	key, addr := defaultTestKey()
+	signer := NewEIP2930Signer(common.Big1)
+
+	to := common.HexToAddress("b94f5374fce5edbc8e2a8697c15331677e6ebf0b")
+	acl := AccessList{{Address: to, StorageKeys: []common.Hash{{0x01}, {0x02}}}}
+
+	tx, err := SignTx(NewAccessListTransaction(common.Big1, 3, &to, big.NewInt(10), 30000, big.NewInt(1), []byte("abcdef"), acl), signer, key)
+	if err != nil {
+		t.Fatalf("could not sign transaction: %v", err)
+	}
+	if tx.Type() != AccessListTxType {
+		t.Fatalf("transaction type mismatch: have %d, want %d", tx.Type(), AccessListTxType)
+	}
+	enc, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatalf("binary encoding failed: %v", err)
+	}
+	if enc[0] != AccessListTxType {
+		t.Fatalf("envelope type mismatch: have %d, want %d", enc[0], AccessListTxType)
+	}
+	parsed := new(Transaction)
+	if err := parsed.UnmarshalBinary(enc); err != nil {
+		t.Fatalf("binary decoding failed: %v", err)
+	}
+	if parsed.Hash() != tx.Hash() {
+		t.Errorf("binary decoded hash mismatch: have %x, want %x", parsed.Hash(), tx.Hash())
+	}
+	if have := parsed.AccessList(); len(have) != 1 || have[0].Address != to || have.StorageKeys() != 2 {
+		t.Errorf("access list mismatch: have %v, want %v", have, acl)
+	}
+	if parsed.GasTipCap().Cmp(big.NewInt(1)) != 0 || parsed.GasFeeCap().Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("fees mismatch: have tip %v cap %v, want the gas price", parsed.GasTipCap(), parsed.GasFeeCap())
+	}
+	// Both Berlin era signers must accept it, legacy ones reject it
+	for _, berlinSigner := range []Signer{signer, NewEIP1559Signer(common.Big1)} {
+		if from, err := Sender(berlinSigner, parsed); err != nil || from != addr {
+			t.Errorf("%T: sender mismatch: have %x (%v), want %x", berlinSigner, from, err, addr)
+		}
+	}
+	if _, err := Sender(NewEIP155Signer(common.Big1), parsed); err != ErrTxTypeNotSupported {
+		t.Errorf("legacy sender error mismatch: have %v, want %v", err, ErrTxTypeNotSupported)
+	}
+	// The Berlin signer predates dynamic fee transactions
+	dynamic, _ := SignTx(NewDynamicFeeTransaction(common.Big1, 0, &to, common.Big0, 21000, big.NewInt(1), big.NewInt(5), nil, nil), NewEIP1559Signer(common.Big1), key)
+	if _, err := Sender(signer, dynamic); err != ErrTxTypeNotSupported {
+		t.Errorf("dynamic fee sender error mismatch: have %v, want %v", err, ErrTxTypeNotSupported)
+	}
+}
+
+// Tests that the effective miner tip is capped by the fee cap minus base fee.
+func TestEffectiveGasTip(t *testing.T) {
+	tx := NewDynamicFeeTransaction(common.Big1, 0, nil, common.Big0, 21000, big.NewInt(2), big.NewInt(10), nil, nil)
+
+	tests := []struct {
+		baseFee *big.Int
+		tip     int64
+	}{
+		{nil, 2},
+		{big.NewInt(5), 2},
+		{big.NewInt(9), 1},
+		{big.NewInt(12), -2},
+	}
+	for i, tt := range tests {
+		if tip := tx.EffectiveGasTip(tt.baseFee); tip.Int64() != tt.tip {
+			t.Errorf("test %d: tip mismatch: have %v, want %d", i, tip, tt.tip)
+		}
+	}
+}
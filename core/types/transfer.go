@@ -0,0 +1,34 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"math/big"
+
+	"github.com/utchain/go-utchain/common"
+)
+
+// InternalTransfer is a value transfer made by a contract while executing a
+// transaction. Unlike the transfer of the transaction itself, these leave no
+// trace in the receipts or logs.
+type InternalTransfer struct {
+	TxHash common.Hash    // Hash of the transaction the transfer was made in
+	Type   string         // Kind of the transfer: "call", "create" or "suicide"
+	From   common.Address // Contract sending the value
+	To     common.Address // Account receiving the value
+	Value  *big.Int       // Amount of value transferred
+}
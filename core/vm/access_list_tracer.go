@@ -0,0 +1,176 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/types"
+)
+
+// accessSet is an accumulator for the accounts and storage slots touched by an
+// EVM execution.
+type accessSet map[common.Address]map[common.Hash]struct{}
+
+// newAccessSet creates an access set prefilled with the given access list.
+func newAccessSet(acl types.AccessList) accessSet {
+	set := make(accessSet)
+	for _, tuple := range acl {
+		set.addAddress(tuple.Address)
+		for _, slot := range tuple.StorageKeys {
+			set.addSlot(tuple.Address, slot)
+		}
+	}
+	return set
+}
+
+// addAddress adds an account to the access set.
+func (set accessSet) addAddress(address common.Address) {
+	if _, ok := set[address]; !ok {
+		set[address] = make(map[common.Hash]struct{})
+	}
+}
+
+// addSlot adds a storage slot of an account to the access set.
+func (set accessSet) addSlot(address common.Address, slot common.Hash) {
+	set.addAddress(address)
+	set[address][slot] = struct{}{}
+}
+
+// equal returns whether the two access sets contain the same entries.
+func (set accessSet) equal(other accessSet) bool {
+	if len(set) != len(other) {
+		return false
+	}
+	for addr, slots := range set {
+		otherSlots, ok := other[addr]
+		if !ok || len(slots) != len(otherSlots) {
+			return false
+		}
+		for slot := range slots {
+			if _, ok := otherSlots[slot]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// accessList converts the access set into an access list, sorted by address
+// and slot to make the result deterministic.
+func (set accessSet) accessList() types.AccessList {
+	acl := make(types.AccessList, 0, len(set))
+	for addr, slots := range set {
+		tuple := types.AccessTuple{Address: addr, StorageKeys: make([]common.Hash, 0, len(slots))}
+		for slot := range slots {
+			tuple.StorageKeys = append(tuple.StorageKeys, slot)
+		}
+		sort.Slice(tuple.StorageKeys, func(i, j int) bool {
+			return bytes.Compare(tuple.StorageKeys[i][:], tuple.StorageKeys[j][:]) < 0
+		})
+		acl = append(acl, tuple)
+	}
+	sort.Slice(acl, func(i, j int) bool {
+		return bytes.Compare(acl[i].Address[:], acl[j].Address[:]) < 0
+	})
+	return acl
+}
+
+// AccessListTracer is an EVM tracer collecting the accounts and storage slots a
+// transaction accesses, i.e. the access list it could declare up front. The
+// sender, the recipient and the precompiled contracts are left out, as they
+// are accessible for free anyway.
+type AccessListTracer struct {
+	excl map[common.Address]struct{} // Accounts warm by default, not worth listing
+	list accessSet                   // Accounts and slots accessed so far
+	err  error                       // Error the execution ended with
+}
+
+// NewAccessListTracer creates a tracer collecting the accesses of a transaction
+// from the given sender to the given recipient, starting out with the access
+// list of the transaction.
+func NewAccessListTracer(acl types.AccessList, from, to common.Address, precompiles []common.Address) *AccessListTracer {
+	excl := map[common.Address]struct{}{
+		from: {},
+		to:   {},
+	}
+	for _, addr := range precompiles {
+		excl[addr] = struct{}{}
+	}
+	list := newAccessSet(acl)
+	for addr := range excl {
+		if slots, ok := list[addr]; ok && len(slots) == 0 {
+			delete(list, addr)
+		}
+	}
+	return &AccessListTracer{
+		excl: excl,
+		list: list,
+	}
+}
+
+func (a *AccessListTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+// CaptureState records the account or storage slot accessed by the upcoming
+// instruction, if any.
+func (a *AccessListTracer) CaptureState(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error {
+	switch {
+	case (op == SLOAD || op == SSTORE) && stack.len() >= 1:
+		a.list.addSlot(contract.Address(), common.BigToHash(stack.Back(0)))
+
+	case (op == BALANCE || op == EXTCODESIZE || op == EXTCODECOPY || op == SELFDESTRUCT) && stack.len() >= 1:
+		if addr := common.BigToAddress(stack.Back(0)); !a.excluded(addr) {
+			a.list.addAddress(addr)
+		}
+
+	case (op == CALL || op == CALLCODE || op == DELEGATECALL || op == STATICCALL) && stack.len() >= 5:
+		if addr := common.BigToAddress(stack.Back(1)); !a.excluded(addr) {
+			a.list.addAddress(addr)
+		}
+	}
+	return nil
+}
+
+func (a *AccessListTracer) CaptureFault(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error {
+	return nil
+}
+
+func (a *AccessListTracer) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	a.err = err
+	return nil
+}
+
+// excluded returns whether the account is warm by default.
+func (a *AccessListTracer) excluded(addr common.Address) bool {
+	_, ok := a.excl[addr]
+	return ok
+}
+
+// AccessList returns the access list collected by the tracer.
+func (a *AccessListTracer) AccessList() types.AccessList { return a.list.accessList() }
+
+// Equal returns whether the two tracers collected the same access list.
+func (a *AccessListTracer) Equal(other *AccessListTracer) bool { return a.list.equal(other.list) }
+
+// Error returns the VM error captured by the trace.
+func (a *AccessListTracer) Error() error { return a.err }
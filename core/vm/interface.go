@@ -58,6 +58,20 @@ type StateDB interface {
 	RevertToSnapshot(int)
 	Snapshot() int
 
+	// PrepareAccessList warms up the accounts and slots accessible at the
+	// start of a transaction, AddressInAccessList and SlotInAccessList report
+	// whether an account or slot is warm.
+	PrepareAccessList(sender common.Address, dest *common.Address, precompiles []common.Address, txAccesses types.AccessList)
+	AddressInAccessList(addr common.Address) bool
+	SlotInAccessList(addr common.Address, slot common.Hash) (addressOk bool, slotOk bool)
+	// AddAddressToAccessList adds the given address to the access list. This
+	// operation is safe to perform even if the feature/fork is not active yet.
+	AddAddressToAccessList(addr common.Address)
+	// AddSlotToAccessList adds the given (address, slot) to the access list.
+	// This operation is safe to perform even if the feature/fork is not
+	// active yet.
+	AddSlotToAccessList(addr common.Address, slot common.Hash)
+
 	AddLog(*types.Log)
 	AddPreimage(common.Hash, []byte)
 
@@ -55,8 +55,19 @@ var (
 	homesteadInstructionSet      = NewHomesteadInstructionSet()
 	byzantiumInstructionSet      = NewByzantiumInstructionSet()
 	constantinopleInstructionSet = NewConstantinopleInstructionSet()
+	berlinInstructionSet         = NewBerlinInstructionSet()
 )
 
+// NewBerlinInstructionSet returns the frontier, homestead, byzantium,
+// constantinople and berlin instructions.
+func NewBerlinInstructionSet() [256]operation {
+	// instructions that can be executed during the constantinople phase,
+	// with state accesses priced by their warmth.
+	instructionSet := NewConstantinopleInstructionSet()
+	enableEIP2929(&instructionSet)
+	return instructionSet
+}
+
 // NewConstantinopleInstructionSet returns the frontier, homestead
 // byzantium and contantinople instructions.
 func NewConstantinopleInstructionSet() [256]operation {
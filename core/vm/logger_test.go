@@ -17,6 +17,7 @@
 package vm
 
 import (
+	"encoding/json"
 	"math/big"
 	"testing"
 
@@ -48,7 +49,7 @@ type dummyStateDB struct {
 
 func TestStoreCapture(t *testing.T) {
 	var (
-		env      = NewEVM(Context{}, nil, params.TestChainConfig, Config{EnableJit: false, ForceJit: false})
+		env      = NewEVM(Context{}, &dummyStateDB{}, params.TestChainConfig, Config{EnableJit: false, ForceJit: false})
 		logger   = NewStructLogger(nil)
 		mem      = NewMemory()
 		stack    = newstack()
@@ -68,3 +69,32 @@ func TestStoreCapture(t *testing.T) {
 		t.Errorf("expected %x, got %x", exp, logger.changedValues[contract.Address()][index])
 	}
 }
+
+// Tests that structured logs are encoded with all the fields of the standard
+// trace format, leaving out the memory only if it wasn't captured.
+func TestStructLogMarshalling(t *testing.T) {
+	log := StructLog{
+		Pc:            3,
+		Op:            SSTORE,
+		Gas:           100,
+		GasCost:       20000,
+		MemorySize:    32,
+		Stack:         []*big.Int{big.NewInt(1), big.NewInt(0)},
+		Depth:         1,
+		RefundCounter: 15000,
+	}
+	want := `{"pc":3,"op":85,"gas":"0x64","gasCost":"0x4e20","memSize":32,"stack":["0x1","0x0"],"depth":1,"refund":15000,"opName":"SSTORE","error":""}`
+	if blob, err := json.Marshal(log); err != nil {
+		t.Fatalf("failed to marshal log: %v", err)
+	} else if string(blob) != want {
+		t.Errorf("encoding mismatch:\nhave %s\nwant %s", blob, want)
+	}
+	// Decoding should restore the refund counter too
+	var dec StructLog
+	if err := json.Unmarshal([]byte(want), &dec); err != nil {
+		t.Fatalf("failed to unmarshal log: %v", err)
+	}
+	if dec.RefundCounter != log.RefundCounter || dec.Op != log.Op || dec.Gas != log.Gas {
+		t.Errorf("decoding mismatch: have %+v, want %+v", dec, log)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/common/math"
+	"github.com/utchain/go-utchain/params"
+)
+
+// enableEIP2929 replaces the gas functions of the state accessing instructions
+// with ones distinguishing cold and warm accesses (EIP-2929). The Berlin gas
+// table prices every access as warm, these functions charge the surcharge of
+// the first access to an account or storage slot within a transaction.
+func enableEIP2929(jt *[256]operation) {
+	jt[SLOAD].gasCost = gasSLoadEIP2929
+	jt[SSTORE].gasCost = gasSStoreEIP2929
+
+	jt[BALANCE].gasCost = makeAccountAccessGasEIP2929(gasBalance, 0)
+	jt[EXTCODESIZE].gasCost = makeAccountAccessGasEIP2929(gasExtCodeSize, 0)
+	jt[EXTCODECOPY].gasCost = makeAccountAccessGasEIP2929(gasExtCodeCopy, 0)
+
+	jt[CALL].gasCost = makeCallVariantGasEIP2929(gasCall)
+	jt[CALLCODE].gasCost = makeCallVariantGasEIP2929(gasCallCode)
+	jt[DELEGATECALL].gasCost = makeCallVariantGasEIP2929(gasDelegateCall)
+	jt[STATICCALL].gasCost = makeCallVariantGasEIP2929(gasStaticCall)
+
+	jt[SELFDESTRUCT].gasCost = gasSuicideEIP2929
+}
+
+// gasSLoadEIP2929 charges the cold read price for storage slots not yet
+// accessed within the transaction and the warm price otherwise.
+func gasSLoadEIP2929(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	slot := common.BigToHash(stack.Back(0))
+	if _, slotPresent := evm.StateDB.SlotInAccessList(contract.Address(), slot); !slotPresent {
+		evm.StateDB.AddSlotToAccessList(contract.Address(), slot)
+		return params.ColdSloadCostEIP2929, nil
+	}
+	return params.WarmStorageReadCostEIP2929, nil
+}
+
+// gasSStoreEIP2929 charges the cold read price on top of the storage write
+// costs for storage slots not yet accessed within the transaction.
+func gasSStoreEIP2929(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	var cost uint64
+
+	slot := common.BigToHash(stack.Back(0))
+	if _, slotPresent := evm.StateDB.SlotInAccessList(contract.Address(), slot); !slotPresent {
+		evm.StateDB.AddSlotToAccessList(contract.Address(), slot)
+		cost = params.ColdSloadCostEIP2929
+	}
+	gas, err := gasSStore(gt, evm, contract, stack, mem, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	var overflow bool
+	if gas, overflow = math.SafeAdd(gas, cost); overflow {
+		return 0, errGasUintOverflow
+	}
+	return gas, nil
+}
+
+// makeAccountAccessGasEIP2929 wraps the gas function of an instruction which
+// accesses the account at the given stack position, charging the cold access
+// surcharge if the account was not yet accessed within the transaction.
+func makeAccountAccessGasEIP2929(oldCalculator gasFunc, addrIdx int) gasFunc {
+	return func(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+		addr := common.BigToAddress(stack.Back(addrIdx))
+
+		gas, err := oldCalculator(gt, evm, contract, stack, mem, memorySize)
+		if err != nil {
+			return 0, err
+		}
+		if !evm.StateDB.AddressInAccessList(addr) {
+			evm.StateDB.AddAddressToAccessList(addr)
+
+			var overflow bool
+			if gas, overflow = math.SafeAdd(gas, params.ColdAccountAccessCostEIP2929-params.WarmStorageReadCostEIP2929); overflow {
+				return 0, errGasUintOverflow
+			}
+		}
+		return gas, nil
+	}
+}
+
+// makeCallVariantGasEIP2929 wraps the gas function of a call instruction,
+// charging the cold access surcharge if the callee was not yet accessed within
+// the transaction. The surcharge is deducted before the gas available to the
+// callee is calculated, so the 63/64 rule only applies to the remainder.
+func makeCallVariantGasEIP2929(oldCalculator gasFunc) gasFunc {
+	return func(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+		var (
+			addr       = common.BigToAddress(stack.Back(1))
+			warmAccess = evm.StateDB.AddressInAccessList(addr)
+			coldCost   = params.ColdAccountAccessCostEIP2929 - params.WarmStorageReadCostEIP2929
+		)
+		if !warmAccess {
+			evm.StateDB.AddAddressToAccessList(addr)
+			if !contract.UseGas(coldCost) {
+				return 0, ErrOutOfGas
+			}
+		}
+		gas, err := oldCalculator(gt, evm, contract, stack, mem, memorySize)
+		if warmAccess || err != nil {
+			return gas, err
+		}
+		// Give back the surcharge, the interpreter deducts the total cost
+		contract.Gas += coldCost
+
+		var overflow bool
+		if gas, overflow = math.SafeAdd(gas, coldCost); overflow {
+			return 0, errGasUintOverflow
+		}
+		return gas, nil
+	}
+}
+
+// gasSuicideEIP2929 charges the cold access price on top of the self destruct
+// costs if the beneficiary was not yet accessed within the transaction.
+func gasSuicideEIP2929(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	address := common.BigToAddress(stack.Back(0))
+
+	gas, err := gasSuicide(gt, evm, contract, stack, mem, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	if !evm.StateDB.AddressInAccessList(address) {
+		evm.StateDB.AddAddressToAccessList(address)
+
+		var overflow bool
+		if gas, overflow = math.SafeAdd(gas, params.ColdAccountAccessCostEIP2929); overflow {
+			return 0, errGasUintOverflow
+		}
+	}
+	return gas, nil
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/utchain/go-utchain/common"
 	"github.com/utchain/go-utchain/core/state"
 	"github.com/utchain/go-utchain/core/vm"
+	"github.com/utchain/go-utchain/params"
 	"github.com/utchain/go-utchain/tstdb"
 )
 
@@ -117,6 +118,40 @@ func TestCall(t *testing.T) {
 	}
 }
 
+// Tests that from Berlin on the first access to a storage slot is charged the
+// cold price and any further access the warm one.
+func TestBerlinColdWarmStorageAccess(t *testing.T) {
+	db, _ := tstdb.NewMemDatabase()
+	state, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	address := common.HexToAddress("0x0a")
+	state.SetCode(address, []byte{
+		byte(vm.PUSH1), 0,
+		byte(vm.SLOAD),
+		byte(vm.POP),
+		byte(vm.PUSH1), 0,
+		byte(vm.SLOAD),
+		byte(vm.POP),
+	})
+	config := &params.ChainConfig{
+		ChainId:             big.NewInt(1),
+		HomesteadBlock:      new(big.Int),
+		EIP150Block:         new(big.Int),
+		EIP155Block:         new(big.Int),
+		EIP158Block:         new(big.Int),
+		ByzantiumBlock:      new(big.Int),
+		ConstantinopleBlock: new(big.Int),
+		BerlinBlock:         new(big.Int),
+	}
+	_, leftOverGas, err := Call(address, nil, &Config{State: state, ChainConfig: config, GasLimit: 100000})
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+	want := 2*(vm.GasFastestStep+vm.GasQuickStep) + params.ColdSloadCostEIP2929 + params.WarmStorageReadCostEIP2929
+	if used := 100000 - leftOverGas; used != want {
+		t.Errorf("gas used mismatch: have %d, want %d", used, want)
+	}
+}
+
 func BenchmarkCall(b *testing.B) {
 	var definition = `[{"constant":true,"inputs":[],"name":"seller","outputs":[{"name":"","type":"address"}],"type":"function"},{"constant":false,"inputs":[],"name":"abort","outputs":[],"type":"function"},{"constant":true,"inputs":[],"name":"value","outputs":[{"name":"","type":"uint256"}],"type":"function"},{"constant":false,"inputs":[],"name":"refund","outputs":[],"type":"function"},{"constant":true,"inputs":[],"name":"buyer","outputs":[{"name":"","type":"address"}],"type":"function"},{"constant":false,"inputs":[],"name":"confirmReceived","outputs":[],"type":"function"},{"constant":true,"inputs":[],"name":"state","outputs":[{"name":"","type":"uint8"}],"type":"function"},{"constant":false,"inputs":[],"name":"confirmPurchase","outputs":[],"type":"function"},{"inputs":[],"type":"constructor"},{"anonymous":false,"inputs":[],"name":"Aborted","type":"event"},{"anonymous":false,"inputs":[],"name":"PurchaseConfirmed","type":"event"},{"anonymous":false,"inputs":[],"name":"ItemReceived","type":"event"},{"anonymous":false,"inputs":[],"name":"Refunded","type":"event"}]`
 
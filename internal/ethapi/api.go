@@ -31,7 +31,9 @@ import (
 	"github.com/utchain/go-utchain/common/hexutil"
 	"github.com/utchain/go-utchain/common/math"
 	"github.com/utchain/go-utchain/consensus/ethash"
+	"github.com/utchain/go-utchain/consensus/misc"
 	"github.com/utchain/go-utchain/core"
+	"github.com/utchain/go-utchain/core/state"
 	"github.com/utchain/go-utchain/core/types"
 	"github.com/utchain/go-utchain/core/vm"
 	"github.com/utchain/go-utchain/crypto"
@@ -64,6 +66,12 @@ func (s *PublicUTChainAPI) GasPrice(ctx context.Context) (*big.Int, error) {
 	return s.b.SuggestPrice(ctx)
 }
 
+// MaxPriorityFeePerGas returns a suggestion for the tip paid to the miner on top
+// of the base fee by dynamic fee transactions.
+func (s *PublicUTChainAPI) MaxPriorityFeePerGas(ctx context.Context) (*big.Int, error) {
+	return s.b.SuggestTipCap(ctx)
+}
+
 // ProtocolVersion returns the current UTChain protocol version this node supports
 func (s *PublicUTChainAPI) ProtocolVersion() hexutil.Uint {
 	return hexutil.Uint(s.b.ProtocolVersion())
@@ -114,18 +122,22 @@ func (s *PublicTxPoolAPI) Content() map[string]map[string]map[string]*RPCTransac
 	// Flatten the pending transactions
 	for account, txs := range pending {
 		dump := make(map[string]*RPCTransaction)
-		for _, tx := range txs {
+		for _, tx := range s.public(txs) {
 			dump[fmt.Sprintf("%d", tx.Nonce())] = newRPCPendingTransaction(tx)
 		}
-		content["pending"][account.Hex()] = dump
+		if len(dump) > 0 {
+			content["pending"][account.Hex()] = dump
+		}
 	}
 	// Flatten the queued transactions
 	for account, txs := range queue {
 		dump := make(map[string]*RPCTransaction)
-		for _, tx := range txs {
+		for _, tx := range s.public(txs) {
 			dump[fmt.Sprintf("%d", tx.Nonce())] = newRPCPendingTransaction(tx)
 		}
-		content["queued"][account.Hex()] = dump
+		if len(dump) > 0 {
+			content["queued"][account.Hex()] = dump
+		}
 	}
 	return content
 }
@@ -158,22 +170,164 @@ func (s *PublicTxPoolAPI) Inspect() map[string]map[string]map[string]string {
 	// Flatten the pending transactions
 	for account, txs := range pending {
 		dump := make(map[string]string)
-		for _, tx := range txs {
+		for _, tx := range s.public(txs) {
 			dump[fmt.Sprintf("%d", tx.Nonce())] = format(tx)
 		}
-		content["pending"][account.Hex()] = dump
+		if len(dump) > 0 {
+			content["pending"][account.Hex()] = dump
+		}
 	}
 	// Flatten the queued transactions
 	for account, txs := range queue {
 		dump := make(map[string]string)
-		for _, tx := range txs {
+		for _, tx := range s.public(txs) {
 			dump[fmt.Sprintf("%d", tx.Nonce())] = format(tx)
 		}
-		content["queued"][account.Hex()] = dump
+		if len(dump) > 0 {
+			content["queued"][account.Hex()] = dump
+		}
 	}
 	return content
 }
 
+// public filters the transactions withheld from the network out of a list, as
+// the public API must not reveal them before they are included.
+func (s *PublicTxPoolAPI) public(txs types.Transactions) types.Transactions {
+	filtered := make(types.Transactions, 0, len(txs))
+	for _, tx := range txs {
+		if !s.b.IsPrivateTx(tx.Hash()) {
+			filtered = append(filtered, tx)
+		}
+	}
+	return filtered
+}
+
+// RPCTxExplanation describes why a transaction is in the state it is in the pool,
+// or why it was dropped from it.
+type RPCTxExplanation struct {
+	Hash          common.Hash      `json:"hash"`
+	Status        string           `json:"status"`
+	Reason        string           `json:"reason"`
+	Transaction   *RPCTransaction  `json:"transaction,omitempty"`
+	Local         bool             `json:"local"`
+	StateNonce    *hexutil.Uint64  `json:"stateNonce,omitempty"`
+	PendingNonce  *hexutil.Uint64  `json:"pendingNonce,omitempty"`
+	MissingNonces []hexutil.Uint64 `json:"missingNonces,omitempty"`
+	Queued        *hexutil.Uint64  `json:"queued,omitempty"`
+	QueueLimit    *hexutil.Uint64  `json:"queueLimit,omitempty"`
+	Expiry        *hexutil.Uint64  `json:"expiry,omitempty"`
+	DropTime      *hexutil.Uint64  `json:"dropTime,omitempty"`
+	Replacement   *common.Hash     `json:"replacement,omitempty"`
+}
+
+// newRPCTxExplanation converts a transaction pool explanation into its RPC
+// representation.
+func newRPCTxExplanation(expl *core.TxExplanation) *RPCTxExplanation {
+	result := &RPCTxExplanation{
+		Hash:   expl.Hash,
+		Status: "unknown",
+		Reason: "transaction not known to the pool",
+		Local:  expl.Local,
+	}
+	if expl.Tx != nil {
+		result.Transaction = newRPCPendingTransaction(expl.Tx)
+	}
+	switch {
+	case expl.Dropped:
+		result.Status, result.Reason = "dropped", string(expl.DropReason)
+
+		dropped := hexutil.Uint64(expl.DropTime.Unix())
+		result.DropTime = &dropped
+		if expl.Replacement != (common.Hash{}) {
+			result.Replacement = &expl.Replacement
+		}
+		return result
+
+	case expl.Status == core.TxStatusPending:
+		result.Status, result.Reason = "pending", "executable"
+
+	case expl.Status == core.TxStatusQueued:
+		result.Status, result.Reason = "queued", "awaiting promotion"
+		if len(expl.MissingNonces) > 0 {
+			result.Reason = fmt.Sprintf("nonce gap, %d missing", len(expl.MissingNonces))
+			for _, nonce := range expl.MissingNonces {
+				result.MissingNonces = append(result.MissingNonces, hexutil.Uint64(nonce))
+			}
+		}
+	default:
+		return result
+	}
+	// The transaction is pooled, report the account state limiting it
+	stateNonce, pendingNonce, queued := hexutil.Uint64(expl.StateNonce), hexutil.Uint64(expl.PendingNonce), hexutil.Uint64(expl.Queued)
+	result.StateNonce, result.PendingNonce, result.Queued = &stateNonce, &pendingNonce, &queued
+
+	if !expl.Local && expl.Queued > 0 {
+		limit, expiry := hexutil.Uint64(expl.QueueLimit), hexutil.Uint64(expl.Expiry/time.Second)
+		result.QueueLimit, result.Expiry = &limit, &expiry
+	}
+	return result
+}
+
+// Explain describes why a transaction is pending or queued in the transaction
+// pool, or why it was dropped from it if that happened recently.
+func (s *PublicTxPoolAPI) Explain(hash common.Hash) *RPCTxExplanation {
+	expl := s.b.TxPoolExplain(hash)
+	if expl.Private {
+		// Private transactions are reported as unknown to the pool
+		expl = &core.TxExplanation{Hash: hash, Status: core.TxStatusUnknown}
+	}
+	return newRPCTxExplanation(expl)
+}
+
+// Drops creates a subscription that is notified each time a transaction is
+// dropped from the transaction pool or replaced by another one.
+func (s *PublicTxPoolAPI) Drops(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		drops := make(chan core.TxDropEvent, 128)
+		dropSub := s.b.SubscribeTxDropEvent(drops)
+		defer dropSub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-drops:
+				if ev.Private {
+					continue
+				}
+				notifier.Notify(rpcSub.ID, newRPCTxExplanation(&core.TxExplanation{
+					Hash:        ev.Tx.Hash(),
+					Tx:          ev.Tx,
+					Dropped:     true,
+					DropReason:  ev.Reason,
+					DropTime:    ev.Time,
+					Replacement: replacementHash(ev.Replacement),
+				}))
+			case <-dropSub.Err():
+				return
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
+// replacementHash returns the hash of a replacement transaction, or the empty
+// hash if there is none.
+func replacementHash(tx *types.Transaction) common.Hash {
+	if tx == nil {
+		return common.Hash{}
+	}
+	return tx.Hash()
+}
+
 // PublicAccountAPI provides an API to access accounts managed by this node.
 // It offers only methods that can retrieve accounts.
 type PublicAccountAPI struct {
@@ -394,7 +548,7 @@ func (s *PrivateAccountAPI) SignTransaction(ctx context.Context, args SendTxArgs
 	if err != nil {
 		return nil, err
 	}
-	data, err := rlp.EncodeToBytes(signed)
+	data, err := signed.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
@@ -601,6 +755,83 @@ func (s *PublicBlockChainAPI) GetStorageAt(ctx context.Context, address common.A
 	return res[:], state.Error()
 }
 
+// AccountResult is the result of a GetProof call, containing the account fields
+// along with the Merkle proof of the account and the requested storage slots.
+type AccountResult struct {
+	Address      common.Address  `json:"address"`
+	AccountProof []string        `json:"accountProof"`
+	Balance      *hexutil.Big    `json:"balance"`
+	CodeHash     common.Hash     `json:"codeHash"`
+	Nonce        hexutil.Uint64  `json:"nonce"`
+	StorageHash  common.Hash     `json:"storageHash"`
+	StorageProof []StorageResult `json:"storageProof"`
+}
+
+// StorageResult is the value and Merkle proof of a single storage slot.
+type StorageResult struct {
+	Key   string       `json:"key"`
+	Value *hexutil.Big `json:"value"`
+	Proof []string     `json:"proof"`
+}
+
+// GetProof returns the Merkle proof for the given account and optionally some of
+// its storage keys in the state of the given block number. The rpc.LatestBlockNumber
+// and rpc.PendingBlockNumber meta block numbers are also allowed.
+func (s *PublicBlockChainAPI) GetProof(ctx context.Context, address common.Address, storageKeys []string, blockNr rpc.BlockNumber) (*AccountResult, error) {
+	state, _, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
+	if state == nil || err != nil {
+		return nil, err
+	}
+	storageTrie := state.StorageTrie(address)
+	storageHash := types.EmptyRootHash
+	codeHash := state.GetCodeHash(address)
+
+	// A missing storage trie means the account does not exist, in which case the
+	// code hash is the hash of the empty code.
+	if storageTrie != nil {
+		storageHash = storageTrie.Hash()
+	} else {
+		codeHash = crypto.Keccak256Hash(nil)
+	}
+	// Create the proofs for the requested storage slots
+	storageProof := make([]StorageResult, len(storageKeys))
+	for i, key := range storageKeys {
+		if storageTrie == nil {
+			storageProof[i] = StorageResult{key, &hexutil.Big{}, []string{}}
+			continue
+		}
+		slot := common.HexToHash(key)
+		proof, err := state.GetStorageProof(address, slot)
+		if err != nil {
+			return nil, err
+		}
+		storageProof[i] = StorageResult{key, (*hexutil.Big)(state.GetState(address, slot).Big()), toHexSlice(proof)}
+	}
+	// Create the proof for the account itself
+	accountProof, err := state.GetProof(address)
+	if err != nil {
+		return nil, err
+	}
+	return &AccountResult{
+		Address:      address,
+		AccountProof: toHexSlice(accountProof),
+		Balance:      (*hexutil.Big)(state.GetBalance(address)),
+		CodeHash:     codeHash,
+		Nonce:        hexutil.Uint64(state.GetNonce(address)),
+		StorageHash:  storageHash,
+		StorageProof: storageProof,
+	}, state.Error()
+}
+
+// toHexSlice encodes a list of byte slices into their 0x prefixed hex form.
+func toHexSlice(b [][]byte) []string {
+	r := make([]string, len(b))
+	for i := range b {
+		r[i] = hexutil.Encode(b[i])
+	}
+	return r
+}
+
 // CallArgs represents the arguments for a call.
 type CallArgs struct {
 	From     common.Address  `json:"from"`
@@ -609,35 +840,96 @@ type CallArgs struct {
 	GasPrice hexutil.Big     `json:"gasPrice"`
 	Value    hexutil.Big     `json:"value"`
 	Data     hexutil.Bytes   `json:"data"`
+	// Accounts and storage slots to warm up before the call (Berlin onwards).
+	AccessList *types.AccessList `json:"accessList"`
+}
+
+// ToMessage converts the call arguments into a message to execute on top of a
+// block with the given base fee, setting a default gas allowance and gas price
+// if none were set.
+func (args *CallArgs) ToMessage(baseFee *big.Int) types.Message {
+	gas, gasPrice := uint64(args.Gas), args.GasPrice.ToInt()
+	if gas == 0 {
+		gas = math.MaxUint64 / 2
+	}
+	if gasPrice.Sign() == 0 {
+		gasPrice = new(big.Int).SetUint64(defaultGasPrice)
+		// Make sure the default price covers the base fee of the block
+		if baseFee != nil && gasPrice.Cmp(baseFee) < 0 {
+			gasPrice = new(big.Int).Set(baseFee)
+		}
+	}
+	var accessList types.AccessList
+	if args.AccessList != nil {
+		accessList = *args.AccessList
+	}
+	return types.NewMessage(args.From, args.To, 0, args.Value.ToInt(), gas, gasPrice, args.Data, accessList, false)
+}
+
+// OverrideAccount specifies the account fields to replace before executing a
+// message call. State and StateDiff are mutually exclusive: State replaces the
+// entire storage of the account, whereas StateDiff only overrides the given slots.
+type OverrideAccount struct {
+	Nonce     *hexutil.Uint64              `json:"nonce"`
+	Code      *hexutil.Bytes               `json:"code"`
+	Balance   **hexutil.Big                `json:"balance"`
+	State     *map[common.Hash]common.Hash `json:"state"`
+	StateDiff *map[common.Hash]common.Hash `json:"stateDiff"`
 }
 
-func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber, vmCfg vm.Config, timeout time.Duration) ([]byte, uint64, bool, error) {
+// StateOverride is the set of accounts to override before executing a call.
+type StateOverride map[common.Address]OverrideAccount
+
+// Apply writes the overridden account fields into the given state.
+func (diff *StateOverride) Apply(state *state.StateDB) error {
+	if diff == nil {
+		return nil
+	}
+	for addr, account := range *diff {
+		if account.Nonce != nil {
+			state.SetNonce(addr, uint64(*account.Nonce))
+		}
+		if account.Code != nil {
+			state.SetCode(addr, *account.Code)
+		}
+		if account.Balance != nil {
+			state.SetBalance(addr, (*big.Int)(*account.Balance))
+		}
+		if account.State != nil && account.StateDiff != nil {
+			return fmt.Errorf("account %s has both 'state' and 'stateDiff'", addr.Hex())
+		}
+		if account.State != nil {
+			state.SetStorage(addr, *account.State)
+		}
+		if account.StateDiff != nil {
+			for key, value := range *account.StateDiff {
+				state.SetState(addr, key, value)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber, overrides *StateOverride, vmCfg vm.Config, timeout time.Duration) ([]byte, uint64, bool, error) {
 	defer func(start time.Time) { log.Debug("Executing EVM call finished", "runtime", time.Since(start)) }(time.Now())
 
 	state, header, err := s.b.StateAndHeaderByNumber(ctx, blockNr)
 	if state == nil || err != nil {
 		return nil, 0, false, err
 	}
+	if err := overrides.Apply(state); err != nil {
+		return nil, 0, false, err
+	}
 	// Set sender address or use a default if none specified
-	addr := args.From
-	if addr == (common.Address{}) {
+	if args.From == (common.Address{}) {
 		if wallets := s.b.AccountManager().Wallets(); len(wallets) > 0 {
 			if accounts := wallets[0].Accounts(); len(accounts) > 0 {
-				addr = accounts[0].Address
+				args.From = accounts[0].Address
 			}
 		}
 	}
-	// Set default gas & gas price if none were set
-	gas, gasPrice := uint64(args.Gas), args.GasPrice.ToInt()
-	if gas == 0 {
-		gas = math.MaxUint64 / 2
-	}
-	if gasPrice.Sign() == 0 {
-		gasPrice = new(big.Int).SetUint64(defaultGasPrice)
-	}
-
 	// Create new call message
-	msg := types.NewMessage(addr, args.To, 0, args.Value.ToInt(), gas, gasPrice, args.Data, false)
+	msg := args.ToMessage(header.BaseFee)
 
 	// Setup context so it may be cancelled the call has completed
 	// or, in case of unmetered gas, setup a context with a timeout.
@@ -675,14 +967,18 @@ func (s *PublicBlockChainAPI) doCall(ctx context.Context, args CallArgs, blockNr
 
 // Call executes the given transaction on the state for the given block number.
 // It doesn't make and changes in the state/blockchain and is useful to execute and retrieve values.
-func (s *PublicBlockChainAPI) Call(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber) (hexutil.Bytes, error) {
-	result, _, _, err := s.doCall(ctx, args, blockNr, vm.Config{}, 5*time.Second)
+//
+// Additionally, the caller can specify a batch of accounts to override before
+// executing the call, e.g. to simulate balances or contract code that don't exist.
+func (s *PublicBlockChainAPI) Call(ctx context.Context, args CallArgs, blockNr rpc.BlockNumber, overrides *StateOverride) (hexutil.Bytes, error) {
+	result, _, _, err := s.doCall(ctx, args, blockNr, overrides, vm.Config{}, 5*time.Second)
 	return (hexutil.Bytes)(result), err
 }
 
 // EstimateGas returns an estimate of the amount of gas needed to execute the
-// given transaction against the current pending block.
-func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (hexutil.Uint64, error) {
+// given transaction against the current pending block, optionally with a set of
+// account overrides applied on top of it.
+func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs, overrides *StateOverride) (hexutil.Uint64, error) {
 	// Binary search the gas requirement, as it may be higher than the amount used
 	var (
 		lo  uint64 = params.TxGas - 1
@@ -701,11 +997,22 @@ func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (h
 	}
 	cap = hi
 
+	// Apply the overrides once up front, as a malformed set would otherwise fail
+	// every execution below and be reported as an insufficient gas allowance.
+	if overrides != nil {
+		state, _, err := s.b.StateAndHeaderByNumber(ctx, rpc.PendingBlockNumber)
+		if state == nil || err != nil {
+			return 0, err
+		}
+		if err := overrides.Apply(state); err != nil {
+			return 0, err
+		}
+	}
 	// Create a helper to check if a gas allowance results in an executable transaction
 	executable := func(gas uint64) bool {
 		args.Gas = hexutil.Uint64(gas)
 
-		_, _, failed, err := s.doCall(ctx, args, rpc.PendingBlockNumber, vm.Config{}, 0)
+		_, _, failed, err := s.doCall(ctx, args, rpc.PendingBlockNumber, overrides, vm.Config{}, 0)
 		if err != nil || failed {
 			return false
 		}
@@ -729,6 +1036,69 @@ func (s *PublicBlockChainAPI) EstimateGas(ctx context.Context, args CallArgs) (h
 	return hexutil.Uint64(hi), nil
 }
 
+// accessListResult is the result of an access list creation: the list itself,
+// the gas the call uses with it and the error the execution failed with, if any.
+type accessListResult struct {
+	Accesslist *types.AccessList `json:"accessList"`
+	Error      string            `json:"error,omitempty"`
+	GasUsed    hexutil.Uint64    `json:"gasUsed"`
+}
+
+// CreateAccessList creates an access list for the given transaction by tracing
+// the accounts and storage slots it touches. As the access list itself alters
+// the gas available to the execution, the call is repeated with the collected
+// list until it no longer changes. It defaults to the pending block if no block
+// number is given.
+func (s *PublicBlockChainAPI) CreateAccessList(ctx context.Context, args CallArgs, blockNr *rpc.BlockNumber) (*accessListResult, error) {
+	bNr := rpc.PendingBlockNumber
+	if blockNr != nil {
+		bNr = *blockNr
+	}
+	state, header, err := s.b.StateAndHeaderByNumber(ctx, bNr)
+	if state == nil || err != nil {
+		return nil, err
+	}
+	// Resolve the sender the same way the call does, it is excluded from the list
+	if args.From == (common.Address{}) {
+		if wallets := s.b.AccountManager().Wallets(); len(wallets) > 0 {
+			if accounts := wallets[0].Accounts(); len(accounts) > 0 {
+				args.From = accounts[0].Address
+			}
+		}
+	}
+	var to common.Address
+	if args.To != nil {
+		to = *args.To
+	} else {
+		to = crypto.CreateAddress(args.From, state.GetNonce(args.From))
+	}
+	precompiles := vm.ActivePrecompiles(s.b.ChainConfig().Rules(header.Number))
+
+	var prevList types.AccessList
+	if args.AccessList != nil {
+		prevList = *args.AccessList
+	}
+	prevTracer := vm.NewAccessListTracer(prevList, args.From, to, precompiles)
+	for {
+		accessList := prevTracer.AccessList()
+		args.AccessList = &accessList
+
+		tracer := vm.NewAccessListTracer(accessList, args.From, to, precompiles)
+		_, gas, _, err := s.doCall(ctx, args, bNr, nil, vm.Config{Debug: true, Tracer: tracer}, 5*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply transaction: %v", err)
+		}
+		if tracer.Equal(prevTracer) {
+			result := &accessListResult{Accesslist: &accessList, GasUsed: hexutil.Uint64(gas)}
+			if vmErr := tracer.Error(); vmErr != nil {
+				result.Error = vmErr.Error()
+			}
+			return result, nil
+		}
+		prevTracer = tracer
+	}
+}
+
 // ExecutionResult groups all structured logs emitted by the EVM
 // while replaying a transaction in debug mode as well as transaction
 // execution status, the amount of gas used and the return value
@@ -815,6 +1185,9 @@ func (s *PublicBlockChainAPI) rpcOutputBlock(b *types.Block, inclTx bool, fullTx
 		"transactionsRoot": head.TxHash,
 		"receiptsRoot":     head.ReceiptHash,
 	}
+	if head.BaseFee != nil {
+		fields["baseFeePerGas"] = (*hexutil.Big)(head.BaseFee)
+	}
 
 	if inclTx {
 		formatTx := func(tx *types.Transaction) (interface{}, error) {
@@ -850,28 +1223,35 @@ func (s *PublicBlockChainAPI) rpcOutputBlock(b *types.Block, inclTx bool, fullTx
 
 // RPCTransaction represents a transaction that will serialize to the RPC representation of a transaction
 type RPCTransaction struct {
-	BlockHash        common.Hash     `json:"blockHash"`
-	BlockNumber      *hexutil.Big    `json:"blockNumber"`
-	From             common.Address  `json:"from"`
-	Gas              hexutil.Uint64  `json:"gas"`
-	GasPrice         *hexutil.Big    `json:"gasPrice"`
-	Hash             common.Hash     `json:"hash"`
-	Input            hexutil.Bytes   `json:"input"`
-	Nonce            hexutil.Uint64  `json:"nonce"`
-	To               *common.Address `json:"to"`
-	TransactionIndex hexutil.Uint    `json:"transactionIndex"`
-	Value            *hexutil.Big    `json:"value"`
-	V                *hexutil.Big    `json:"v"`
-	R                *hexutil.Big    `json:"r"`
-	S                *hexutil.Big    `json:"s"`
+	BlockHash        common.Hash       `json:"blockHash"`
+	BlockNumber      *hexutil.Big      `json:"blockNumber"`
+	From             common.Address    `json:"from"`
+	Gas              hexutil.Uint64    `json:"gas"`
+	GasPrice         *hexutil.Big      `json:"gasPrice"`
+	Hash             common.Hash       `json:"hash"`
+	Input            hexutil.Bytes     `json:"input"`
+	Nonce            hexutil.Uint64    `json:"nonce"`
+	To               *common.Address   `json:"to"`
+	TransactionIndex hexutil.Uint      `json:"transactionIndex"`
+	Value            *hexutil.Big      `json:"value"`
+	V                *hexutil.Big      `json:"v"`
+	R                *hexutil.Big      `json:"r"`
+	S                *hexutil.Big      `json:"s"`
+	Type             hexutil.Uint64    `json:"type"`
+	ChainID          *hexutil.Big      `json:"chainId,omitempty"`
+	GasFeeCap        *hexutil.Big      `json:"maxFeePerGas,omitempty"`
+	GasTipCap        *hexutil.Big      `json:"maxPriorityFeePerGas,omitempty"`
+	Accesses         *types.AccessList `json:"accessList,omitempty"`
 }
 
 // newRPCTransaction returns a transaction that will serialize to the RPC
-// representation, with the given location metadata set (if available).
-func newRPCTransaction(tx *types.Transaction, blockHash common.Hash, blockNumber uint64, index uint64) *RPCTransaction {
+// representation, with the given location metadata set (if available). For
+// dynamic fee transactions included in a block with the given base fee, the
+// gas price reports the effective price paid.
+func newRPCTransaction(tx *types.Transaction, blockHash common.Hash, blockNumber uint64, index uint64, baseFee *big.Int) *RPCTransaction {
 	var signer types.Signer = types.FrontierSigner{}
 	if tx.Protected() {
-		signer = types.NewEIP155Signer(tx.ChainId())
+		signer = types.NewEIP1559Signer(tx.ChainId())
 	}
 	from, _ := types.Sender(signer, tx)
 	v, r, s := tx.RawSignatureValues()
@@ -888,6 +1268,19 @@ func newRPCTransaction(tx *types.Transaction, blockHash common.Hash, blockNumber
 		V:        (*hexutil.Big)(v),
 		R:        (*hexutil.Big)(r),
 		S:        (*hexutil.Big)(s),
+		Type:     hexutil.Uint64(tx.Type()),
+	}
+	if tx.Type() != types.LegacyTxType {
+		al := tx.AccessList()
+		result.ChainID = (*hexutil.Big)(tx.ChainId())
+		result.Accesses = &al
+	}
+	if tx.Type() == types.DynamicFeeTxType {
+		result.GasFeeCap = (*hexutil.Big)(tx.GasFeeCap())
+		result.GasTipCap = (*hexutil.Big)(tx.GasTipCap())
+		if baseFee != nil && blockHash != (common.Hash{}) {
+			result.GasPrice = (*hexutil.Big)(new(big.Int).Add(baseFee, tx.EffectiveGasTip(baseFee)))
+		}
 	}
 	if blockHash != (common.Hash{}) {
 		result.BlockHash = blockHash
@@ -899,7 +1292,7 @@ func newRPCTransaction(tx *types.Transaction, blockHash common.Hash, blockNumber
 
 // newRPCPendingTransaction returns a pending transaction that will serialize to the RPC representation
 func newRPCPendingTransaction(tx *types.Transaction) *RPCTransaction {
-	return newRPCTransaction(tx, common.Hash{}, 0, 0)
+	return newRPCTransaction(tx, common.Hash{}, 0, 0, nil)
 }
 
 // newRPCTransactionFromBlockIndex returns a transaction that will serialize to the RPC representation.
@@ -908,7 +1301,7 @@ func newRPCTransactionFromBlockIndex(b *types.Block, index uint64) *RPCTransacti
 	if index >= uint64(len(txs)) {
 		return nil
 	}
-	return newRPCTransaction(txs[index], b.Hash(), b.NumberU64(), index)
+	return newRPCTransaction(txs[index], b.Hash(), b.NumberU64(), index, b.BaseFee())
 }
 
 // newRPCRawTransactionFromBlockIndex returns the bytes of a transaction given a block and a transaction index.
@@ -917,7 +1310,7 @@ func newRPCRawTransactionFromBlockIndex(b *types.Block, index uint64) hexutil.By
 	if index >= uint64(len(txs)) {
 		return nil
 	}
-	blob, _ := rlp.EncodeToBytes(txs[index])
+	blob, _ := txs[index].MarshalBinary()
 	return blob
 }
 
@@ -1006,10 +1399,14 @@ func (s *PublicTransactionPoolAPI) GetTransactionCount(ctx context.Context, addr
 func (s *PublicTransactionPoolAPI) GetTransactionByHash(ctx context.Context, hash common.Hash) *RPCTransaction {
 	// Try to return an already finalized transaction
 	if tx, blockHash, blockNumber, index := core.GetTransaction(s.b.ChainDb(), hash); tx != nil {
-		return newRPCTransaction(tx, blockHash, blockNumber, index)
+		var baseFee *big.Int
+		if header := core.GetHeader(s.b.ChainDb(), blockHash, blockNumber); header != nil {
+			baseFee = header.BaseFee
+		}
+		return newRPCTransaction(tx, blockHash, blockNumber, index, baseFee)
 	}
 	// No finalized transaction, try to retrieve it from the pool
-	if tx := s.b.GetPoolTransaction(hash); tx != nil {
+	if tx := s.b.GetPoolTransaction(hash); tx != nil && !s.b.IsPrivateTx(hash) {
 		return newRPCPendingTransaction(tx)
 	}
 	// Transaction unknown, return as such
@@ -1022,13 +1419,13 @@ func (s *PublicTransactionPoolAPI) GetRawTransactionByHash(ctx context.Context,
 
 	// Retrieve a finalized transaction, or a pooled otherwise
 	if tx, _, _, _ = core.GetTransaction(s.b.ChainDb(), hash); tx == nil {
-		if tx = s.b.GetPoolTransaction(hash); tx == nil {
-			// Transaction not found anywhere, abort
+		if tx = s.b.GetPoolTransaction(hash); tx == nil || s.b.IsPrivateTx(hash) {
+			// Transaction not found anywhere (or withheld from the public), abort
 			return nil, nil
 		}
 	}
-	// Serialize to RLP and return
-	return rlp.EncodeToBytes(tx)
+	// Serialize to the canonical encoding and return
+	return tx.MarshalBinary()
 }
 
 // GetTransactionReceipt returns the transaction receipt for the given transaction hash.
@@ -1048,7 +1445,7 @@ func (s *PublicTransactionPoolAPI) GetTransactionReceipt(ctx context.Context, ha
 
 	var signer types.Signer = types.FrontierSigner{}
 	if tx.Protected() {
-		signer = types.NewEIP155Signer(tx.ChainId())
+		signer = types.NewEIP1559Signer(tx.ChainId())
 	}
 	from, _ := types.Sender(signer, tx)
 
@@ -1107,6 +1504,14 @@ type SendTxArgs struct {
 	GasPrice *hexutil.Big    `json:"gasPrice"`
 	Value    *hexutil.Big    `json:"value"`
 	Nonce    *hexutil.Uint64 `json:"nonce"`
+	// Setting any of the EIP1559 fee fields creates a dynamic fee transaction
+	// instead of a legacy one.
+	MaxFeePerGas         *hexutil.Big `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big `json:"maxPriorityFeePerGas"`
+	ChainID              *hexutil.Big `json:"chainId,omitempty"`
+	// Setting an access list without any of the EIP1559 fee fields creates an
+	// EIP-2930 access list transaction.
+	AccessList *types.AccessList `json:"accessList,omitempty"`
 	// We accept "data" and "input" for backwards-compatibility reasons. "input" is the
 	// newer name and should be preferred by clients.
 	Data  *hexutil.Bytes `json:"data"`
@@ -1119,12 +1524,8 @@ func (args *SendTxArgs) setDefaults(ctx context.Context, b Backend) error {
 		args.Gas = new(hexutil.Uint64)
 		*(*uint64)(args.Gas) = 90000
 	}
-	if args.GasPrice == nil {
-		price, err := b.SuggestPrice(ctx)
-		if err != nil {
-			return err
-		}
-		args.GasPrice = (*hexutil.Big)(price)
+	if err := args.setFeeDefaults(ctx, b); err != nil {
+		return err
 	}
 	if args.Value == nil {
 		args.Value = new(hexutil.Big)
@@ -1154,6 +1555,62 @@ func (args *SendTxArgs) setDefaults(ctx context.Context, b Backend) error {
 	return nil
 }
 
+// setFeeDefaults fills in the gas price of legacy transactions, or the fee cap
+// and tip of dynamic fee transactions if any of those was requested.
+func (args *SendTxArgs) setFeeDefaults(ctx context.Context, b Backend) error {
+	if args.MaxFeePerGas == nil && args.MaxPriorityFeePerGas == nil {
+		if args.GasPrice == nil {
+			price, err := b.SuggestPrice(ctx)
+			if err != nil {
+				return err
+			}
+			args.GasPrice = (*hexutil.Big)(price)
+		}
+		if args.AccessList == nil {
+			return nil
+		}
+		head := b.CurrentBlock().Header()
+		if !b.ChainConfig().IsBerlin(new(big.Int).Add(head.Number, common.Big1)) {
+			return errors.New("access list transactions are not supported before the Berlin fork")
+		}
+		return args.setChainIDDefault(b)
+	}
+	if args.GasPrice != nil {
+		return errors.New("both gasPrice and (maxFeePerGas or maxPriorityFeePerGas) specified")
+	}
+	head := b.CurrentBlock().Header()
+	if !b.ChainConfig().IsEIP1559(new(big.Int).Add(head.Number, common.Big1)) {
+		return errors.New("dynamic fee transactions are not supported before the EIP1559 fork")
+	}
+	if args.MaxPriorityFeePerGas == nil {
+		tip, err := b.SuggestTipCap(ctx)
+		if err != nil {
+			return err
+		}
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tip)
+	}
+	if args.MaxFeePerGas == nil {
+		// Leave room for the base fee to double before the transaction is included
+		feeCap := new(big.Int).Mul(misc.CalcBaseFee(b.ChainConfig(), head), common.Big2)
+		args.MaxFeePerGas = (*hexutil.Big)(feeCap.Add(feeCap, args.MaxPriorityFeePerGas.ToInt()))
+	}
+	if args.MaxFeePerGas.ToInt().Cmp(args.MaxPriorityFeePerGas.ToInt()) < 0 {
+		return fmt.Errorf("maxFeePerGas (%v) < maxPriorityFeePerGas (%v)", args.MaxFeePerGas, args.MaxPriorityFeePerGas)
+	}
+	return args.setChainIDDefault(b)
+}
+
+// setChainIDDefault fills in the chain id of typed transactions, rejecting any
+// explicitly requested one not matching the node's.
+func (args *SendTxArgs) setChainIDDefault(b Backend) error {
+	if args.ChainID == nil {
+		args.ChainID = (*hexutil.Big)(b.ChainConfig().ChainId)
+	} else if args.ChainID.ToInt().Cmp(b.ChainConfig().ChainId) != 0 {
+		return fmt.Errorf("chainId does not match node's (have=%v, want=%v)", args.ChainID, b.ChainConfig().ChainId)
+	}
+	return nil
+}
+
 func (args *SendTxArgs) toTransaction() *types.Transaction {
 	var input []byte
 	if args.Data != nil {
@@ -1161,6 +1618,16 @@ func (args *SendTxArgs) toTransaction() *types.Transaction {
 	} else if args.Input != nil {
 		input = *args.Input
 	}
+	var accessList types.AccessList
+	if args.AccessList != nil {
+		accessList = *args.AccessList
+	}
+	if args.MaxFeePerGas != nil {
+		return types.NewDynamicFeeTransaction((*big.Int)(args.ChainID), uint64(*args.Nonce), args.To, (*big.Int)(args.Value), uint64(*args.Gas), (*big.Int)(args.MaxPriorityFeePerGas), (*big.Int)(args.MaxFeePerGas), input, accessList)
+	}
+	if args.AccessList != nil {
+		return types.NewAccessListTransaction((*big.Int)(args.ChainID), uint64(*args.Nonce), args.To, (*big.Int)(args.Value), uint64(*args.Gas), (*big.Int)(args.GasPrice), input, accessList)
+	}
 	if args.To == nil {
 		return types.NewContractCreation(uint64(*args.Nonce), (*big.Int)(args.Value), uint64(*args.Gas), (*big.Int)(args.GasPrice), input)
 	}
@@ -1186,6 +1653,41 @@ func submitTransaction(ctx context.Context, b Backend, tx *types.Transaction) (c
 	return tx.Hash(), nil
 }
 
+// defaultPrivateTxBlocks is the number of blocks a private transaction is withheld
+// from the network for if no explicit expiry block is requested.
+const defaultPrivateTxBlocks = 25
+
+// PrivateTxArgs represents the arguments to submit a private transaction.
+type PrivateTxArgs struct {
+	MaxBlockNumber *hexutil.Uint64 `json:"maxBlockNumber"` // Last block to withhold the transaction until
+	Fallback       bool            `json:"fallback"`       // Gossip the transaction after expiry instead of dropping it
+}
+
+// SendPrivateTransaction adds a signed transaction to the local pool without
+// propagating it to the network until the requested expiry block. Afterwards it
+// is either gossiped as usual or dropped, depending on the fallback flag.
+func (s *PublicTransactionPoolAPI) SendPrivateTransaction(ctx context.Context, encodedTx hexutil.Bytes, args *PrivateTxArgs) (common.Hash, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(encodedTx); err != nil {
+		return common.Hash{}, err
+	}
+	var (
+		expiry   = s.b.CurrentBlock().NumberU64() + defaultPrivateTxBlocks
+		fallback bool
+	)
+	if args != nil {
+		if args.MaxBlockNumber != nil {
+			expiry = uint64(*args.MaxBlockNumber)
+		}
+		fallback = args.Fallback
+	}
+	if err := s.b.SendPrivateTx(ctx, tx, expiry, fallback); err != nil {
+		return common.Hash{}, err
+	}
+	log.Info("Submitted private transaction", "fullhash", tx.Hash().Hex(), "expiry", expiry, "fallback", fallback)
+	return tx.Hash(), nil
+}
+
 // SendTransaction creates a transaction for the given argument, sign it and submit it to the
 // transaction pool.
 func (s *PublicTransactionPoolAPI) SendTransaction(ctx context.Context, args SendTxArgs) (common.Hash, error) {
@@ -1227,7 +1729,7 @@ func (s *PublicTransactionPoolAPI) SendTransaction(ctx context.Context, args Sen
 // The sender is responsible for signing the transaction and using the correct nonce.
 func (s *PublicTransactionPoolAPI) SendRawTransaction(ctx context.Context, encodedTx hexutil.Bytes) (common.Hash, error) {
 	tx := new(types.Transaction)
-	if err := rlp.DecodeBytes(encodedTx, tx); err != nil {
+	if err := tx.UnmarshalBinary(encodedTx); err != nil {
 		return common.Hash{}, err
 	}
 	return submitTransaction(ctx, s.b, tx)
@@ -1284,7 +1786,7 @@ func (s *PublicTransactionPoolAPI) SignTransaction(ctx context.Context, args Sen
 	if err != nil {
 		return nil, err
 	}
-	data, err := rlp.EncodeToBytes(tx)
+	data, err := tx.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
@@ -1303,7 +1805,7 @@ func (s *PublicTransactionPoolAPI) PendingTransactions() ([]*RPCTransaction, err
 	for _, tx := range pending {
 		var signer types.Signer = types.HomesteadSigner{}
 		if tx.Protected() {
-			signer = types.NewEIP155Signer(tx.ChainId())
+			signer = types.NewEIP1559Signer(tx.ChainId())
 		}
 		from, _ := types.Sender(signer, tx)
 		if _, err := s.b.AccountManager().Find(accounts.Account{Address: from}); err == nil {
@@ -1331,7 +1833,7 @@ func (s *PublicTransactionPoolAPI) Resend(ctx context.Context, sendArgs SendTxAr
 	for _, p := range pending {
 		var signer types.Signer = types.HomesteadSigner{}
 		if p.Protected() {
-			signer = types.NewEIP155Signer(p.ChainId())
+			signer = types.NewEIP1559Signer(p.ChainId())
 		}
 		wantSigHash := signer.Hash(matchTx)
 
@@ -18,6 +18,7 @@ package les
 
 import (
 	"context"
+	"errors"
 	"math/big"
 
 	"github.com/utchain/go-utchain/accounts"
@@ -37,6 +38,10 @@ import (
 	"github.com/utchain/go-utchain/rpc"
 )
 
+// errPrivateTxUnsupported is returned if a private transaction is submitted to a
+// light client.
+var errPrivateTxUnsupported = errors.New("private transactions not supported by light clients")
+
 type LesApiBackend struct {
 	tst *LightUTChain
 	gpo *gasprice.Oracle
@@ -133,6 +138,36 @@ func (b *LesApiBackend) SubscribeTxPreEvent(ch chan<- core.TxPreEvent) event.Sub
 	return b.tst.txPool.SubscribeTxPreEvent(ch)
 }
 
+// SendPrivateTx is not supported by light clients, as they relay all their
+// transactions to the servers right away.
+func (b *LesApiBackend) SendPrivateTx(ctx context.Context, signedTx *types.Transaction, expiry uint64, fallback bool) error {
+	return errPrivateTxUnsupported
+}
+
+// IsPrivateTx always reports false, as the light pool cannot hold private
+// transactions.
+func (b *LesApiBackend) IsPrivateTx(txHash common.Hash) bool {
+	return false
+}
+
+// TxPoolExplain reports whether a transaction is pending in the light pool. The
+// light pool has no queue nor eviction rules, so there is nothing else to tell.
+func (b *LesApiBackend) TxPoolExplain(hash common.Hash) *core.TxExplanation {
+	if tx := b.tst.txPool.GetTransaction(hash); tx != nil {
+		return &core.TxExplanation{Hash: hash, Tx: tx, Status: core.TxStatusPending}
+	}
+	return &core.TxExplanation{Hash: hash, Status: core.TxStatusUnknown}
+}
+
+// SubscribeTxDropEvent returns a subscription that never fires, as the light
+// pool does not drop transactions based on pool limits.
+func (b *LesApiBackend) SubscribeTxDropEvent(ch chan<- core.TxDropEvent) event.Subscription {
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		<-quit
+		return nil
+	})
+}
+
 func (b *LesApiBackend) SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subscription {
 	return b.tst.blockchain.SubscribeChainEvent(ch)
 }
@@ -165,6 +200,10 @@ func (b *LesApiBackend) SuggestPrice(ctx context.Context) (*big.Int, error) {
 	return b.gpo.SuggestPrice(ctx)
 }
 
+func (b *LesApiBackend) SuggestTipCap(ctx context.Context) (*big.Int, error) {
+	return b.gpo.SuggestTipCap(ctx)
+}
+
 func (b *LesApiBackend) ChainDb() tstdb.Database {
 	return b.tst.chainDb
 }
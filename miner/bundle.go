@@ -0,0 +1,199 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core"
+	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/crypto/sha3"
+	"github.com/utchain/go-utchain/event"
+	"github.com/utchain/go-utchain/log"
+)
+
+// maxPendingBundles is the maximum number of bundles the miner tracks at once.
+const maxPendingBundles = 1024
+
+var (
+	// ErrEmptyBundle is returned if a bundle without transactions is submitted.
+	ErrEmptyBundle = errors.New("empty bundle")
+
+	// ErrStaleBundle is returned if a bundle is submitted for a block which is
+	// already part of the chain.
+	ErrStaleBundle = errors.New("bundle targets past block")
+
+	// ErrBundleKnown is returned if a bundle is submitted which is already
+	// tracked by the miner.
+	ErrBundleKnown = errors.New("known bundle")
+
+	// ErrTooManyBundles is returned if a bundle is submitted while the miner is
+	// already tracking the maximum number of allowed bundles.
+	ErrTooManyBundles = errors.New("too many pending bundles")
+
+	// errBundleTxReverted is returned if a transaction within a bundle fails
+	// during execution, aborting the inclusion of the entire bundle.
+	errBundleTxReverted = errors.New("bundle transaction reverted")
+)
+
+// Bundle is an ordered list of signed transactions that the miner includes into
+// the targeted block contiguously and entirely, or not at all. Bundles are never
+// propagated to the network.
+type Bundle struct {
+	Txs         types.Transactions // Transactions to include, in order
+	BlockNumber uint64             // Number of the block to include the bundle in
+}
+
+// Hash returns the identifier of the bundle, the hash of its transaction hashes.
+func (b *Bundle) Hash() common.Hash {
+	hasher := sha3.NewKeccak256()
+	for _, tx := range b.Txs {
+		hasher.Write(tx.Hash().Bytes())
+	}
+	var h common.Hash
+	hasher.Sum(h[:0])
+	return h
+}
+
+// addBundle starts tracking a bundle for inclusion into its target block.
+func (self *worker) addBundle(bundle *Bundle) error {
+	if len(bundle.Txs) == 0 {
+		return ErrEmptyBundle
+	}
+	if bundle.BlockNumber <= self.chain.CurrentBlock().NumberU64() {
+		return ErrStaleBundle
+	}
+	// Reject bundles with invalid signatures before accepting them
+	signer := types.MakeSigner(self.config, new(big.Int).SetUint64(bundle.BlockNumber))
+	for _, tx := range bundle.Txs {
+		if _, err := types.Sender(signer, tx); err != nil {
+			return err
+		}
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	hash := bundle.Hash()
+	for _, known := range self.bundles {
+		if known.BlockNumber == bundle.BlockNumber && known.Hash() == hash {
+			return ErrBundleKnown
+		}
+	}
+	if len(self.bundles) >= maxPendingBundles {
+		return ErrTooManyBundles
+	}
+	self.bundles = append(self.bundles, bundle)
+
+	// If the work for the target block was already committed, recommit it to
+	// include the bundle, otherwise it would be discarded at the next head
+	if bundle.BlockNumber == self.chain.CurrentBlock().NumberU64()+1 {
+		select {
+		case self.bundleCh <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// pendingBundles returns the bundles targeting the given block, dropping all the
+// bundles whose target block already passed. The caller must hold the lock.
+func (self *worker) pendingBundles(number uint64) []*Bundle {
+	var (
+		live    = self.bundles[:0]
+		pending []*Bundle
+	)
+	for _, bundle := range self.bundles {
+		if bundle.BlockNumber < number {
+			log.Debug("Discarding stale bundle", "hash", bundle.Hash(), "number", bundle.BlockNumber)
+			continue
+		}
+		if bundle.BlockNumber == number {
+			pending = append(pending, bundle)
+		}
+		live = append(live, bundle)
+	}
+	for i := len(live); i < len(self.bundles); i++ {
+		self.bundles[i] = nil
+	}
+	self.bundles = live
+	return pending
+}
+
+// commitBundles includes the given bundles into the work in order, skipping any
+// bundle that does not execute entirely.
+func (env *Work) commitBundles(mux *event.TypeMux, bundles []*Bundle, bc *core.BlockChain, coinbase common.Address) {
+	var coalescedLogs []*types.Log
+
+	for _, bundle := range bundles {
+		logs, err := env.commitBundle(bundle, bc, coinbase)
+		if err != nil {
+			log.Debug("Bundle execution failed", "hash", bundle.Hash(), "err", err)
+			continue
+		}
+		log.Trace("Committed bundle", "hash", bundle.Hash(), "txs", len(bundle.Txs))
+		coalescedLogs = append(coalescedLogs, logs...)
+	}
+	if len(coalescedLogs) > 0 {
+		// Copy the logs, same as in commitTransactions
+		cpy := make([]*types.Log, len(coalescedLogs))
+		for i, l := range coalescedLogs {
+			cpy[i] = new(types.Log)
+			*cpy[i] = *l
+		}
+		go mux.Post(core.PendingLogsEvent{Logs: cpy})
+	}
+}
+
+// commitBundle simulates a bundle against a copy of the pending state, and only
+// if all its transactions execute successfully, adopts the resulting state.
+func (env *Work) commitBundle(bundle *Bundle, bc *core.BlockChain, coinbase common.Address) ([]*types.Log, error) {
+	sim := *env
+	sim.state = env.state.Copy()
+	sim.header = types.CopyHeader(env.header)
+	sim.txs, sim.receipts = nil, nil
+
+	var (
+		gp   = new(core.GasPool).AddGas(sim.header.GasLimit - sim.header.GasUsed)
+		logs []*types.Log
+	)
+	for _, tx := range bundle.Txs {
+		if tx.Protected() && !sim.config.IsEIP155(sim.header.Number) {
+			return nil, types.ErrInvalidChainId
+		}
+		sim.state.Prepare(tx.Hash(), common.Hash{}, sim.tcount)
+
+		err, txLogs := sim.commitTransaction(tx, bc, coinbase, gp)
+		if err != nil {
+			return nil, err
+		}
+		if sim.receipts[len(sim.receipts)-1].Status == types.ReceiptStatusFailed {
+			return nil, errBundleTxReverted
+		}
+		logs = append(logs, txLogs...)
+		sim.tcount++
+	}
+	// The whole bundle executed fine, adopt the simulated state
+	env.state = sim.state
+	env.header.GasUsed = sim.header.GasUsed
+	env.txs = append(env.txs, sim.txs...)
+	env.receipts = append(env.receipts, sim.receipts...)
+	env.tcount = sim.tcount
+
+	return logs, nil
+}
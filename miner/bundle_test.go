@@ -0,0 +1,170 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/consensus/ethash"
+	"github.com/utchain/go-utchain/core"
+	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/core/vm"
+	"github.com/utchain/go-utchain/crypto"
+	"github.com/utchain/go-utchain/event"
+	"github.com/utchain/go-utchain/params"
+	"github.com/utchain/go-utchain/tstdb"
+	"gopkg.in/fatih/set.v0"
+)
+
+var (
+	bundleTestKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	bundleTestAddr    = crypto.PubkeyToAddress(bundleTestKey.PublicKey)
+	bundleTestFailing = common.HexToAddress("0xfe") // Contract always failing execution
+)
+
+// newBundleTestWorker creates a worker stub with a single funded account, and a
+// fresh work environment on top of its genesis block.
+func newBundleTestWorker(t *testing.T) (*worker, *Work) {
+	db, _ := tstdb.NewMemDatabase()
+	var (
+		gspec = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				bundleTestAddr:    {Balance: big.NewInt(params.Tster)},
+				bundleTestFailing: {Balance: new(big.Int), Code: []byte{0xfe}},
+			},
+		}
+		genesis = gspec.MustCommit(db)
+	)
+	chain, err := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	statedb, _ := chain.StateAt(genesis.Root())
+
+	w := &worker{config: gspec.Config, chain: chain, bundleCh: make(chan struct{}, 1)}
+	work := &Work{
+		config:    gspec.Config,
+		signer:    types.NewEIP155Signer(gspec.Config.ChainId),
+		state:     statedb,
+		ancestors: set.New(),
+		family:    set.New(),
+		uncles:    set.New(),
+		header: &types.Header{
+			ParentHash: genesis.Hash(),
+			Number:     big.NewInt(1),
+			GasLimit:   genesis.GasLimit(),
+			Time:       big.NewInt(time.Now().Unix()),
+		},
+		createdAt: time.Now(),
+	}
+	return w, work
+}
+
+// newBundleTestTx creates a signed transaction from the funded test account.
+func newBundleTestTx(t *testing.T, nonce uint64, to common.Address) *types.Transaction {
+	tx, err := types.SignTx(types.NewTransaction(nonce, to, big.NewInt(1), 50000, big.NewInt(1), nil), types.NewEIP155Signer(params.TestChainConfig.ChainId), bundleTestKey)
+	if err != nil {
+		t.Fatalf("failed to sign transaction: %v", err)
+	}
+	return tx
+}
+
+// Tests that bundles are either included entirely and contiguously, or not at
+// all if any of their transactions fail.
+func TestBundleCommit(t *testing.T) {
+	w, work := newBundleTestWorker(t)
+
+	valid := &Bundle{Txs: types.Transactions{newBundleTestTx(t, 0, common.Address{1}), newBundleTestTx(t, 1, common.Address{2})}, BlockNumber: 1}
+	gapped := &Bundle{Txs: types.Transactions{newBundleTestTx(t, 2, common.Address{1}), newBundleTestTx(t, 4, common.Address{2})}, BlockNumber: 1}
+	reverted := &Bundle{Txs: types.Transactions{newBundleTestTx(t, 2, common.Address{1}), newBundleTestTx(t, 3, bundleTestFailing)}, BlockNumber: 1}
+
+	work.commitBundles(new(event.TypeMux), []*Bundle{gapped, valid, reverted}, w.chain, common.Address{})
+
+	if len(work.txs) != 2 || len(work.receipts) != 2 || work.tcount != 2 {
+		t.Fatalf("included transaction count mismatch: have %d/%d/%d, want 2", len(work.txs), len(work.receipts), work.tcount)
+	}
+	for i, tx := range valid.Txs {
+		if work.txs[i].Hash() != tx.Hash() {
+			t.Errorf("transaction %d: hash mismatch: have %x, want %x", i, work.txs[i].Hash(), tx.Hash())
+		}
+	}
+	if nonce := work.state.GetNonce(bundleTestAddr); nonce != 2 {
+		t.Errorf("account nonce mismatch: have %d, want 2", nonce)
+	}
+	if balance := work.state.GetBalance(common.Address{2}); balance.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("recipient balance mismatch: have %v, want 1", balance)
+	}
+	if used := work.receipts[1].CumulativeGasUsed; work.header.GasUsed != used {
+		t.Errorf("gas used mismatch: have %d, want %d", work.header.GasUsed, used)
+	}
+}
+
+// Tests that bundles are validated on submission and discarded once their target
+// block passed.
+func TestBundleTracking(t *testing.T) {
+	w, _ := newBundleTestWorker(t)
+
+	if err := w.addBundle(&Bundle{BlockNumber: 1}); err != ErrEmptyBundle {
+		t.Errorf("empty bundle error mismatch: have %v, want %v", err, ErrEmptyBundle)
+	}
+	stale := &Bundle{Txs: types.Transactions{newBundleTestTx(t, 0, common.Address{1})}, BlockNumber: 0}
+	if err := w.addBundle(stale); err != ErrStaleBundle {
+		t.Errorf("stale bundle error mismatch: have %v, want %v", err, ErrStaleBundle)
+	}
+	unsigned := &Bundle{Txs: types.Transactions{types.NewTransaction(0, common.Address{1}, big.NewInt(1), 50000, big.NewInt(1), nil)}, BlockNumber: 1}
+	if err := w.addBundle(unsigned); err == nil {
+		t.Errorf("unsigned bundle accepted")
+	}
+	first := &Bundle{Txs: types.Transactions{newBundleTestTx(t, 0, common.Address{1})}, BlockNumber: 1}
+	second := &Bundle{Txs: types.Transactions{newBundleTestTx(t, 0, common.Address{2})}, BlockNumber: 2}
+	for i, bundle := range []*Bundle{first, second} {
+		if err := w.addBundle(bundle); err != nil {
+			t.Fatalf("failed to add bundle: %v", err)
+		}
+		// Only bundles for the block being built should trigger a recommit
+		select {
+		case <-w.bundleCh:
+			if bundle != first {
+				t.Errorf("bundle %d: recommit requested for future block", i)
+			}
+		default:
+			if bundle == first {
+				t.Errorf("bundle %d: recommit not requested for pending block", i)
+			}
+		}
+	}
+	if err := w.addBundle(first); err != ErrBundleKnown {
+		t.Errorf("duplicate bundle error mismatch: have %v, want %v", err, ErrBundleKnown)
+	}
+	if pending := w.pendingBundles(1); len(pending) != 1 || pending[0] != first {
+		t.Errorf("pending bundles mismatch for block 1: %v", pending)
+	}
+	if pending := w.pendingBundles(2); len(pending) != 1 || pending[0] != second {
+		t.Errorf("pending bundles mismatch for block 2: %v", pending)
+	}
+	if len(w.bundles) != 1 {
+		t.Errorf("tracked bundle count mismatch: have %d, want 1", len(w.bundles))
+	}
+	w.pendingBundles(3)
+	if len(w.bundles) != 0 {
+		t.Errorf("tracked bundle count mismatch: have %d, want 0", len(w.bundles))
+	}
+}
@@ -163,6 +163,18 @@ func (self *Miner) SetExtra(extra []byte) error {
 	return nil
 }
 
+// SetOrdering sets the policy deciding the order in which pending transactions
+// are included into mined blocks.
+func (self *Miner) SetOrdering(ordering TxOrdering) {
+	self.worker.setOrdering(ordering)
+}
+
+// AddBundle submits a private transaction bundle for inclusion into its target
+// block. The bundle is included contiguously and entirely, or not at all.
+func (self *Miner) AddBundle(bundle *Bundle) error {
+	return self.worker.addBundle(bundle)
+}
+
 // Pending returns the currently pending block and associated state.
 func (self *Miner) Pending() (*types.Block, *state.StateDB) {
 	return self.worker.pending()
@@ -0,0 +1,200 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"container/heap"
+	"fmt"
+	"math/big"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/types"
+)
+
+// TransactionSet is an iterator over the pending transactions in the order the
+// miner should try to include them into a block.
+type TransactionSet interface {
+	// Peek returns the next transaction to include, or nil if none are left.
+	Peek() *types.Transaction
+
+	// Shift replaces the current transaction with the next one from the same
+	// account, after the current one was included.
+	Shift()
+
+	// Pop removes the current transaction *without* replacing it with the next
+	// one from the same account, used if it could not be included.
+	Pop()
+}
+
+// TxOrdering is a policy deciding the order in which the miner includes the
+// pending transactions into blocks. Implementations must honour the nonce order
+// of the transactions of each account.
+type TxOrdering interface {
+	// Order creates a transaction set over the given nonce sorted transactions
+	// of each account, for a block with the given base fee (nil before EIP1559).
+	// The map is reowned by the set, the caller should not use it any more after
+	// providing it.
+	Order(signer types.Signer, txs map[common.Address]types.Transactions, baseFee *big.Int) TransactionSet
+}
+
+// Names of the available transaction orderings.
+const (
+	OrderingPrice    = "price"    // Highest miner tip first (default)
+	OrderingFIFO     = "fifo"     // First seen locally first
+	OrderingPriority = "priority" // Whitelisted senders first, then highest miner tip
+)
+
+// NewTxOrdering creates the named transaction ordering policy. The priority
+// senders are only used by the priority ordering.
+func NewTxOrdering(name string, priority []common.Address) (TxOrdering, error) {
+	switch name {
+	case "", OrderingPrice:
+		return PriceOrdering{}, nil
+	case OrderingFIFO:
+		return FIFOOrdering{}, nil
+	case OrderingPriority:
+		return NewPriorityOrdering(priority), nil
+	default:
+		return nil, fmt.Errorf("unknown transaction ordering %q", name)
+	}
+}
+
+// PriceOrdering includes the transactions paying the highest tip first,
+// maximising the fees collected by the miner.
+type PriceOrdering struct{}
+
+// Order implements TxOrdering.
+func (PriceOrdering) Order(signer types.Signer, txs map[common.Address]types.Transactions, baseFee *big.Int) TransactionSet {
+	return types.NewTransactionsByPriceAndNonce(signer, txs, baseFee)
+}
+
+// FIFOOrdering includes the transactions in the order they were first seen
+// locally, regardless of their gas price.
+type FIFOOrdering struct{}
+
+// Order implements TxOrdering.
+func (FIFOOrdering) Order(signer types.Signer, txs map[common.Address]types.Transactions, baseFee *big.Int) TransactionSet {
+	return newOrderedTransactions(signer, txs, func(a, b *orderedHead) bool {
+		return a.tx.Time().Before(b.tx.Time())
+	})
+}
+
+// PriorityOrdering includes the transactions of a set of whitelisted senders
+// first, ordering both the whitelisted and the remaining ones by miner tip.
+type PriorityOrdering struct {
+	senders map[common.Address]struct{}
+}
+
+// NewPriorityOrdering creates a transaction ordering which prefers the given
+// senders over all others.
+func NewPriorityOrdering(senders []common.Address) *PriorityOrdering {
+	ordering := &PriorityOrdering{senders: make(map[common.Address]struct{})}
+	for _, sender := range senders {
+		ordering.senders[sender] = struct{}{}
+	}
+	return ordering
+}
+
+// Order implements TxOrdering.
+func (o *PriorityOrdering) Order(signer types.Signer, txs map[common.Address]types.Transactions, baseFee *big.Int) TransactionSet {
+	return newOrderedTransactions(signer, txs, func(a, b *orderedHead) bool {
+		_, aPriority := o.senders[a.from]
+		_, bPriority := o.senders[b.from]
+		if aPriority != bPriority {
+			return aPriority
+		}
+		return a.tx.EffectiveGasTip(baseFee).Cmp(b.tx.EffectiveGasTip(baseFee)) > 0
+	})
+}
+
+// orderedHead is the next transaction of an account in an ordered transaction
+// set, along with its sender.
+type orderedHead struct {
+	tx   *types.Transaction
+	from common.Address
+}
+
+// orderedHeads is a heap of account head transactions sorted by a custom rule.
+type orderedHeads struct {
+	heads []*orderedHead
+	less  func(a, b *orderedHead) bool
+}
+
+func (h *orderedHeads) Len() int           { return len(h.heads) }
+func (h *orderedHeads) Less(i, j int) bool { return h.less(h.heads[i], h.heads[j]) }
+func (h *orderedHeads) Swap(i, j int)      { h.heads[i], h.heads[j] = h.heads[j], h.heads[i] }
+
+func (h *orderedHeads) Push(x interface{}) {
+	h.heads = append(h.heads, x.(*orderedHead))
+}
+
+func (h *orderedHeads) Pop() interface{} {
+	old := h.heads
+	n := len(old)
+	x := old[n-1]
+	h.heads = old[0 : n-1]
+	return x
+}
+
+// orderedTransactions is a transaction set that returns the account head
+// transactions ordered by a custom rule, in a nonce-honouring way.
+type orderedTransactions struct {
+	txs   map[common.Address]types.Transactions // Per account nonce-sorted list of transactions
+	heads *orderedHeads                         // Next transaction for each unique account
+}
+
+// newOrderedTransactions creates a transaction set ordering the account head
+// transactions by the given rule.
+func newOrderedTransactions(signer types.Signer, txs map[common.Address]types.Transactions, less func(a, b *orderedHead) bool) *orderedTransactions {
+	heads := &orderedHeads{heads: make([]*orderedHead, 0, len(txs)), less: less}
+	for _, accTxs := range txs {
+		// Ensure the sender address is from the signer
+		acc, _ := types.Sender(signer, accTxs[0])
+		heads.heads = append(heads.heads, &orderedHead{tx: accTxs[0], from: acc})
+		txs[acc] = accTxs[1:]
+	}
+	heap.Init(heads)
+
+	return &orderedTransactions{
+		txs:   txs,
+		heads: heads,
+	}
+}
+
+// Peek implements TransactionSet.
+func (t *orderedTransactions) Peek() *types.Transaction {
+	if t.heads.Len() == 0 {
+		return nil
+	}
+	return t.heads.heads[0].tx
+}
+
+// Shift implements TransactionSet.
+func (t *orderedTransactions) Shift() {
+	head := t.heads.heads[0]
+	if txs, ok := t.txs[head.from]; ok && len(txs) > 0 {
+		head.tx, t.txs[head.from] = txs[0], txs[1:]
+		heap.Fix(t.heads, 0)
+	} else {
+		heap.Pop(t.heads)
+	}
+}
+
+// Pop implements TransactionSet.
+func (t *orderedTransactions) Pop() {
+	heap.Pop(t.heads)
+}
@@ -0,0 +1,163 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/crypto"
+)
+
+// orderingTx is a transaction to be created for an ordering test, in creation
+// order.
+type orderingTx struct {
+	account int
+	nonce   uint64
+	price   int64
+}
+
+// makeOrderingTxs creates and signs the requested transactions in order, spaced
+// out in time so their local arrival times are distinct.
+func makeOrderingTxs(t *testing.T, signer types.Signer, keys []*ecdsa.PrivateKey, specs []orderingTx) (map[common.Address]types.Transactions, []*types.Transaction) {
+	var (
+		groups  = make(map[common.Address]types.Transactions)
+		created []*types.Transaction
+	)
+	for _, spec := range specs {
+		tx, err := types.SignTx(types.NewTransaction(spec.nonce, common.Address{}, big.NewInt(0), 21000, big.NewInt(spec.price), nil), signer, keys[spec.account])
+		if err != nil {
+			t.Fatalf("failed to sign transaction: %v", err)
+		}
+		addr := crypto.PubkeyToAddress(keys[spec.account].PublicKey)
+		groups[addr] = append(groups[addr], tx)
+		created = append(created, tx)
+
+		time.Sleep(time.Millisecond)
+	}
+	return groups, created
+}
+
+// drainOrdering pulls all the transactions out of a transaction set, assuming
+// all of them can be included.
+func drainOrdering(txs TransactionSet) []*types.Transaction {
+	var ordered []*types.Transaction
+	for tx := txs.Peek(); tx != nil; tx = txs.Peek() {
+		ordered = append(ordered, tx)
+		txs.Shift()
+	}
+	return ordered
+}
+
+func newOrderingKeys(t *testing.T, n int) []*ecdsa.PrivateKey {
+	keys := make([]*ecdsa.PrivateKey, n)
+	for i := range keys {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatalf("failed to generate key: %v", err)
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+// Tests that the FIFO ordering includes transactions in their local arrival
+// order, irrespective of gas prices, while honouring account nonces.
+func TestFIFOOrdering(t *testing.T) {
+	signer := types.HomesteadSigner{}
+	keys := newOrderingKeys(t, 3)
+
+	groups, created := makeOrderingTxs(t, signer, keys, []orderingTx{
+		{account: 0, nonce: 0, price: 1},
+		{account: 1, nonce: 0, price: 3},
+		{account: 2, nonce: 0, price: 2},
+		{account: 0, nonce: 1, price: 10},
+		{account: 1, nonce: 1, price: 1},
+	})
+	ordered := drainOrdering(FIFOOrdering{}.Order(signer, groups, nil))
+	if len(ordered) != len(created) {
+		t.Fatalf("transaction count mismatch: have %d, want %d", len(ordered), len(created))
+	}
+	for i, tx := range ordered {
+		if tx.Hash() != created[i].Hash() {
+			t.Errorf("transaction %d: hash mismatch: have %x, want %x", i, tx.Hash(), created[i].Hash())
+		}
+	}
+}
+
+// Tests that the priority ordering includes the transactions of the whitelisted
+// senders first, falling back to gas price ordering for everyone else.
+func TestPriorityOrdering(t *testing.T) {
+	signer := types.HomesteadSigner{}
+	keys := newOrderingKeys(t, 3)
+
+	groups, created := makeOrderingTxs(t, signer, keys, []orderingTx{
+		{account: 0, nonce: 0, price: 5}, // 0
+		{account: 0, nonce: 1, price: 6}, // 1
+		{account: 1, nonce: 0, price: 1}, // 2 (priority)
+		{account: 1, nonce: 1, price: 1}, // 3 (priority)
+		{account: 2, nonce: 0, price: 4}, // 4
+	})
+	ordering := NewPriorityOrdering([]common.Address{crypto.PubkeyToAddress(keys[1].PublicKey)})
+
+	ordered := drainOrdering(ordering.Order(signer, groups, nil))
+	want := []*types.Transaction{created[2], created[3], created[0], created[1], created[4]}
+	if len(ordered) != len(want) {
+		t.Fatalf("transaction count mismatch: have %d, want %d", len(ordered), len(want))
+	}
+	for i, tx := range ordered {
+		if tx.Hash() != want[i].Hash() {
+			t.Errorf("transaction %d: hash mismatch: have %x, want %x", i, tx.Hash(), want[i].Hash())
+		}
+	}
+}
+
+// Tests that popping a transaction from an ordered set drops the remaining
+// transactions of the same account.
+func TestOrderingPop(t *testing.T) {
+	signer := types.HomesteadSigner{}
+	keys := newOrderingKeys(t, 2)
+
+	groups, created := makeOrderingTxs(t, signer, keys, []orderingTx{
+		{account: 0, nonce: 0, price: 1},
+		{account: 0, nonce: 1, price: 1},
+		{account: 1, nonce: 0, price: 1},
+	})
+	txs := FIFOOrdering{}.Order(signer, groups, nil)
+	txs.Pop()
+
+	ordered := drainOrdering(txs)
+	if len(ordered) != 1 || ordered[0].Hash() != created[2].Hash() {
+		t.Fatalf("unexpected transactions after pop: %v", ordered)
+	}
+}
+
+// Tests that the ordering policies can be selected by name.
+func TestNewTxOrdering(t *testing.T) {
+	for _, name := range []string{"", OrderingPrice, OrderingFIFO, OrderingPriority} {
+		if _, err := NewTxOrdering(name, nil); err != nil {
+			t.Errorf("ordering %q: failed to create: %v", name, err)
+		}
+	}
+	if _, err := NewTxOrdering("random", nil); err == nil {
+		t.Errorf("unknown ordering accepted")
+	}
+}
@@ -101,6 +101,7 @@ type worker struct {
 	chainHeadSub event.Subscription
 	chainSideCh  chan core.ChainSideEvent
 	chainSideSub event.Subscription
+	bundleCh     chan struct{} // notification of a bundle arriving for the block being built
 	wg           sync.WaitGroup
 
 	agents map[Agent]struct{}
@@ -113,6 +114,8 @@ type worker struct {
 
 	coinbase common.Address
 	extra    []byte
+	ordering TxOrdering // policy deciding the order of the transactions in mined blocks
+	bundles  []*Bundle  // private transaction bundles awaiting inclusion
 
 	currentMu sync.Mutex
 	current   *Work
@@ -136,12 +139,14 @@ func newWorker(config *params.ChainConfig, engine consensus.Engine, coinbase com
 		txCh:           make(chan core.TxPreEvent, txChanSize),
 		chainHeadCh:    make(chan core.ChainHeadEvent, chainHeadChanSize),
 		chainSideCh:    make(chan core.ChainSideEvent, chainSideChanSize),
+		bundleCh:       make(chan struct{}, 1),
 		chainDb:        tst.ChainDb(),
 		recv:           make(chan *Result, resultQueueSize),
 		chain:          tst.BlockChain(),
 		proc:           tst.BlockChain().Validator(),
 		possibleUncles: make(map[common.Hash]*types.Block),
 		coinbase:       coinbase,
+		ordering:       PriceOrdering{},
 		agents:         make(map[Agent]struct{}),
 		unconfirmed:    newUnconfirmedBlocks(tst.BlockChain(), miningLogAtDepth),
 	}
@@ -170,6 +175,12 @@ func (self *worker) setExtra(extra []byte) {
 	self.extra = extra
 }
 
+func (self *worker) setOrdering(ordering TxOrdering) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.ordering = ordering
+}
+
 func (self *worker) pending() (*types.Block, *state.StateDB) {
 	self.currentMu.Lock()
 	defer self.currentMu.Unlock()
@@ -258,6 +269,10 @@ func (self *worker) update() {
 			self.possibleUncles[ev.Block.Hash()] = ev.Block
 			self.uncleMu.Unlock()
 
+		// Handle bundles arriving for the block already being built
+		case <-self.bundleCh:
+			self.commitNewWork()
+
 		// Handle TxPreEvent
 		case ev := <-self.txCh:
 			// Apply transaction to the pending state if we're not mining
@@ -265,7 +280,7 @@ func (self *worker) update() {
 				self.currentMu.Lock()
 				acc, _ := types.Sender(self.current.signer, ev.Tx)
 				txs := map[common.Address]types.Transactions{acc: {ev.Tx}}
-				txset := types.NewTransactionsByPriceAndNonce(self.current.signer, txs)
+				txset := types.NewTransactionsByPriceAndNonce(self.current.signer, txs, self.current.header.BaseFee)
 
 				self.current.commitTransactions(self.mux, txset, self.chain, self.coinbase)
 				self.currentMu.Unlock()
@@ -362,7 +377,7 @@ func (self *worker) makeCurrent(parent *types.Block, header *types.Header) error
 	}
 	work := &Work{
 		config:    self.config,
-		signer:    types.NewEIP155Signer(self.config.ChainId),
+		signer:    types.NewEIP1559Signer(self.config.ChainId),
 		state:     state,
 		ancestors: set.New(),
 		family:    set.New(),
@@ -416,6 +431,9 @@ func (self *worker) commitNewWork() {
 		Extra:      self.extra,
 		Time:       big.NewInt(tstamp),
 	}
+	if self.config.IsEIP1559(header.Number) {
+		header.BaseFee = misc.CalcBaseFee(self.config, parent.Header())
+	}
 	// Only set the coinbase if we are mining (avoid spurious block rewards)
 	if atomic.LoadInt32(&self.mining) == 1 {
 		header.Coinbase = self.coinbase
@@ -453,7 +471,9 @@ func (self *worker) commitNewWork() {
 		log.Error("Failed to fetch pending transactions", "err", err)
 		return
 	}
-	txs := types.NewTransactionsByPriceAndNonce(self.current.signer, pending)
+	work.commitBundles(self.mux, self.pendingBundles(header.Number.Uint64()), self.chain, self.coinbase)
+
+	txs := self.ordering.Order(self.current.signer, pending, header.BaseFee)
 	work.commitTransactions(self.mux, txs, self.chain, self.coinbase)
 
 	// compute uncles for the new block.
@@ -506,8 +526,8 @@ func (self *worker) commitUncle(work *Work, uncle *types.Header) error {
 	return nil
 }
 
-func (env *Work) commitTransactions(mux *event.TypeMux, txs *types.TransactionsByPriceAndNonce, bc *core.BlockChain, coinbase common.Address) {
-	gp := new(core.GasPool).AddGas(env.header.GasLimit)
+func (env *Work) commitTransactions(mux *event.TypeMux, txs TransactionSet, bc *core.BlockChain, coinbase common.Address) {
+	gp := new(core.GasPool).AddGas(env.header.GasLimit - env.header.GasUsed)
 
 	var coalescedLogs []*types.Log
 
@@ -525,7 +545,7 @@ func (env *Work) commitTransactions(mux *event.TypeMux, txs *types.TransactionsB
 		// Error may be ignored here. The error has already been checked
 		// during transaction acceptance is the transaction pool.
 		//
-		// We use the eip155 signer regardless of the current hf.
+		// We use the eip1559 signer regardless of the current hf.
 		from, _ := types.Sender(env.signer, tx)
 		// Check whtster the tx is replay protected. If we're not in the EIP155 hf
 		// phase, start ignoring the sender until we do.
@@ -535,6 +555,14 @@ func (env *Work) commitTransactions(mux *event.TypeMux, txs *types.TransactionsB
 			txs.Pop()
 			continue
 		}
+		// Typed transactions can only be included after the hf introducing them
+		if (tx.Type() == types.AccessListTxType && !env.config.IsBerlin(env.header.Number)) ||
+			(tx.Type() == types.DynamicFeeTxType && !env.config.IsEIP1559(env.header.Number)) {
+			log.Trace("Ignoring typed transaction", "hash", tx.Hash(), "type", tx.Type())
+
+			txs.Pop()
+			continue
+		}
 		// Start executing the transaction
 		env.state.Prepare(tx.Hash(), common.Hash{}, env.tcount)
 
@@ -550,6 +578,11 @@ func (env *Work) commitTransactions(mux *event.TypeMux, txs *types.TransactionsB
 			log.Trace("Skipping transaction with low nonce", "sender", from, "nonce", tx.Nonce())
 			txs.Shift()
 
+		case core.ErrFeeCapTooLow:
+			// The fee cap doesn't cover the base fee, neither will the later ones from the account
+			log.Trace("Skipping account with low fee cap", "sender", from, "feecap", tx.GasFeeCap(), "basefee", env.header.BaseFee)
+			txs.Pop()
+
 		case core.ErrNonceTooHigh:
 			// Reorg notification data race between the transaction pool and miner, skip account =
 			log.Trace("Skipping account with hight nonce", "sender", from, "nonce", tx.Nonce())
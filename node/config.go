@@ -33,6 +33,7 @@ import (
 	"github.com/utchain/go-utchain/log"
 	"github.com/utchain/go-utchain/p2p"
 	"github.com/utchain/go-utchain/p2p/discover"
+	"github.com/utchain/go-utchain/tstdb"
 )
 
 const (
@@ -41,6 +42,8 @@ const (
 	datadirStaticNodes     = "static-nodes.json"  // Path within the datadir to the static node list
 	datadirTrustedNodes    = "trusted-nodes.json" // Path within the datadir to the trusted node list
 	datadirNodeDatabase    = "nodes"              // Path within the datadir to store the node infos
+
+	databaseEngineMarker = "ENGINE" // Path within a database directory to the name of its engine
 )
 
 // Config represents a small collection of configuration values to fine tune the
@@ -66,6 +69,10 @@ type Config struct {
 	// in memory.
 	DataDir string
 
+	// DBEngine is the key-value store backing the databases opened within the
+	// data directory, either "leveldb" (the default) or "bolt".
+	DBEngine string `toml:",omitempty"`
+
 	// Configuration of peer-to-peer networking.
 	P2P p2p.Config
 
@@ -262,6 +269,111 @@ var isOldGtstResource = map[string]bool{
 	"trusted-nodes.json": true,
 }
 
+// openDatabase opens the database at the given path using the engine it was
+// created with, or the configured database engine for new databases.
+func (c *Config) openDatabase(path string, cache, handles int) (tstdb.Database, error) {
+	engine, err := c.databaseEngine(path)
+	if err != nil {
+		return nil, err
+	}
+	var db tstdb.Database
+	switch engine {
+	case "leveldb":
+		db, err = tstdb.NewLDBDatabase(path, cache, handles)
+
+		// Reattach the ancient store if chain data was already moved into one
+		if err == nil {
+			if freezer := tstdb.AttachedFreezer(db); freezer != "" {
+				log.Info("Reattaching ancient store", "database", path, "ancient", freezer)
+				db.Close()
+				db, err = tstdb.NewLDBDatabaseWithFreezer(path, cache, handles, freezer)
+			}
+		}
+	case "bolt":
+		db, err = tstdb.NewBoltDatabase(path)
+	default:
+		return nil, fmt.Errorf("unknown database engine %q", engine)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := markDatabaseEngine(path, engine); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// openDatabaseWithFreezer opens the database at the given path along with an
+// ancient store. Only LevelDB databases support ancient stores.
+func (c *Config) openDatabaseWithFreezer(path string, cache, handles int, freezer string) (tstdb.Database, error) {
+	engine, err := c.databaseEngine(path)
+	if err != nil {
+		return nil, err
+	}
+	if engine != "leveldb" {
+		return nil, fmt.Errorf("ancient store not supported by %s engine", engine)
+	}
+	db, err := tstdb.NewLDBDatabaseWithFreezer(path, cache, handles, freezer)
+	if err != nil {
+		return nil, err
+	}
+	if err := markDatabaseEngine(path, engine); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// databaseEngine returns the engine to open the database at the given path with.
+// Existing databases are reopened with the engine they were created with, unless
+// a different engine was requested explicitly, which is an error rather than a
+// reason to silently start over with a new empty database.
+func (c *Config) databaseEngine(path string) (string, error) {
+	existing, err := detectDatabaseEngine(path)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case existing == "" && c.DBEngine == "":
+		return "leveldb", nil
+	case existing == "":
+		return c.DBEngine, nil
+	case c.DBEngine != "" && c.DBEngine != existing:
+		return "", fmt.Errorf("database %s was created by the %s engine, cannot open it with %s", path, existing, c.DBEngine)
+	}
+	return existing, nil
+}
+
+// detectDatabaseEngine returns the engine of the database at the given path, or
+// an empty string if there's no database yet. Databases created before engines
+// were recorded are recognized by the files of the engine.
+func detectDatabaseEngine(path string) (string, error) {
+	blob, err := ioutil.ReadFile(filepath.Join(path, databaseEngineMarker))
+	switch {
+	case err == nil:
+		return strings.TrimSpace(string(blob)), nil
+	case !os.IsNotExist(err):
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(path, "bolt.db")); err == nil {
+		return "bolt", nil
+	}
+	if _, err := os.Stat(filepath.Join(path, "CURRENT")); err == nil {
+		return "leveldb", nil
+	}
+	return "", nil
+}
+
+// markDatabaseEngine records the engine of the database at the given path.
+func markDatabaseEngine(path string, engine string) error {
+	marker := filepath.Join(path, databaseEngineMarker)
+	if _, err := os.Stat(marker); err == nil {
+		return nil
+	}
+	return ioutil.WriteFile(marker, []byte(engine+"\n"), 0600)
+}
+
 // resolvePath resolves path in the instance directory.
 func (c *Config) resolvePath(path string) string {
 	if filepath.IsAbs(path) {
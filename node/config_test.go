@@ -26,6 +26,7 @@ import (
 
 	"github.com/utchain/go-utchain/crypto"
 	"github.com/utchain/go-utchain/p2p"
+	"github.com/utchain/go-utchain/tstdb"
 )
 
 // Tests that datadirs can be successfully created, be them manually configured
@@ -148,3 +149,94 @@ func TestNodeKeyPersistency(t *testing.T) {
 		t.Fatalf("ephemeral node key persisted to disk")
 	}
 }
+
+// Tests that databases are reopened with the engine they were created with, and
+// that explicitly requesting a different engine is rejected.
+func TestDatabaseEngineDetection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary data dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Create a new database with the non-default engine
+	path := filepath.Join(dir, "chaindata")
+	db, err := (&Config{DBEngine: "bolt"}).openDatabase(path, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	db.Put([]byte("key"), []byte("value"))
+	db.Close()
+
+	// Reopening without an explicit engine should pick the recorded one
+	db, err = (&Config{}).openDatabase(path, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	if value, err := db.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Errorf("stored value mismatch: have %q, %v", value, err)
+	}
+	db.Close()
+
+	// Reopening with a conflicting engine should fail, also with an ancient store
+	if _, err := (&Config{DBEngine: "leveldb"}).openDatabase(path, 0, 0); err == nil {
+		t.Errorf("conflicting engine accepted")
+	}
+	if _, err := (&Config{}).openDatabaseWithFreezer(path, 0, 0, filepath.Join(path, "ancient")); err == nil {
+		t.Errorf("ancient store accepted for bolt database")
+	}
+	// LevelDB databases predating the engine marker should be recognized
+	legacy := filepath.Join(dir, "legacy")
+	ldb, err := tstdb.NewLDBDatabase(legacy, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create legacy database: %v", err)
+	}
+	ldb.Close()
+
+	if _, err := (&Config{DBEngine: "bolt"}).openDatabase(legacy, 0, 0); err == nil {
+		t.Errorf("conflicting engine accepted for legacy database")
+	}
+	if db, err = (&Config{}).openDatabase(legacy, 0, 0); err != nil {
+		t.Fatalf("failed to reopen legacy database: %v", err)
+	}
+	db.Close()
+
+	if engine, _ := detectDatabaseEngine(legacy); engine != "leveldb" {
+		t.Errorf("legacy database engine mismatch: have %q, want leveldb", engine)
+	}
+}
+
+// Tests that a database once opened with an ancient store gets it reattached even
+// if not requested explicitly, so frozen chain data doesn't silently go missing.
+func TestDatabaseFreezerReattach(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary data dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		path    = filepath.Join(dir, "chaindata")
+		freezer = filepath.Join(dir, "ancient")
+	)
+	db, err := (&Config{}).openDatabaseWithFreezer(path, 0, 0, freezer)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	if err := db.(tstdb.AncientStore).AppendAncient(0, []byte{0x01}, []byte{0x02}, []byte{0x03}, []byte{0x04}, []byte{0x05}); err != nil {
+		t.Fatalf("failed to freeze block: %v", err)
+	}
+	db.Close()
+
+	if db, err = (&Config{}).openDatabase(path, 0, 0); err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	if frozen, err := db.(tstdb.AncientReader).Ancients(); err != nil || frozen != 1 {
+		t.Errorf("frozen blocks mismatch: have %d (%v), want 1", frozen, err)
+	}
+	// Switching to another, empty ancient store should be refused
+	db.Close()
+	if _, err := (&Config{}).openDatabaseWithFreezer(path, 0, 0, filepath.Join(dir, "other")); err == nil {
+		t.Errorf("empty ancient store accepted for frozen database")
+	}
+}
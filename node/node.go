@@ -645,7 +645,26 @@ func (n *Node) OpenDatabase(name string, cache, handles int) (tstdb.Database, er
 	if n.config.DataDir == "" {
 		return tstdb.NewMemDatabase()
 	}
-	return tstdb.NewLDBDatabase(n.config.resolvePath(name), cache, handles)
+	return n.config.openDatabase(n.config.resolvePath(name), cache, handles)
+}
+
+// OpenDatabaseWithFreezer opens an existing database with the given name (or
+// creates one if no previous can be found) from within the node's instance
+// directory, also attaching an ancient store for immutable chain data to it. If
+// the freezer path is empty, the ancient store is placed inside the database
+// directory. If the node is ephemeral, a memory database is returned.
+func (n *Node) OpenDatabaseWithFreezer(name string, cache, handles int, freezer string) (tstdb.Database, error) {
+	if n.config.DataDir == "" {
+		return tstdb.NewMemDatabase()
+	}
+	root := n.config.resolvePath(name)
+	switch {
+	case freezer == "":
+		freezer = filepath.Join(root, "ancient")
+	case !filepath.IsAbs(freezer):
+		freezer = n.config.resolvePath(freezer)
+	}
+	return n.config.openDatabaseWithFreezer(root, cache, handles, freezer)
 }
 
 // ResolvePath returns the absolute path of a resource in the instance directory.
@@ -17,6 +17,7 @@
 package node
 
 import (
+	"path/filepath"
 	"reflect"
 
 	"github.com/utchain/go-utchain/accounts"
@@ -43,11 +44,26 @@ func (ctx *ServiceContext) OpenDatabase(name string, cache int, handles int) (ts
 	if ctx.config.DataDir == "" {
 		return tstdb.NewMemDatabase()
 	}
-	db, err := tstdb.NewLDBDatabase(ctx.config.resolvePath(name), cache, handles)
-	if err != nil {
-		return nil, err
+	return ctx.config.openDatabase(ctx.config.resolvePath(name), cache, handles)
+}
+
+// OpenDatabaseWithFreezer opens an existing database with the given name (or
+// creates one if no previous can be found) from within the node's data directory,
+// also attaching an ancient store for immutable chain data to it. If the freezer
+// path is empty, the ancient store is placed inside the database directory. If
+// the node is an ephemeral one, a memory database is returned.
+func (ctx *ServiceContext) OpenDatabaseWithFreezer(name string, cache int, handles int, freezer string) (tstdb.Database, error) {
+	if ctx.config.DataDir == "" {
+		return tstdb.NewMemDatabase()
+	}
+	root := ctx.config.resolvePath(name)
+	switch {
+	case freezer == "":
+		freezer = filepath.Join(root, "ancient")
+	case !filepath.IsAbs(freezer):
+		freezer = ctx.config.resolvePath(freezer)
 	}
-	return db, nil
+	return ctx.config.openDatabaseWithFreezer(root, cache, handles, freezer)
 }
 
 // ResolvePath resolves a user path into the data directory if that was relative
@@ -22,10 +22,12 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/utchain/go-utchain/log"
 	"github.com/utchain/go-utchain/p2p/discover"
+	"github.com/utchain/go-utchain/p2p/enr"
 	"github.com/utchain/go-utchain/p2p/netutil"
 )
 
@@ -45,6 +47,10 @@ const (
 	// Endpoint resolution is throttled with bounded backoff.
 	initialResolveDelay = 60 * time.Second
 	maxResolveDelay     = time.Hour
+
+	// Node filter verdicts are remembered for this amount of time
+	// before the node record is requested again.
+	nodeFilterExpiration = 30 * time.Minute
 )
 
 // NodeDialer is used to connect to nodes in the network, typically by using
@@ -90,6 +96,7 @@ type discoverTable interface {
 	Resolve(target discover.NodeID) *discover.Node
 	Lookup(target discover.NodeID) []*discover.Node
 	ReadRandomNodes([]*discover.Node) int
+	RequestENR(*discover.Node) (*enr.Record, error)
 }
 
 // the dial history remembers recent dials.
@@ -294,6 +301,9 @@ func (t *dialTask) Do(srv *Server) {
 			return
 		}
 	}
+	if !t.accepted(srv) {
+		return
+	}
 	err := t.dial(srv, t.dest)
 	if err != nil {
 		log.Trace("Dial error", "task", t, "err", err)
@@ -340,6 +350,95 @@ func (t *dialTask) resolve(srv *Server) bool {
 	return true
 }
 
+// accepted checks a discovered node against the node filters of the running
+// protocols. Static nodes are always accepted. The node record is requested in
+// the background and the verdict cached, so a dial never waits on a node that
+// doesn't serve its record: unknown nodes are dialed right away, leaving the
+// compatibility checks to the protocol handshakes, and incompatible nodes are
+// skipped from their next dial on.
+func (t *dialTask) accepted(srv *Server) bool {
+	if t.flags&dynDialedConn == 0 {
+		return true
+	}
+	var filters []func(*enr.Record) bool
+	for _, proto := range srv.Protocols {
+		if proto.NodeFilter != nil {
+			filters = append(filters, proto.NodeFilter)
+		}
+	}
+	if len(filters) == 0 || srv.ntab == nil {
+		return true
+	}
+	accept, known := srv.nodeFilters.verdict(t.dest.ID, time.Now())
+	if !known {
+		go srv.nodeFilters.fetch(srv.ntab, t.dest, filters)
+		return true
+	}
+	if !accept {
+		log.Trace("Skipping incompatible node", "id", t.dest.ID, "addr", &net.TCPAddr{IP: t.dest.IP, Port: int(t.dest.TCP)})
+	}
+	return accept
+}
+
+// nodeFilterCache remembers whether discovered nodes passed the node filters
+// of the running protocols.
+type nodeFilterCache struct {
+	lock     sync.Mutex
+	verdicts map[discover.NodeID]*nodeFilterVerdict
+}
+
+// nodeFilterVerdict is an entry in the node filter cache.
+type nodeFilterVerdict struct {
+	accept  bool
+	pending bool // the node record is being requested
+	exp     time.Time
+}
+
+func newNodeFilterCache() *nodeFilterCache {
+	return &nodeFilterCache{verdicts: make(map[discover.NodeID]*nodeFilterVerdict)}
+}
+
+// verdict returns the cached verdict of a node. Nodes that are neither known
+// nor being looked up are marked pending, the caller is expected to fetch them.
+// Nodes with a pending lookup are reported as known and accepted.
+func (c *nodeFilterCache) verdict(id discover.NodeID, now time.Time) (accept bool, known bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if v := c.verdicts[id]; v != nil && (v.pending || now.Before(v.exp)) {
+		return v.accept, true
+	}
+	c.verdicts[id] = &nodeFilterVerdict{accept: true, pending: true}
+	return true, false
+}
+
+// fetch requests the node record of n and caches whether it passes all filters.
+// Nodes not serving their record are accepted.
+func (c *nodeFilterCache) fetch(ntab discoverTable, n *discover.Node, filters []func(*enr.Record) bool) {
+	accept := true
+	record, err := ntab.RequestENR(n)
+	if err != nil {
+		log.Trace("Node record unavailable", "id", n.ID, "err", err)
+	} else {
+		for _, filter := range filters {
+			if !filter(record) {
+				accept = false
+				break
+			}
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	for id, v := range c.verdicts {
+		if !v.pending && !now.Before(v.exp) {
+			delete(c.verdicts, id)
+		}
+	}
+	c.verdicts[n.ID] = &nodeFilterVerdict{accept: accept, exp: now.Add(nodeFilterExpiration)}
+}
+
 type dialError struct {
 	error
 }
@@ -18,13 +18,16 @@ package p2p
 
 import (
 	"encoding/binary"
+	"errors"
 	"net"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/utchain/go-utchain/p2p/discover"
+	"github.com/utchain/go-utchain/p2p/enr"
 	"github.com/utchain/go-utchain/p2p/netutil"
 )
 
@@ -83,6 +86,9 @@ func (t fakeTable) Close()                                   {}
 func (t fakeTable) Lookup(discover.NodeID) []*discover.Node  { return nil }
 func (t fakeTable) Resolve(discover.NodeID) *discover.Node   { return nil }
 func (t fakeTable) ReadRandomNodes(buf []*discover.Node) int { return copy(buf, t) }
+func (t fakeTable) RequestENR(*discover.Node) (*enr.Record, error) {
+	return nil, errors.New("no record")
+}
 
 // This test checks that dynamic dials are launched from discovery results.
 func TestDialStateDynDial(t *testing.T) {
@@ -655,6 +661,75 @@ func TestDialResolve(t *testing.T) {
 	}
 }
 
+// This test checks that the node records of discovered nodes are filtered by the
+// protocols, while static nodes are always dialed. Records are fetched in the
+// background, so the first dial of an unknown node always goes ahead.
+func TestDialNodeFilter(t *testing.T) {
+	compatible, incompatible := new(enr.Record), new(enr.Record)
+	compatible.Set(enr.WithEntry("test", uint(1)))
+	incompatible.Set(enr.WithEntry("test", uint(2)))
+
+	table := &recordMock{records: map[discover.NodeID]*enr.Record{
+		uintID(1): compatible,
+		uintID(2): incompatible,
+	}}
+	filter := func(r *enr.Record) bool {
+		var version uint
+		return r.Load(enr.WithEntry("test", &version)) == nil && version == 1
+	}
+	srv := &Server{
+		ntab:        table,
+		nodeFilters: newNodeFilterCache(),
+		Config:      Config{Protocols: []Protocol{{Name: "test", NodeFilter: filter}}},
+	}
+	tests := []struct {
+		flags  connFlag
+		id     discover.NodeID
+		accept bool
+	}{
+		{dynDialedConn, uintID(1), true},
+		{dynDialedConn, uintID(2), false},
+		{dynDialedConn, uintID(3), true}, // no record served
+		{staticDialedConn, uintID(2), true},
+	}
+	newTask := func(flags connFlag, id discover.NodeID) *dialTask {
+		return &dialTask{flags: flags, dest: discover.NewNode(id, net.IP{127, 0, 0, 1}, 30303, 30303)}
+	}
+	// Unknown nodes are dialed without waiting for their records.
+	for i, tt := range tests {
+		if !newTask(tt.flags, tt.id).accepted(srv) {
+			t.Errorf("test %d: unknown node rejected", i)
+		}
+	}
+	// Wait for the background record requests to finish.
+	for deadline := time.Now().Add(time.Second); ; {
+		srv.nodeFilters.lock.Lock()
+		pending := 0
+		for _, v := range srv.nodeFilters.verdicts {
+			if v.pending {
+				pending++
+			}
+		}
+		srv.nodeFilters.lock.Unlock()
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d record requests still pending", pending)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Known nodes are dialed according to their cached verdicts.
+	for i, tt := range tests {
+		if accepted := newTask(tt.flags, tt.id).accepted(srv); accepted != tt.accept {
+			t.Errorf("test %d: acceptance mismatch: have %v, want %v", i, accepted, tt.accept)
+		}
+	}
+	if calls := table.calls(); calls != 3 {
+		t.Errorf("record request count mismatch: have %d, want 3", calls)
+	}
+}
+
 // compares task lists but doesn't care about the order.
 func sametasks(a, b []task) bool {
 	if len(a) != len(b) {
@@ -694,3 +769,32 @@ func (t *resolveMock) Close()                                   {}
 func (t *resolveMock) Bootstrap([]*discover.Node)               {}
 func (t *resolveMock) Lookup(discover.NodeID) []*discover.Node  { return nil }
 func (t *resolveMock) ReadRandomNodes(buf []*discover.Node) int { return 0 }
+func (t *resolveMock) RequestENR(*discover.Node) (*enr.Record, error) {
+	return nil, errors.New("no record")
+}
+
+// implements discoverTable for TestDialNodeFilter
+type recordMock struct {
+	fakeTable
+	records map[discover.NodeID]*enr.Record
+
+	lock     sync.Mutex
+	requests int
+}
+
+func (t *recordMock) RequestENR(n *discover.Node) (*enr.Record, error) {
+	t.lock.Lock()
+	t.requests++
+	t.lock.Unlock()
+
+	if record, ok := t.records[n.ID]; ok {
+		return record, nil
+	}
+	return nil, errors.New("no record")
+}
+
+func (t *recordMock) calls() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.requests
+}
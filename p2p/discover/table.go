@@ -36,6 +36,7 @@ import (
 	"github.com/utchain/go-utchain/common"
 	"github.com/utchain/go-utchain/crypto"
 	"github.com/utchain/go-utchain/log"
+	"github.com/utchain/go-utchain/p2p/enr"
 	"github.com/utchain/go-utchain/p2p/netutil"
 )
 
@@ -101,6 +102,7 @@ type transport interface {
 	ping(NodeID, *net.UDPAddr) error
 	waitping(NodeID) error
 	findnode(toid NodeID, addr *net.UDPAddr, target NodeID) ([]*Node, error)
+	requestENR(toid NodeID, addr *net.UDPAddr) (*enr.Record, error)
 	close()
 }
 
@@ -270,6 +272,12 @@ func (tab *Table) Resolve(targetID NodeID) *Node {
 	return nil
 }
 
+// RequestENR retrieves the current node record of the given node. It fails if
+// the node doesn't serve its record, e.g. because it doesn't support records.
+func (tab *Table) RequestENR(n *Node) (*enr.Record, error) {
+	return tab.net.requestENR(n.ID, n.addr())
+}
+
 // Lookup performs a network search for nodes close
 // to the given target. It approaches the target by querying
 // nodes that are closer to it on each iteration.
@@ -36,6 +36,7 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/utchain/go-utchain/common"
 	"github.com/utchain/go-utchain/crypto"
+	"github.com/utchain/go-utchain/p2p/enr"
 	"github.com/utchain/go-utchain/rlp"
 )
 
@@ -386,6 +387,57 @@ func TestUDP_successfulPing(t *testing.T) {
 	}
 }
 
+func TestUDP_ENR(t *testing.T) {
+	test := newUDPTest(t)
+	defer test.table.Close()
+
+	// Requests of unknown nodes and requests without a local record aren't served
+	test.packetIn(errUnknownNode, enrRequestPacket, &enrRequest{Expiration: futureExp})
+	test.table.db.updateBondTime(PubkeyID(&test.remotekey.PublicKey), time.Now())
+	test.packetIn(errNoRecord, enrRequestPacket, &enrRequest{Expiration: futureExp})
+
+	// The local record is served once available
+	local := new(enr.Record)
+	if err := local.Sign(test.localkey); err != nil {
+		t.Fatalf("failed to sign local record: %v", err)
+	}
+	test.udp.record = func() (*enr.Record, error) { return local, nil }
+	test.packetIn(nil, enrRequestPacket, &enrRequest{Expiration: futureExp})
+	test.waitPacketOut(func(p *enrResponse) {
+		if reqhash := test.sent[len(test.sent)-1][:macSize]; !bytes.Equal(p.ReplyTok, reqhash) {
+			t.Errorf("got enrResponse.ReplyTok %x, want %x", p.ReplyTok, reqhash)
+		}
+		if !bytes.Equal(p.Record.NodeAddr(), local.NodeAddr()) || p.Record.Seq() != local.Seq() {
+			t.Errorf("served record mismatch: got seq %d of %x", p.Record.Seq(), p.Record.NodeAddr())
+		}
+	})
+	// Remote records are only accepted if signed by the requested node
+	tests := []struct {
+		signer *ecdsa.PrivateKey
+		err    error
+	}{
+		{test.remotekey, nil},
+		{newkey(), errRecordMismatch},
+	}
+	for i, tt := range tests {
+		errc := make(chan error)
+		go func() {
+			_, err := test.udp.requestENR(PubkeyID(&test.remotekey.PublicKey), test.remoteaddr)
+			errc <- err
+		}()
+		hash, _ := test.waitPacketOut(func(p *enrRequest) {})
+
+		remote := new(enr.Record)
+		if err := remote.Sign(tt.signer); err != nil {
+			t.Fatalf("test %d: failed to sign remote record: %v", i, err)
+		}
+		test.packetIn(nil, enrResponsePacket, &enrResponse{ReplyTok: hash, Record: *remote})
+		if err := <-errc; err != tt.err {
+			t.Errorf("test %d: error mismatch: got %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
 var testPackets = []struct {
 	input      string
 	wantPacket interface{}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 
 	"github.com/utchain/go-utchain/p2p/discover"
+	"github.com/utchain/go-utchain/p2p/enr"
 )
 
 // Protocol represents a P2P subprotocol implementation.
@@ -52,6 +53,15 @@ type Protocol struct {
 	// about a certain peer in the network. If an info retrieval function is set,
 	// but returns nil, it is assumed that the protocol handshake is still running.
 	PeerInfo func(id discover.NodeID) interface{}
+
+	// Attributes is an optional helper method to retrieve protocol specific
+	// entries to publish in the node record of the host node.
+	Attributes func() []enr.Entry
+
+	// NodeFilter is an optional helper method to check the node record of a
+	// discovered node before dialing it. Nodes whose record is rejected by any
+	// protocol are not dialed.
+	NodeFilter func(*enr.Record) bool
 }
 
 func (p Protocol) cap() Cap {
@@ -18,7 +18,9 @@
 package p2p
 
 import (
+	"bytes"
 	"crypto/ecdsa"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"net"
@@ -31,8 +33,10 @@ import (
 	"github.com/utchain/go-utchain/log"
 	"github.com/utchain/go-utchain/p2p/discover"
 	"github.com/utchain/go-utchain/p2p/discv5"
+	"github.com/utchain/go-utchain/p2p/enr"
 	"github.com/utchain/go-utchain/p2p/nat"
 	"github.com/utchain/go-utchain/p2p/netutil"
+	"github.com/utchain/go-utchain/rlp"
 )
 
 const (
@@ -51,7 +55,10 @@ const (
 	frameWriteTimeout = 20 * time.Second
 )
 
-var errServerStopped = errors.New("server stopped")
+var (
+	errServerStopped = errors.New("server stopped")
+	errNoPrivateKey  = errors.New("no private key to sign the node record")
+)
 
 // Config holds Server options.
 type Config struct {
@@ -162,6 +169,12 @@ type Server struct {
 	lastLookup   time.Time
 	DiscV5       *discv5.Network
 
+	record     *enr.Record // Last signed node record, reused while its contents don't change
+	recordBlob []byte      // RLP encoding of the entries the last node record was built from
+	recordLock sync.Mutex  // Protects the cached node record
+
+	nodeFilters *nodeFilterCache // Node filter verdicts of discovered nodes
+
 	// These are for Peers, PeerCount (and nothing else).
 	peerOp     chan peerOpFunc
 	peerOpDone chan struct{}
@@ -336,6 +349,53 @@ func (srv *Server) makeSelf(listener net.Listener, ntab discoverTable) *discover
 	return ntab.Self()
 }
 
+// NodeRecord returns the signed node record (ENR) of the local node, containing
+// its endpoint along with the attributes published by the running protocols.
+// The sequence number of the record is bumped whenever its contents change.
+func (srv *Server) NodeRecord() (*enr.Record, error) {
+	if srv.PrivateKey == nil {
+		return nil, errNoPrivateKey
+	}
+	// Gather all the entries the record should contain
+	var (
+		node    = srv.Self()
+		entries []enr.Entry
+	)
+	if ip := node.IP.To4(); ip != nil {
+		entries = append(entries, enr.IP4(ip))
+	} else if node.IP != nil {
+		entries = append(entries, enr.IP6(node.IP))
+	}
+	for _, proto := range srv.Protocols {
+		if proto.Attributes != nil {
+			entries = append(entries, proto.Attributes()...)
+		}
+	}
+	blob, err := rlp.EncodeToBytes(entries)
+	if err != nil {
+		return nil, err
+	}
+	// Reuse the cached record if nothing changed, otherwise sign a new one
+	srv.recordLock.Lock()
+	defer srv.recordLock.Unlock()
+
+	if srv.record != nil && bytes.Equal(srv.recordBlob, blob) {
+		return srv.record, nil
+	}
+	record := new(enr.Record)
+	if srv.record != nil {
+		record.SetSeq(srv.record.Seq())
+	}
+	for _, entry := range entries {
+		record.Set(entry)
+	}
+	if err := record.Sign(srv.PrivateKey); err != nil {
+		return nil, err
+	}
+	srv.record, srv.recordBlob = record, blob
+	return record, nil
+}
+
 // Stop terminates the server and all active peer connections.
 // It blocks until all active connections have been closed.
 func (srv *Server) Stop() {
@@ -412,6 +472,7 @@ func (srv *Server) Start() (err error) {
 	srv.removestatic = make(chan *discover.Node)
 	srv.peerOp = make(chan peerOpFunc)
 	srv.peerOpDone = make(chan struct{})
+	srv.nodeFilters = newNodeFilterCache()
 
 	var (
 		conn      *net.UDPConn
@@ -455,6 +516,7 @@ func (srv *Server) Start() (err error) {
 			NetRestrict:  srv.NetRestrict,
 			Bootnodes:    srv.BootstrapNodes,
 			Unhandled:    unhandled,
+			NodeRecord:   srv.NodeRecord,
 		}
 		ntab, err := discover.ListenUDP(conn, cfg)
 		if err != nil {
@@ -910,6 +972,7 @@ type NodeInfo struct {
 	ID    string `json:"id"`    // Unique node identifier (also the encryption key)
 	Name  string `json:"name"`  // Name of the node, including client type, version, OS, custom data
 	Enode string `json:"enode"` // Enode URL for adding this peer from remote peers
+	ENR   string `json:"enr"`   // UTChain Node Record, base64 encoded (empty if it cannot be signed)
 	IP    string `json:"ip"`    // IP address of the node
 	Ports struct {
 		Discovery int `json:"discovery"` // UDP listening port for discovery protocol
@@ -935,6 +998,12 @@ func (srv *Server) NodeInfo() *NodeInfo {
 	info.Ports.Discovery = int(node.UDP)
 	info.Ports.Listener = int(node.TCP)
 
+	if record, err := srv.NodeRecord(); err == nil {
+		if blob, err := rlp.EncodeToBytes(record); err == nil {
+			info.ENR = "enr:" + base64.RawURLEncoding.EncodeToString(blob)
+		}
+	}
+
 	// Gather all the running protocol infos (only once per protocol type)
 	for _, proto := range srv.Protocols {
 		if _, ok := info.Protocols[proto.Name]; !ok {
@@ -29,6 +29,7 @@ import (
 	"github.com/utchain/go-utchain/crypto/sha3"
 	"github.com/utchain/go-utchain/log"
 	"github.com/utchain/go-utchain/p2p/discover"
+	"github.com/utchain/go-utchain/p2p/enr"
 )
 
 func init() {
@@ -502,3 +503,41 @@ func randomID() (id discover.NodeID) {
 	}
 	return id
 }
+
+// Tests that the node record carries the protocol attributes and that it is only
+// re-signed with a new sequence number if its contents change.
+func TestServerNodeRecord(t *testing.T) {
+	attr := uint(1)
+	srv := &Server{
+		Config: Config{
+			PrivateKey: newkey(),
+			Protocols: []Protocol{{
+				Name: "test",
+				Attributes: func() []enr.Entry {
+					return []enr.Entry{enr.WithEntry("test", attr)}
+				},
+			}},
+		},
+	}
+	check := func(seq uint64, want uint) {
+		record, err := srv.NodeRecord()
+		if err != nil {
+			t.Fatalf("failed to create node record: %v", err)
+		}
+		if record.Seq() != seq {
+			t.Errorf("sequence number mismatch: have %d, want %d", record.Seq(), seq)
+		}
+		var have uint
+		if err := record.Load(enr.WithEntry("test", &have)); err != nil {
+			t.Fatalf("failed to load attribute: %v", err)
+		}
+		if have != want {
+			t.Errorf("attribute mismatch: have %d, want %d", have, want)
+		}
+	}
+	check(1, 1)
+	check(1, 1)
+
+	attr = 2
+	check(2, 2)
+}
@@ -79,3 +79,23 @@ func TestCheckCompatible(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckConfigForkOrder(t *testing.T) {
+	tests := []struct {
+		berlin, eip1559 *big.Int
+		wantErr         bool
+	}{
+		{nil, nil, false},
+		{big.NewInt(0), nil, false},
+		{big.NewInt(0), big.NewInt(0), false},
+		{big.NewInt(5), big.NewInt(10), false},
+		{nil, big.NewInt(0), true},
+		{big.NewInt(10), big.NewInt(5), true},
+	}
+	for i, test := range tests {
+		config := &ChainConfig{BerlinBlock: test.berlin, EIP1559Block: test.eip1559}
+		if err := config.CheckConfigForkOrder(); (err != nil) != test.wantErr {
+			t.Errorf("test %d: error mismatch: have %v, want error %v", i, err, test.wantErr)
+		}
+	}
+}
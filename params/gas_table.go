@@ -72,4 +72,18 @@ var (
 
 		CreateBySuicide: 25000,
 	}
+
+	// GasTableBerlin contains the gas prices of warm state accesses for the
+	// Berlin phase. Cold accesses are charged extra by the EVM.
+	GasTableBerlin = GasTable{
+		ExtcodeSize: WarmStorageReadCostEIP2929,
+		ExtcodeCopy: WarmStorageReadCostEIP2929,
+		Balance:     WarmStorageReadCostEIP2929,
+		SLoad:       WarmStorageReadCostEIP2929,
+		Calls:       WarmStorageReadCostEIP2929,
+		Suicide:     5000,
+		ExpByte:     50,
+
+		CreateBySuicide: 25000,
+	}
 )
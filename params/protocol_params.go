@@ -63,6 +63,16 @@ const (
 
 	MaxCodeSize = 24576 // Maximum bytecode to permit for a contract
 
+	TxAccessListAddressGas       uint64 = 2400 // Per address specified in an access list transaction.
+	TxAccessListStorageKeyGas    uint64 = 1900 // Per storage key specified in an access list transaction.
+	ColdAccountAccessCostEIP2929 uint64 = 2600 // Paid for the first access of an account within a transaction.
+	ColdSloadCostEIP2929         uint64 = 2100 // Paid for the first access of a storage slot within a transaction.
+	WarmStorageReadCostEIP2929   uint64 = 100  // Paid for every access of an already accessed account or slot.
+
+	BaseFeeChangeDenominator = 8          // Bounds the amount the base fee can change between blocks.
+	ElasticityMultiplier     = 2          // Bounds the maximum gas limit an EIP-1559 block may have.
+	InitialBaseFee           = 1000000000 // Initial base fee for EIP-1559 blocks.
+
 	// Precompiled contract gas prices
 
 	EcrecoverGas            uint64 = 3000   // Elliptic curve sender recovery gas price
@@ -17,12 +17,15 @@
 package rpc
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"reflect"
 	"strings"
 	"sync"
 
+	"github.com/utchain/go-utchain/common"
 	"github.com/utchain/go-utchain/common/hexutil"
 	"gopkg.in/fatih/set.v0"
 )
@@ -163,3 +166,59 @@ func (bn *BlockNumber) UnmarshalJSON(data []byte) error {
 func (bn BlockNumber) Int64() int64 {
 	return (int64)(bn)
 }
+
+// BlockNumberOrHash references a block either by number, including the special
+// "latest", "earliest" and "pending" ones, or by hash.
+type BlockNumberOrHash struct {
+	BlockNumber *BlockNumber `json:"blockNumber,omitempty"`
+	BlockHash   *common.Hash `json:"blockHash,omitempty"`
+}
+
+// UnmarshalJSON parses the given JSON fragment into a BlockNumberOrHash. It
+// supports everything BlockNumber does, a 32 byte hex encoded block hash, or
+// an object with exactly one of the "blockNumber" and "blockHash" fields.
+func (bnh *BlockNumberOrHash) UnmarshalJSON(data []byte) error {
+	input := strings.TrimSpace(string(data))
+	if len(input) > 0 && input[0] == '{' {
+		type numberOrHash BlockNumberOrHash
+		var obj numberOrHash
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return err
+		}
+		if (obj.BlockNumber == nil) == (obj.BlockHash == nil) {
+			return errors.New("exactly one of blockNumber and blockHash must be specified")
+		}
+		*bnh = BlockNumberOrHash(obj)
+		return nil
+	}
+	if len(input) == 2*common.HashLength+4 && strings.HasPrefix(input, `"0x`) {
+		var hash common.Hash
+		if err := json.Unmarshal(data, &hash); err != nil {
+			return err
+		}
+		*bnh = BlockNumberOrHash{BlockHash: &hash}
+		return nil
+	}
+	var number BlockNumber
+	if err := number.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	*bnh = BlockNumberOrHash{BlockNumber: &number}
+	return nil
+}
+
+// Number returns the referenced block number, if the block is referenced by it.
+func (bnh *BlockNumberOrHash) Number() (BlockNumber, bool) {
+	if bnh.BlockNumber != nil {
+		return *bnh.BlockNumber, true
+	}
+	return BlockNumber(0), false
+}
+
+// Hash returns the referenced block hash, if the block is referenced by it.
+func (bnh *BlockNumberOrHash) Hash() (common.Hash, bool) {
+	if bnh.BlockHash != nil {
+		return *bnh.BlockHash, true
+	}
+	return common.Hash{}, false
+}
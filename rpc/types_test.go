@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/utchain/go-utchain/common"
 	"github.com/utchain/go-utchain/common/math"
 )
 
@@ -64,3 +65,48 @@ func TestBlockNumberJSONUnmarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestBlockNumberOrHashJSONUnmarshal(t *testing.T) {
+	hash := common.HexToHash("0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+	tests := []struct {
+		input    string
+		mustFail bool
+		number   *BlockNumber
+		hash     *common.Hash
+	}{
+		0: {`"0x12"`, false, blockNumberPtr(18), nil},
+		1: {`"latest"`, false, blockNumberPtr(LatestBlockNumber), nil},
+		2: {`"` + hash.Hex() + `"`, false, nil, &hash},
+		3: {`{"blockNumber": "pending"}`, false, blockNumberPtr(PendingBlockNumber), nil},
+		4: {`{"blockHash": "` + hash.Hex() + `"}`, false, nil, &hash},
+		5: {`{"blockNumber": "0x1", "blockHash": "` + hash.Hex() + `"}`, true, nil, nil},
+		6: {`{}`, true, nil, nil},
+		7: {`"0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b4"`, true, nil, nil},
+		8: {`someString`, true, nil, nil},
+	}
+	for i, test := range tests {
+		var bnh BlockNumberOrHash
+		err := json.Unmarshal([]byte(test.input), &bnh)
+		if test.mustFail && err == nil {
+			t.Errorf("Test %d should fail", i)
+			continue
+		}
+		if !test.mustFail && err != nil {
+			t.Errorf("Test %d should pass but got err: %v", i, err)
+			continue
+		}
+		if test.mustFail {
+			continue
+		}
+		if number, ok := bnh.Number(); ok != (test.number != nil) || (ok && number != *test.number) {
+			t.Errorf("Test %d got unexpected number, want %v, got %v (%v)", i, test.number, number, ok)
+		}
+		if hash, ok := bnh.Hash(); ok != (test.hash != nil) || (ok && hash != *test.hash) {
+			t.Errorf("Test %d got unexpected hash, want %v, got %x (%v)", i, test.hash, hash, ok)
+		}
+	}
+}
+
+func blockNumberPtr(number BlockNumber) *BlockNumber {
+	return &number
+}
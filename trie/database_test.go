@@ -0,0 +1,76 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"testing"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/tstdb"
+)
+
+// Tests that dereferencing a stale trie root garbage collects all the nodes that
+// are not shared with a still referenced root, without touching the disk.
+func TestDatabaseDereference(t *testing.T) {
+	diskdb, _ := tstdb.NewMemDatabase()
+	triedb := NewDatabase(diskdb)
+
+	// Create two consecutive versions of a trie, sharing most of their nodes
+	trie, _ := New(common.Hash{}, triedb)
+	for i := byte(0); i < 64; i++ {
+		trie.Update([]byte{i, i, i}, []byte{i})
+	}
+	first, _ := trie.Commit(nil)
+	triedb.Reference(first, common.Hash{})
+
+	trie.Update([]byte{0, 0, 0}, []byte{0xff})
+	second, _ := trie.Commit(nil)
+	triedb.Reference(second, common.Hash{})
+
+	live := len(triedb.Nodes())
+
+	// Drop the first root and ensure only its unique nodes are deleted
+	triedb.Dereference(first, common.Hash{})
+	if nodes := len(triedb.Nodes()); nodes >= live {
+		t.Fatalf("stale nodes not garbage collected: have %d, had %d", nodes, live)
+	}
+	if _, err := triedb.Node(first); err == nil {
+		t.Errorf("stale root still available")
+	}
+	if len(diskdb.Keys()) != 0 {
+		t.Errorf("garbage collection leaked data to disk: %d entries", len(diskdb.Keys()))
+	}
+	// The remaining root must still be fully available and flushable
+	if err := triedb.Commit(second, false); err != nil {
+		t.Fatalf("failed to commit live root: %v", err)
+	}
+	if size := triedb.Size(); size != 0 {
+		t.Errorf("dangling nodes after commit: %v", size)
+	}
+	trie, err := New(second, NewDatabase(diskdb))
+	if err != nil {
+		t.Fatalf("failed to open committed trie: %v", err)
+	}
+	for i := byte(1); i < 64; i++ {
+		if val := trie.Get([]byte{i, i, i}); len(val) != 1 || val[0] != i {
+			t.Errorf("key %d: value mismatch: have %x, want %x", i, val, []byte{i})
+		}
+	}
+	if val := trie.Get([]byte{0, 0, 0}); len(val) != 1 || val[0] != 0xff {
+		t.Errorf("updated value mismatch: have %x, want %x", val, []byte{0xff})
+	}
+}
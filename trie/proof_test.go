@@ -20,6 +20,7 @@ import (
 	"bytes"
 	crand "crypto/rand"
 	mrand "math/rand"
+	"sort"
 	"testing"
 	"time"
 
@@ -89,6 +90,158 @@ func TestVerifyBadProof(t *testing.T) {
 }
 
 // mutateByte changes one byte in b.
+// sortedEntries returns the key-value pairs of a random trie in key order.
+func sortedEntries(vals map[string]*kv) []*kv {
+	entries := make([]*kv, 0, len(vals))
+	for _, kv := range vals {
+		entries = append(entries, kv)
+	}
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].k, entries[j].k) < 0 })
+	return entries
+}
+
+// proveRange constructs the edge proofs for the given range of trie leaves.
+func proveRange(t *testing.T, trie *Trie, origin []byte, last []byte) *tstdb.MemDatabase {
+	proof, _ := tstdb.NewMemDatabase()
+	if err := trie.Prove(origin, 0, proof); err != nil {
+		t.Fatalf("failed to prove origin %x: %v", origin, err)
+	}
+	if err := trie.Prove(last, 0, proof); err != nil {
+		t.Fatalf("failed to prove last key %x: %v", last, err)
+	}
+	return proof
+}
+
+// Tests that random ranges of leaves are accepted with their edge proofs, both
+// when the origin is the first leaf and when it's a non-existent key before it.
+func TestRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+
+	for i := 0; i < 100; i++ {
+		start := mrand.Intn(len(entries))
+		end := start + 1 + mrand.Intn(len(entries)-start)
+
+		var keys, values [][]byte
+		for _, entry := range entries[start:end] {
+			keys = append(keys, entry.k)
+			values = append(values, entry.v)
+		}
+		origins := [][]byte{keys[0]}
+		if prev := decreaseKey(common.CopyBytes(keys[0])); prev != nil && (start == 0 || bytes.Compare(prev, entries[start-1].k) > 0) {
+			origins = append(origins, prev)
+		}
+		for _, origin := range origins {
+			proof := proveRange(t, trie, origin, keys[len(keys)-1])
+			more, err := VerifyRangeProof(trie.Hash(), origin, keys, values, proof)
+			if err != nil {
+				t.Fatalf("range %d-%d, origin %x: failed to verify: %v", start, end, origin, err)
+			}
+			if more != (end < len(entries)) {
+				t.Fatalf("range %d-%d: continuation mismatch: have %v, want %v", start, end, more, end < len(entries))
+			}
+		}
+	}
+}
+
+// Tests that tampered ranges (missing, extra or modified leaves) are rejected.
+func TestBadRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+
+	for i := 0; i < 100; i++ {
+		start := mrand.Intn(len(entries) - 3)
+		end := start + 3 + mrand.Intn(len(entries)-start-2)
+
+		var keys, values [][]byte
+		for _, entry := range entries[start:end] {
+			keys = append(keys, entry.k)
+			values = append(values, entry.v)
+		}
+		proof := proveRange(t, trie, keys[0], keys[len(keys)-1])
+
+		index := 1 + mrand.Intn(len(keys)-2)
+		switch mrand.Intn(3) {
+		case 0: // Drop a leaf from the middle
+			keys = append(keys[:index:index], keys[index+1:]...)
+			values = append(values[:index:index], values[index+1:]...)
+		case 1: // Modify a value
+			values[index] = randBytes(20)
+		case 2: // Inject a leaf
+			extra := common.CopyBytes(keys[index])
+			if increaseKey(extra) == nil || bytes.Equal(extra, keys[index+1]) {
+				continue
+			}
+			keys = append(keys[:index+1], append([][]byte{extra}, keys[index+1:]...)...)
+			values = append(values[:index+1], append([][]byte{randBytes(20)}, values[index+1:]...)...)
+		}
+		if _, err := VerifyRangeProof(trie.Hash(), keys[0], keys, values, proof); err == nil {
+			t.Fatalf("range %d-%d: tampered range accepted", start, end)
+		}
+	}
+}
+
+// Tests the special cases of range proofs: the whole trie without any proof, a
+// single leaf and an empty range at the end of the trie.
+func TestRangeProofSpecialCases(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+
+	// The entire trie can be verified without proofs
+	var keys, values [][]byte
+	for _, entry := range entries {
+		keys = append(keys, entry.k)
+		values = append(values, entry.v)
+	}
+	if more, err := VerifyRangeProof(trie.Hash(), nil, keys, values, nil); err != nil || more {
+		t.Fatalf("whole trie: have %v, %v, want false, nil", more, err)
+	}
+	if _, err := VerifyRangeProof(trie.Hash(), nil, keys[1:], values[1:], nil); err == nil {
+		t.Fatalf("partial trie accepted without proof")
+	}
+	// A single leaf proven by itself
+	index := mrand.Intn(len(entries))
+	proof := proveRange(t, trie, entries[index].k, entries[index].k)
+	if more, err := VerifyRangeProof(trie.Hash(), entries[index].k, [][]byte{entries[index].k}, [][]byte{entries[index].v}, proof); err != nil || more != (index < len(entries)-1) {
+		t.Fatalf("single leaf: have %v, %v, want %v, nil", more, err, index < len(entries)-1)
+	}
+	// An empty range after the last leaf, and a bogus empty one before it
+	last := entries[len(entries)-1].k
+	origin := common.CopyBytes(last)
+	if increaseKey(origin) != nil {
+		proof = proveRange(t, trie, origin, origin)
+		if more, err := VerifyRangeProof(trie.Hash(), origin, nil, nil, proof); err != nil || more {
+			t.Fatalf("empty tail range: have %v, %v, want false, nil", more, err)
+		}
+	}
+	proof = proveRange(t, trie, entries[index].k, entries[index].k)
+	if _, err := VerifyRangeProof(trie.Hash(), entries[index].k, nil, nil, proof); err == nil {
+		t.Fatalf("empty range accepted with leaves remaining")
+	}
+}
+
+// increaseKey increments the given key in place, returning nil on overflow.
+func increaseKey(key []byte) []byte {
+	for i := len(key) - 1; i >= 0; i-- {
+		key[i]++
+		if key[i] != 0x0 {
+			return key
+		}
+	}
+	return nil
+}
+
+// decreaseKey decrements the given key in place, returning nil on underflow.
+func decreaseKey(key []byte) []byte {
+	for i := len(key) - 1; i >= 0; i-- {
+		key[i]--
+		if key[i] != 0xff {
+			return key
+		}
+	}
+	return nil
+}
+
 func mutateByte(b []byte) {
 	for r := mrand.Intn(len(b)); ; {
 		new := byte(mrand.Intn(255))
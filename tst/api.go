@@ -198,6 +198,27 @@ func (api *PrivateMinerAPI) GetHashrate() uint64 {
 	return uint64(api.e.miner.HashRate())
 }
 
+// SendBundle submits an ordered list of signed transactions for inclusion into
+// the given block. The bundle is either included contiguously and entirely, or
+// not at all, and is never propagated to the network. The bundle hash is returned.
+func (api *PrivateMinerAPI) SendBundle(encodedTxs []hexutil.Bytes, blockNumber hexutil.Uint64) (common.Hash, error) {
+	bundle := &miner.Bundle{
+		Txs:         make(types.Transactions, len(encodedTxs)),
+		BlockNumber: uint64(blockNumber),
+	}
+	for i, encodedTx := range encodedTxs {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(encodedTx); err != nil {
+			return common.Hash{}, fmt.Errorf("transaction %d: %v", i, err)
+		}
+		bundle.Txs[i] = tx
+	}
+	if err := api.e.Miner().AddBundle(bundle); err != nil {
+		return common.Hash{}, err
+	}
+	return bundle.Hash(), nil
+}
+
 // PrivateAdminAPI is the collection of UTChain full node-related APIs
 // exposed over the private admin endpoint.
 type PrivateAdminAPI struct {
@@ -152,6 +152,10 @@ func (b *TstApiBackend) SendTx(ctx context.Context, signedTx *types.Transaction)
 	return b.tst.txPool.AddLocal(signedTx)
 }
 
+func (b *TstApiBackend) SendPrivateTx(ctx context.Context, signedTx *types.Transaction, expiry uint64, fallback bool) error {
+	return b.tst.txPool.AddPrivate(signedTx, expiry, fallback)
+}
+
 func (b *TstApiBackend) GetPoolTransactions() (types.Transactions, error) {
 	pending, err := b.tst.txPool.Pending()
 	if err != nil {
@@ -168,6 +172,10 @@ func (b *TstApiBackend) GetPoolTransaction(hash common.Hash) *types.Transaction
 	return b.tst.txPool.Get(hash)
 }
 
+func (b *TstApiBackend) IsPrivateTx(hash common.Hash) bool {
+	return b.tst.txPool.IsPrivate(hash)
+}
+
 func (b *TstApiBackend) GetPoolNonce(ctx context.Context, addr common.Address) (uint64, error) {
 	return b.tst.txPool.State().GetNonce(addr), nil
 }
@@ -184,6 +192,14 @@ func (b *TstApiBackend) SubscribeTxPreEvent(ch chan<- core.TxPreEvent) event.Sub
 	return b.tst.TxPool().SubscribeTxPreEvent(ch)
 }
 
+func (b *TstApiBackend) TxPoolExplain(hash common.Hash) *core.TxExplanation {
+	return b.tst.TxPool().Explain(hash)
+}
+
+func (b *TstApiBackend) SubscribeTxDropEvent(ch chan<- core.TxDropEvent) event.Subscription {
+	return b.tst.TxPool().SubscribeTxDropEvent(ch)
+}
+
 func (b *TstApiBackend) Downloader() *downloader.Downloader {
 	return b.tst.Downloader()
 }
@@ -196,6 +212,10 @@ func (b *TstApiBackend) SuggestPrice(ctx context.Context) (*big.Int, error) {
 	return b.gpo.SuggestPrice(ctx)
 }
 
+func (b *TstApiBackend) SuggestTipCap(ctx context.Context) (*big.Int, error) {
+	return b.gpo.SuggestTipCap(ctx)
+}
+
 func (b *TstApiBackend) ChainDb() tstdb.Database {
 	return b.tst.ChainDb()
 }
@@ -0,0 +1,381 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package tst
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/common/hexutil"
+	"github.com/utchain/go-utchain/consensus/ethash"
+	"github.com/utchain/go-utchain/consensus/misc"
+	"github.com/utchain/go-utchain/core"
+	"github.com/utchain/go-utchain/core/state"
+	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/params"
+	"github.com/utchain/go-utchain/rpc"
+)
+
+// maxTraceFilterRange is the maximum number of blocks a single trace_filter
+// request is allowed to re-execute.
+const maxTraceFilterRange = 1000
+
+// traceFilterArgs are the criteria of trace_filter. Traces are matched if they
+// originate from any of the from addresses and are received by any of the to
+// addresses, an empty list matching everything.
+type traceFilterArgs struct {
+	FromBlock   *rpc.BlockNumber `json:"fromBlock"`
+	ToBlock     *rpc.BlockNumber `json:"toBlock"`
+	FromAddress []common.Address `json:"fromAddress"`
+	ToAddress   []common.Address `json:"toAddress"`
+	After       *uint64          `json:"after"`
+	Count       *uint64          `json:"count"`
+}
+
+// traceReplayResult is the result of replaying a transaction, containing only
+// the trace types requested.
+type traceReplayResult struct {
+	Output    hexutil.Bytes                        `json:"output"`
+	StateDiff map[common.Address]*stateDiffAccount `json:"stateDiff"`
+	Trace     []*flatTrace                         `json:"trace"`
+	VmTrace   *vmTrace                             `json:"vmTrace"`
+}
+
+// PrivateTraceAPI is the collection of UTChain APIs exposing the flat traces of
+// the calls, contract creations, self destructs and rewards of the chain.
+type PrivateTraceAPI struct {
+	config *params.ChainConfig
+	tst    *UTChain
+	debug  *PrivateDebugAPI
+}
+
+// NewPrivateTraceAPI creates a new API definition for the flat tracing methods
+// of the UTChain service.
+func NewPrivateTraceAPI(config *params.ChainConfig, tst *UTChain) *PrivateTraceAPI {
+	return &PrivateTraceAPI{config: config, tst: tst, debug: NewPrivateDebugAPI(config, tst)}
+}
+
+// Block returns the flat traces of all the transactions of a block, followed by
+// the mining rewards paid in it.
+func (api *PrivateTraceAPI) Block(ctx context.Context, number rpc.BlockNumber) ([]*flatTrace, error) {
+	block, err := api.blockByNumber(number)
+	if err != nil {
+		return nil, err
+	}
+	statedb, err := api.stateBefore(block)
+	if err != nil {
+		return nil, err
+	}
+	return api.traceBlock(ctx, block, statedb)
+}
+
+// Transaction returns the flat traces of a single transaction.
+func (api *PrivateTraceAPI) Transaction(ctx context.Context, hash common.Hash) ([]*flatTrace, error) {
+	tx, blockHash, blockNumber, index := core.GetTransaction(api.tst.ChainDb(), hash)
+	if tx == nil {
+		return nil, fmt.Errorf("transaction %x not found", hash)
+	}
+	msg, vmctx, statedb, err := api.debug.computeTxEnv(blockHash, int(index), defaultTraceReexec)
+	if err != nil {
+		return nil, err
+	}
+	tracer := newFlatCallTracer()
+	if _, _, _, err := api.debug.executeTrace(ctx, msg, vmctx, statedb, tracer, defaultTraceTimeout); err != nil {
+		return nil, err
+	}
+	return annotateTraces(tracer.traces(), blockHash, blockNumber, hash, index), nil
+}
+
+// Filter returns the flat traces of a range of blocks matching the given
+// address criteria. The default range is the latest block only, and at most
+// maxTraceFilterRange blocks are traced by a single request.
+func (api *PrivateTraceAPI) Filter(ctx context.Context, args traceFilterArgs) ([]*flatTrace, error) {
+	start, end := rpc.LatestBlockNumber, rpc.LatestBlockNumber
+	if args.FromBlock != nil {
+		start = *args.FromBlock
+	}
+	if args.ToBlock != nil {
+		end = *args.ToBlock
+	}
+	first, err := api.blockByNumber(start)
+	if err != nil {
+		return nil, err
+	}
+	last, err := api.blockByNumber(end)
+	if err != nil {
+		return nil, err
+	}
+	if first.NumberU64() > last.NumberU64() {
+		return nil, fmt.Errorf("invalid block range #%d - #%d", first.NumberU64(), last.NumberU64())
+	}
+	if last.NumberU64()-first.NumberU64() >= maxTraceFilterRange {
+		return nil, fmt.Errorf("block range #%d - #%d exceeds the limit of %d blocks", first.NumberU64(), last.NumberU64(), maxTraceFilterRange)
+	}
+	// Retrieve the state before the first block, carried forward while tracing
+	statedb, err := api.stateBefore(first)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		database = statedb.Database().TrieDB()
+		proot    common.Hash
+	)
+	defer func() { database.Dereference(proot, common.Hash{}) }()
+
+	var skip uint64
+	if args.After != nil {
+		skip = *args.After
+	}
+	traces := []*flatTrace{}
+	for number := first.NumberU64(); number <= last.NumberU64(); number++ {
+		block := last
+		if number < last.NumberU64() {
+			if block = api.tst.blockchain.GetBlockByNumber(number); block == nil {
+				return nil, fmt.Errorf("block #%d not found", number)
+			}
+		}
+		blockTraces, err := api.traceBlock(ctx, block, statedb)
+		if err != nil {
+			return nil, err
+		}
+		for _, trace := range blockTraces {
+			if !matchAddress(trace.from(), args.FromAddress) || !matchAddress(trace.to(), args.ToAddress) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			traces = append(traces, trace)
+			if args.Count != nil && uint64(len(traces)) >= *args.Count {
+				return traces, nil
+			}
+		}
+		// Commit the state after the block to trace the next one on top
+		if number < last.NumberU64() {
+			root, err := statedb.Commit(api.config.IsEIP158(block.Number()))
+			if err != nil {
+				return nil, err
+			}
+			if err := statedb.Reset(root); err != nil {
+				return nil, err
+			}
+			database.Reference(root, common.Hash{})
+			database.Dereference(proot, common.Hash{})
+			proot = root
+		}
+	}
+	return traces, nil
+}
+
+// ReplayTransaction re-executes a transaction and returns its output along with
+// the requested trace types: "trace" for the flat traces, "stateDiff" for the
+// state modifications and "vmTrace" for the executed instructions.
+func (api *PrivateTraceAPI) ReplayTransaction(ctx context.Context, hash common.Hash, traceTypes []string) (*traceReplayResult, error) {
+	// Assemble the tracers of the requested trace types
+	var (
+		tracers traceMux
+		flat    *flatCallTracer
+		diff    *stateDiffTracer
+		vmt     *vmTracer
+	)
+	for _, typ := range traceTypes {
+		switch typ {
+		case "trace":
+			if flat == nil {
+				flat = newFlatCallTracer()
+				tracers = append(tracers, flat)
+			}
+		case "stateDiff":
+			if diff == nil {
+				diff = newStateDiffTracer()
+				tracers = append(tracers, diff)
+			}
+		case "vmTrace":
+			if vmt == nil {
+				vmt = newVMTracer()
+				tracers = append(tracers, vmt)
+			}
+		default:
+			return nil, fmt.Errorf("unknown trace type %q", typ)
+		}
+	}
+	// Retrieve the transaction and assemble its EVM context
+	tx, blockHash, _, index := core.GetTransaction(api.tst.ChainDb(), hash)
+	if tx == nil {
+		return nil, fmt.Errorf("transaction %x not found", hash)
+	}
+	msg, vmctx, statedb, err := api.debug.computeTxEnv(blockHash, int(index), defaultTraceReexec)
+	if err != nil {
+		return nil, err
+	}
+	// Keep the state before the transaction around if it needs to be diffed
+	var pre *state.StateDB
+	if diff != nil {
+		statedb.Finalise(api.config.IsEIP158(vmctx.BlockNumber))
+		pre = statedb.Copy()
+
+		diff.touch(vmctx.Coinbase)
+	}
+	output, _, _, err := api.debug.executeTrace(ctx, msg, vmctx, statedb, tracers, defaultTraceTimeout)
+	if err != nil {
+		return nil, err
+	}
+	// Gather the requested traces and return
+	result := &traceReplayResult{Output: output}
+	if flat != nil {
+		if result.Trace = flat.traces(); result.Trace == nil {
+			result.Trace = []*flatTrace{}
+		}
+	}
+	if diff != nil {
+		statedb.Finalise(api.config.IsEIP158(vmctx.BlockNumber))
+		result.StateDiff = diff.diff(pre, statedb)
+	}
+	if vmt != nil {
+		result.VmTrace = vmt.trace()
+	}
+	return result, nil
+}
+
+// blockByNumber retrieves a block of the local chain, or the pending one.
+func (api *PrivateTraceAPI) blockByNumber(number rpc.BlockNumber) (*types.Block, error) {
+	var block *types.Block
+
+	switch number {
+	case rpc.PendingBlockNumber:
+		block = api.tst.miner.PendingBlock()
+	case rpc.LatestBlockNumber:
+		block = api.tst.blockchain.CurrentBlock()
+	default:
+		block = api.tst.blockchain.GetBlockByNumber(uint64(number))
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block #%d not found", number)
+	}
+	return block, nil
+}
+
+// stateBefore retrieves the state the transactions of a block are executed on,
+// which is that of its parent, or the genesis state itself.
+func (api *PrivateTraceAPI) stateBefore(block *types.Block) (*state.StateDB, error) {
+	if block.NumberU64() == 0 {
+		return api.debug.computeStateDB(block, defaultTraceReexec)
+	}
+	parent := api.tst.blockchain.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, fmt.Errorf("parent %x not found", block.ParentHash())
+	}
+	return api.debug.computeStateDB(parent, defaultTraceReexec)
+}
+
+// traceBlock re-executes all the transactions of a block on top of the given
+// state of its parent, and returns their flat traces followed by those of the
+// mining rewards. The state is left as it is after the block.
+func (api *PrivateTraceAPI) traceBlock(ctx context.Context, block *types.Block, statedb *state.StateDB) ([]*flatTrace, error) {
+	// The genesis block has neither transactions nor rewards
+	traces := []*flatTrace{}
+	if block.NumberU64() == 0 {
+		return traces, nil
+	}
+	// Mutate the the block and state according to any hard-fork specs
+	if api.config.DAOForkSupport && api.config.DAOForkBlock != nil && api.config.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	signer := types.MakeSigner(api.config, block.Number())
+
+	for i, tx := range block.Transactions() {
+		msg, err := tx.AsMessage(signer)
+		if err != nil {
+			return nil, fmt.Errorf("tx %x: %v", tx.Hash(), err)
+		}
+		vmctx := core.NewEVMContext(msg, block.Header(), api.tst.blockchain, nil)
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+
+		tracer := newFlatCallTracer()
+		if _, _, _, err := api.debug.executeTrace(ctx, msg, vmctx, statedb, tracer, defaultTraceTimeout); err != nil {
+			return nil, fmt.Errorf("tx %x failed: %v", tx.Hash(), err)
+		}
+		// Finalize the state so any modifications are written to the trie
+		statedb.Finalise(api.config.IsEIP158(block.Number()))
+
+		traces = append(traces, annotateTraces(tracer.traces(), block.Hash(), block.NumberU64(), tx.Hash(), uint64(i))...)
+	}
+	// Pay the mining rewards, completing the state after the block
+	api.tst.engine.Finalize(api.tst.blockchain, block.Header(), statedb, block.Transactions(), block.Uncles(), nil)
+
+	return append(traces, api.rewardTraces(block)...), nil
+}
+
+// rewardTraces returns the traces of the block and uncle rewards paid in a block
+// as per the ethash consensus rules. Other consensus engines pay no rewards.
+func (api *PrivateTraceAPI) rewardTraces(block *types.Block) []*flatTrace {
+	if _, ok := api.tst.engine.(*ethash.Tstash); !ok {
+		return nil
+	}
+	reward, uncleRewards := ethash.Rewards(api.config, block.Header(), block.Uncles())
+
+	traces := []*flatTrace{newRewardTrace(block, block.Coinbase(), "block", reward)}
+	for i, uncle := range block.Uncles() {
+		traces = append(traces, newRewardTrace(block, uncle.Coinbase, "uncle", uncleRewards[i]))
+	}
+	return traces
+}
+
+// newRewardTrace creates the trace of a mining reward paid in a block.
+func newRewardTrace(block *types.Block, author common.Address, kind string, value *big.Int) *flatTrace {
+	hash, number := block.Hash(), block.NumberU64()
+	return &flatTrace{
+		Action: &traceAction{
+			Author:     &author,
+			RewardType: kind,
+			Value:      (*hexutil.Big)(value),
+		},
+		BlockHash:    &hash,
+		BlockNumber:  &number,
+		TraceAddress: []int{},
+		Type:         "reward",
+	}
+}
+
+// annotateTraces sets the block and transaction the traces originate from.
+func annotateTraces(traces []*flatTrace, blockHash common.Hash, blockNumber uint64, txHash common.Hash, index uint64) []*flatTrace {
+	for _, trace := range traces {
+		trace.BlockHash, trace.BlockNumber = &blockHash, &blockNumber
+		trace.TransactionHash, trace.TransactionPosition = &txHash, &index
+	}
+	return traces
+}
+
+// matchAddress reports whether an address is contained in the filter list, an
+// empty list matching any address.
+func matchAddress(addr *common.Address, filter []common.Address) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	if addr == nil {
+		return false
+	}
+	for _, candidate := range filter {
+		if candidate == *addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package tst
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/consensus/ethash"
+	"github.com/utchain/go-utchain/core"
+	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/core/vm"
+	"github.com/utchain/go-utchain/crypto"
+	"github.com/utchain/go-utchain/params"
+	"github.com/utchain/go-utchain/rpc"
+)
+
+// newTestTraceAPI creates a trace API on top of a chain of two blocks. The first
+// block calls a contract forwarding some of the received value to a recipient,
+// the second one transfers value to an account directly.
+func newTestTraceAPI(t *testing.T) (*PrivateTraceAPI, common.Address, common.Address, common.Address, []*types.Transaction) {
+	var (
+		key, _    = crypto.GenerateKey()
+		sender    = crypto.PubkeyToAddress(key.PublicKey)
+		contract  = common.Address{0xc0}
+		recipient = common.Address{0xee}
+		signer    = types.HomesteadSigner{}
+		txs       []*types.Transaction
+	)
+	// Forward a single wei to the recipient with only the stipend as gas
+	code := []byte{
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 1,
+		byte(vm.PUSH20),
+	}
+	code = append(code, recipient.Bytes()...)
+	code = append(code, byte(vm.PUSH1), 0, byte(vm.CALL), byte(vm.POP), byte(vm.STOP))
+
+	backend := newTestTracerBackend(t, 2, core.GenesisAlloc{
+		sender:   {Balance: big.NewInt(params.Tster)},
+		contract: {Balance: new(big.Int), Code: code},
+	}, func(i int, b *core.BlockGen) {
+		var tx *types.Transaction
+		if i == 0 {
+			tx = types.NewTransaction(b.TxNonce(sender), contract, big.NewInt(10), 100000, nil, nil)
+		} else {
+			tx = types.NewTransaction(b.TxNonce(sender), common.Address{0xaa}, big.NewInt(5), params.TxGas, nil, nil)
+		}
+		tx, _ = types.SignTx(tx, signer, key)
+		b.AddTx(tx)
+		txs = append(txs, tx)
+	})
+	return NewPrivateTraceAPI(backend.chainConfig, backend), sender, contract, recipient, txs
+}
+
+// Tests that the flat traces of a block contain all the calls of its transactions
+// positioned in their call trees, followed by the block reward.
+func TestTraceBlock(t *testing.T) {
+	api, sender, contract, recipient, txs := newTestTraceAPI(t)
+
+	traces, err := api.Block(context.Background(), rpc.BlockNumber(1))
+	if err != nil {
+		t.Fatalf("failed to trace block: %v", err)
+	}
+	if len(traces) != 3 {
+		t.Fatalf("trace count mismatch: have %d, want 3", len(traces))
+	}
+	outer, inner, reward := traces[0], traces[1], traces[2]
+
+	if outer.Type != "call" || *outer.Action.From != sender || *outer.Action.To != contract || outer.Subtraces != 1 || len(outer.TraceAddress) != 0 {
+		t.Errorf("outer call mismatch: have %+v", outer.Action)
+	}
+	if gas := uint64(*outer.Action.Gas); gas != 100000-params.TxGas {
+		t.Errorf("outer call gas mismatch: have %d, want %d", gas, 100000-params.TxGas)
+	}
+	if *outer.TransactionHash != txs[0].Hash() || *outer.TransactionPosition != 0 || *outer.BlockNumber != 1 {
+		t.Errorf("outer call context mismatch: have tx %x #%d in block #%d", *outer.TransactionHash, *outer.TransactionPosition, *outer.BlockNumber)
+	}
+	if inner.Type != "call" || *inner.Action.From != contract || *inner.Action.To != recipient || inner.Action.Value.ToInt().Int64() != 1 {
+		t.Errorf("inner call mismatch: have %+v", inner.Action)
+	}
+	if len(inner.TraceAddress) != 1 || inner.TraceAddress[0] != 0 || inner.Subtraces != 0 {
+		t.Errorf("inner call position mismatch: have address %v with %d subtraces", inner.TraceAddress, inner.Subtraces)
+	}
+	if gas, used := uint64(*inner.Action.Gas), uint64(*inner.Result.GasUsed); gas != params.CallStipend || used != 0 {
+		t.Errorf("inner call gas mismatch: have %d/%d, want %d/0", gas, used, params.CallStipend)
+	}
+	if reward.Type != "reward" || reward.Action.RewardType != "block" || reward.Action.Value.ToInt().Cmp(ethash.ByzantiumBlockReward) != 0 {
+		t.Errorf("reward mismatch: have %+v", reward.Action)
+	}
+	if reward.TransactionHash != nil {
+		t.Errorf("reward assigned to transaction %x", *reward.TransactionHash)
+	}
+	// Transactions should be traceable on their own too
+	if traces, err = api.Transaction(context.Background(), txs[1].Hash()); err != nil {
+		t.Fatalf("failed to trace transaction: %v", err)
+	}
+	if len(traces) != 1 || *traces[0].Action.From != sender || *traces[0].BlockNumber != 2 || traces[0].Subtraces != 0 {
+		t.Errorf("transaction traces mismatch: have %d, first %+v", len(traces), traces[0].Action)
+	}
+}
+
+// Tests that traces of a block range can be filtered by their sender and receiver,
+// and paginated.
+func TestTraceFilter(t *testing.T) {
+	api, sender, contract, recipient, txs := newTestTraceAPI(t)
+
+	var (
+		genesis = rpc.BlockNumber(0)
+		first   = rpc.BlockNumber(1)
+		last    = rpc.BlockNumber(2)
+		one     = uint64(1)
+	)
+	tests := []struct {
+		args  traceFilterArgs
+		froms []common.Address
+	}{
+		{traceFilterArgs{FromBlock: &first, ToBlock: &last, ToAddress: []common.Address{recipient}}, []common.Address{contract}},
+		{traceFilterArgs{FromBlock: &first, ToBlock: &last, FromAddress: []common.Address{sender}}, []common.Address{sender, sender}},
+		{traceFilterArgs{FromBlock: &first, ToBlock: &last, FromAddress: []common.Address{sender}, After: &one, Count: &one}, []common.Address{sender}},
+		{traceFilterArgs{FromBlock: &first, ToBlock: &first, FromAddress: []common.Address{recipient}}, nil},
+		{traceFilterArgs{FromBlock: &genesis, ToBlock: &last, FromAddress: []common.Address{sender}}, []common.Address{sender, sender}},
+	}
+	for i, tt := range tests {
+		traces, err := api.Filter(context.Background(), tt.args)
+		if err != nil {
+			t.Fatalf("test %d: failed to filter traces: %v", i, err)
+		}
+		if len(traces) != len(tt.froms) {
+			t.Errorf("test %d: trace count mismatch: have %d, want %d", i, len(traces), len(tt.froms))
+			continue
+		}
+		for j, trace := range traces {
+			if *trace.Action.From != tt.froms[j] {
+				t.Errorf("test %d, trace %d: sender mismatch: have %x, want %x", i, j, *trace.Action.From, tt.froms[j])
+			}
+		}
+	}
+	// Pagination should skip the first transaction's trace
+	traces, _ := api.Filter(context.Background(), tests[2].args)
+	if len(traces) == 1 && *traces[0].TransactionHash != txs[1].Hash() {
+		t.Errorf("paginated trace mismatch: have tx %x, want %x", *traces[0].TransactionHash, txs[1].Hash())
+	}
+	// Inverted ranges should be rejected
+	if _, err := api.Filter(context.Background(), traceFilterArgs{FromBlock: &last, ToBlock: &first}); err == nil {
+		t.Errorf("inverted block range accepted")
+	}
+}
+
+// Tests that replaying a transaction reports the requested state diff and
+// virtual machine traces.
+func TestTraceReplayTransaction(t *testing.T) {
+	api, _, contract, recipient, txs := newTestTraceAPI(t)
+
+	result, err := api.ReplayTransaction(context.Background(), txs[0].Hash(), []string{"trace", "stateDiff", "vmTrace"})
+	if err != nil {
+		t.Fatalf("failed to replay transaction: %v", err)
+	}
+	if len(result.Trace) != 2 || result.Trace[0].BlockHash != nil {
+		t.Errorf("flat traces mismatch: have %d traces", len(result.Trace))
+	}
+	// The recipient is created by the call, the contract keeps the rest
+	diffs := map[common.Address]string{
+		recipient: `{"balance":{"+":"0x1"},"code":{"+":"0x"},"nonce":{"+":"0x0"},"storage":{}}`,
+		contract:  `{"balance":{"*":{"from":"0x0","to":"0x9"}},"code":"=","nonce":"=","storage":{}}`,
+	}
+	for addr, want := range diffs {
+		blob, err := json.Marshal(result.StateDiff[addr])
+		if err != nil {
+			t.Fatalf("failed to marshal state diff: %v", err)
+		}
+		if string(blob) != want {
+			t.Errorf("state diff mismatch of %x: have %s, want %s", addr, blob, want)
+		}
+	}
+	// Every instruction of the contract should be traced, with its effects
+	if len(result.VmTrace.Ops) != 10 {
+		t.Fatalf("instruction count mismatch: have %d, want 10", len(result.VmTrace.Ops))
+	}
+	call := result.VmTrace.Ops[7]
+	if len(call.Ex.Push) != 1 || call.Ex.Push[0].ToInt().Int64() != 1 || call.Sub != nil {
+		t.Errorf("call instruction mismatch: have %+v", call.Ex)
+	}
+	// Unknown trace types should be rejected
+	if _, err := api.ReplayTransaction(context.Background(), txs[0].Hash(), []string{"unknown"}); err == nil {
+		t.Errorf("unknown trace type accepted")
+	}
+}
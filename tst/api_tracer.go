@@ -19,6 +19,7 @@ package tst
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -57,6 +58,10 @@ type TraceConfig struct {
 	Tracer  *string
 	Timeout *string
 	Reexec  *uint64
+
+	// TracerConfig is passed to native tracers, e.g. {"diffMode": true} for
+	// the native prestate tracer.
+	TracerConfig json.RawMessage
 }
 
 // txTraceResult is the result of a single transaction trace.
@@ -549,35 +554,83 @@ func (api *PrivateDebugAPI) TraceTransaction(ctx context.Context, hash common.Ha
 	return api.traceTx(ctx, msg, vmctx, statedb, config)
 }
 
+// TraceCall traces an unsigned call on top of the state of the given block, as
+// eth_call would execute it, and returns the results of the requested tracer,
+// or the structured logs if none was requested.
+func (api *PrivateDebugAPI) TraceCall(ctx context.Context, args ethapi.CallArgs, blockNrOrHash rpc.BlockNumberOrHash, config *TraceConfig) (interface{}, error) {
+	// Retrieve the block to execute the call on top of, along with its state
+	var (
+		block   *types.Block
+		statedb *state.StateDB
+	)
+	if hash, ok := blockNrOrHash.Hash(); ok {
+		block = api.tst.blockchain.GetBlockByHash(hash)
+		if block == nil {
+			return nil, fmt.Errorf("block %x not found", hash)
+		}
+	} else {
+		number, _ := blockNrOrHash.Number()
+		switch number {
+		case rpc.PendingBlockNumber:
+			block, statedb = api.tst.miner.Pending()
+		case rpc.LatestBlockNumber:
+			block = api.tst.blockchain.CurrentBlock()
+		default:
+			block = api.tst.blockchain.GetBlockByNumber(uint64(number))
+		}
+		if block == nil {
+			return nil, fmt.Errorf("block #%d not found", number)
+		}
+	}
+	if statedb == nil {
+		reexec := defaultTraceReexec
+		if config != nil && config.Reexec != nil {
+			reexec = *config.Reexec
+		}
+		var err error
+		if statedb, err = api.computeStateDB(block, reexec); err != nil {
+			return nil, err
+		}
+	}
+	// Set sender address or use a default if none specified
+	if args.From == (common.Address{}) {
+		if wallets := api.tst.AccountManager().Wallets(); len(wallets) > 0 {
+			if accounts := wallets[0].Accounts(); len(accounts) > 0 {
+				args.From = accounts[0].Address
+			}
+		}
+	}
+	// Assemble the EVM context of the call
+	msg := args.ToMessage(block.BaseFee())
+	vmctx := core.NewEVMContext(msg, block.Header(), api.tst.blockchain, nil)
+
+	// Trace the call and return
+	return api.traceTx(ctx, msg, vmctx, statedb, config)
+}
+
 // traceTx configures a new tracer according to the provided configuration, and
 // executes the given message in the provided environment. The return value will
 // be tracer dependent.
 func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, vmctx vm.Context, statedb *state.StateDB, config *TraceConfig) (interface{}, error) {
 	// Assemble the structured logger or the JavaScript tracer
 	var (
-		tracer vm.Tracer
-		err    error
+		tracer  vm.Tracer
+		timeout time.Duration
+		err     error
 	)
 	switch {
 	case config != nil && config.Tracer != nil:
 		// Define a meaningful timeout of a single transaction trace
-		timeout := defaultTraceTimeout
+		timeout = defaultTraceTimeout
 		if config.Timeout != nil {
 			if timeout, err = time.ParseDuration(*config.Timeout); err != nil {
 				return nil, err
 			}
 		}
-		// Constuct the JavaScript tracer to execute with
-		if tracer, err = tracers.New(*config.Tracer); err != nil {
+		// Constuct the native or JavaScript tracer to execute with
+		if tracer, err = tracers.Create(*config.Tracer, config.TracerConfig); err != nil {
 			return nil, err
 		}
-		// Handle timeouts and RPC cancellations
-		deadlineCtx, cancel := context.WithTimeout(ctx, timeout)
-		go func() {
-			<-deadlineCtx.Done()
-			tracer.(*tracers.Tracer).Stop(errors.New("execution timeout"))
-		}()
-		defer cancel()
 
 	case config == nil:
 		tracer = vm.NewStructLogger(nil)
@@ -586,11 +639,9 @@ func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, v
 		tracer = vm.NewStructLogger(config.LogConfig)
 	}
 	// Run the transaction with tracing enabled.
-	vmenv := vm.NewEVM(vmctx, statedb, api.config, vm.Config{Debug: true, Tracer: tracer})
-
-	ret, gas, failed, err := core.ApplyMessage(vmenv, message, new(core.GasPool).AddGas(message.Gas()))
+	ret, gas, failed, err := api.executeTrace(ctx, message, vmctx, statedb, tracer, timeout)
 	if err != nil {
-		return nil, fmt.Errorf("tracing failed: %v", err)
+		return nil, err
 	}
 	// Depending on the tracer type, format and return the output
 	switch tracer := tracer.(type) {
@@ -602,7 +653,7 @@ func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, v
 			StructLogs:  ethapi.FormatLogs(tracer.StructLogs()),
 		}, nil
 
-	case *tracers.Tracer:
+	case tracers.Interface:
 		return tracer.GetResult()
 
 	default:
@@ -610,6 +661,47 @@ func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, v
 	}
 }
 
+// executeTrace executes the given message in the provided environment with the
+// tracer attached, returning the output, the gas used and whether the execution
+// failed. The execution is aborted if the request is cancelled or, given a non
+// zero timeout, if it runs for longer than that.
+func (api *PrivateDebugAPI) executeTrace(ctx context.Context, message core.Message, vmctx vm.Context, statedb *state.StateDB, tracer vm.Tracer, timeout time.Duration) ([]byte, uint64, bool, error) {
+	vmenv := vm.NewEVM(vmctx, statedb, api.config, vm.Config{Debug: true, Tracer: tracer})
+	if capturer, ok := tracer.(tracers.TxStartCapturer); ok {
+		capturer.CaptureTxStart(statedb, message.From(), message.To(), vmctx.Coinbase)
+	}
+	// Handle timeouts and RPC cancellations
+	var (
+		deadlineCtx context.Context
+		cancel      context.CancelFunc
+	)
+	if timeout > 0 {
+		deadlineCtx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		deadlineCtx, cancel = context.WithCancel(ctx)
+	}
+	go func() {
+		<-deadlineCtx.Done()
+		if tracer, ok := tracer.(tracers.Interface); ok {
+			tracer.Stop(errors.New("execution timeout"))
+		}
+		vmenv.Cancel()
+	}()
+	defer cancel()
+
+	ret, gas, failed, err := core.ApplyMessage(vmenv, message, new(core.GasPool).AddGas(message.Gas()))
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("tracing failed: %v", err)
+	}
+	switch deadlineCtx.Err() {
+	case context.DeadlineExceeded:
+		return nil, 0, false, errors.New("execution timeout")
+	case context.Canceled:
+		return nil, 0, false, errors.New("tracing cancelled")
+	}
+	return ret, gas, failed, nil
+}
+
 // computeTxEnv returns the execution environment of a certain transaction.
 func (api *PrivateDebugAPI) computeTxEnv(blockHash common.Hash, txIndex int, reexec uint64) (core.Message, vm.Context, *state.StateDB, error) {
 	// Create the parent state database
@@ -0,0 +1,149 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package tst
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/utchain/go-utchain/accounts"
+	"github.com/utchain/go-utchain/accounts/keystore"
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/consensus/ethash"
+	"github.com/utchain/go-utchain/core"
+	"github.com/utchain/go-utchain/core/vm"
+	"github.com/utchain/go-utchain/internal/ethapi"
+	"github.com/utchain/go-utchain/params"
+	"github.com/utchain/go-utchain/rpc"
+	"github.com/utchain/go-utchain/tstdb"
+)
+
+// newTestTracerBackend creates a minimal UTChain service on top of a chain of
+// the given length, with the given genesis allocation and block generator.
+func newTestTracerBackend(t *testing.T, blocks int, alloc core.GenesisAlloc, generator func(int, *core.BlockGen)) *UTChain {
+	db, _ := tstdb.NewMemDatabase()
+	gspec := &core.Genesis{Config: params.TestChainConfig, Alloc: alloc}
+	genesis := gspec.MustCommit(db)
+	engine := ethash.NewFaker()
+
+	blockchain, _ := core.NewBlockChain(db, nil, gspec.Config, engine, vm.Config{})
+	chain, _ := core.GenerateChain(gspec.Config, genesis, engine, db, blocks, generator)
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	return &UTChain{chainConfig: gspec.Config, blockchain: blockchain, chainDb: db, engine: engine, accountManager: accounts.NewManager()}
+}
+
+// Tests that unsigned calls can be traced on top of blocks referenced both by
+// number and by hash, with the structured logger or any named tracer.
+func TestTraceCall(t *testing.T) {
+	var (
+		from     = common.Address{0x01}
+		contract = common.Address{0x02}
+		code     = []byte{byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.REVERT)}
+	)
+	backend := newTestTracerBackend(t, 2, core.GenesisAlloc{
+		from:     {Balance: big.NewInt(params.Tster)},
+		contract: {Balance: new(big.Int), Code: code},
+	}, nil)
+	api := NewPrivateDebugAPI(backend.chainConfig, backend)
+	args := ethapi.CallArgs{From: from, To: &contract, Gas: 100000}
+
+	var (
+		tracer = "nativeCallTracer"
+		latest = rpc.LatestBlockNumber
+		first  = rpc.BlockNumber(1)
+		hash   = backend.blockchain.CurrentBlock().Hash()
+	)
+	for i, block := range []rpc.BlockNumberOrHash{{BlockNumber: &latest}, {BlockNumber: &first}, {BlockHash: &hash}} {
+		res, err := api.TraceCall(context.Background(), args, block, &TraceConfig{Tracer: &tracer})
+		if err != nil {
+			t.Fatalf("test %d: failed to trace call: %v", i, err)
+		}
+		trace := new(callTrace)
+		if err := json.Unmarshal(res.(json.RawMessage), trace); err != nil {
+			t.Fatalf("test %d: failed to unmarshal trace: %v", i, err)
+		}
+		if trace.From != from || trace.To != contract || trace.Error != "execution reverted" {
+			t.Errorf("test %d: trace mismatch: have %+v", i, trace)
+		}
+	}
+	// Without a tracer the structured logs should be returned
+	res, err := api.TraceCall(context.Background(), args, rpc.BlockNumberOrHash{BlockNumber: &latest}, nil)
+	if err != nil {
+		t.Fatalf("failed to trace call: %v", err)
+	}
+	if result := res.(*ethapi.ExecutionResult); !result.Failed || len(result.StructLogs) != 3 {
+		t.Errorf("structured logs mismatch: have failed %v with %d logs, want true with 3", result.Failed, len(result.StructLogs))
+	}
+	// Unknown blocks should be rejected
+	missing := rpc.BlockNumber(10)
+	if _, err := api.TraceCall(context.Background(), args, rpc.BlockNumberOrHash{BlockNumber: &missing}, nil); err == nil {
+		t.Errorf("call traced on top of missing block")
+	}
+}
+
+// Tests that calls without a sender are traced from the first wallet account,
+// the same way they are executed by tst_call.
+func TestTraceCallDefaultSender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracecall-keystore")
+	if err != nil {
+		t.Fatalf("failed to create temporary keystore: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ks := keystore.NewKeyStore(dir, keystore.LightScryptN, keystore.LightScryptP)
+	account, err := ks.NewAccount("")
+	if err != nil {
+		t.Fatalf("failed to create account: %v", err)
+	}
+	contract := common.Address{0x02}
+	backend := newTestTracerBackend(t, 1, core.GenesisAlloc{
+		account.Address: {Balance: big.NewInt(params.Tster)},
+		contract:        {Balance: new(big.Int), Code: []byte{byte(vm.STOP)}},
+	}, nil)
+	backend.accountManager = accounts.NewManager(ks)
+	defer backend.accountManager.Close()
+
+	var (
+		api    = NewPrivateDebugAPI(backend.chainConfig, backend)
+		tracer = "nativeCallTracer"
+		latest = rpc.LatestBlockNumber
+	)
+	res, err := api.TraceCall(context.Background(), ethapi.CallArgs{To: &contract, Gas: 100000}, rpc.BlockNumberOrHash{BlockNumber: &latest}, &TraceConfig{Tracer: &tracer})
+	if err != nil {
+		t.Fatalf("failed to trace call: %v", err)
+	}
+	trace := new(callTrace)
+	if err := json.Unmarshal(res.(json.RawMessage), trace); err != nil {
+		t.Fatalf("failed to unmarshal trace: %v", err)
+	}
+	if trace.From != account.Address {
+		t.Errorf("sender mismatch: have %x, want %x", trace.From, account.Address)
+	}
+}
+
+// callTrace is the subset of a callTracer result checked by the tests.
+type callTrace struct {
+	From  common.Address `json:"from"`
+	To    common.Address `json:"to"`
+	Error string         `json:"error"`
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package tst
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/common/hexutil"
+	"github.com/utchain/go-utchain/core"
+	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/rpc"
+)
+
+// internalTransferQuery are the criteria of an internal transfer lookup. Both
+// ends of the block range default to the last indexed block.
+type internalTransferQuery struct {
+	Address   common.Address   `json:"address"`
+	FromBlock *rpc.BlockNumber `json:"fromBlock"`
+	ToBlock   *rpc.BlockNumber `json:"toBlock"`
+}
+
+// internalTransferResult is an internal transfer along with its position in
+// the chain.
+type internalTransferResult struct {
+	BlockHash   common.Hash    `json:"blockHash"`
+	BlockNumber hexutil.Uint64 `json:"blockNumber"`
+	TxHash      common.Hash    `json:"transactionHash"`
+	Type        string         `json:"type"`
+	From        common.Address `json:"from"`
+	To          common.Address `json:"to"`
+	Value       *hexutil.Big   `json:"value"`
+}
+
+// PublicTransferAPI provides an API to look up the value transfers made by
+// contracts, as recorded by the internal transfer index.
+type PublicTransferAPI struct {
+	tst *UTChain
+}
+
+// NewPublicTransferAPI creates a new UTChain internal transfer lookup API.
+func NewPublicTransferAPI(tst *UTChain) *PublicTransferAPI {
+	return &PublicTransferAPI{tst: tst}
+}
+
+// GetInternalTransfers returns the value transfers made by contracts that the
+// given address sent or received within a range of blocks. The range must be
+// covered by the index, which lags behind the chain head by a few blocks.
+func (api *PublicTransferAPI) GetInternalTransfers(ctx context.Context, query internalTransferQuery) ([]*internalTransferResult, error) {
+	sections, head, _ := api.tst.transferIndexer.Sections()
+	if sections == 0 {
+		return nil, errors.New("internal transfer index not yet available")
+	}
+	resolve := func(number *rpc.BlockNumber) (uint64, error) {
+		switch {
+		case number == nil || *number == rpc.LatestBlockNumber:
+			return head, nil
+		case *number == rpc.PendingBlockNumber:
+			return 0, errors.New("pending block not indexed")
+		case uint64(*number) > head:
+			return 0, fmt.Errorf("block #%d not yet indexed, index head is #%d", *number, head)
+		}
+		return uint64(*number), nil
+	}
+	from, err := resolve(query.FromBlock)
+	if err != nil {
+		return nil, err
+	}
+	to, err := resolve(query.ToBlock)
+	if err != nil {
+		return nil, err
+	}
+	if from > to {
+		return nil, fmt.Errorf("invalid block range #%d - #%d", from, to)
+	}
+	results := []*internalTransferResult{}
+	err = core.IterateInternalTransfers(api.tst.ChainDb(), query.Address, from, to, func(number uint64, hash common.Hash, transfers []*types.InternalTransfer) {
+		for _, transfer := range transfers {
+			results = append(results, &internalTransferResult{
+				BlockHash:   hash,
+				BlockNumber: hexutil.Uint64(number),
+				TxHash:      transfer.TxHash,
+				Type:        transfer.Type,
+				From:        transfer.From,
+				To:          transfer.To,
+				Value:       (*hexutil.Big)(transfer.Value),
+			})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
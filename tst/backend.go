@@ -82,6 +82,8 @@ type UTChain struct {
 	bloomRequests chan chan *bloombits.Retrieval // Channel receiving bloom data retrieval requests
 	bloomIndexer  *core.ChainIndexer             // Bloom indexer operating during block imports
 
+	transferIndexer *core.ChainIndexer // Internal transfer indexer, nil if disabled
+
 	ApiBackend *TstApiBackend
 
 	miner     *miner.Miner
@@ -146,8 +148,11 @@ func New(ctx *node.ServiceContext, config *Config) (*UTChain, error) {
 	}
 	var (
 		vmConfig    = vm.Config{EnablePreimageRecording: config.EnablePreimageRecording}
-		cacheConfig = &core.CacheConfig{Disabled: config.NoPruning, TrieNodeLimit: config.TrieCache, TrieTimeLimit: config.TrieTimeout}
+		cacheConfig = &core.CacheConfig{Disabled: config.NoPruning, TrieNodeLimit: config.TrieCache, TrieTimeLimit: config.TrieTimeout, Snapshot: config.Snapshot}
 	)
+	if config.Ancient {
+		cacheConfig.AncientDepth = config.AncientDepth
+	}
 	tst.blockchain, err = core.NewBlockChain(chainDb, cacheConfig, tst.chainConfig, tst.engine, vmConfig)
 	if err != nil {
 		return nil, err
@@ -160,16 +165,29 @@ func New(ctx *node.ServiceContext, config *Config) (*UTChain, error) {
 	}
 	tst.bloomIndexer.Start(tst.blockchain)
 
+	if config.TransferIndex {
+		tst.transferIndexer = NewTransferIndexer(chainDb, tst.blockchain)
+		tst.transferIndexer.Start(tst.blockchain)
+	}
+
 	if config.TxPool.Journal != "" {
 		config.TxPool.Journal = ctx.ResolvePath(config.TxPool.Journal)
 	}
+	if config.TxPool.Snapshot != "" {
+		config.TxPool.Snapshot = ctx.ResolvePath(config.TxPool.Snapshot)
+	}
 	tst.txPool = core.NewTxPool(config.TxPool, tst.chainConfig, tst.blockchain)
 
 	if tst.protocolManager, err = NewProtocolManager(tst.chainConfig, config.SyncMode, config.NetworkId, tst.eventMux, tst.txPool, tst.engine, tst.blockchain, chainDb); err != nil {
 		return nil, err
 	}
+	ordering, err := miner.NewTxOrdering(config.MinerOrdering, config.MinerPrioritySenders)
+	if err != nil {
+		return nil, err
+	}
 	tst.miner = miner.New(tst, tst.chainConfig, tst.EventMux(), tst.engine)
 	tst.miner.SetExtra(makeExtraData(config.ExtraData))
+	tst.miner.SetOrdering(ordering)
 
 	tst.ApiBackend = &TstApiBackend{tst, nil}
 	gpoParams := config.GPO
@@ -200,7 +218,15 @@ func makeExtraData(extra []byte) []byte {
 
 // CreateDB creates the chain database.
 func CreateDB(ctx *node.ServiceContext, config *Config, name string) (tstdb.Database, error) {
-	db, err := ctx.OpenDatabase(name, config.DatabaseCache, config.DatabaseHandles)
+	var (
+		db  tstdb.Database
+		err error
+	)
+	if config.Ancient {
+		db, err = ctx.OpenDatabaseWithFreezer(name, config.DatabaseCache, config.DatabaseHandles, config.AncientDir)
+	} else {
+		db, err = ctx.OpenDatabase(name, config.DatabaseCache, config.DatabaseHandles)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -249,6 +275,15 @@ func (s *UTChain) APIs() []rpc.API {
 	// Append any APIs exposed explicitly by the consensus engine
 	apis = append(apis, s.engine.APIs(s.BlockChain())...)
 
+	// Append the internal transfer lookups if they are being indexed
+	if s.transferIndexer != nil {
+		apis = append(apis, rpc.API{
+			Namespace: "tst",
+			Version:   "1.0",
+			Service:   NewPublicTransferAPI(s),
+			Public:    true,
+		})
+	}
 	// Append all the local APIs and return
 	return append(apis, []rpc.API{
 		{
@@ -289,6 +324,10 @@ func (s *UTChain) APIs() []rpc.API {
 			Namespace: "debug",
 			Version:   "1.0",
 			Service:   NewPrivateDebugAPI(s.chainConfig, s),
+		}, {
+			Namespace: "trace",
+			Version:   "1.0",
+			Service:   NewPrivateTraceAPI(s.chainConfig, s),
 		}, {
 			Namespace: "net",
 			Version:   "1.0",
@@ -415,6 +454,9 @@ func (s *UTChain) Stop() error {
 		s.stopDbUpgrade()
 	}
 	s.bloomIndexer.Close()
+	if s.transferIndexer != nil {
+		s.transferIndexer.Close()
+	}
 	s.blockchain.Stop()
 	s.protocolManager.Stop()
 	if s.lesServer != nil {
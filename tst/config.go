@@ -48,6 +48,7 @@ var DefaultConfig = Config{
 	DatabaseCache: 768,
 	TrieCache:     256,
 	TrieTimeout:   5 * time.Minute,
+	AncientDepth:  90000,
 	GasPrice:      big.NewInt(18 * params.Shannon),
 
 	TxPool: core.DefaultTxPoolConfig,
@@ -82,6 +83,10 @@ type Config struct {
 	NetworkId uint64 // Network ID to use for selecting peers to connect to
 	SyncMode  downloader.SyncMode
 	NoPruning bool
+	Snapshot  bool // Maintain a flat state snapshot for faster state access
+
+	// Indexing options
+	TransferIndex bool // Index the value transfers made by contracts for lookups by address
 
 	// Light client options
 	LightServ  int `toml:",omitempty"` // Maximum percentage of time allowed for serving LES requests
@@ -94,12 +99,20 @@ type Config struct {
 	TrieCache          int
 	TrieTimeout        time.Duration
 
+	// Ancient store options
+	Ancient      bool   // Move finalized chain data into an append-only ancient store
+	AncientDir   string `toml:",omitempty"` // Directory of the ancient store (default = inside chaindata)
+	AncientDepth uint64 // Number of recent blocks to keep out of the ancient store
+
 	// Mining-related options
 	Tsterbase    common.Address `toml:",omitempty"`
 	MinerThreads int            `toml:",omitempty"`
 	ExtraData    []byte         `toml:",omitempty"`
 	GasPrice     *big.Int
 
+	MinerOrdering        string           `toml:",omitempty"` // Transaction ordering policy (price, fifo or priority)
+	MinerPrioritySenders []common.Address `toml:",omitempty"` // Senders preferred by the priority ordering
+
 	// Tstash options
 	Tstash ethash.Config
 
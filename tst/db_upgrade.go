@@ -50,7 +50,7 @@ func upgradeDeduplicateData(db tstdb.Database) func() error {
 
 	go func() {
 		// Create an iterator to read the entire database and covert old lookup entires
-		it := db.(*tstdb.LDBDatabase).NewIterator()
+		it := db.NewIterator(nil, nil)
 		defer func() {
 			if it != nil {
 				it.Release()
@@ -99,9 +99,9 @@ func upgradeDeduplicateData(db tstdb.Database) func() error {
 			// avoid too high memory consumption.
 			converted++
 			if converted%100000 == 0 {
+				key = common.CopyBytes(key)
 				it.Release()
-				it = db.(*tstdb.LDBDatabase).NewIterator()
-				it.Seek(key)
+				it = db.NewIterator(nil, key)
 
 				log.Info("Deduplicating database entries", "deduped", converted)
 			}
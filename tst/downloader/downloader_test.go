@@ -17,6 +17,7 @@
 package downloader
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"math/big"
@@ -28,11 +29,13 @@ import (
 	"github.com/utchain/go-utchain/common"
 	"github.com/utchain/go-utchain/consensus/ethash"
 	"github.com/utchain/go-utchain/core"
+	"github.com/utchain/go-utchain/core/state"
 	"github.com/utchain/go-utchain/core/types"
 	"github.com/utchain/go-utchain/crypto"
 	"github.com/utchain/go-utchain/tstdb"
 	"github.com/utchain/go-utchain/event"
 	"github.com/utchain/go-utchain/params"
+	"github.com/utchain/go-utchain/rlp"
 	"github.com/utchain/go-utchain/trie"
 )
 
@@ -1753,3 +1756,197 @@ func testDeliverHeadersHang(t *testing.T, protocol int, mode SyncMode) {
 		tester.downloader.peers.peers["peer"].peer.(*floodingTestPeer).pend.Wait()
 	}
 }
+
+// snapTesterPeer is a state range source serving from a trie database, capping
+// its responses to a small size to exercise range continuations.
+type snapTesterPeer struct {
+	id      string
+	triedb  *trie.Database
+	limit   int
+	deliver chan dataPack
+}
+
+// proveRange collects the trie nodes proving the given keys of a trie.
+func proveRange(tr *trie.Trie, keys ...[]byte) [][]byte {
+	db, _ := tstdb.NewMemDatabase()
+	for _, key := range keys {
+		tr.Prove(key, 0, db)
+	}
+	var proof [][]byte
+	for _, key := range db.Keys() {
+		node, _ := db.Get(key)
+		proof = append(proof, node)
+	}
+	return proof
+}
+
+func (p *snapTesterPeer) RequestAccountRange(id uint64, root common.Hash, origin, limit common.Hash, bytes uint64) error {
+	pack := &accountRangePack{peerId: p.id, id: id}
+	if tr, err := trie.New(root, p.triedb); err == nil {
+		size := 0
+		for it := trie.NewIterator(tr.NodeIterator(origin[:])); it.Next(); {
+			hash := common.BytesToHash(it.Key)
+			pack.hashes = append(pack.hashes, hash)
+			pack.accounts = append(pack.accounts, common.CopyBytes(it.Value))
+			if size += len(it.Value); hash.Big().Cmp(limit.Big()) >= 0 || size >= p.limit {
+				break
+			}
+		}
+		keys := [][]byte{origin[:]}
+		if len(pack.hashes) > 0 {
+			keys = append(keys, pack.hashes[len(pack.hashes)-1][:])
+		}
+		pack.proof = proveRange(tr, keys...)
+	}
+	go func() { p.deliver <- pack }()
+	return nil
+}
+
+func (p *snapTesterPeer) RequestStorageRanges(id uint64, root common.Hash, accounts []common.Hash, origin, limit []byte, bytes uint64) error {
+	pack := &storageRangesPack{peerId: p.id, id: id}
+	accTrie, _ := trie.New(root, p.triedb)
+
+	size := 0
+	for i, hash := range accounts {
+		if size >= p.limit {
+			break
+		}
+		var acc state.Account
+		rlp.DecodeBytes(accTrie.Get(hash[:]), &acc)
+		stTrie, _ := trie.New(acc.Root, p.triedb)
+
+		var start common.Hash
+		if i == 0 {
+			start = common.BytesToHash(origin)
+		}
+		var (
+			hashes    []common.Hash
+			slots     [][]byte
+			truncated bool
+		)
+		for it := trie.NewIterator(stTrie.NodeIterator(start[:])); it.Next(); {
+			if size >= p.limit {
+				truncated = true
+				break
+			}
+			hashes = append(hashes, common.BytesToHash(it.Key))
+			slots = append(slots, common.CopyBytes(it.Value))
+			size += len(it.Value)
+		}
+		pack.hashes = append(pack.hashes, hashes)
+		pack.slots = append(pack.slots, slots)
+		if truncated || start != (common.Hash{}) {
+			keys := [][]byte{start[:]}
+			if len(hashes) > 0 {
+				keys = append(keys, hashes[len(hashes)-1][:])
+			}
+			pack.proof = proveRange(stTrie, keys...)
+			break
+		}
+	}
+	go func() { p.deliver <- pack }()
+	return nil
+}
+
+func (p *snapTesterPeer) RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error {
+	pack := &byteCodesPack{peerId: p.id, id: id}
+	for _, hash := range hashes {
+		if code, err := p.triedb.Node(hash); err == nil {
+			pack.codes = append(pack.codes, code)
+		}
+	}
+	go func() { p.deliver <- pack }()
+	return nil
+}
+
+// Tests that the state is downloaded in ranges by snap sync, leaving only a few
+// trie nodes for the healing phase.
+func TestSnapSyncState(t *testing.T) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	// Create a source state with plain accounts, contracts and a few large storages
+	srcDb, _ := tstdb.NewMemDatabase()
+	srcState, _ := state.New(common.Hash{}, state.NewDatabase(srcDb))
+	for i := 0; i < 1000; i++ {
+		addr := common.BigToAddress(big.NewInt(int64(i)))
+		srcState.AddBalance(addr, big.NewInt(int64(i+1)))
+		if i%10 == 0 {
+			srcState.SetCode(addr, []byte{byte(i), byte(i >> 8), 0x01})
+		}
+		if i%50 == 0 {
+			slots := 2
+			if i%200 == 0 {
+				slots = 300
+			}
+			for j := 1; j <= slots; j++ {
+				srcState.SetState(addr, common.BigToHash(big.NewInt(int64(j))), common.BigToHash(big.NewInt(int64(i+j))))
+			}
+		}
+	}
+	root, _ := srcState.Commit(false)
+	srcTrieDb := srcState.Database().TrieDB()
+	if err := srcTrieDb.Commit(root, false); err != nil {
+		t.Fatalf("failed to commit source state: %v", err)
+	}
+	// Download the state ranges from a peer
+	deliver := make(chan dataPack)
+	peer := &snapTesterPeer{id: "peer", triedb: srcTrieDb, limit: 1024, deliver: deliver}
+	if err := tester.downloader.RegisterSnapPeer(peer.id, peer); err != nil {
+		t.Fatalf("failed to register snap peer: %v", err)
+	}
+	if err := tester.downloader.snapSyncer.sync(root, deliver, make(chan struct{})); err != nil {
+		t.Fatalf("failed to sync state ranges: %v", err)
+	}
+	// Heal the missing trie nodes and ensure only the top of the trie is needed
+	sched := state.NewStateSync(root, tester.stateDb)
+	healed := 0
+	for queue := sched.Missing(0); len(queue) > 0; queue = sched.Missing(0) {
+		results := make([]trie.SyncResult, len(queue))
+		for i, hash := range queue {
+			data, err := srcTrieDb.Node(hash)
+			if err != nil {
+				t.Fatalf("failed to retrieve node data for %x", hash)
+			}
+			results[i] = trie.SyncResult{Hash: hash, Data: data}
+		}
+		if _, index, err := sched.Process(results); err != nil {
+			t.Fatalf("failed to process result #%d: %v", index, err)
+		}
+		if index, err := sched.Commit(tester.stateDb); err != nil {
+			t.Fatalf("failed to commit data #%d: %v", index, err)
+		}
+		healed += len(queue)
+	}
+	if healed > snapAccountConcurrency {
+		t.Errorf("too many trie nodes healed: have %d, want at most %d", healed, snapAccountConcurrency)
+	}
+	// Cross check the synced state with the source
+	dstState, err := state.New(root, state.NewDatabase(tester.stateDb))
+	if err != nil {
+		t.Fatalf("failed to open synced state: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		addr := common.BigToAddress(big.NewInt(int64(i)))
+		if have, want := dstState.GetBalance(addr), srcState.GetBalance(addr); have.Cmp(want) != 0 {
+			t.Fatalf("account %d: balance mismatch: have %v, want %v", i, have, want)
+		}
+		if have, want := dstState.GetCode(addr), srcState.GetCode(addr); !bytes.Equal(have, want) {
+			t.Fatalf("account %d: code mismatch: have %x, want %x", i, have, want)
+		}
+		for j := 1; j <= 300; j++ {
+			key := common.BigToHash(big.NewInt(int64(j)))
+			if have, want := dstState.GetState(addr, key), srcState.GetState(addr, key); have != want {
+				t.Fatalf("account %d, slot %d: value mismatch: have %x, want %x", i, j, have, want)
+			}
+		}
+	}
+	it := state.NewNodeIterator(dstState)
+	for it.Next() {
+	}
+	if it.Error != nil {
+		t.Fatalf("synced state incomplete: %v", it.Error)
+	}
+}
@@ -40,4 +40,7 @@ var (
 
 	stateInMeter   = metrics.NewRegisteredMeter("tst/downloader/states/in", nil)
 	stateDropMeter = metrics.NewRegisteredMeter("tst/downloader/states/drop", nil)
+
+	snapInMeter   = metrics.NewRegisteredMeter("tst/downloader/snap/in", nil)
+	snapDropMeter = metrics.NewRegisteredMeter("tst/downloader/snap/drop", nil)
 )
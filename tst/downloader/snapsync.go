@@ -0,0 +1,690 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/state"
+	"github.com/utchain/go-utchain/crypto"
+	"github.com/utchain/go-utchain/log"
+	"github.com/utchain/go-utchain/rlp"
+	"github.com/utchain/go-utchain/trie"
+	"github.com/utchain/go-utchain/tstdb"
+)
+
+var (
+	snapAccountConcurrency = 16         // Number of account ranges to download in parallel
+	snapStorageBatch       = 64         // Maximum number of accounts to request storage ranges for at once
+	snapCodeBatch          = 64         // Maximum number of contract codes to request at once
+	snapResponseBytes      = 512 * 1024 // Soft limit on the size of the requested responses
+	snapMaxPending         = 1024       // Maximum accounts per range waiting for storage or code
+	snapCommitThreshold    = 16 * 1024  // Number of accounts after which a partial range trie is flushed
+	snapLogFrequency       = 8 * time.Second
+)
+
+var (
+	// emptyRoot is the known root hash of an empty trie.
+	emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+	// emptyCode is the known hash of the empty EVM bytecode.
+	emptyCode = crypto.Keccak256Hash(nil)
+
+	// errNoSnapPeers is returned if no peer is able to serve state ranges, in
+	// which case the state is synced trie node by trie node instead.
+	errNoSnapPeers = errors.New("no snap peers available")
+)
+
+// SnapPeer encapsulates the methods required to synchronise the state from a
+// remote peer in contiguous account and storage ranges.
+type SnapPeer interface {
+	RequestAccountRange(id uint64, root common.Hash, origin, limit common.Hash, bytes uint64) error
+	RequestStorageRanges(id uint64, root common.Hash, accounts []common.Hash, origin, limit []byte, bytes uint64) error
+	RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error
+}
+
+// accountTask is a contiguous range of the account trie being downloaded. The
+// fetched accounts are collected into a partial trie, which only ever contains
+// accounts whose storage and code are already fully present in the database.
+// This way any trie node persisted from it (be it a valid one or not) has its
+// entire subtree available locally, which the trie node healing phase relies on.
+type accountTask struct {
+	next common.Hash // Next account hash to request from the range
+	last common.Hash // Last account hash covered by the range
+
+	req      bool           // Flag whether an account request is in flight
+	done     bool           // Flag whether all the accounts in the range were fetched
+	pending  []*snapAccount // Fetched accounts waiting for their storage or code
+	trie     *trie.Trie     // Partial account trie of the range
+	inserted int            // Number of accounts inserted since the last flush
+}
+
+// snapAccount is an account fetched from a range that is waiting for its storage
+// and code to be downloaded before being inserted into the range trie.
+type snapAccount struct {
+	task    *accountTask
+	hash    common.Hash
+	blob    []byte
+	account state.Account
+
+	needCode    bool       // Flag whether the contract code is still missing
+	needStorage bool       // Flag whether the storage trie is still missing
+	storageReq  bool       // Flag whether a storage request is in flight
+	storage     *trie.Trie // Partial storage trie of a large account
+	storageNext []byte     // Next slot hash to request for a large account
+}
+
+// snapRequest is a single in-flight request of the state range sync.
+type snapRequest struct {
+	id    uint64
+	peer  string
+	timer *time.Timer
+
+	task     *accountTask   // Account range being requested
+	accounts []*snapAccount // Accounts whose storage is being requested
+	origin   []byte         // Storage origin of the first requested account
+	codes    []common.Hash  // Contract codes being requested
+}
+
+// snapSyncer downloads the state of a given root in contiguous account and
+// storage ranges, verifying each of them with a range proof against the root.
+// Its progress is retained across pivot moves, the healing phase fixing up the
+// ranges downloaded from older state roots.
+type snapSyncer struct {
+	d      *Downloader
+	triedb *trie.Database // Trie database the partial tries are built in
+
+	root    common.Hash    // State root currently being synced
+	started bool           // Flag whether the account ranges were initialised
+	tasks   []*accountTask // Account ranges remaining to be downloaded
+
+	reqID     uint64                  // Last request id handed out
+	requests  map[uint64]*snapRequest // Currently in-flight requests
+	busy      map[string]struct{}     // Peers with an in-flight request
+	stateless map[string]struct{}     // Peers not having the current state root
+
+	codeQueue   map[common.Hash]struct{}       // Contract codes needed but not yet requested
+	codeWaiters map[common.Hash][]*snapAccount // Accounts waiting for a contract code
+
+	accounts, slots, codes uint64    // Number of items downloaded
+	start, logged          time.Time // Timestamps for progress reporting
+}
+
+// newSnapSyncer creates a state range syncer writing into the downloader's
+// state database.
+func newSnapSyncer(d *Downloader) *snapSyncer {
+	return &snapSyncer{
+		d:           d,
+		triedb:      trie.NewDatabase(d.stateDB),
+		requests:    make(map[uint64]*snapRequest),
+		busy:        make(map[string]struct{}),
+		stateless:   make(map[string]struct{}),
+		codeQueue:   make(map[common.Hash]struct{}),
+		codeWaiters: make(map[common.Hash][]*snapAccount),
+	}
+}
+
+// reset prepares the syncer to download the given state root. Accounts fetched
+// from a previous root but not yet inserted are discarded and their ranges are
+// rewound, as their storage might not be available for the new root.
+func (s *snapSyncer) reset(root common.Hash) {
+	if !s.started {
+		step := new(big.Int).Div(new(big.Int).Lsh(common.Big1, 256), big.NewInt(int64(snapAccountConcurrency)))
+		for i := 0; i < snapAccountConcurrency; i++ {
+			last := common.BigToHash(new(big.Int).Sub(new(big.Int).Mul(step, big.NewInt(int64(i+1))), common.Big1))
+			if i == snapAccountConcurrency-1 {
+				last = common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
+			}
+			tr, _ := trie.New(common.Hash{}, s.triedb)
+			s.tasks = append(s.tasks, &accountTask{
+				next: common.BigToHash(new(big.Int).Mul(step, big.NewInt(int64(i)))),
+				last: last,
+				trie: tr,
+			})
+		}
+		s.started, s.start, s.logged = true, time.Now(), time.Now()
+	}
+	if s.root == root {
+		return
+	}
+	for _, task := range s.tasks {
+		for _, acc := range task.pending {
+			if bytes.Compare(acc.hash[:], task.next[:]) < 0 {
+				task.next = acc.hash
+			}
+		}
+		task.pending, task.done = nil, false
+	}
+	s.root = root
+	s.stateless = make(map[string]struct{})
+	s.codeQueue = make(map[common.Hash]struct{})
+	s.codeWaiters = make(map[common.Hash][]*snapAccount)
+}
+
+// sync downloads the account and storage ranges of the given state root, until
+// all of them are present or the sync is canceled.
+func (s *snapSyncer) sync(root common.Hash, deliver chan dataPack, cancel chan struct{}) error {
+	s.reset(root)
+
+	timeout := make(chan *snapRequest)
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		for _, req := range s.requests {
+			req.timer.Stop()
+			s.revert(req)
+		}
+		s.requests = make(map[uint64]*snapRequest)
+		s.busy = make(map[string]struct{})
+	}()
+	// Wake up periodically to assign tasks to newly connected peers
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for len(s.tasks) > 0 {
+		if len(s.requests) == 0 && !s.servable() {
+			return errNoSnapPeers
+		}
+		s.assignTasks(timeout, done)
+
+		select {
+		case <-ticker.C:
+
+		case <-cancel:
+			return errCancelStateFetch
+
+		case <-s.d.cancelCh:
+			return errCancelStateFetch
+
+		case req := <-timeout:
+			// Ignore the timeout if the response arrived simultaneously
+			if s.requests[req.id] != req {
+				continue
+			}
+			log.Debug("State range request timed out", "peer", req.peer, "id", req.id)
+			delete(s.requests, req.id)
+			delete(s.busy, req.peer)
+			s.revert(req)
+
+		case pack := <-deliver:
+			if err := s.process(pack); err != nil {
+				log.Warn("Invalid state range delivered", "peer", pack.PeerId(), "err", err)
+				s.d.dropPeer(pack.PeerId())
+			}
+		}
+		if err := s.flush(); err != nil {
+			return err
+		}
+		if time.Since(s.logged) > snapLogFrequency {
+			log.Info("Syncing state ranges", "accounts", s.accounts, "slots", s.slots, "codes", s.codes, "ranges", len(s.tasks), "elapsed", common.PrettyDuration(time.Since(s.start)))
+			s.logged = time.Now()
+		}
+	}
+	log.Info("Synced state ranges", "accounts", s.accounts, "slots", s.slots, "codes", s.codes, "elapsed", common.PrettyDuration(time.Since(s.start)))
+	return nil
+}
+
+// servable returns whether there is any connected peer that might be able to
+// serve the current state root.
+func (s *snapSyncer) servable() bool {
+	s.d.snapPeersLock.RLock()
+	defer s.d.snapPeersLock.RUnlock()
+
+	for id := range s.d.snapPeers {
+		if _, ok := s.stateless[id]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// assignTasks sends a new request to every idle peer, prioritising the code and
+// storage retrievals unblocking already fetched accounts.
+func (s *snapSyncer) assignTasks(timeout chan *snapRequest, done chan struct{}) {
+	s.d.snapPeersLock.RLock()
+	peers := make(map[string]SnapPeer)
+	for id, peer := range s.d.snapPeers {
+		if _, ok := s.busy[id]; ok {
+			continue
+		}
+		if _, ok := s.stateless[id]; ok {
+			continue
+		}
+		peers[id] = peer
+	}
+	s.d.snapPeersLock.RUnlock()
+
+	for id, peer := range peers {
+		req := s.nextRequest()
+		if req == nil {
+			return
+		}
+		s.reqID++
+		req.id, req.peer = s.reqID, id
+
+		var err error
+		switch {
+		case req.task != nil:
+			err = peer.RequestAccountRange(req.id, s.root, req.task.next, req.task.last, uint64(snapResponseBytes))
+		case req.accounts != nil:
+			hashes := make([]common.Hash, len(req.accounts))
+			for i, acc := range req.accounts {
+				hashes[i] = acc.hash
+			}
+			err = peer.RequestStorageRanges(req.id, s.root, hashes, req.origin, nil, uint64(snapResponseBytes))
+		default:
+			err = peer.RequestByteCodes(req.id, req.codes, uint64(snapResponseBytes))
+		}
+		if err != nil {
+			log.Debug("Failed to request state ranges", "peer", id, "err", err)
+			s.revert(req)
+			continue
+		}
+		req.timer = time.AfterFunc(s.d.requestTTL(), func() {
+			select {
+			case timeout <- req:
+			case <-done:
+			}
+		})
+		s.requests[req.id] = req
+		s.busy[id] = struct{}{}
+	}
+}
+
+// nextRequest assembles the next request to send out, marking its items as in
+// flight. Nil is returned if there is nothing left to request.
+func (s *snapSyncer) nextRequest() *snapRequest {
+	// Contract codes are the cheapest to retrieve, request those first
+	if len(s.codeQueue) > 0 {
+		req := new(snapRequest)
+		for hash := range s.codeQueue {
+			req.codes = append(req.codes, hash)
+			delete(s.codeQueue, hash)
+			if len(req.codes) == snapCodeBatch {
+				break
+			}
+		}
+		return req
+	}
+	// Gather accounts needing storage, large ones are continued one by one
+	req := new(snapRequest)
+gather:
+	for _, task := range s.tasks {
+		for _, acc := range task.pending {
+			if !acc.needStorage || acc.storageReq {
+				continue
+			}
+			if acc.storage != nil {
+				if len(req.accounts) > 0 {
+					continue
+				}
+				req.accounts, req.origin = []*snapAccount{acc}, acc.storageNext
+				break gather
+			}
+			req.accounts = append(req.accounts, acc)
+			if len(req.accounts) == snapStorageBatch {
+				break gather
+			}
+		}
+	}
+	if len(req.accounts) > 0 {
+		for _, acc := range req.accounts {
+			acc.storageReq = true
+		}
+		return req
+	}
+	// Nothing blocks the fetched accounts, retrieve the next account range
+	for _, task := range s.tasks {
+		if task.req || task.done || len(task.pending) >= snapMaxPending {
+			continue
+		}
+		task.req = true
+		return &snapRequest{task: task}
+	}
+	return nil
+}
+
+// revert returns the items of a failed request into the download queues.
+func (s *snapSyncer) revert(req *snapRequest) {
+	if req.task != nil {
+		req.task.req = false
+	}
+	for _, acc := range req.accounts {
+		acc.storageReq = false
+	}
+	for _, hash := range req.codes {
+		if len(s.codeWaiters[hash]) > 0 {
+			s.codeQueue[hash] = struct{}{}
+		}
+	}
+}
+
+// process handles a state range response, returning an error if the peer sent
+// invalid data.
+func (s *snapSyncer) process(pack dataPack) error {
+	var id uint64
+	switch pack := pack.(type) {
+	case *accountRangePack:
+		id = pack.id
+	case *storageRangesPack:
+		id = pack.id
+	case *byteCodesPack:
+		id = pack.id
+	}
+	req := s.requests[id]
+	if req == nil || req.peer != pack.PeerId() {
+		log.Debug("Unrequested state range", "peer", pack.PeerId(), "id", id)
+		return nil
+	}
+	req.timer.Stop()
+	delete(s.requests, id)
+	delete(s.busy, req.peer)
+
+	var err error
+	switch pack := pack.(type) {
+	case *accountRangePack:
+		if req.task == nil {
+			err = errors.New("account range for non-account request")
+			break
+		}
+		err = s.processAccounts(req, pack)
+	case *storageRangesPack:
+		if req.accounts == nil {
+			err = errors.New("storage ranges for non-storage request")
+			break
+		}
+		err = s.processStorage(req, pack)
+	case *byteCodesPack:
+		if req.codes == nil {
+			err = errors.New("contract codes for non-code request")
+			break
+		}
+		err = s.processCodes(req, pack)
+	}
+	if err != nil {
+		s.revert(req)
+	}
+	return err
+}
+
+// processAccounts verifies and stores a delivered range of accounts.
+func (s *snapSyncer) processAccounts(req *snapRequest, pack *accountRangePack) error {
+	task := req.task
+	task.req = false
+
+	// An empty response without proofs means the peer doesn't have the state
+	if len(pack.hashes) == 0 && len(pack.proof) == 0 {
+		s.stateless[req.peer] = struct{}{}
+		return nil
+	}
+	keys := make([][]byte, len(pack.hashes))
+	for i, hash := range pack.hashes {
+		keys[i] = common.CopyBytes(hash[:])
+	}
+	more, err := trie.VerifyRangeProof(s.root, task.next[:], keys, pack.accounts, newProofDB(pack.proof))
+	if err != nil {
+		return err
+	}
+	// Drop anything beyond the range, it belongs to a different task
+	hashes, blobs := pack.hashes, pack.accounts
+	for i, hash := range hashes {
+		if bytes.Compare(hash[:], task.last[:]) > 0 {
+			hashes, blobs, more = hashes[:i], blobs[:i], false
+			break
+		}
+	}
+	for i, hash := range hashes {
+		acc := &snapAccount{task: task, hash: hash, blob: blobs[i]}
+		if err := rlp.DecodeBytes(blobs[i], &acc.account); err != nil {
+			return fmt.Errorf("invalid account %x: %v", hash, err)
+		}
+		if acc.account.Root != emptyRoot {
+			if ok, _ := s.d.stateDB.Has(acc.account.Root[:]); !ok {
+				acc.needStorage = true
+			}
+		}
+		if codeHash := common.BytesToHash(acc.account.CodeHash); codeHash != emptyCode {
+			if ok, _ := s.d.stateDB.Has(codeHash[:]); !ok {
+				acc.needCode = true
+				if len(s.codeWaiters[codeHash]) == 0 {
+					s.codeQueue[codeHash] = struct{}{}
+				}
+				s.codeWaiters[codeHash] = append(s.codeWaiters[codeHash], acc)
+			}
+		}
+		task.pending = append(task.pending, acc)
+		if err := s.tryInsert(acc); err != nil {
+			return err
+		}
+	}
+	s.accounts += uint64(len(hashes))
+
+	if !more || (len(hashes) > 0 && hashes[len(hashes)-1] == task.last) {
+		task.done = true
+	} else {
+		task.next = incHash(hashes[len(hashes)-1])
+	}
+	return nil
+}
+
+// processStorage verifies and stores a delivered batch of storage ranges. The
+// response may cover only a prefix of the requested accounts, with the storage
+// of the last one possibly truncated and proven by the attached range proof.
+func (s *snapSyncer) processStorage(req *snapRequest, pack *storageRangesPack) error {
+	for _, acc := range req.accounts {
+		acc.storageReq = false
+	}
+	if len(pack.hashes) != len(pack.slots) {
+		return fmt.Errorf("inconsistent storage ranges, hashes: %d, slots: %d", len(pack.hashes), len(pack.slots))
+	}
+	if len(pack.hashes) > len(req.accounts) {
+		return fmt.Errorf("too many storage ranges, have %d, want at most %d", len(pack.hashes), len(req.accounts))
+	}
+	// An empty response without proofs means the peer doesn't have the state
+	if len(pack.hashes) == 0 && len(pack.proof) == 0 {
+		s.stateless[req.peer] = struct{}{}
+		return nil
+	}
+	for i, hashes := range pack.hashes {
+		acc := req.accounts[i]
+
+		keys := make([][]byte, len(hashes))
+		for j, hash := range hashes {
+			keys[j] = common.CopyBytes(hash[:])
+		}
+		var origin []byte
+		if i == 0 {
+			origin = req.origin
+		}
+		if i == len(pack.hashes)-1 && len(pack.proof) > 0 {
+			// Truncated or continued storage range, verify it with the proof
+			first := common.BytesToHash(origin)
+			more, err := trie.VerifyRangeProof(acc.account.Root, first[:], keys, pack.slots[i], newProofDB(pack.proof))
+			if err != nil {
+				return fmt.Errorf("invalid storage range of %x: %v", acc.hash, err)
+			}
+			if acc.storage == nil {
+				acc.storage, _ = trie.New(common.Hash{}, s.triedb)
+			}
+			for j, key := range keys {
+				acc.storage.Update(key, pack.slots[i][j])
+			}
+			s.slots += uint64(len(keys))
+
+			if more {
+				// Flush the partial trie to keep the memory use bounded
+				root, err := acc.storage.Commit(nil)
+				if err != nil {
+					return err
+				}
+				if err := s.triedb.Commit(root, false); err != nil {
+					return err
+				}
+				acc.storage, _ = trie.New(root, s.triedb)
+				acc.storageNext = incHash(hashes[len(hashes)-1]).Bytes()
+				continue
+			}
+			if root := acc.storage.Hash(); root != acc.account.Root {
+				return fmt.Errorf("storage root mismatch of %x: have %x, want %x", acc.hash, root, acc.account.Root)
+			}
+		} else {
+			// Complete storage range, rebuild the trie and check the root
+			if len(origin) > 0 {
+				return fmt.Errorf("continued storage range of %x without proof", acc.hash)
+			}
+			acc.storage, _ = trie.New(common.Hash{}, s.triedb)
+			for j, key := range keys {
+				acc.storage.Update(key, pack.slots[i][j])
+			}
+			if root := acc.storage.Hash(); root != acc.account.Root {
+				acc.storage = nil
+				return fmt.Errorf("storage root mismatch of %x: have %x, want %x", acc.hash, root, acc.account.Root)
+			}
+			s.slots += uint64(len(keys))
+		}
+		// Storage trie complete, persist it and unblock the account
+		if _, err := acc.storage.Commit(nil); err != nil {
+			return err
+		}
+		if err := s.triedb.Commit(acc.account.Root, false); err != nil {
+			return err
+		}
+		acc.storage, acc.storageNext, acc.needStorage = nil, nil, false
+		if err := s.tryInsert(acc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processCodes stores a delivered batch of contract codes, requeueing any that
+// were requested but not delivered.
+func (s *snapSyncer) processCodes(req *snapRequest, pack *byteCodesPack) error {
+	// An empty response means the peer doesn't have the codes
+	if len(pack.codes) == 0 {
+		s.stateless[req.peer] = struct{}{}
+		s.revert(req)
+		return nil
+	}
+	requested := make(map[common.Hash]struct{}, len(req.codes))
+	for _, hash := range req.codes {
+		requested[hash] = struct{}{}
+	}
+	batch := s.d.stateDB.NewBatch()
+	var delivered []common.Hash
+	for _, code := range pack.codes {
+		hash := crypto.Keccak256Hash(code)
+		if _, ok := requested[hash]; !ok {
+			return fmt.Errorf("unrequested contract code %x", hash)
+		}
+		delete(requested, hash)
+		batch.Put(hash[:], code)
+		delivered = append(delivered, hash)
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	s.codes += uint64(len(delivered))
+
+	for _, hash := range delivered {
+		for _, acc := range s.codeWaiters[hash] {
+			acc.needCode = false
+			if err := s.tryInsert(acc); err != nil {
+				return err
+			}
+		}
+		delete(s.codeWaiters, hash)
+	}
+	for hash := range requested {
+		if len(s.codeWaiters[hash]) > 0 {
+			s.codeQueue[hash] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// tryInsert inserts a fetched account into its range trie if its storage and
+// code are both present.
+func (s *snapSyncer) tryInsert(acc *snapAccount) error {
+	if acc.needCode || acc.needStorage {
+		return nil
+	}
+	task := acc.task
+	for i, pending := range task.pending {
+		if pending == acc {
+			task.pending = append(task.pending[:i], task.pending[i+1:]...)
+			break
+		}
+	}
+	task.inserted++
+	return task.trie.TryUpdate(acc.hash[:], acc.blob)
+}
+
+// flush persists the range tries that grew large enough, and drops the fully
+// downloaded ranges.
+func (s *snapSyncer) flush() error {
+	remaining := s.tasks[:0]
+	for _, task := range s.tasks {
+		complete := task.done && len(task.pending) == 0
+		if complete || task.inserted >= snapCommitThreshold {
+			root, err := task.trie.Commit(nil)
+			if err != nil {
+				return err
+			}
+			if err := s.triedb.Commit(root, false); err != nil {
+				return err
+			}
+			// Reopen the trie from disk to release the committed nodes
+			task.trie, _ = trie.New(root, s.triedb)
+			task.inserted = 0
+		}
+		if !complete {
+			remaining = append(remaining, task)
+		}
+	}
+	s.tasks = remaining
+	return nil
+}
+
+// newProofDB creates a database from a list of trie nodes, keyed by their hash,
+// for range proof verification. If the list is empty, nil is returned.
+func newProofDB(proof [][]byte) trie.DatabaseReader {
+	if len(proof) == 0 {
+		return nil
+	}
+	db, _ := tstdb.NewMemDatabase()
+	for _, node := range proof {
+		db.Put(crypto.Keccak256(node), node)
+	}
+	return db
+}
+
+// incHash returns the hash following the given one.
+func incHash(h common.Hash) common.Hash {
+	for i := len(h) - 1; i >= 0; i-- {
+		h[i]++
+		if h[i] != 0 {
+			break
+		}
+	}
+	return h
+}
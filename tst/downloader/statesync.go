@@ -80,6 +80,8 @@ func (d *Downloader) stateFetcher() {
 			}
 		case <-d.stateCh:
 			// Ignore state responses while no sync is running.
+		case <-d.snapCh:
+			// Ignore state range responses while no sync is running.
 		case <-d.quitCh:
 			return
 		}
@@ -93,6 +95,7 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 		active   = make(map[string]*stateReq) // Currently in-flight requests
 		finished []*stateReq                  // Completed or failed requests
 		timeout  = make(chan *stateReq)       // Timed out active requests
+		ranges   []dataPack                   // State range responses waiting for processing
 	)
 	defer func() {
 		// Cancel active request timers on exit. Also set peers to idle so they're
@@ -121,6 +124,14 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 			deliverReq = finished[0]
 			deliverReqCh = s.deliver
 		}
+		var (
+			deliverRange   dataPack
+			deliverRangeCh chan dataPack
+		)
+		if len(ranges) > 0 {
+			deliverRange = ranges[0]
+			deliverRangeCh = s.deliverRange
+		}
 
 		select {
 		// The stateSync lifecycle:
@@ -137,6 +148,16 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 			finished[len(finished)-1] = nil
 			finished = finished[:len(finished)-1]
 
+		// Send the next state range response to the current sync:
+		case deliverRangeCh <- deliverRange:
+			copy(ranges, ranges[1:])
+			ranges[len(ranges)-1] = nil
+			ranges = ranges[:len(ranges)-1]
+
+		// Handle incoming state range packs:
+		case pack := <-d.snapCh:
+			ranges = append(ranges, pack)
+
 		// Handle incoming state packs:
 		case pack := <-d.stateCh:
 			// Discard any data not requested (or previsouly timed out)
@@ -212,7 +233,8 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 // stateSync schedules requests for downloading a particular state trie defined
 // by a given state root.
 type stateSync struct {
-	d *Downloader // Downloader instance to access and manage current peerset
+	d    *Downloader // Downloader instance to access and manage current peerset
+	root common.Hash // State root being synced
 
 	sched  *trie.TrieSync             // State trie sync scheduler defining the tasks
 	keccak hash.Hash                  // Keccak256 hasher to verify deliveries with
@@ -221,11 +243,12 @@ type stateSync struct {
 	numUncommitted   int
 	bytesUncommitted int
 
-	deliver    chan *stateReq // Delivery channel multiplexing peer responses
-	cancel     chan struct{}  // Channel to signal a termination request
-	cancelOnce sync.Once      // Ensures cancel only ever gets called once
-	done       chan struct{}  // Channel to signal termination completion
-	err        error          // Any error hit during sync (set before completion)
+	deliver      chan *stateReq // Delivery channel multiplexing peer responses
+	deliverRange chan dataPack  // Delivery channel multiplexing state range responses
+	cancel       chan struct{}  // Channel to signal a termination request
+	cancelOnce   sync.Once      // Ensures cancel only ever gets called once
+	done         chan struct{}  // Channel to signal termination completion
+	err          error          // Any error hit during sync (set before completion)
 }
 
 // stateTask represents a single trie node download taks, containing a set of
@@ -238,20 +261,37 @@ type stateTask struct {
 // yet start the sync. The user needs to call run to initiate.
 func newStateSync(d *Downloader, root common.Hash) *stateSync {
 	return &stateSync{
-		d:       d,
-		sched:   state.NewStateSync(root, d.stateDB),
-		keccak:  sha3.NewKeccak256(),
-		tasks:   make(map[common.Hash]*stateTask),
-		deliver: make(chan *stateReq),
-		cancel:  make(chan struct{}),
-		done:    make(chan struct{}),
+		d:            d,
+		root:         root,
+		sched:        state.NewStateSync(root, d.stateDB),
+		keccak:       sha3.NewKeccak256(),
+		tasks:        make(map[common.Hash]*stateTask),
+		deliver:      make(chan *stateReq),
+		deliverRange: make(chan dataPack),
+		cancel:       make(chan struct{}),
+		done:         make(chan struct{}),
 	}
 }
 
 // run starts the task assignment and response processing loop, blocking until
 // it finishes, and finally notifying any goroutines waiting for the loop to
 // finish.
+//
+// In snap sync mode the state is first downloaded in account and storage ranges,
+// after which the trie node sync only heals the parts that changed since.
 func (s *stateSync) run() {
+	if s.d.mode == SnapSync {
+		err := s.d.snapSyncer.sync(s.root, s.deliverRange, s.cancel)
+		if err == errNoSnapPeers {
+			log.Warn("No peers serving state ranges, syncing trie nodes")
+			err = nil
+		}
+		if err != nil {
+			s.err = err
+			close(s.done)
+			return
+		}
+	}
 	s.err = s.loop()
 	close(s.done)
 }
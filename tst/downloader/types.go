@@ -19,6 +19,7 @@ package downloader
 import (
 	"fmt"
 
+	"github.com/utchain/go-utchain/common"
 	"github.com/utchain/go-utchain/core/types"
 )
 
@@ -77,3 +78,40 @@ type statePack struct {
 func (p *statePack) PeerId() string { return p.peerId }
 func (p *statePack) Items() int     { return len(p.states) }
 func (p *statePack) Stats() string  { return fmt.Sprintf("%d", len(p.states)) }
+
+// accountRangePack is a range of accounts returned by a snap peer.
+type accountRangePack struct {
+	peerId   string
+	id       uint64
+	hashes   []common.Hash
+	accounts [][]byte
+	proof    [][]byte
+}
+
+func (p *accountRangePack) PeerId() string { return p.peerId }
+func (p *accountRangePack) Items() int     { return len(p.accounts) }
+func (p *accountRangePack) Stats() string  { return fmt.Sprintf("%d", len(p.accounts)) }
+
+// storageRangesPack is a batch of storage ranges returned by a snap peer.
+type storageRangesPack struct {
+	peerId string
+	id     uint64
+	hashes [][]common.Hash
+	slots  [][][]byte
+	proof  [][]byte
+}
+
+func (p *storageRangesPack) PeerId() string { return p.peerId }
+func (p *storageRangesPack) Items() int     { return len(p.slots) }
+func (p *storageRangesPack) Stats() string  { return fmt.Sprintf("%d", len(p.slots)) }
+
+// byteCodesPack is a batch of contract codes returned by a snap peer.
+type byteCodesPack struct {
+	peerId string
+	id     uint64
+	codes  [][]byte
+}
+
+func (p *byteCodesPack) PeerId() string { return p.peerId }
+func (p *byteCodesPack) Items() int     { return len(p.codes) }
+func (p *byteCodesPack) Stats() string  { return fmt.Sprintf("%d", len(p.codes)) }
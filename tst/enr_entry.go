@@ -0,0 +1,58 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package tst
+
+import (
+	"github.com/utchain/go-utchain/core/forkid"
+	"github.com/utchain/go-utchain/p2p/enr"
+	"github.com/utchain/go-utchain/rlp"
+)
+
+// enrEntry is the ENR entry which advertises the tst protocol in node records.
+type enrEntry struct {
+	ForkID forkid.ID // Fork identifier of the advertised chain
+
+	// Ignore additional fields (for forward compatibility).
+	Rest []rlp.RawValue `rlp:"tail"`
+}
+
+// ENRKey implements enr.Entry.
+func (e enrEntry) ENRKey() string {
+	return "tst"
+}
+
+// currentENREntry constructs a tst ENR entry based on the current state of the
+// local chain.
+func (pm *ProtocolManager) currentENREntry() *enrEntry {
+	return &enrEntry{
+		ForkID: forkid.NewID(pm.blockchain),
+	}
+}
+
+// NewNodeFilter returns a filter accepting the node records which advertise the
+// tst protocol on a chain compatible with the local one. The p2p server uses it
+// to skip incompatible discovered nodes before even dialing them.
+func NewNodeFilter(chain forkid.Blockchain) func(*enr.Record) bool {
+	filter := forkid.NewFilter(chain)
+	return func(r *enr.Record) bool {
+		var entry enrEntry
+		if err := r.Load(&entry); err != nil {
+			return false
+		}
+		return filter(entry.ForkID) == nil
+	}
+}
@@ -40,4 +40,12 @@ var (
 	headerFilterOutMeter = metrics.NewRegisteredMeter("tst/fetcher/filter/headers/out", nil)
 	bodyFilterInMeter    = metrics.NewRegisteredMeter("tst/fetcher/filter/bodies/in", nil)
 	bodyFilterOutMeter   = metrics.NewRegisteredMeter("tst/fetcher/filter/bodies/out", nil)
+
+	txAnnounceInMeter     = metrics.NewRegisteredMeter("tst/fetcher/tx/announces/in", nil)
+	txAnnounceKnownMeter  = metrics.NewRegisteredMeter("tst/fetcher/tx/announces/known", nil)
+	txAnnounceDOSMeter    = metrics.NewRegisteredMeter("tst/fetcher/tx/announces/dos", nil)
+	txBroadcastInMeter    = metrics.NewRegisteredMeter("tst/fetcher/tx/broadcasts/in", nil)
+	txRequestOutMeter     = metrics.NewRegisteredMeter("tst/fetcher/tx/requests/out", nil)
+	txRequestTimeoutMeter = metrics.NewRegisteredMeter("tst/fetcher/tx/requests/timeout", nil)
+	txReplyInMeter        = metrics.NewRegisteredMeter("tst/fetcher/tx/replies/in", nil)
 )
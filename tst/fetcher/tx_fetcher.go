@@ -0,0 +1,434 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package fetcher
+
+import (
+	"time"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/log"
+)
+
+const (
+	txArriveTimeout = 500 * time.Millisecond // Time allowance before an announced transaction is explicitly requested
+	txGatherSlack   = 100 * time.Millisecond // Interval used to collate almost-expired announces with fetches
+	txFetchTimeout  = 5 * time.Second        // Maximum allotted time to return an explicitly requested transaction
+	maxTxAnnounces  = 4096                   // Maximum number of unique transactions a peer may have announced
+	maxTxRetrievals = 256                    // Maximum number of transactions to request from a peer in one go
+)
+
+// txRetrievalFn is a callback type for checking whether a transaction is already
+// known locally.
+type txRetrievalFn func(common.Hash) bool
+
+// txAdderFn is a callback type for injecting a batch of transactions into the
+// local transaction pool.
+type txAdderFn func([]*types.Transaction) []error
+
+// txRequesterFn is a callback type for sending a transaction retrieval request.
+type txRequesterFn func(string, []common.Hash) error
+
+// txAnnounce is the hash notification of the availability of a batch of new
+// transactions in the network.
+type txAnnounce struct {
+	origin string        // Identifier of the peer originating the notification
+	hashes []common.Hash // Batch of transaction hashes being announced
+}
+
+// txDelivery is the notification that a batch of transactions have been added
+// to the pool and should be untracked.
+type txDelivery struct {
+	origin string        // Identifier of the peer originating the notification
+	hashes []common.Hash // Batch of transaction hashes having been delivered
+	direct bool          // Whether this is a direct broadcast or a requested reply
+}
+
+// txRequest represents an in-flight transaction retrieval request to a peer.
+type txRequest struct {
+	hashes []common.Hash // Transactions having been requested
+	time   time.Time     // Timestamp of the request
+}
+
+// TxFetcher is responsible for retrieving new transactions based on hash
+// announcements. Announced transactions are first given some time to arrive by
+// direct broadcast, after which they are explicitly requested from one of the
+// announcing peers at a time, falling back to the others on failure.
+type TxFetcher struct {
+	notify  chan *txAnnounce
+	cleanup chan *txDelivery
+	drop    chan string
+	quit    chan struct{}
+
+	// Stage 1: announced transactions waiting for a direct broadcast
+	waitlist  map[common.Hash]map[string]struct{} // Peers having announced a waiting transaction
+	waittime  map[common.Hash]time.Time           // Timestamp of the first announcement of a waiting transaction
+	waitslots map[string]map[common.Hash]struct{} // Waiting announcements grouped by peer
+
+	// Stage 2: announced transactions scheduled for retrieval
+	announces map[string]map[common.Hash]struct{} // Retrievable announcements grouped by peer
+	announced map[common.Hash]map[string]struct{} // Peers able to serve a retrievable transaction
+
+	// Stage 3: transactions currently being retrieved
+	fetching map[common.Hash]string // Transactions being retrieved, with the peer serving them
+	requests map[string]*txRequest  // In-flight retrieval requests per peer
+
+	// Callbacks
+	hasTx    txRetrievalFn // Checks whether a transaction is already known locally
+	addTxs   txAdderFn     // Injects a batch of transactions into the pool
+	fetchTxs txRequesterFn // Requests a batch of transactions from a peer
+
+	// Testing hooks
+	fetchingHook func(string, []common.Hash) // Method to call upon starting a transaction retrieval
+}
+
+// NewTxFetcher creates a transaction fetcher to retrieve transactions based on
+// hash announcements.
+func NewTxFetcher(hasTx txRetrievalFn, addTxs txAdderFn, fetchTxs txRequesterFn) *TxFetcher {
+	return &TxFetcher{
+		notify:    make(chan *txAnnounce),
+		cleanup:   make(chan *txDelivery),
+		drop:      make(chan string),
+		quit:      make(chan struct{}),
+		waitlist:  make(map[common.Hash]map[string]struct{}),
+		waittime:  make(map[common.Hash]time.Time),
+		waitslots: make(map[string]map[common.Hash]struct{}),
+		announces: make(map[string]map[common.Hash]struct{}),
+		announced: make(map[common.Hash]map[string]struct{}),
+		fetching:  make(map[common.Hash]string),
+		requests:  make(map[string]*txRequest),
+		hasTx:     hasTx,
+		addTxs:    addTxs,
+		fetchTxs:  fetchTxs,
+	}
+}
+
+// Start boots up the announcement based transaction retriever.
+func (f *TxFetcher) Start() {
+	go f.loop()
+}
+
+// Stop terminates the announcement based transaction retriever, canceling all
+// pending operations.
+func (f *TxFetcher) Stop() {
+	close(f.quit)
+}
+
+// Notify announces the fetcher of the potential availability of a batch of new
+// transactions in the network.
+func (f *TxFetcher) Notify(peer string, hashes []common.Hash) error {
+	txAnnounceInMeter.Mark(int64(len(hashes)))
+
+	// Skip any transaction announcements that we already know of
+	unknown := make([]common.Hash, 0, len(hashes))
+	for _, hash := range hashes {
+		if !f.hasTx(hash) {
+			unknown = append(unknown, hash)
+		}
+	}
+	txAnnounceKnownMeter.Mark(int64(len(hashes) - len(unknown)))
+	if len(unknown) == 0 {
+		return nil
+	}
+	select {
+	case f.notify <- &txAnnounce{origin: peer, hashes: unknown}:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// Enqueue imports a batch of received transactions into the transaction pool
+// and the fetcher. It may be called both for directly broadcast transactions
+// and for replies to previous retrieval requests.
+func (f *TxFetcher) Enqueue(peer string, txs []*types.Transaction, direct bool) error {
+	if direct {
+		txBroadcastInMeter.Mark(int64(len(txs)))
+	} else {
+		txReplyInMeter.Mark(int64(len(txs)))
+	}
+	f.addTxs(txs)
+
+	hashes := make([]common.Hash, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.Hash()
+	}
+	select {
+	case f.cleanup <- &txDelivery{origin: peer, hashes: hashes, direct: direct}:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// Drop should be called when a peer disconnects. It cleans up all the internal
+// data structures of the given node and reschedules its pending retrievals.
+func (f *TxFetcher) Drop(peer string) error {
+	select {
+	case f.drop <- peer:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// loop is the main fetcher loop, checking and processing various notification
+// events.
+func (f *TxFetcher) loop() {
+	waitTimer := time.NewTimer(0)
+	timeoutTimer := time.NewTimer(0)
+
+	for {
+		select {
+		case <-f.quit:
+			return
+
+		case ann := <-f.notify:
+			// Make sure the peer isn't DOSing us with announcements
+			used := len(f.waitslots[ann.origin]) + len(f.announces[ann.origin])
+			if used+len(ann.hashes) > maxTxAnnounces {
+				txAnnounceDOSMeter.Mark(int64(used + len(ann.hashes) - maxTxAnnounces))
+				log.Debug("Peer exceeded outstanding tx announces", "peer", ann.origin, "limit", maxTxAnnounces)
+				if used >= maxTxAnnounces {
+					break
+				}
+				ann.hashes = ann.hashes[:maxTxAnnounces-used]
+			}
+			var (
+				idle       = len(f.waitlist) == 0
+				reschedule = false
+			)
+			for _, hash := range ann.hashes {
+				// If the transaction is already scheduled or being fetched, track
+				// the peer as an alternate source
+				if f.announced[hash] != nil || f.fetching[hash] != "" {
+					f.addAnnounce(ann.origin, hash)
+					reschedule = true
+					continue
+				}
+				// Otherwise give the transaction some time to arrive by broadcast
+				if f.waitlist[hash] == nil {
+					f.waitlist[hash] = make(map[string]struct{})
+					f.waittime[hash] = time.Now()
+				}
+				f.waitlist[hash][ann.origin] = struct{}{}
+				if f.waitslots[ann.origin] == nil {
+					f.waitslots[ann.origin] = make(map[common.Hash]struct{})
+				}
+				f.waitslots[ann.origin][hash] = struct{}{}
+			}
+			if idle && len(f.waitlist) > 0 {
+				f.rescheduleWait(waitTimer)
+			}
+			if reschedule {
+				f.scheduleFetches(timeoutTimer)
+			}
+
+		case <-waitTimer.C:
+			// At least one transaction's arrival timer ran out, schedule its retrieval
+			for hash, since := range f.waittime {
+				if time.Since(since) < txArriveTimeout-txGatherSlack {
+					continue
+				}
+				if !f.hasTx(hash) {
+					for peer := range f.waitlist[hash] {
+						f.addAnnounce(peer, hash)
+					}
+				}
+				f.forgetWaiting(hash)
+			}
+			f.scheduleFetches(timeoutTimer)
+			f.rescheduleWait(waitTimer)
+
+		case <-timeoutTimer.C:
+			// At least one request timed out, reschedule its transactions elsewhere
+			for peer, req := range f.requests {
+				if time.Since(req.time) < txFetchTimeout {
+					continue
+				}
+				txRequestTimeoutMeter.Mark(int64(len(req.hashes)))
+				for _, hash := range req.hashes {
+					if f.fetching[hash] == peer {
+						delete(f.fetching, hash)
+					}
+					f.forgetAnnounce(peer, hash)
+				}
+				delete(f.requests, peer)
+			}
+			f.scheduleFetches(timeoutTimer)
+			f.rescheduleTimeout(timeoutTimer)
+
+		case delivery := <-f.cleanup:
+			// A batch of transactions arrived, stop tracking all of them
+			for _, hash := range delivery.hashes {
+				f.forgetWaiting(hash)
+				for peer := range f.announced[hash] {
+					f.forgetAnnounce(peer, hash)
+				}
+				delete(f.fetching, hash)
+			}
+			// If this was a reply, any requested but undelivered transactions
+			// are not available from the peer, try the alternates
+			if req := f.requests[delivery.origin]; req != nil && !delivery.direct {
+				for _, hash := range req.hashes {
+					if f.fetching[hash] == delivery.origin {
+						delete(f.fetching, hash)
+					}
+					f.forgetAnnounce(delivery.origin, hash)
+				}
+				delete(f.requests, delivery.origin)
+			}
+			f.scheduleFetches(timeoutTimer)
+
+		case peer := <-f.drop:
+			// A peer was dropped, remove all traces of it
+			for hash := range f.waitslots[peer] {
+				delete(f.waitlist[hash], peer)
+				if len(f.waitlist[hash]) == 0 {
+					delete(f.waitlist, hash)
+					delete(f.waittime, hash)
+				}
+			}
+			delete(f.waitslots, peer)
+
+			if req := f.requests[peer]; req != nil {
+				for _, hash := range req.hashes {
+					if f.fetching[hash] == peer {
+						delete(f.fetching, hash)
+					}
+				}
+				delete(f.requests, peer)
+			}
+			for hash := range f.announces[peer] {
+				f.forgetAnnounce(peer, hash)
+			}
+			f.scheduleFetches(timeoutTimer)
+		}
+	}
+}
+
+// addAnnounce marks a transaction as retrievable from the given peer.
+func (f *TxFetcher) addAnnounce(peer string, hash common.Hash) {
+	if f.announces[peer] == nil {
+		f.announces[peer] = make(map[common.Hash]struct{})
+	}
+	f.announces[peer][hash] = struct{}{}
+
+	if f.announced[hash] == nil {
+		f.announced[hash] = make(map[string]struct{})
+	}
+	f.announced[hash][peer] = struct{}{}
+}
+
+// forgetAnnounce removes the given peer as a retrieval source of a transaction.
+func (f *TxFetcher) forgetAnnounce(peer string, hash common.Hash) {
+	if announces := f.announces[peer]; announces != nil {
+		delete(announces, hash)
+		if len(announces) == 0 {
+			delete(f.announces, peer)
+		}
+	}
+	if announced := f.announced[hash]; announced != nil {
+		delete(announced, peer)
+		if len(announced) == 0 {
+			delete(f.announced, hash)
+		}
+	}
+}
+
+// forgetWaiting removes all traces of a transaction from the waiting list.
+func (f *TxFetcher) forgetWaiting(hash common.Hash) {
+	for peer := range f.waitlist[hash] {
+		delete(f.waitslots[peer], hash)
+		if len(f.waitslots[peer]) == 0 {
+			delete(f.waitslots, peer)
+		}
+	}
+	delete(f.waitlist, hash)
+	delete(f.waittime, hash)
+}
+
+// scheduleFetches assigns the retrievable transactions to the idle peers that
+// announced them, making sure no transaction is requested from multiple peers
+// at the same time.
+func (f *TxFetcher) scheduleFetches(timer *time.Timer) {
+	idle := len(f.requests) == 0
+
+	for peer, announces := range f.announces {
+		if f.requests[peer] != nil {
+			continue
+		}
+		hashes := make([]common.Hash, 0, maxTxRetrievals)
+		for hash := range announces {
+			if f.fetching[hash] != "" {
+				continue
+			}
+			f.fetching[hash] = peer
+			if hashes = append(hashes, hash); len(hashes) >= maxTxRetrievals {
+				break
+			}
+		}
+		if len(hashes) == 0 {
+			continue
+		}
+		f.requests[peer] = &txRequest{hashes: hashes, time: time.Now()}
+		txRequestOutMeter.Mark(int64(len(hashes)))
+
+		log.Trace("Fetching scheduled transactions", "peer", peer, "count", len(hashes))
+		go func(peer string, hashes []common.Hash) {
+			if f.fetchingHook != nil {
+				f.fetchingHook(peer, hashes)
+			}
+			if err := f.fetchTxs(peer, hashes); err != nil {
+				log.Debug("Failed to request transactions", "peer", peer, "err", err)
+			}
+		}(peer, hashes)
+	}
+	if idle && len(f.requests) > 0 {
+		f.rescheduleTimeout(timer)
+	}
+}
+
+// rescheduleWait resets the specified wait timer to the next transaction
+// arrival timeout.
+func (f *TxFetcher) rescheduleWait(timer *time.Timer) {
+	if len(f.waittime) == 0 {
+		return
+	}
+	earliest := time.Now()
+	for _, since := range f.waittime {
+		if earliest.After(since) {
+			earliest = since
+		}
+	}
+	timer.Reset(txArriveTimeout - time.Since(earliest))
+}
+
+// rescheduleTimeout resets the specified timeout timer to the next request
+// expiration.
+func (f *TxFetcher) rescheduleTimeout(timer *time.Timer) {
+	if len(f.requests) == 0 {
+		return
+	}
+	earliest := time.Now()
+	for _, req := range f.requests {
+		if earliest.After(req.time) {
+			earliest = req.time
+		}
+	}
+	timer.Reset(txFetchTimeout - time.Since(earliest))
+}
@@ -0,0 +1,233 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package fetcher
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/types"
+)
+
+// txFetcherTester is a test simulator for mocking out the transaction pool and
+// the network retrievals.
+type txFetcherTester struct {
+	fetcher *TxFetcher
+
+	pool     map[common.Hash]*types.Transaction // Transactions added to the mock pool
+	requests chan txFetchRequest                // Retrieval requests sent out by the fetcher
+	lock     sync.RWMutex
+}
+
+// txFetchRequest is a retrieval request issued by the fetcher.
+type txFetchRequest struct {
+	peer   string
+	hashes []common.Hash
+}
+
+// newTxFetcherTester creates a new transaction fetcher test mocker.
+func newTxFetcherTester() *txFetcherTester {
+	tester := &txFetcherTester{
+		pool:     make(map[common.Hash]*types.Transaction),
+		requests: make(chan txFetchRequest, 16),
+	}
+	tester.fetcher = NewTxFetcher(tester.hasTx, tester.addTxs, tester.fetchTxs)
+	tester.fetcher.Start()
+
+	return tester
+}
+
+// hasTx checks whether a transaction was added to the mock pool.
+func (f *txFetcherTester) hasTx(hash common.Hash) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return f.pool[hash] != nil
+}
+
+// addTxs injects a batch of transactions into the mock pool.
+func (f *txFetcherTester) addTxs(txs []*types.Transaction) []error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, tx := range txs {
+		f.pool[tx.Hash()] = tx
+	}
+	return make([]error, len(txs))
+}
+
+// fetchTxs records a retrieval request sent out by the fetcher.
+func (f *txFetcherTester) fetchTxs(peer string, hashes []common.Hash) error {
+	f.requests <- txFetchRequest{peer: peer, hashes: hashes}
+	return nil
+}
+
+// expectRequest waits for a retrieval request and checks its contents.
+func (f *txFetcherTester) expectRequest(t *testing.T, peer string, hashes ...common.Hash) {
+	select {
+	case req := <-f.requests:
+		if req.peer != peer {
+			t.Fatalf("request peer mismatch: have %s, want %s", req.peer, peer)
+		}
+		if len(req.hashes) != len(hashes) {
+			t.Fatalf("request length mismatch: have %d, want %d", len(req.hashes), len(hashes))
+		}
+		want := make(map[common.Hash]bool)
+		for _, hash := range hashes {
+			want[hash] = true
+		}
+		for _, hash := range req.hashes {
+			if !want[hash] {
+				t.Fatalf("unexpected transaction requested: %x", hash)
+			}
+		}
+	case <-time.After(txArriveTimeout + time.Second):
+		t.Fatalf("retrieval timeout")
+	}
+}
+
+// expectNoRequest checks that no retrieval request is sent out for a while.
+func (f *txFetcherTester) expectNoRequest(t *testing.T) {
+	select {
+	case req := <-f.requests:
+		t.Fatalf("unexpected request to %s: %x", req.peer, req.hashes)
+	case <-time.After(txArriveTimeout + 100*time.Millisecond):
+	}
+}
+
+// newTestTxs creates a batch of unsigned dummy transactions.
+func newTestTxs(n int) []*types.Transaction {
+	txs := make([]*types.Transaction, n)
+	for i := range txs {
+		txs[i] = types.NewTransaction(uint64(i), common.Address{}, big.NewInt(0), 21000, big.NewInt(1), nil)
+	}
+	return txs
+}
+
+// Tests that announced transactions are retrieved from the announcing peer if
+// they are not broadcast in the meantime.
+func TestTxFetcherRetrieval(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	txs := newTestTxs(2)
+	tester.fetcher.Notify("A", []common.Hash{txs[0].Hash(), txs[1].Hash()})
+	tester.expectRequest(t, "A", txs[0].Hash(), txs[1].Hash())
+
+	tester.fetcher.Enqueue("A", txs, false)
+	for _, tx := range txs {
+		if !tester.hasTx(tx.Hash()) {
+			t.Fatalf("transaction %x not added to the pool", tx.Hash())
+		}
+	}
+	// Announcing known transactions again should not trigger retrievals
+	tester.fetcher.Notify("B", []common.Hash{txs[0].Hash()})
+	tester.expectNoRequest(t)
+}
+
+// Tests that transactions arriving by direct broadcast within the arrival
+// timeout are not retrieved explicitly.
+func TestTxFetcherBroadcastArrival(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	txs := newTestTxs(1)
+	tester.fetcher.Notify("A", []common.Hash{txs[0].Hash()})
+	tester.fetcher.Enqueue("B", txs, true)
+
+	tester.expectNoRequest(t)
+}
+
+// Tests that a transaction announced by multiple peers is only requested from
+// one of them, and that it is retrieved from an alternate if that peer drops.
+func TestTxFetcherAlternates(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	txs := newTestTxs(1)
+	tester.fetcher.Notify("A", []common.Hash{txs[0].Hash()})
+	tester.fetcher.Notify("B", []common.Hash{txs[0].Hash()})
+
+	var first string
+	select {
+	case req := <-tester.requests:
+		first = req.peer
+	case <-time.After(txArriveTimeout + time.Second):
+		t.Fatalf("retrieval timeout")
+	}
+	select {
+	case req := <-tester.requests:
+		t.Fatalf("duplicate request to %s: %x", req.peer, req.hashes)
+	case <-time.After(100 * time.Millisecond):
+	}
+	second := "A"
+	if first == "A" {
+		second = "B"
+	}
+	tester.fetcher.Drop(first)
+	tester.expectRequest(t, second, txs[0].Hash())
+}
+
+// Tests that transactions the requested peer does not deliver are retrieved
+// from an alternate peer.
+func TestTxFetcherUndelivered(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	txs := newTestTxs(2)
+	tester.fetcher.Notify("A", []common.Hash{txs[0].Hash(), txs[1].Hash()})
+	tester.expectRequest(t, "A", txs[0].Hash(), txs[1].Hash())
+
+	// Have a second peer announce one of the transactions, then deliver only
+	// the other one from the first peer
+	tester.fetcher.Notify("B", []common.Hash{txs[1].Hash()})
+	tester.fetcher.Enqueue("A", txs[:1], false)
+
+	tester.expectRequest(t, "B", txs[1].Hash())
+}
+
+// Tests that a peer is not allowed to have more than the permitted number of
+// announcements tracked.
+func TestTxFetcherAnnounceLimit(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	hashes := make([]common.Hash, maxTxAnnounces+10)
+	for i := range hashes {
+		hashes[i] = common.BigToHash(big.NewInt(int64(i + 1)))
+	}
+	tester.fetcher.Notify("A", hashes)
+
+	var requested int
+	for requested < maxTxAnnounces {
+		select {
+		case req := <-tester.requests:
+			requested += len(req.hashes)
+			// Deliver nothing, making the fetcher forget the retrieved batch
+			tester.fetcher.Enqueue("A", nil, false)
+		case <-time.After(txArriveTimeout + time.Second):
+			t.Fatalf("retrieval timeout, requested %d", requested)
+		}
+	}
+	if requested != maxTxAnnounces {
+		t.Fatalf("requested transaction count mismatch: have %d, want %d", requested, maxTxAnnounces)
+	}
+	tester.expectNoRequest(t)
+}
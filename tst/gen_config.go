@@ -4,6 +4,7 @@ package tst
 
 import (
 	"math/big"
+	"time"
 
 	"github.com/utchain/go-utchain/common"
 	"github.com/utchain/go-utchain/common/hexutil"
@@ -20,15 +21,25 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		Genesis                 *core.Genesis `toml:",omitempty"`
 		NetworkId               uint64
 		SyncMode                downloader.SyncMode
+		NoPruning               bool
+		Snapshot                bool
+		TransferIndex           bool
 		LightServ               int  `toml:",omitempty"`
 		LightPeers              int  `toml:",omitempty"`
 		SkipBcVersionCheck      bool `toml:"-"`
 		DatabaseHandles         int  `toml:"-"`
 		DatabaseCache           int
+		TrieCache               int
+		TrieTimeout             time.Duration
+		Ancient                 bool
+		AncientDir              string `toml:",omitempty"`
+		AncientDepth            uint64
 		Tsterbase               common.Address `toml:",omitempty"`
 		MinerThreads            int            `toml:",omitempty"`
 		ExtraData               hexutil.Bytes  `toml:",omitempty"`
 		GasPrice                *big.Int
+		MinerOrdering           string           `toml:",omitempty"`
+		MinerPrioritySenders    []common.Address `toml:",omitempty"`
 		Tstash                  ethash.Config
 		TxPool                  core.TxPoolConfig
 		GPO                     gasprice.Config
@@ -39,15 +50,25 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.Genesis = c.Genesis
 	enc.NetworkId = c.NetworkId
 	enc.SyncMode = c.SyncMode
+	enc.NoPruning = c.NoPruning
+	enc.Snapshot = c.Snapshot
+	enc.TransferIndex = c.TransferIndex
 	enc.LightServ = c.LightServ
 	enc.LightPeers = c.LightPeers
 	enc.SkipBcVersionCheck = c.SkipBcVersionCheck
 	enc.DatabaseHandles = c.DatabaseHandles
 	enc.DatabaseCache = c.DatabaseCache
+	enc.TrieCache = c.TrieCache
+	enc.TrieTimeout = c.TrieTimeout
+	enc.Ancient = c.Ancient
+	enc.AncientDir = c.AncientDir
+	enc.AncientDepth = c.AncientDepth
 	enc.Tsterbase = c.Tsterbase
 	enc.MinerThreads = c.MinerThreads
 	enc.ExtraData = c.ExtraData
 	enc.GasPrice = c.GasPrice
+	enc.MinerOrdering = c.MinerOrdering
+	enc.MinerPrioritySenders = c.MinerPrioritySenders
 	enc.Tstash = c.Tstash
 	enc.TxPool = c.TxPool
 	enc.GPO = c.GPO
@@ -61,15 +82,25 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		Genesis                 *core.Genesis `toml:",omitempty"`
 		NetworkId               *uint64
 		SyncMode                *downloader.SyncMode
+		NoPruning               *bool
+		Snapshot                *bool
+		TransferIndex           *bool
 		LightServ               *int  `toml:",omitempty"`
 		LightPeers              *int  `toml:",omitempty"`
 		SkipBcVersionCheck      *bool `toml:"-"`
 		DatabaseHandles         *int  `toml:"-"`
 		DatabaseCache           *int
+		TrieCache               *int
+		TrieTimeout             *time.Duration
+		Ancient                 *bool
+		AncientDir              *string `toml:",omitempty"`
+		AncientDepth            *uint64
 		Tsterbase               *common.Address `toml:",omitempty"`
 		MinerThreads            *int            `toml:",omitempty"`
 		ExtraData               *hexutil.Bytes  `toml:",omitempty"`
 		GasPrice                *big.Int
+		MinerOrdering           *string          `toml:",omitempty"`
+		MinerPrioritySenders    []common.Address `toml:",omitempty"`
 		Tstash                  *ethash.Config
 		TxPool                  *core.TxPoolConfig
 		GPO                     *gasprice.Config
@@ -89,6 +120,15 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.SyncMode != nil {
 		c.SyncMode = *dec.SyncMode
 	}
+	if dec.NoPruning != nil {
+		c.NoPruning = *dec.NoPruning
+	}
+	if dec.Snapshot != nil {
+		c.Snapshot = *dec.Snapshot
+	}
+	if dec.TransferIndex != nil {
+		c.TransferIndex = *dec.TransferIndex
+	}
 	if dec.LightServ != nil {
 		c.LightServ = *dec.LightServ
 	}
@@ -104,6 +144,21 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.DatabaseCache != nil {
 		c.DatabaseCache = *dec.DatabaseCache
 	}
+	if dec.TrieCache != nil {
+		c.TrieCache = *dec.TrieCache
+	}
+	if dec.TrieTimeout != nil {
+		c.TrieTimeout = *dec.TrieTimeout
+	}
+	if dec.Ancient != nil {
+		c.Ancient = *dec.Ancient
+	}
+	if dec.AncientDir != nil {
+		c.AncientDir = *dec.AncientDir
+	}
+	if dec.AncientDepth != nil {
+		c.AncientDepth = *dec.AncientDepth
+	}
 	if dec.Tsterbase != nil {
 		c.Tsterbase = *dec.Tsterbase
 	}
@@ -116,6 +171,12 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.GasPrice != nil {
 		c.GasPrice = dec.GasPrice
 	}
+	if dec.MinerOrdering != nil {
+		c.MinerOrdering = *dec.MinerOrdering
+	}
+	if dec.MinerPrioritySenders != nil {
+		c.MinerPrioritySenders = dec.MinerPrioritySenders
+	}
 	if dec.Tstash != nil {
 		c.Tstash = *dec.Tstash
 	}
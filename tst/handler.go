@@ -17,6 +17,7 @@
 package tst
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -30,6 +31,8 @@ import (
 	"github.com/utchain/go-utchain/consensus"
 	"github.com/utchain/go-utchain/consensus/misc"
 	"github.com/utchain/go-utchain/core"
+	"github.com/utchain/go-utchain/core/forkid"
+	"github.com/utchain/go-utchain/core/state"
 	"github.com/utchain/go-utchain/core/types"
 	"github.com/utchain/go-utchain/tst/downloader"
 	"github.com/utchain/go-utchain/tst/fetcher"
@@ -38,8 +41,10 @@ import (
 	"github.com/utchain/go-utchain/log"
 	"github.com/utchain/go-utchain/p2p"
 	"github.com/utchain/go-utchain/p2p/discover"
+	"github.com/utchain/go-utchain/p2p/enr"
 	"github.com/utchain/go-utchain/params"
 	"github.com/utchain/go-utchain/rlp"
+	"github.com/utchain/go-utchain/trie"
 )
 
 const (
@@ -67,15 +72,18 @@ type ProtocolManager struct {
 	networkId uint64
 
 	fastSync  uint32 // Flag whtster fast sync is enabled (gets disabled if we already have blocks)
+	snapSync  uint32 // Flag if fast sync downloads the state in ranges instead of trie nodes
 	acceptTxs uint32 // Flag whtster we're considered synchronised (enables transaction processing)
 
 	txpool      txPool
 	blockchain  *core.BlockChain
 	chainconfig *params.ChainConfig
 	maxPeers    int
+	forkFilter  forkid.Filter // Fork ID filter, constant across the lifetime of the node
 
 	downloader *downloader.Downloader
 	fetcher    *fetcher.Fetcher
+	txFetcher  *fetcher.TxFetcher
 	peers      *peerSet
 
 	SubProtocols []p2p.Protocol
@@ -106,6 +114,7 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		txpool:      txpool,
 		blockchain:  blockchain,
 		chainconfig: config,
+		forkFilter:  forkid.NewFilter(blockchain),
 		peers:       newPeerSet(),
 		newPeerCh:   make(chan *peer),
 		noMorePeers: make(chan struct{}),
@@ -113,18 +122,22 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 		quitSync:    make(chan struct{}),
 	}
 	// Figure out whtster to allow fast sync or not
-	if mode == downloader.FastSync && blockchain.CurrentBlock().NumberU64() > 0 {
+	if (mode == downloader.FastSync || mode == downloader.SnapSync) && blockchain.CurrentBlock().NumberU64() > 0 {
 		log.Warn("Blockchain not empty, fast sync disabled")
 		mode = downloader.FullSync
 	}
-	if mode == downloader.FastSync {
+	if mode == downloader.FastSync || mode == downloader.SnapSync {
 		manager.fastSync = uint32(1)
 	}
+	if mode == downloader.SnapSync {
+		manager.snapSync = uint32(1)
+	}
 	// Initiate a sub-protocol for every implemented version we can handle
 	manager.SubProtocols = make([]p2p.Protocol, 0, len(ProtocolVersions))
+	nodeFilter := NewNodeFilter(blockchain)
 	for i, version := range ProtocolVersions {
 		// Skip protocol version if incompatible with the mode of operation
-		if mode == downloader.FastSync && version < tst63 {
+		if (mode == downloader.FastSync || mode == downloader.SnapSync) && version < tst63 {
 			continue
 		}
 		// Compatible; initialise the sub-protocol
@@ -153,11 +166,33 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 				}
 				return nil
 			},
+			Attributes: func() []enr.Entry {
+				return []enr.Entry{manager.currentENREntry()}
+			},
+			NodeFilter: nodeFilter,
 		})
 	}
 	if len(manager.SubProtocols) == 0 {
 		return nil, errIncompatibleConfig
 	}
+	// Serve the state in contiguous ranges to snap syncing peers
+	for i, version := range SnapProtocolVersions {
+		manager.SubProtocols = append(manager.SubProtocols, p2p.Protocol{
+			Name:    SnapProtocolName,
+			Version: version,
+			Length:  SnapProtocolLengths[i],
+			Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+				select {
+				case <-manager.quitSync:
+					return p2p.DiscQuitting
+				default:
+				}
+				manager.wg.Add(1)
+				defer manager.wg.Done()
+				return manager.handleSnap(newSnapPeer(p, rw))
+			},
+		})
+	}
 	// Construct the different synchronisation mechanisms
 	manager.downloader = downloader.New(mode, chaindb, manager.eventMux, blockchain, nil, manager.removePeer)
 
@@ -178,6 +213,26 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 	}
 	manager.fetcher = fetcher.New(blockchain.GetBlockByHash, validator, manager.BroadcastBlock, heighter, inserter, manager.removePeer)
 
+	hasTx := func(hash common.Hash) bool {
+		return txpool.Get(hash) != nil
+	}
+	fetchTxs := func(id string, hashes []common.Hash) error {
+		p := manager.peers.Peer(id)
+		if p == nil {
+			return errNotRegistered
+		}
+		return p.RequestTxs(hashes)
+	}
+	addTxs := func(txs []*types.Transaction) []error {
+		// Requested transactions arriving before we're synchronised are only
+		// accounted for by the fetcher, but not added to the pool
+		if atomic.LoadUint32(&manager.acceptTxs) == 0 {
+			return make([]error, len(txs))
+		}
+		return txpool.AddRemotes(txs)
+	}
+	manager.txFetcher = fetcher.NewTxFetcher(hasTx, addTxs, fetchTxs)
+
 	return manager, nil
 }
 
@@ -191,6 +246,7 @@ func (pm *ProtocolManager) removePeer(id string) {
 
 	// Unregister the peer from the downloader and UTChain peer set
 	pm.downloader.UnregisterPeer(id)
+	pm.txFetcher.Drop(id)
 	if err := pm.peers.Unregister(id); err != nil {
 		log.Error("Peer removal failed", "peer", id, "err", err)
 	}
@@ -263,7 +319,7 @@ func (pm *ProtocolManager) handle(p *peer) error {
 		number  = head.Number.Uint64()
 		td      = pm.blockchain.GetTd(hash, number)
 	)
-	if err := p.Handshake(pm.networkId, td, hash, genesis.Hash()); err != nil {
+	if err := p.Handshake(pm.networkId, td, hash, genesis.Hash(), forkid.NewID(pm.blockchain), pm.forkFilter); err != nil {
 		p.Log().Debug("UTChain handshake failed", "err", err)
 		return err
 	}
@@ -276,6 +332,7 @@ func (pm *ProtocolManager) handle(p *peer) error {
 		return err
 	}
 	defer pm.removePeer(p.id)
+	defer p.closeRequests()
 
 	// Register the peer in the downloader. If the downloader considers it banned, we disconnect
 	if err := pm.downloader.RegisterPeer(p.id, p.version, p); err != nil {
@@ -286,7 +343,14 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	pm.syncTransactions(p)
 
 	// If we're DAO hard-fork aware, validate any remote peer with regard to the hard-fork
-	if daoBlock := pm.chainconfig.DAOForkBlock; daoBlock != nil {
+	if daoBlock := pm.chainconfig.DAOForkBlock; daoBlock != nil && p.version >= tst65 {
+		// Request the peer's DAO fork header and validate the reply in the background
+		sink := make(chan *response, 1)
+		if err := p.requestHeadersByNumber(daoBlock.Uint64(), 1, 0, false, sink, daoChallengeTimeout); err != nil {
+			return err
+		}
+		go pm.verifyDAOChallenge(p, sink)
+	} else if daoBlock != nil {
 		// Request the peer's DAO fork header for extra-data validation
 		if err := p.RequestHeadersByNumber(daoBlock.Uint64(), 1, 0, false); err != nil {
 			return err
@@ -313,6 +377,340 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	}
 }
 
+// verifyDAOChallenge waits for the reply to the DAO fork header challenge sent to
+// a peer supporting request IDs, dropping the peer if it's on the other side of
+// the fork or fails to answer in time.
+func (pm *ProtocolManager) verifyDAOChallenge(p *peer, sink <-chan *response) {
+	res := <-sink
+	switch {
+	case res.Err == errPeerClosed:
+		return
+	case res.Err != nil:
+		p.Log().Debug("Timed out DAO fork-check, dropping")
+		pm.removePeer(p.id)
+		return
+	}
+	headers := res.Data.([]*types.Header)
+	switch {
+	case len(headers) == 0:
+		// If we already have a DAO header, we can check the peer's TD against it. If
+		// the peer's ahead of this, it too must have a reply to the DAO check
+		if daoHeader := pm.blockchain.GetHeaderByNumber(pm.chainconfig.DAOForkBlock.Uint64()); daoHeader != nil {
+			if _, td := p.Head(); td.Cmp(pm.blockchain.GetTd(daoHeader.Hash(), daoHeader.Number.Uint64())) >= 0 {
+				p.Log().Debug("Withheld DAO fork header, dropping")
+				pm.removePeer(p.id)
+				return
+			}
+		}
+		p.Log().Debug("Seems to be on the same side of the DAO fork")
+
+	case len(headers) == 1 && pm.chainconfig.DAOForkBlock.Cmp(headers[0].Number) == 0:
+		if err := misc.VerifyDAOHeaderExtraData(pm.chainconfig, headers[0]); err != nil {
+			p.Log().Debug("Verified to be on the other side of the DAO fork, dropping")
+			pm.removePeer(p.id)
+			return
+		}
+		p.Log().Debug("Verified to be on the same side of the DAO fork")
+
+	default:
+		p.Log().Debug("Invalid DAO fork-check reply, dropping", "headers", len(headers))
+		pm.removePeer(p.id)
+	}
+}
+
+// decodeMsg decodes the payload of a retrieval request or response message into
+// val, returning the request ID from the envelope on protocol versions having it.
+func decodeMsg(p *peer, msg p2p.Msg, val interface{}) (uint64, error) {
+	if p.version < tst65 {
+		return 0, msg.Decode(val)
+	}
+	var envelope requestEnvelope
+	if err := msg.Decode(&envelope); err != nil {
+		return 0, err
+	}
+	return envelope.ID, rlp.DecodeBytes(envelope.Data, val)
+}
+
+// requestStream opens a retrieval request message containing a list of hashes
+// for streamed decoding, returning the request ID from the envelope on protocol
+// versions having it. The returned stream is positioned inside the hash list.
+func requestStream(p *peer, msg p2p.Msg) (*rlp.Stream, uint64, error) {
+	stream := rlp.NewStream(msg.Payload, uint64(msg.Size))
+	if _, err := stream.List(); err != nil {
+		return nil, 0, err
+	}
+	var id uint64
+	if p.version >= tst65 {
+		if err := stream.Decode(&id); err != nil {
+			return nil, 0, err
+		}
+		if _, err := stream.List(); err != nil {
+			return nil, 0, err
+		}
+	}
+	return stream, id, nil
+}
+
+// handleSnap is the callback invoked to manage the life cycle of a snap peer.
+// When this function terminates, the peer is disconnected.
+func (pm *ProtocolManager) handleSnap(p *snapPeer) error {
+	p.Log().Debug("Snap peer connected", "name", p.Name())
+
+	if err := pm.downloader.RegisterSnapPeer(p.id, p); err != nil {
+		return err
+	}
+	defer pm.downloader.UnregisterSnapPeer(p.id)
+
+	for {
+		if err := pm.handleSnapMsg(p); err != nil {
+			p.Log().Debug("Snap message handling failed", "err", err)
+			return err
+		}
+	}
+}
+
+// handleSnapMsg is invoked whenever an inbound snap message is received from a
+// remote peer. The remote connection is torn down upon returning any error.
+func (pm *ProtocolManager) handleSnapMsg(p *snapPeer) error {
+	// Read the next message from the remote peer, and ensure it's fully consumed
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if msg.Size > ProtocolMaxMsgSize {
+		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
+	}
+	defer msg.Discard()
+
+	// Handle the message depending on its contents
+	switch msg.Code {
+	case GetAccountRangeMsg:
+		var req getAccountRangeData
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		accounts, proof := pm.serviceAccountRange(&req)
+		return p.SendAccountRange(req.ID, accounts, proof)
+
+	case AccountRangeMsg:
+		// A range of accounts arrived to one of our previous requests
+		var res accountRangeData
+		if err := msg.Decode(&res); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		hashes := make([]common.Hash, len(res.Accounts))
+		accounts := make([][]byte, len(res.Accounts))
+		for i, acc := range res.Accounts {
+			hashes[i], accounts[i] = acc.Hash, acc.Body
+		}
+		if err := pm.downloader.DeliverAccountRange(p.id, res.ID, hashes, accounts, res.Proof); err != nil {
+			log.Debug("Failed to deliver account range", "err", err)
+		}
+
+	case GetStorageRangesMsg:
+		var req getStorageRangesData
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		slots, proof := pm.serviceStorageRanges(&req)
+		return p.SendStorageRanges(req.ID, slots, proof)
+
+	case StorageRangesMsg:
+		// Ranges of storage slots arrived to one of our previous requests
+		var res storageRangesData
+		if err := msg.Decode(&res); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		hashes := make([][]common.Hash, len(res.Slots))
+		slots := make([][][]byte, len(res.Slots))
+		for i, storage := range res.Slots {
+			hashes[i] = make([]common.Hash, len(storage))
+			slots[i] = make([][]byte, len(storage))
+			for j, slot := range storage {
+				hashes[i][j], slots[i][j] = slot.Hash, slot.Body
+			}
+		}
+		if err := pm.downloader.DeliverStorageRanges(p.id, res.ID, hashes, slots, res.Proof); err != nil {
+			log.Debug("Failed to deliver storage ranges", "err", err)
+		}
+
+	case GetByteCodesMsg:
+		var req getByteCodesData
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		return p.SendByteCodes(req.ID, pm.serviceByteCodes(&req))
+
+	case ByteCodesMsg:
+		// A batch of contract codes arrived to one of our previous requests
+		var res byteCodesData
+		if err := msg.Decode(&res); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if err := pm.downloader.DeliverByteCodes(p.id, res.ID, res.Codes); err != nil {
+			log.Debug("Failed to deliver contract codes", "err", err)
+		}
+
+	default:
+		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
+	}
+	return nil
+}
+
+// proofList is a trie node collector for range proofs.
+type proofList [][]byte
+
+func (l *proofList) Put(key []byte, value []byte) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// snapResponseLimit caps the requested response size of a snap query.
+func snapResponseLimit(bytes uint64) int {
+	if bytes > softResponseLimit {
+		return softResponseLimit
+	}
+	return int(bytes)
+}
+
+// serviceAccountRange gathers a range of accounts from the requested state,
+// along with the proofs of the range boundaries. An empty response without any
+// proofs is returned if the state is not available.
+func (pm *ProtocolManager) serviceAccountRange(req *getAccountRangeData) ([]accountData, [][]byte) {
+	tr, err := trie.New(req.Root, pm.blockchain.StateCache().TrieDB())
+	if err != nil {
+		return nil, nil
+	}
+	var (
+		accounts []accountData
+		size     int
+		limit    = snapResponseLimit(req.Bytes)
+	)
+	it := trie.NewIterator(tr.NodeIterator(req.Origin[:]))
+	for it.Next() {
+		hash := common.BytesToHash(it.Key)
+		accounts = append(accounts, accountData{Hash: hash, Body: common.CopyBytes(it.Value)})
+		size += common.HashLength + len(it.Value)
+
+		// The first account beyond the limit is included to prove the range end
+		if bytes.Compare(hash[:], req.Limit[:]) >= 0 || size >= limit {
+			break
+		}
+	}
+	if it.Err != nil {
+		return nil, nil
+	}
+	var proof proofList
+	if err := tr.Prove(req.Origin[:], 0, &proof); err != nil {
+		return nil, nil
+	}
+	if len(accounts) > 0 {
+		if err := tr.Prove(accounts[len(accounts)-1].Hash[:], 0, &proof); err != nil {
+			return nil, nil
+		}
+	}
+	return accounts, proof
+}
+
+// serviceStorageRanges gathers the storage slot ranges of the requested accounts,
+// until the response size limit is reached. If the last range is incomplete, or
+// is not requested from the start or up to the end of the storage trie, the
+// proofs of its boundaries are attached.
+func (pm *ProtocolManager) serviceStorageRanges(req *getStorageRangesData) ([][]storageData, [][]byte) {
+	triedb := pm.blockchain.StateCache().TrieDB()
+	accTrie, err := trie.New(req.Root, triedb)
+	if err != nil {
+		return nil, nil
+	}
+	var (
+		slots [][]storageData
+		proof proofList
+		size  int
+		limit = snapResponseLimit(req.Bytes)
+	)
+	for i, accHash := range req.Accounts {
+		if size >= limit {
+			break
+		}
+		blob, err := accTrie.TryGet(accHash[:])
+		if err != nil || len(blob) == 0 {
+			break
+		}
+		var acc state.Account
+		if err := rlp.DecodeBytes(blob, &acc); err != nil {
+			break
+		}
+		stTrie, err := trie.New(acc.Root, triedb)
+		if err != nil {
+			break
+		}
+		var origin, last common.Hash
+		if i == 0 && len(req.Origin) > 0 {
+			origin = common.BytesToHash(req.Origin)
+		}
+		bounded := origin != (common.Hash{})
+		if i == len(req.Accounts)-1 && len(req.Limit) > 0 {
+			last, bounded = common.BytesToHash(req.Limit), true
+		}
+		var (
+			storage   []storageData
+			truncated bool
+		)
+		it := trie.NewIterator(stTrie.NodeIterator(origin[:]))
+		for it.Next() {
+			if size >= limit {
+				truncated = true
+				break
+			}
+			hash := common.BytesToHash(it.Key)
+			storage = append(storage, storageData{Hash: hash, Body: common.CopyBytes(it.Value)})
+			size += common.HashLength + len(it.Value)
+
+			if len(req.Limit) > 0 && i == len(req.Accounts)-1 && bytes.Compare(hash[:], last[:]) >= 0 {
+				break
+			}
+		}
+		if it.Err != nil {
+			return nil, nil
+		}
+		slots = append(slots, storage)
+
+		// Partial ranges need to be proven, and must be the last in the response
+		if truncated || bounded {
+			if err := stTrie.Prove(origin[:], 0, &proof); err != nil {
+				return nil, nil
+			}
+			if len(storage) > 0 {
+				if err := stTrie.Prove(storage[len(storage)-1].Hash[:], 0, &proof); err != nil {
+					return nil, nil
+				}
+			}
+			break
+		}
+	}
+	return slots, proof
+}
+
+// serviceByteCodes gathers the requested contract codes, until the response
+// size limit is reached.
+func (pm *ProtocolManager) serviceByteCodes(req *getByteCodesData) [][]byte {
+	var (
+		codes [][]byte
+		size  int
+		limit = snapResponseLimit(req.Bytes)
+	)
+	for _, hash := range req.Hashes {
+		if size >= limit {
+			break
+		}
+		if code, err := pm.blockchain.TrieNode(hash); err == nil {
+			codes = append(codes, code)
+			size += len(code)
+		}
+	}
+	return codes
+}
+
 // handleMsg is invoked whenever an inbound message is received from a remote
 // peer. The remote connection is torn down upon returning any error.
 func (pm *ProtocolManager) handleMsg(p *peer) error {
@@ -336,7 +734,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 	case msg.Code == GetBlockHeadersMsg:
 		// Decode the complex header query
 		var query getBlockHeadersData
-		if err := msg.Decode(&query); err != nil {
+		reqID, err := decodeMsg(p, msg, &query)
+		if err != nil {
 			return errResp(ErrDecode, "%v: %v", msg, err)
 		}
 		hashMode := query.Origin.Hash != (common.Hash{})
@@ -409,14 +808,26 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				query.Origin.Number += query.Skip + 1
 			}
 		}
-		return p.SendBlockHeaders(headers)
+		return p.SendBlockHeaders(reqID, headers)
 
 	case msg.Code == BlockHeadersMsg:
 		// A batch of headers arrived to one of our previous requests
 		var headers []*types.Header
-		if err := msg.Decode(&headers); err != nil {
+		reqID, err := decodeMsg(p, msg, &headers)
+		if err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		route, consumed, err := p.resolve(reqID, msg.Code, headers)
+		if consumed || err != nil {
+			return err
+		}
+		// Responses to the downloader's own requests bypass the fetcher filters
+		if route == routeDownloader {
+			if err := pm.downloader.DeliverHeaders(p.id, headers); err != nil {
+				log.Debug("Failed to deliver headers", "err", err)
+			}
+			return nil
+		}
 		// If no headers were received, but we're expending a DAO fork check, maybe it's that
 		if len(headers) == 0 && p.forkDrop != nil {
 			// Possibly an empty reply to the fork header checks, sanity check TDs
@@ -457,7 +868,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			// Irrelevant of the fork checks, send the header to the fetcher just in case
 			headers = pm.fetcher.FilterHeaders(p.id, headers, time.Now())
 		}
-		if len(headers) > 0 || !filter {
+		if route == routeByContent && (len(headers) > 0 || !filter) {
 			err := pm.downloader.DeliverHeaders(p.id, headers)
 			if err != nil {
 				log.Debug("Failed to deliver headers", "err", err)
@@ -466,8 +877,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 
 	case msg.Code == GetBlockBodiesMsg:
 		// Decode the retrieval message
-		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
-		if _, err := msgStream.List(); err != nil {
+		msgStream, reqID, err := requestStream(p, msg)
+		if err != nil {
 			return err
 		}
 		// Gather blocks until the fetch or network limits is reached
@@ -489,14 +900,19 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(data)
 			}
 		}
-		return p.SendBlockBodiesRLP(bodies)
+		return p.SendBlockBodiesRLP(reqID, bodies)
 
 	case msg.Code == BlockBodiesMsg:
 		// A batch of block bodies arrived to one of our previous requests
 		var request blockBodiesData
-		if err := msg.Decode(&request); err != nil {
+		reqID, err := decodeMsg(p, msg, &request)
+		if err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		route, consumed, err := p.resolve(reqID, msg.Code, request)
+		if consumed || err != nil {
+			return err
+		}
 		// Deliver them all to the downloader for queuing
 		trasactions := make([][]*types.Transaction, len(request))
 		uncles := make([][]*types.Header, len(request))
@@ -505,12 +921,19 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			trasactions[i] = body.Transactions
 			uncles[i] = body.Uncles
 		}
+		// Responses to the downloader's own requests bypass the fetcher filters
+		if route == routeDownloader {
+			if err := pm.downloader.DeliverBodies(p.id, trasactions, uncles); err != nil {
+				log.Debug("Failed to deliver bodies", "err", err)
+			}
+			return nil
+		}
 		// Filter out any explicitly requested bodies, deliver the rest to the downloader
 		filter := len(trasactions) > 0 || len(uncles) > 0
 		if filter {
 			trasactions, uncles = pm.fetcher.FilterBodies(p.id, trasactions, uncles, time.Now())
 		}
-		if len(trasactions) > 0 || len(uncles) > 0 || !filter {
+		if route == routeByContent && (len(trasactions) > 0 || len(uncles) > 0 || !filter) {
 			err := pm.downloader.DeliverBodies(p.id, trasactions, uncles)
 			if err != nil {
 				log.Debug("Failed to deliver bodies", "err", err)
@@ -519,8 +942,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 
 	case p.version >= tst63 && msg.Code == GetNodeDataMsg:
 		// Decode the retrieval message
-		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
-		if _, err := msgStream.List(); err != nil {
+		msgStream, reqID, err := requestStream(p, msg)
+		if err != nil {
 			return err
 		}
 		// Gather state data until the fetch or network limits is reached
@@ -542,14 +965,18 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(entry)
 			}
 		}
-		return p.SendNodeData(data)
+		return p.SendNodeData(reqID, data)
 
 	case p.version >= tst63 && msg.Code == NodeDataMsg:
 		// A batch of node state data arrived to one of our previous requests
 		var data [][]byte
-		if err := msg.Decode(&data); err != nil {
+		reqID, err := decodeMsg(p, msg, &data)
+		if err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		if _, consumed, err := p.resolve(reqID, msg.Code, data); consumed || err != nil {
+			return err
+		}
 		// Deliver all to the downloader
 		if err := pm.downloader.DeliverNodeData(p.id, data); err != nil {
 			log.Debug("Failed to deliver node state data", "err", err)
@@ -557,8 +984,8 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 
 	case p.version >= tst63 && msg.Code == GetReceiptsMsg:
 		// Decode the retrieval message
-		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
-		if _, err := msgStream.List(); err != nil {
+		msgStream, reqID, err := requestStream(p, msg)
+		if err != nil {
 			return err
 		}
 		// Gather state data until the fetch or network limits is reached
@@ -589,14 +1016,18 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(encoded)
 			}
 		}
-		return p.SendReceiptsRLP(receipts)
+		return p.SendReceiptsRLP(reqID, receipts)
 
 	case p.version >= tst63 && msg.Code == ReceiptsMsg:
 		// A batch of receipts arrived to one of our previous requests
 		var receipts [][]*types.Receipt
-		if err := msg.Decode(&receipts); err != nil {
+		reqID, err := decodeMsg(p, msg, &receipts)
+		if err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		if _, consumed, err := p.resolve(reqID, msg.Code, receipts); consumed || err != nil {
+			return err
+		}
 		// Deliver all to the downloader
 		if err := pm.downloader.DeliverReceipts(p.id, receipts); err != nil {
 			log.Debug("Failed to deliver receipts", "err", err)
@@ -619,7 +1050,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			}
 		}
 		for _, block := range unknown {
-			pm.fetcher.Notify(p.id, block.Hash, block.Number, time.Now(), p.RequestOneHeader, p.RequestBodies)
+			pm.fetcher.Notify(p.id, block.Hash, block.Number, time.Now(), p.RequestOneHeader, p.RequestAnnouncedBodies)
 		}
 
 	case msg.Code == NewBlockMsg:
@@ -671,7 +1102,76 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			}
 			p.MarkTransaction(tx.Hash())
 		}
-		pm.txpool.AddRemotes(txs)
+		pm.txFetcher.Enqueue(p.id, txs, true)
+
+	case p.version >= tst64 && msg.Code == NewPooledTransactionHashesMsg:
+		// New transactions were announced, make sure we're synchronised to handle them
+		if atomic.LoadUint32(&pm.acceptTxs) == 0 {
+			break
+		}
+		var hashes []common.Hash
+		if err := msg.Decode(&hashes); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		for _, hash := range hashes {
+			p.MarkTransaction(hash)
+		}
+		pm.txFetcher.Notify(p.id, hashes)
+
+	case p.version >= tst64 && msg.Code == GetPooledTransactionsMsg:
+		// Decode the retrieval message
+		msgStream, reqID, err := requestStream(p, msg)
+		if err != nil {
+			return err
+		}
+		// Gather transactions until the fetch or network limits is reached
+		var (
+			hash   common.Hash
+			bytes  int
+			hashes []common.Hash
+			txs    []rlp.RawValue
+		)
+		for bytes < softResponseLimit {
+			// Retrieve the hash of the next transaction
+			if err := msgStream.Decode(&hash); err == rlp.EOL {
+				break
+			} else if err != nil {
+				return errResp(ErrDecode, "msg %v: %v", msg, err)
+			}
+			// Retrieve the requested transaction, skipping if unknown to us or private
+			tx := pm.txpool.Get(hash)
+			if tx == nil || pm.txpool.IsPrivate(hash) {
+				continue
+			}
+			if encoded, err := rlp.EncodeToBytes(tx); err != nil {
+				log.Error("Failed to encode transaction", "err", err)
+			} else {
+				hashes = append(hashes, hash)
+				txs = append(txs, encoded)
+				bytes += len(encoded)
+			}
+		}
+		return p.SendPooledTransactionsRLP(reqID, hashes, txs)
+
+	case p.version >= tst64 && msg.Code == PooledTransactionsMsg:
+		// Requested transactions arrived, match them up with their request
+		var txs []*types.Transaction
+		reqID, err := decodeMsg(p, msg, &txs)
+		if err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if _, consumed, err := p.resolve(reqID, msg.Code, txs); consumed || err != nil {
+			return err
+		}
+		// Deliver them to the fetcher even if we're not synchronised, so it can
+		// clear the request. The pool insertion is skipped by the fetcher then.
+		for i, tx := range txs {
+			if tx == nil {
+				return errResp(ErrDecode, "transaction %d is nil", i)
+			}
+			p.MarkTransaction(tx.Hash())
+		}
+		pm.txFetcher.Enqueue(p.id, txs, false)
 
 	default:
 		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
@@ -712,16 +1212,25 @@ func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
 	}
 }
 
-// BroadcastTx will propagate a transaction to all peers which are not known to
-// already have the given transaction.
+// BroadcastTx will propagate a transaction to a square root subset of the peers
+// which are not known to already have the given transaction, and announce it to
+// the rest of them. Peers on protocols predating announcements can't retrieve
+// transactions themselves, so they always receive the full transaction.
 func (pm *ProtocolManager) BroadcastTx(hash common.Hash, tx *types.Transaction) {
-	// Broadcast transaction to a batch of peers not knowing about it
-	peers := pm.peers.PeersWithoutTx(hash)
-	//FIXME include this again: peers = peers[:int(math.Sqrt(float64(len(peers))))]
-	for _, peer := range peers {
-		peer.SendTransactions(types.Transactions{tx})
+	var (
+		peers    = pm.peers.PeersWithoutTx(hash)
+		direct   = int(math.Sqrt(float64(len(peers))))
+		announce int
+	)
+	for i, peer := range peers {
+		if i < direct || peer.version < tst64 {
+			peer.SendTransactions(types.Transactions{tx})
+			continue
+		}
+		peer.SendPooledTransactionHashes([]common.Hash{hash})
+		announce++
 	}
-	log.Trace("Broadcast transaction", "hash", hash, "recipients", len(peers))
+	log.Trace("Broadcast transaction", "hash", hash, "recipients", len(peers)-announce, "announced", announce)
 }
 
 // Mined broadcast loop
@@ -740,6 +1249,10 @@ func (self *ProtocolManager) txBroadcastLoop() {
 	for {
 		select {
 		case event := <-self.txCh:
+			// Private transactions are never propagated
+			if self.txpool.IsPrivate(event.Tx.Hash()) {
+				continue
+			}
 			self.BroadcastTx(event.Tx.Hash(), event.Tx)
 
 		// Err() channel will be closed when unsubscribing.
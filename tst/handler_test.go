@@ -69,6 +69,8 @@ func TestProtocolCompatibility(t *testing.T) {
 // Tests that block headers can be retrieved from a remote chain based on user queries.
 func TestGetBlockHeaders62(t *testing.T) { testGetBlockHeaders(t, 62) }
 func TestGetBlockHeaders63(t *testing.T) { testGetBlockHeaders(t, 63) }
+func TestGetBlockHeaders65(t *testing.T) { testGetBlockHeaders(t, 65) }
+func TestGetBlockHeaders66(t *testing.T) { testGetBlockHeaders(t, 66) }
 
 func testGetBlockHeaders(t *testing.T, protocol int) {
 	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, downloader.MaxHashFetch+15, nil, nil)
@@ -207,8 +209,8 @@ func testGetBlockHeaders(t *testing.T, protocol int) {
 			headers = append(headers, pm.blockchain.GetBlockByHash(hash).Header())
 		}
 		// Send the hash request and verify the response
-		p2p.Send(peer.app, 0x03, tt.query)
-		if err := p2p.ExpectMsg(peer.app, 0x04, headers); err != nil {
+		peer.send(0x03, tt.query)
+		if err := peer.expect(0x04, headers); err != nil {
 			t.Errorf("test %d: headers mismatch: %v", i, err)
 		}
 		// If the test used number origins, repeat with hashes as the too
@@ -216,8 +218,8 @@ func testGetBlockHeaders(t *testing.T, protocol int) {
 			if origin := pm.blockchain.GetBlockByNumber(tt.query.Origin.Number); origin != nil {
 				tt.query.Origin.Hash, tt.query.Origin.Number = origin.Hash(), 0
 
-				p2p.Send(peer.app, 0x03, tt.query)
-				if err := p2p.ExpectMsg(peer.app, 0x04, headers); err != nil {
+				peer.send(0x03, tt.query)
+				if err := peer.expect(0x04, headers); err != nil {
 					t.Errorf("test %d: headers mismatch: %v", i, err)
 				}
 			}
@@ -228,6 +230,8 @@ func testGetBlockHeaders(t *testing.T, protocol int) {
 // Tests that block contents can be retrieved from a remote chain based on their hashes.
 func TestGetBlockBodies62(t *testing.T) { testGetBlockBodies(t, 62) }
 func TestGetBlockBodies63(t *testing.T) { testGetBlockBodies(t, 63) }
+func TestGetBlockBodies65(t *testing.T) { testGetBlockBodies(t, 65) }
+func TestGetBlockBodies66(t *testing.T) { testGetBlockBodies(t, 66) }
 
 func testGetBlockBodies(t *testing.T, protocol int) {
 	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, downloader.MaxBlockFetch+15, nil, nil)
@@ -290,8 +294,8 @@ func testGetBlockBodies(t *testing.T, protocol int) {
 			}
 		}
 		// Send the hash request and verify the response
-		p2p.Send(peer.app, 0x05, hashes)
-		if err := p2p.ExpectMsg(peer.app, 0x06, bodies); err != nil {
+		peer.send(0x05, hashes)
+		if err := peer.expect(0x06, bodies); err != nil {
 			t.Errorf("test %d: bodies mismatch: %v", i, err)
 		}
 	}
@@ -299,6 +303,8 @@ func testGetBlockBodies(t *testing.T, protocol int) {
 
 // Tests that the node state database can be retrieved based on hashes.
 func TestGetNodeData63(t *testing.T) { testGetNodeData(t, 63) }
+func TestGetNodeData65(t *testing.T) { testGetNodeData(t, 65) }
+func TestGetNodeData66(t *testing.T) { testGetNodeData(t, 66) }
 
 func testGetNodeData(t *testing.T, protocol int) {
 	// Define three accounts to simulate transactions with
@@ -348,7 +354,7 @@ func testGetNodeData(t *testing.T, protocol int) {
 			hashes = append(hashes, common.BytesToHash(key))
 		}
 	}
-	p2p.Send(peer.app, 0x0d, hashes)
+	peer.send(0x0d, hashes)
 	msg, err := peer.app.ReadMsg()
 	if err != nil {
 		t.Fatalf("failed to read node data response: %v", err)
@@ -357,7 +363,7 @@ func testGetNodeData(t *testing.T, protocol int) {
 		t.Fatalf("response packet code mismatch: have %x, want %x", msg.Code, 0x0c)
 	}
 	var data [][]byte
-	if err := msg.Decode(&data); err != nil {
+	if _, err := decodeMsg(peer.peer, msg, &data); err != nil {
 		t.Fatalf("failed to decode response node data: %v", err)
 	}
 	// Verify that all hashes correspond to the requested data, and reconstruct a state tree
@@ -391,6 +397,8 @@ func testGetNodeData(t *testing.T, protocol int) {
 
 // Tests that the transaction receipts can be retrieved based on hashes.
 func TestGetReceipt63(t *testing.T) { testGetReceipt(t, 63) }
+func TestGetReceipt65(t *testing.T) { testGetReceipt(t, 65) }
+func TestGetReceipt66(t *testing.T) { testGetReceipt(t, 66) }
 
 func testGetReceipt(t *testing.T, protocol int) {
 	// Define three accounts to simulate transactions with
@@ -442,8 +450,8 @@ func testGetReceipt(t *testing.T, protocol int) {
 		receipts = append(receipts, pm.blockchain.GetReceiptsByHash(block.Hash()))
 	}
 	// Send the hash request and verify the response
-	p2p.Send(peer.app, 0x0f, hashes)
-	if err := p2p.ExpectMsg(peer.app, 0x10, receipts); err != nil {
+	peer.send(0x0f, hashes)
+	if err := peer.expect(0x10, receipts); err != nil {
 		t.Errorf("receipts mismatch: %v", err)
 	}
 }
@@ -451,14 +459,20 @@ func testGetReceipt(t *testing.T, protocol int) {
 // Tests that post tst protocol handshake, DAO fork-enabled clients also execute
 // a DAO "challenge" verifying each others' DAO fork headers to ensure they're on
 // compatible chains.
-func TestDAOChallengeNoVsNo(t *testing.T)       { testDAOChallenge(t, false, false, false) }
-func TestDAOChallengeNoVsPro(t *testing.T)      { testDAOChallenge(t, false, true, false) }
-func TestDAOChallengeProVsNo(t *testing.T)      { testDAOChallenge(t, true, false, false) }
-func TestDAOChallengeProVsPro(t *testing.T)     { testDAOChallenge(t, true, true, false) }
-func TestDAOChallengeNoVsTimeout(t *testing.T)  { testDAOChallenge(t, false, false, true) }
-func TestDAOChallengeProVsTimeout(t *testing.T) { testDAOChallenge(t, true, true, true) }
+func TestDAOChallengeNoVsNo63(t *testing.T)       { testDAOChallenge(t, 63, false, false, false) }
+func TestDAOChallengeNoVsPro63(t *testing.T)      { testDAOChallenge(t, 63, false, true, false) }
+func TestDAOChallengeProVsNo63(t *testing.T)      { testDAOChallenge(t, 63, true, false, false) }
+func TestDAOChallengeProVsPro63(t *testing.T)     { testDAOChallenge(t, 63, true, true, false) }
+func TestDAOChallengeNoVsTimeout63(t *testing.T)  { testDAOChallenge(t, 63, false, false, true) }
+func TestDAOChallengeProVsTimeout63(t *testing.T) { testDAOChallenge(t, 63, true, true, true) }
+func TestDAOChallengeNoVsNo65(t *testing.T)       { testDAOChallenge(t, 65, false, false, false) }
+func TestDAOChallengeNoVsPro65(t *testing.T)      { testDAOChallenge(t, 65, false, true, false) }
+func TestDAOChallengeProVsNo65(t *testing.T)      { testDAOChallenge(t, 65, true, false, false) }
+func TestDAOChallengeProVsPro65(t *testing.T)     { testDAOChallenge(t, 65, true, true, false) }
+func TestDAOChallengeNoVsTimeout65(t *testing.T)  { testDAOChallenge(t, 65, false, false, true) }
+func TestDAOChallengeProVsTimeout65(t *testing.T) { testDAOChallenge(t, 65, true, true, true) }
 
-func testDAOChallenge(t *testing.T, localForked, remoteForked bool, timeout bool) {
+func testDAOChallenge(t *testing.T, protocol int, localForked, remoteForked bool, timeout bool) {
 	// Reduce the DAO handshake challenge timeout
 	if timeout {
 		defer func(old time.Duration) { daoChallengeTimeout = old }(daoChallengeTimeout)
@@ -482,7 +496,7 @@ func testDAOChallenge(t *testing.T, localForked, remoteForked bool, timeout bool
 	defer pm.Stop()
 
 	// Connect a new peer and check that we receive the DAO challenge
-	peer, _ := newTestPeer("peer", tst63, pm, true)
+	peer, _ := newTestPeer("peer", protocol, pm, true)
 	defer peer.close()
 
 	challenge := &getBlockHeadersData{
@@ -491,7 +505,14 @@ func testDAOChallenge(t *testing.T, localForked, remoteForked bool, timeout bool
 		Skip:    0,
 		Reverse: false,
 	}
-	if err := p2p.ExpectMsg(peer.app, GetBlockHeadersMsg, challenge); err != nil {
+	var (
+		expect interface{} = challenge
+		reply  interface{}
+	)
+	if protocol >= tst65 {
+		expect = []interface{}{uint64(0), challenge} // First request of the local peer
+	}
+	if err := p2p.ExpectMsg(peer.app, GetBlockHeadersMsg, expect); err != nil {
 		t.Fatalf("challenge mismatch: %v", err)
 	}
 	// Create a block to reply to the challenge if no timeout is simulated
@@ -501,7 +522,11 @@ func testDAOChallenge(t *testing.T, localForked, remoteForked bool, timeout bool
 				block.SetExtra(params.DAOForkBlockExtra)
 			}
 		})
-		if err := p2p.Send(peer.app, BlockHeadersMsg, []*types.Header{blocks[0].Header()}); err != nil {
+		reply = []*types.Header{blocks[0].Header()}
+		if protocol >= tst65 {
+			reply = []interface{}{uint64(0), reply}
+		}
+		if err := p2p.Send(peer.app, BlockHeadersMsg, reply); err != nil {
 			t.Fatalf("failed to answer challenge: %v", err)
 		}
 		time.Sleep(100 * time.Millisecond) // Sleep to avoid the verification racing with the drops
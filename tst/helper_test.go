@@ -30,6 +30,7 @@ import (
 	"github.com/utchain/go-utchain/common"
 	"github.com/utchain/go-utchain/consensus/ethash"
 	"github.com/utchain/go-utchain/core"
+	"github.com/utchain/go-utchain/core/forkid"
 	"github.com/utchain/go-utchain/core/types"
 	"github.com/utchain/go-utchain/core/vm"
 	"github.com/utchain/go-utchain/crypto"
@@ -88,9 +89,10 @@ func newTestProtocolManagerMust(t *testing.T, mode downloader.SyncMode, blocks i
 
 // testTxPool is a fake, helper transaction pool for testing purposes
 type testTxPool struct {
-	txFeed event.Feed
-	pool   []*types.Transaction        // Collection of all transactions
-	added  chan<- []*types.Transaction // Notification channel for new transactions
+	txFeed  event.Feed
+	pool    []*types.Transaction        // Collection of all transactions
+	private map[common.Hash]bool        // Transactions withheld from the network
+	added   chan<- []*types.Transaction // Notification channel for new transactions
 
 	lock sync.RWMutex // Protects the transaction pool
 }
@@ -124,6 +126,27 @@ func (p *testTxPool) Pending() (map[common.Address]types.Transactions, error) {
 	return batches, nil
 }
 
+// Get retrieves the transaction from the pool with the given hash.
+func (p *testTxPool) Get(hash common.Hash) *types.Transaction {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	for _, tx := range p.pool {
+		if tx.Hash() == hash {
+			return tx
+		}
+	}
+	return nil
+}
+
+// IsPrivate returns whether a transaction is marked as withheld from the network.
+func (p *testTxPool) IsPrivate(hash common.Hash) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.private[hash]
+}
+
 func (p *testTxPool) SubscribeTxPreEvent(ch chan<- core.TxPreEvent) event.Subscription {
 	return p.txFeed.Subscribe(ch)
 }
@@ -171,21 +194,31 @@ func newTestPeer(name string, version int, pm *ProtocolManager, shake bool) (*te
 			head    = pm.blockchain.CurrentHeader()
 			td      = pm.blockchain.GetTd(head.Hash(), head.Number.Uint64())
 		)
-		tp.handshake(nil, td, head.Hash(), genesis.Hash())
+		tp.handshake(nil, td, head.Hash(), genesis.Hash(), forkid.NewID(pm.blockchain))
 	}
 	return tp, errc
 }
 
 // handshake simulates a trivial handshake that expects the same state from the
 // remote side as we are simulating locally.
-func (p *testPeer) handshake(t *testing.T, td *big.Int, head common.Hash, genesis common.Hash) {
-	msg := &statusData{
+func (p *testPeer) handshake(t *testing.T, td *big.Int, head common.Hash, genesis common.Hash, forkID forkid.ID) {
+	var msg interface{} = &statusData{
 		ProtocolVersion: uint32(p.version),
 		NetworkId:       DefaultConfig.NetworkId,
 		TD:              td,
 		CurrentBlock:    head,
 		GenesisBlock:    genesis,
 	}
+	if p.version >= tst66 {
+		msg = &statusData66{
+			ProtocolVersion: uint32(p.version),
+			NetworkId:       DefaultConfig.NetworkId,
+			TD:              td,
+			CurrentBlock:    head,
+			GenesisBlock:    genesis,
+			ForkID:          forkID,
+		}
+	}
 	if err := p2p.ExpectMsg(p.app, StatusMsg, msg); err != nil {
 		t.Fatalf("status recv: %v", err)
 	}
@@ -194,6 +227,28 @@ func (p *testPeer) handshake(t *testing.T, td *big.Int, head common.Hash, genesi
 	}
 }
 
+// testRequestID is the request ID used by the test peers on protocol versions
+// wrapping requests and responses into envelopes.
+const testRequestID = 42
+
+// send sends a retrieval request or response to the local side, wrapping it into
+// an envelope on protocol versions with request IDs.
+func (p *testPeer) send(code uint64, data interface{}) error {
+	if p.version >= tst65 {
+		return p2p.Send(p.app, code, []interface{}{uint64(testRequestID), data})
+	}
+	return p2p.Send(p.app, code, data)
+}
+
+// expect checks that the next message from the local side is the given response,
+// wrapped into an envelope on protocol versions with request IDs.
+func (p *testPeer) expect(code uint64, data interface{}) error {
+	if p.version >= tst65 {
+		return p2p.ExpectMsg(p.app, code, []interface{}{uint64(testRequestID), data})
+	}
+	return p2p.ExpectMsg(p.app, code, data)
+}
+
 // close terminates the local side of the peer, notifying the remote protocol
 // manager of termination.
 func (p *testPeer) close() {
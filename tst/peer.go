@@ -24,6 +24,7 @@ import (
 	"time"
 
 	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/forkid"
 	"github.com/utchain/go-utchain/core/types"
 	"github.com/utchain/go-utchain/p2p"
 	"github.com/utchain/go-utchain/rlp"
@@ -34,12 +35,21 @@ var (
 	errClosed            = errors.New("peer set is closed")
 	errAlreadyRegistered = errors.New("peer is already registered")
 	errNotRegistered     = errors.New("peer is not registered")
+
+	errRequestTimeout = errors.New("request timed out")
+	errPeerClosed     = errors.New("peer closed")
+	errNoRequestIDs   = errors.New("protocol version has no request IDs")
 )
 
 const (
 	maxKnownTxs      = 32768 // Maximum transactions hashes to keep in the known list (prevent DOS)
 	maxKnownBlocks   = 1024  // Maximum block hashes to keep in the known list (prevent DOS)
 	handshakeTimeout = 5 * time.Second
+
+	// requestTTL is the time allowance for responses to requests not having an
+	// explicit timeout, after which late responses are discarded. Such requests
+	// are issued by the downloader and fetchers, which track their own timeouts.
+	requestTTL = time.Minute
 )
 
 // PeerInfo represents a short summary of the UTChain sub-protocol metadata known
@@ -65,6 +75,35 @@ type peer struct {
 
 	knownTxs    *set.Set // Set of transaction hashes known to be known by this peer
 	knownBlocks *set.Set // Set of block hashes known to be known by this peer
+
+	nextReqID uint64              // Request ID to assign to the next request
+	requests  map[uint64]*request // In-flight requests waiting for a response
+	reqLock   sync.Mutex          // Mutex protecting the in-flight requests
+}
+
+// requestRoute identifies the component a response without a sink is handed to
+// by the handler.
+type requestRoute int
+
+const (
+	routeByContent  requestRoute = iota // Protocol without request IDs, routed on the response contents
+	routeFetcher                        // Announcement driven retrieval of blocks and transactions
+	routeDownloader                     // Chain synchronisation
+)
+
+// request is a retrieval request sent to a peer supporting request IDs, waiting
+// for a response.
+type request struct {
+	code  uint64           // Message code of the expected response
+	route requestRoute     // Component to hand the response to if there's no sink
+	sink  chan<- *response // Channel to deliver the response on, nil if the handler delivers it
+	timer *time.Timer      // Timer expiring the request if no response arrives
+}
+
+// response is the reply to a request with a sink, or the error why none arrived.
+type response struct {
+	Data interface{} // Decoded payload of the response message
+	Err  error       // Error if the request failed (timeout, disconnect)
 }
 
 func newPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
@@ -77,6 +116,7 @@ func newPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
 		id:          fmt.Sprintf("%x", id[:8]),
 		knownTxs:    set.New(),
 		knownBlocks: set.New(),
+		requests:    make(map[uint64]*request),
 	}
 }
 
@@ -130,6 +170,122 @@ func (p *peer) MarkTransaction(hash common.Hash) {
 	p.knownTxs.Add(hash)
 }
 
+// dispatch sends a retrieval request to the peer. On protocol versions with
+// request IDs, the request is tracked until the response with the given code
+// arrives or the timeout expires. If a sink is given, the response or failure
+// is delivered on it, otherwise the response is left for the handler to route
+// to the component on the given route.
+func (p *peer) dispatch(code, resCode uint64, data interface{}, route requestRoute, sink chan<- *response, timeout time.Duration) error {
+	if p.version < tst65 {
+		if sink != nil {
+			return errNoRequestIDs
+		}
+		return p2p.Send(p.rw, code, data)
+	}
+	p.reqLock.Lock()
+	if p.requests == nil {
+		p.reqLock.Unlock()
+		return errPeerClosed
+	}
+	// The downloader keeps at most one request of each type in flight to a peer,
+	// so any still pending one was given up on. Drop it so that a late response
+	// isn't mistaken for the answer to the new request.
+	if route == routeDownloader {
+		for id, req := range p.requests {
+			if req.route == routeDownloader && req.code == resCode {
+				req.timer.Stop()
+				delete(p.requests, id)
+			}
+		}
+	}
+	id := p.nextReqID
+	p.nextReqID++
+
+	req := &request{code: resCode, route: route, sink: sink}
+	req.timer = time.AfterFunc(timeout, func() { p.expire(id) })
+	p.requests[id] = req
+	p.reqLock.Unlock()
+
+	if err := p2p.Send(p.rw, code, []interface{}{id, data}); err != nil {
+		p.reqLock.Lock()
+		delete(p.requests, id)
+		p.reqLock.Unlock()
+
+		req.timer.Stop()
+		return err
+	}
+	return nil
+}
+
+// resolve matches up a response with its pending request on protocol versions
+// with request IDs, returning whether the response was consumed. It is consumed
+// if it was delivered to the sink of the request, or if it answers an unknown,
+// expired or superseded request and must be discarded. Otherwise the handler
+// should hand it to the component on the returned route. An error is returned
+// if the peer responded with the wrong message type.
+func (p *peer) resolve(id uint64, code uint64, data interface{}) (requestRoute, bool, error) {
+	if p.version < tst65 {
+		return routeByContent, false, nil
+	}
+	p.reqLock.Lock()
+	req := p.requests[id]
+	if req == nil {
+		p.reqLock.Unlock()
+		p.Log().Debug("Discarded unrequested response", "id", id, "code", code)
+		return routeByContent, true, nil
+	}
+	if req.code != code {
+		p.reqLock.Unlock()
+		return routeByContent, false, errResp(ErrUnexpectedResponse, "request %d: code %x (!= %x)", id, code, req.code)
+	}
+	delete(p.requests, id)
+	p.reqLock.Unlock()
+
+	req.timer.Stop()
+	if req.sink == nil {
+		return req.route, false, nil
+	}
+	req.sink <- &response{Data: data}
+	return req.route, true, nil
+}
+
+// expire drops a request if it's still pending, notifying its sink.
+func (p *peer) expire(id uint64) {
+	p.reqLock.Lock()
+	req := p.requests[id]
+	delete(p.requests, id)
+	p.reqLock.Unlock()
+
+	if req != nil && req.sink != nil {
+		req.sink <- &response{Err: errRequestTimeout}
+	}
+}
+
+// closeRequests fails all the pending requests and disables dispatching any new
+// ones. It is called when the peer disconnects.
+func (p *peer) closeRequests() {
+	p.reqLock.Lock()
+	requests := p.requests
+	p.requests = nil
+	p.reqLock.Unlock()
+
+	for _, req := range requests {
+		req.timer.Stop()
+		if req.sink != nil {
+			req.sink <- &response{Err: errPeerClosed}
+		}
+	}
+}
+
+// reply sends a response message to the peer, wrapping it into an envelope with
+// the ID of the request it answers on protocol versions supporting it.
+func (p *peer) reply(code uint64, id uint64, data interface{}) error {
+	if p.version < tst65 {
+		return p2p.Send(p.rw, code, data)
+	}
+	return p2p.Send(p.rw, code, []interface{}{id, data})
+}
+
 // SendTransactions sends transactions to the peer and includes the hashes
 // in its transaction hash set for future reference.
 func (p *peer) SendTransactions(txs types.Transactions) error {
@@ -139,6 +295,25 @@ func (p *peer) SendTransactions(txs types.Transactions) error {
 	return p2p.Send(p.rw, TxMsg, txs)
 }
 
+// SendPooledTransactionHashes announces the availability of a batch of
+// transactions through a hash notification, leaving it to the remote peer to
+// retrieve the ones it does not know about.
+func (p *peer) SendPooledTransactionHashes(hashes []common.Hash) error {
+	for _, hash := range hashes {
+		p.MarkTransaction(hash)
+	}
+	return p2p.Send(p.rw, NewPooledTransactionHashesMsg, hashes)
+}
+
+// SendPooledTransactionsRLP sends a batch of requested transactions to the peer
+// from an already RLP encoded format.
+func (p *peer) SendPooledTransactionsRLP(id uint64, hashes []common.Hash, txs []rlp.RawValue) error {
+	for _, hash := range hashes {
+		p.MarkTransaction(hash)
+	}
+	return p.reply(PooledTransactionsMsg, id, txs)
+}
+
 // SendNewBlockHashes announces the availability of a number of blocks through
 // a hash notification.
 func (p *peer) SendNewBlockHashes(hashes []common.Hash, numbers []uint64) error {
@@ -160,82 +335,117 @@ func (p *peer) SendNewBlock(block *types.Block, td *big.Int) error {
 }
 
 // SendBlockHeaders sends a batch of block headers to the remote peer.
-func (p *peer) SendBlockHeaders(headers []*types.Header) error {
-	return p2p.Send(p.rw, BlockHeadersMsg, headers)
+func (p *peer) SendBlockHeaders(id uint64, headers []*types.Header) error {
+	return p.reply(BlockHeadersMsg, id, headers)
 }
 
 // SendBlockBodies sends a batch of block contents to the remote peer.
-func (p *peer) SendBlockBodies(bodies []*blockBody) error {
-	return p2p.Send(p.rw, BlockBodiesMsg, blockBodiesData(bodies))
+func (p *peer) SendBlockBodies(id uint64, bodies []*blockBody) error {
+	return p.reply(BlockBodiesMsg, id, blockBodiesData(bodies))
 }
 
 // SendBlockBodiesRLP sends a batch of block contents to the remote peer from
 // an already RLP encoded format.
-func (p *peer) SendBlockBodiesRLP(bodies []rlp.RawValue) error {
-	return p2p.Send(p.rw, BlockBodiesMsg, bodies)
+func (p *peer) SendBlockBodiesRLP(id uint64, bodies []rlp.RawValue) error {
+	return p.reply(BlockBodiesMsg, id, bodies)
 }
 
 // SendNodeDataRLP sends a batch of arbitrary internal data, corresponding to the
 // hashes requested.
-func (p *peer) SendNodeData(data [][]byte) error {
-	return p2p.Send(p.rw, NodeDataMsg, data)
+func (p *peer) SendNodeData(id uint64, data [][]byte) error {
+	return p.reply(NodeDataMsg, id, data)
 }
 
 // SendReceiptsRLP sends a batch of transaction receipts, corresponding to the
 // ones requested from an already RLP encoded format.
-func (p *peer) SendReceiptsRLP(receipts []rlp.RawValue) error {
-	return p2p.Send(p.rw, ReceiptsMsg, receipts)
+func (p *peer) SendReceiptsRLP(id uint64, receipts []rlp.RawValue) error {
+	return p.reply(ReceiptsMsg, id, receipts)
 }
 
 // RequestOneHeader is a wrapper around the header query functions to fetch a
 // single header. It is used solely by the fetcher.
 func (p *peer) RequestOneHeader(hash common.Hash) error {
 	p.Log().Debug("Fetching single header", "hash", hash)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: hash}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
+	return p.dispatch(GetBlockHeadersMsg, BlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: hash}, Amount: uint64(1), Skip: uint64(0), Reverse: false}, routeFetcher, nil, requestTTL)
 }
 
 // RequestHeadersByHash fetches a batch of blocks' headers corresponding to the
 // specified header query, based on the hash of an origin block.
 func (p *peer) RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error {
 	p.Log().Debug("Fetching batch of headers", "count", amount, "fromhash", origin, "skip", skip, "reverse", reverse)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+	return p.dispatch(GetBlockHeadersMsg, BlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse}, routeDownloader, nil, requestTTL)
 }
 
 // RequestHeadersByNumber fetches a batch of blocks' headers corresponding to the
 // specified header query, based on the number of an origin block.
 func (p *peer) RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error {
 	p.Log().Debug("Fetching batch of headers", "count", amount, "fromnum", origin, "skip", skip, "reverse", reverse)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+	return p.dispatch(GetBlockHeadersMsg, BlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse}, routeDownloader, nil, requestTTL)
 }
 
 // RequestBodies fetches a batch of blocks' bodies corresponding to the hashes
 // specified.
 func (p *peer) RequestBodies(hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of block bodies", "count", len(hashes))
-	return p2p.Send(p.rw, GetBlockBodiesMsg, hashes)
+	return p.dispatch(GetBlockBodiesMsg, BlockBodiesMsg, hashes, routeDownloader, nil, requestTTL)
+}
+
+// RequestAnnouncedBodies fetches the bodies of a batch of announced blocks like
+// RequestBodies, but routes the response to the fetcher. It is used solely by
+// the fetcher.
+func (p *peer) RequestAnnouncedBodies(hashes []common.Hash) error {
+	p.Log().Debug("Fetching batch of announced block bodies", "count", len(hashes))
+	return p.dispatch(GetBlockBodiesMsg, BlockBodiesMsg, hashes, routeFetcher, nil, requestTTL)
 }
 
 // RequestNodeData fetches a batch of arbitrary data from a node's known state
 // data, corresponding to the specified hashes.
 func (p *peer) RequestNodeData(hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of state data", "count", len(hashes))
-	return p2p.Send(p.rw, GetNodeDataMsg, hashes)
+	return p.dispatch(GetNodeDataMsg, NodeDataMsg, hashes, routeDownloader, nil, requestTTL)
 }
 
 // RequestReceipts fetches a batch of transaction receipts from a remote node.
 func (p *peer) RequestReceipts(hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of receipts", "count", len(hashes))
-	return p2p.Send(p.rw, GetReceiptsMsg, hashes)
+	return p.dispatch(GetReceiptsMsg, ReceiptsMsg, hashes, routeDownloader, nil, requestTTL)
+}
+
+// RequestTxs fetches a batch of transactions from a remote node's pool.
+func (p *peer) RequestTxs(hashes []common.Hash) error {
+	p.Log().Debug("Fetching batch of transactions", "count", len(hashes))
+	return p.dispatch(GetPooledTransactionsMsg, PooledTransactionsMsg, hashes, routeFetcher, nil, requestTTL)
+}
+
+// requestHeadersByNumber fetches a batch of headers like RequestHeadersByNumber,
+// but delivers the response on the given sink instead of the downloader. It is
+// only supported on protocol versions with request IDs. The sink must have room
+// for the single response.
+func (p *peer) requestHeadersByNumber(origin uint64, amount int, skip int, reverse bool, sink chan<- *response, timeout time.Duration) error {
+	p.Log().Debug("Fetching batch of headers", "count", amount, "fromnum", origin, "skip", skip, "reverse", reverse)
+	return p.dispatch(GetBlockHeadersMsg, BlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse}, routeByContent, sink, timeout)
 }
 
 // Handshake executes the tst protocol handshake, negotiating version number,
-// network IDs, difficulties, head and genesis blocks.
-func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash) error {
+// network IDs, difficulties, head and genesis blocks. From tst/66 onwards the
+// fork IDs are exchanged too, rejecting the peer if the filter refuses its one.
+func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis common.Hash, forkID forkid.ID, forkFilter forkid.Filter) error {
 	// Send out own handshake in a new thread
 	errc := make(chan error, 2)
 	var status statusData // safe to read after two values have been received from errc
 
 	go func() {
+		if p.version >= tst66 {
+			errc <- p2p.Send(p.rw, StatusMsg, &statusData66{
+				ProtocolVersion: uint32(p.version),
+				NetworkId:       network,
+				TD:              td,
+				CurrentBlock:    head,
+				GenesisBlock:    genesis,
+				ForkID:          forkID,
+			})
+			return
+		}
 		errc <- p2p.Send(p.rw, StatusMsg, &statusData{
 			ProtocolVersion: uint32(p.version),
 			NetworkId:       network,
@@ -245,7 +455,7 @@ func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis
 		})
 	}()
 	go func() {
-		errc <- p.readStatus(network, &status, genesis)
+		errc <- p.readStatus(network, &status, genesis, forkFilter)
 	}()
 	timeout := time.NewTimer(handshakeTimeout)
 	defer timeout.Stop()
@@ -263,7 +473,7 @@ func (p *peer) Handshake(network uint64, td *big.Int, head common.Hash, genesis
 	return nil
 }
 
-func (p *peer) readStatus(network uint64, status *statusData, genesis common.Hash) (err error) {
+func (p *peer) readStatus(network uint64, status *statusData, genesis common.Hash, forkFilter forkid.Filter) (err error) {
 	msg, err := p.rw.ReadMsg()
 	if err != nil {
 		return err
@@ -275,7 +485,15 @@ func (p *peer) readStatus(network uint64, status *statusData, genesis common.Has
 		return errResp(ErrMsgTooLarge, "%v > %v", msg.Size, ProtocolMaxMsgSize)
 	}
 	// Decode the handshake and make sure everything matches
-	if err := msg.Decode(&status); err != nil {
+	var forkID *forkid.ID
+	if p.version >= tst66 {
+		var status66 statusData66
+		if err := msg.Decode(&status66); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		*status = statusData{status66.ProtocolVersion, status66.NetworkId, status66.TD, status66.CurrentBlock, status66.GenesisBlock}
+		forkID = &status66.ForkID
+	} else if err := msg.Decode(&status); err != nil {
 		return errResp(ErrDecode, "msg %v: %v", msg, err)
 	}
 	if status.GenesisBlock != genesis {
@@ -287,6 +505,11 @@ func (p *peer) readStatus(network uint64, status *statusData, genesis common.Has
 	if int(status.ProtocolVersion) != p.version {
 		return errResp(ErrProtocolVersionMismatch, "%d (!= %d)", status.ProtocolVersion, p.version)
 	}
+	if forkID != nil {
+		if err := forkFilter(*forkID); err != nil {
+			return errResp(ErrForkIDRejected, "%v", err)
+		}
+	}
 	return nil
 }
 
@@ -415,3 +638,58 @@ func (ps *peerSet) Close() {
 	}
 	ps.closed = true
 }
+
+// snapPeer is a remote peer speaking the snap protocol, serving the state in
+// contiguous account and storage ranges.
+type snapPeer struct {
+	id string
+
+	*p2p.Peer
+	rw p2p.MsgReadWriter
+}
+
+func newSnapPeer(p *p2p.Peer, rw p2p.MsgReadWriter) *snapPeer {
+	id := p.ID()
+
+	return &snapPeer{
+		Peer: p,
+		rw:   rw,
+		id:   fmt.Sprintf("%x", id[:8]),
+	}
+}
+
+// SendAccountRange sends a batch of accounts along with their range proof.
+func (p *snapPeer) SendAccountRange(id uint64, accounts []accountData, proof [][]byte) error {
+	return p2p.Send(p.rw, AccountRangeMsg, &accountRangeData{ID: id, Accounts: accounts, Proof: proof})
+}
+
+// SendStorageRanges sends batches of storage slots along with the range proof
+// of the last one.
+func (p *snapPeer) SendStorageRanges(id uint64, slots [][]storageData, proof [][]byte) error {
+	return p2p.Send(p.rw, StorageRangesMsg, &storageRangesData{ID: id, Slots: slots, Proof: proof})
+}
+
+// SendByteCodes sends a batch of contract codes.
+func (p *snapPeer) SendByteCodes(id uint64, codes [][]byte) error {
+	return p2p.Send(p.rw, ByteCodesMsg, &byteCodesData{ID: id, Codes: codes})
+}
+
+// RequestAccountRange fetches a range of accounts from the account trie of the
+// given state root.
+func (p *snapPeer) RequestAccountRange(id uint64, root common.Hash, origin, limit common.Hash, bytes uint64) error {
+	p.Log().Debug("Fetching range of accounts", "root", root, "origin", origin, "limit", limit)
+	return p2p.Send(p.rw, GetAccountRangeMsg, &getAccountRangeData{ID: id, Root: root, Origin: origin, Limit: limit, Bytes: bytes})
+}
+
+// RequestStorageRanges fetches the storage slot ranges of a batch of accounts
+// from the given state root.
+func (p *snapPeer) RequestStorageRanges(id uint64, root common.Hash, accounts []common.Hash, origin, limit []byte, bytes uint64) error {
+	p.Log().Debug("Fetching ranges of storage slots", "root", root, "accounts", len(accounts))
+	return p2p.Send(p.rw, GetStorageRangesMsg, &getStorageRangesData{ID: id, Root: root, Accounts: accounts, Origin: origin, Limit: limit, Bytes: bytes})
+}
+
+// RequestByteCodes fetches a batch of contract codes by hash.
+func (p *snapPeer) RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error {
+	p.Log().Debug("Fetching batch of contract codes", "count", len(hashes))
+	return p2p.Send(p.rw, GetByteCodesMsg, &getByteCodesData{ID: id, Hashes: hashes, Bytes: bytes})
+}
@@ -23,6 +23,7 @@ import (
 
 	"github.com/utchain/go-utchain/common"
 	"github.com/utchain/go-utchain/core"
+	"github.com/utchain/go-utchain/core/forkid"
 	"github.com/utchain/go-utchain/core/types"
 	"github.com/utchain/go-utchain/event"
 	"github.com/utchain/go-utchain/rlp"
@@ -32,16 +33,19 @@ import (
 const (
 	tst62 = 62
 	tst63 = 63
+	tst64 = 64
+	tst65 = 65 // Retrieval requests and responses wrapped into request ID envelopes
+	tst66 = 66 // Status message carrying the fork identifier of the sender's chain
 )
 
 // Official short name of the protocol used during capability negotiation.
 var ProtocolName = "tst"
 
 // Supported versions of the tst protocol (first is primary).
-var ProtocolVersions = []uint{tst63, tst62}
+var ProtocolVersions = []uint{tst66, tst65, tst64, tst63, tst62}
 
 // Number of implemented message corresponding to different protocol versions.
-var ProtocolLengths = []uint64{17, 8}
+var ProtocolLengths = []uint64{17, 17, 17, 17, 8}
 
 const ProtocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
 
@@ -62,6 +66,36 @@ const (
 	NodeDataMsg    = 0x0e
 	GetReceiptsMsg = 0x0f
 	ReceiptsMsg    = 0x10
+
+	// Protocol messages belonging to tst/64
+	NewPooledTransactionHashesMsg = 0x08
+	GetPooledTransactionsMsg      = 0x09
+	PooledTransactionsMsg         = 0x0a
+)
+
+// Constants to match up snap protocol versions and messages
+const (
+	snap1 = 1
+)
+
+// Official short name of the state range sync protocol used during capability
+// negotiation.
+var SnapProtocolName = "snap"
+
+// Supported versions of the snap protocol (first is primary).
+var SnapProtocolVersions = []uint{snap1}
+
+// Number of implemented message corresponding to different snap protocol versions.
+var SnapProtocolLengths = []uint64{6}
+
+// snap protocol message codes
+const (
+	GetAccountRangeMsg  = 0x00
+	AccountRangeMsg     = 0x01
+	GetStorageRangesMsg = 0x02
+	StorageRangesMsg    = 0x03
+	GetByteCodesMsg     = 0x04
+	ByteCodesMsg        = 0x05
 )
 
 type errCode int
@@ -76,6 +110,8 @@ const (
 	ErrNoStatusMsg
 	ErrExtraStatusMsg
 	ErrSuspendedPeer
+	ErrUnexpectedResponse
+	ErrForkIDRejected
 )
 
 func (e errCode) String() string {
@@ -93,6 +129,8 @@ var errorToString = map[int]string{
 	ErrNoStatusMsg:             "No status message",
 	ErrExtraStatusMsg:          "Extra status message",
 	ErrSuspendedPeer:           "Suspended peer",
+	ErrUnexpectedResponse:      "Unexpected response",
+	ErrForkIDRejected:          "Fork ID rejected",
 }
 
 type txPool interface {
@@ -103,6 +141,14 @@ type txPool interface {
 	// The slice should be modifiable by the caller.
 	Pending() (map[common.Address]types.Transactions, error)
 
+	// Get should return a transaction if it is contained in the pool, or nil
+	// otherwise.
+	Get(hash common.Hash) *types.Transaction
+
+	// IsPrivate should return whether a transaction must be withheld from the
+	// network.
+	IsPrivate(hash common.Hash) bool
+
 	// SubscribeTxPreEvent should return an event subscription of
 	// TxPreEvent and send events to the given channel.
 	SubscribeTxPreEvent(chan<- core.TxPreEvent) event.Subscription
@@ -117,6 +163,24 @@ type statusData struct {
 	GenesisBlock    common.Hash
 }
 
+// statusData66 is the network packet for the status message on tst/66 and
+// above, extending the original one with the fork identifier.
+type statusData66 struct {
+	ProtocolVersion uint32
+	NetworkId       uint64
+	TD              *big.Int
+	CurrentBlock    common.Hash
+	GenesisBlock    common.Hash
+	ForkID          forkid.ID
+}
+
+// requestEnvelope is the network packet wrapping a retrieval request or response
+// on protocol versions supporting request IDs.
+type requestEnvelope struct {
+	ID   uint64       // Request ID to match up responses with
+	Data rlp.RawValue // RLP encoded payload of the request or response
+}
+
 // newBlockHashesData is the network packet for the block announcements.
 type newBlockHashesData []struct {
 	Hash   common.Hash // Hash of one particular block being announced
@@ -181,3 +245,66 @@ type blockBody struct {
 
 // blockBodiesData is the network packet for block content distribution.
 type blockBodiesData []*blockBody
+
+// getAccountRangeData represents an account range query.
+type getAccountRangeData struct {
+	ID     uint64      // Request ID to match up responses with
+	Root   common.Hash // Root hash of the account trie to serve
+	Origin common.Hash // Hash of the first account to retrieve
+	Limit  common.Hash // Hash of the last account to retrieve
+	Bytes  uint64      // Soft limit at which to stop returning data
+}
+
+// accountRangeData is the network packet for an account range response. The
+// proof contains the trie nodes proving the origin and the last account.
+type accountRangeData struct {
+	ID       uint64        // ID of the request this is a response for
+	Accounts []accountData // List of consecutive accounts from the trie
+	Proof    [][]byte      // List of trie nodes proving the account range
+}
+
+// accountData represents a single account in an account range response.
+type accountData struct {
+	Hash common.Hash // Hash of the account
+	Body []byte      // RLP encoded consensus representation of the account
+}
+
+// getStorageRangesData represents a storage slot range query, spanning the
+// storage of multiple accounts. The origin only applies to the first account
+// and the limit only to the last one.
+type getStorageRangesData struct {
+	ID       uint64        // Request ID to match up responses with
+	Root     common.Hash   // Root hash of the account trie to serve
+	Accounts []common.Hash // Account hashes of the storage tries to serve
+	Origin   []byte        // Hash of the first storage slot to retrieve
+	Limit    []byte        // Hash of the last storage slot to retrieve
+	Bytes    uint64        // Soft limit at which to stop returning data
+}
+
+// storageRangesData is the network packet for a storage ranges response. Only
+// the last storage range may be incomplete, in which case the proof contains the
+// trie nodes proving its boundaries.
+type storageRangesData struct {
+	ID    uint64          // ID of the request this is a response for
+	Slots [][]storageData // Lists of consecutive storage slots for the requested accounts
+	Proof [][]byte        // Trie nodes proving the last, possibly partial, range
+}
+
+// storageData represents a single storage slot in a storage ranges response.
+type storageData struct {
+	Hash common.Hash // Hash of the storage slot
+	Body []byte      // Data content of the slot
+}
+
+// getByteCodesData represents a contract code query.
+type getByteCodesData struct {
+	ID     uint64        // Request ID to match up responses with
+	Hashes []common.Hash // Code hashes to retrieve the code for
+	Bytes  uint64        // Soft limit at which to stop returning data
+}
+
+// byteCodesData is the network packet for a contract code response.
+type byteCodesData struct {
+	ID    uint64   // ID of the request this is a response for
+	Codes [][]byte // Requested contract bytecodes
+}
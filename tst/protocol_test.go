@@ -18,15 +18,20 @@ package tst
 
 import (
 	"fmt"
+	"math/big"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/forkid"
 	"github.com/utchain/go-utchain/core/types"
 	"github.com/utchain/go-utchain/crypto"
 	"github.com/utchain/go-utchain/tst/downloader"
 	"github.com/utchain/go-utchain/p2p"
+	"github.com/utchain/go-utchain/p2p/discover"
+	"github.com/utchain/go-utchain/p2p/enr"
 	"github.com/utchain/go-utchain/rlp"
 )
 
@@ -39,6 +44,7 @@ var testAccount, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6
 // Tests that handshake failures are detected and reported correctly.
 func TestStatusMsgErrors62(t *testing.T) { testStatusMsgErrors(t, 62) }
 func TestStatusMsgErrors63(t *testing.T) { testStatusMsgErrors(t, 63) }
+func TestStatusMsgErrors64(t *testing.T) { testStatusMsgErrors(t, 64) }
 
 func testStatusMsgErrors(t *testing.T, protocol int) {
 	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
@@ -92,9 +98,85 @@ func testStatusMsgErrors(t *testing.T, protocol int) {
 	}
 }
 
+// Tests that fork ID handshake failures are detected and reported correctly.
+func TestStatusMsgErrors66(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	var (
+		genesis = pm.blockchain.Genesis()
+		head    = pm.blockchain.CurrentHeader()
+		td      = pm.blockchain.GetTd(head.Hash(), head.Number.Uint64())
+	)
+	defer pm.Stop()
+
+	tests := []struct {
+		code      uint64
+		data      interface{}
+		wantError error
+	}{
+		{
+			code: StatusMsg, data: statusData{tst66, DefaultConfig.NetworkId, td, head.Hash(), genesis.Hash()},
+			wantError: errResp(ErrDecode, "msg msg #0 (71 bytes): invalid message: (code 0) (size 71) rlp: too few elements for tst.statusData66"),
+		},
+		{
+			code: StatusMsg, data: statusData66{tst66, DefaultConfig.NetworkId, td, head.Hash(), genesis.Hash(), forkid.ID{Hash: [4]byte{0x00, 0x01, 0x02, 0x03}}},
+			wantError: errResp(ErrForkIDRejected, "%v", forkid.ErrLocalIncompatibleOrStale),
+		},
+	}
+	for i, test := range tests {
+		p, errc := newTestPeer("peer", tst66, pm, false)
+		// The send call might hang until reset because
+		// the protocol might not read the payload.
+		go p2p.Send(p.app, test.code, test.data)
+
+		select {
+		case err := <-errc:
+			if err == nil {
+				t.Errorf("test %d: protocol returned nil error, want %q", i, test.wantError)
+			} else if err.Error() != test.wantError.Error() {
+				t.Errorf("test %d: wrong error: got %q, want %q", i, err, test.wantError)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("protocol did not shut down within 2 seconds")
+		}
+		p.close()
+	}
+}
+
+// Tests that node records are only accepted if they advertise the tst protocol
+// on a chain compatible with the local one.
+func TestNodeFilter(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+
+	filter := NewNodeFilter(pm.blockchain)
+
+	tests := []struct {
+		entry  enr.Entry
+		accept bool
+	}{
+		{nil, false},
+		{pm.currentENREntry(), true},
+		{&enrEntry{ForkID: forkid.ID{Hash: [4]byte{0x00, 0x01, 0x02, 0x03}}}, false},
+		{enr.WithEntry("tst", []interface{}{forkid.NewID(pm.blockchain), uint(1)}), true}, // Extra fields are ignored
+	}
+	for i, tt := range tests {
+		var record enr.Record
+		if tt.entry != nil {
+			record.Set(tt.entry)
+		}
+		if err := record.Sign(testBankKey); err != nil {
+			t.Fatalf("test %d: failed to sign record: %v", i, err)
+		}
+		if accept := filter(&record); accept != tt.accept {
+			t.Errorf("test %d: acceptance mismatch: have %v, want %v", i, accept, tt.accept)
+		}
+	}
+}
+
 // This test checks that received transactions are added to the local pool.
 func TestRecvTransactions62(t *testing.T) { testRecvTransactions(t, 62) }
 func TestRecvTransactions63(t *testing.T) { testRecvTransactions(t, 63) }
+func TestRecvTransactions64(t *testing.T) { testRecvTransactions(t, 64) }
 
 func testRecvTransactions(t *testing.T, protocol int) {
 	txAdded := make(chan []*types.Transaction)
@@ -123,6 +205,7 @@ func testRecvTransactions(t *testing.T, protocol int) {
 // This test checks that pending transactions are sent.
 func TestSendTransactions62(t *testing.T) { testSendTransactions(t, 62) }
 func TestSendTransactions63(t *testing.T) { testSendTransactions(t, 63) }
+func TestSendTransactions64(t *testing.T) { testSendTransactions(t, 64) }
 
 func testSendTransactions(t *testing.T, protocol int) {
 	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
@@ -146,18 +229,32 @@ func testSendTransactions(t *testing.T, protocol int) {
 			seen[tx.Hash()] = false
 		}
 		for n := 0; n < len(alltxs) && !t.Failed(); {
-			var txs []*types.Transaction
 			msg, err := p.app.ReadMsg()
 			if err != nil {
 				t.Errorf("%v: read error: %v", p.Peer, err)
-			} else if msg.Code != TxMsg {
-				t.Errorf("%v: got code %d, want TxMsg", p.Peer, msg.Code)
 			}
-			if err := msg.Decode(&txs); err != nil {
-				t.Errorf("%v: %v", p.Peer, err)
+			// Peers supporting announcements should only receive hashes
+			var hashes []common.Hash
+			if protocol >= 64 {
+				if msg.Code != NewPooledTransactionHashesMsg {
+					t.Errorf("%v: got code %d, want NewPooledTransactionHashesMsg", p.Peer, msg.Code)
+				}
+				if err := msg.Decode(&hashes); err != nil {
+					t.Errorf("%v: %v", p.Peer, err)
+				}
+			} else {
+				if msg.Code != TxMsg {
+					t.Errorf("%v: got code %d, want TxMsg", p.Peer, msg.Code)
+				}
+				var txs []*types.Transaction
+				if err := msg.Decode(&txs); err != nil {
+					t.Errorf("%v: %v", p.Peer, err)
+				}
+				for _, tx := range txs {
+					hashes = append(hashes, tx.Hash())
+				}
 			}
-			for _, tx := range txs {
-				hash := tx.Hash()
+			for _, hash := range hashes {
 				seentx, want := seen[hash]
 				if seentx {
 					t.Errorf("%v: got tx more than once: %x", p.Peer, hash)
@@ -178,6 +275,233 @@ func testSendTransactions(t *testing.T, protocol int) {
 	wg.Wait()
 }
 
+// Tests that announced transactions are retrieved from the announcing peer, and
+// that requested pooled transactions are served.
+func TestTransactionAnnouncement64(t *testing.T) {
+	txAdded := make(chan []*types.Transaction)
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, txAdded)
+	pm.acceptTxs = 1 // mark synced to accept transactions
+	p, _ := newTestPeer("peer", 64, pm, true)
+	defer pm.Stop()
+	defer p.close()
+
+	// Announce a transaction and wait for it to be requested
+	tx := newTestTransaction(testAccount, 0, 0)
+	if err := p2p.Send(p.app, NewPooledTransactionHashesMsg, []common.Hash{tx.Hash()}); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	if err := p2p.ExpectMsg(p.app, GetPooledTransactionsMsg, []common.Hash{tx.Hash()}); err != nil {
+		t.Fatalf("retrieval mismatch: %v", err)
+	}
+	// Deliver the transaction and make sure it's added to the pool
+	if err := p2p.Send(p.app, PooledTransactionsMsg, []*types.Transaction{tx}); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	select {
+	case added := <-txAdded:
+		if len(added) != 1 || added[0].Hash() != tx.Hash() {
+			t.Fatalf("added transactions mismatch: have %v, want [%x]", added, tx.Hash())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("announced transaction not added within 2 seconds")
+	}
+	// Request the transaction back, along with an unknown one
+	if err := p2p.Send(p.app, GetPooledTransactionsMsg, []common.Hash{tx.Hash(), {0x01}}); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	if err := p2p.ExpectMsg(p.app, PooledTransactionsMsg, []*types.Transaction{tx}); err != nil {
+		t.Fatalf("pooled transactions mismatch: %v", err)
+	}
+}
+
+// Tests that requested transactions arriving while not synchronised are still
+// delivered to the fetcher to clear the request, but are not added to the pool.
+func TestTransactionUnsyncedDelivery64(t *testing.T) {
+	txAdded := make(chan []*types.Transaction)
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, txAdded)
+	atomic.StoreUint32(&pm.acceptTxs, 1)
+	p, _ := newTestPeer("peer", 64, pm, true)
+	defer pm.Stop()
+	defer p.close()
+
+	// Announce a transaction and wait for it to be requested
+	tx := newTestTransaction(testAccount, 0, 0)
+	if err := p2p.Send(p.app, NewPooledTransactionHashesMsg, []common.Hash{tx.Hash()}); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	if err := p2p.ExpectMsg(p.app, GetPooledTransactionsMsg, []common.Hash{tx.Hash()}); err != nil {
+		t.Fatalf("retrieval mismatch: %v", err)
+	}
+	// Lose the sync status and deliver the transaction, it must not be pooled
+	atomic.StoreUint32(&pm.acceptTxs, 0)
+	if err := p2p.Send(p.app, PooledTransactionsMsg, []*types.Transaction{tx}); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	select {
+	case added := <-txAdded:
+		t.Fatalf("unsynced transactions added: %v", added)
+	case <-time.After(100 * time.Millisecond):
+	}
+	// Regain the sync status and announce a new transaction. It should be
+	// requested right away, as the previous request was cleared by the delivery.
+	atomic.StoreUint32(&pm.acceptTxs, 1)
+
+	next := newTestTransaction(testAccount, 1, 0)
+	if err := p2p.Send(p.app, NewPooledTransactionHashesMsg, []common.Hash{next.Hash()}); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	start := time.Now()
+	if err := p2p.ExpectMsg(p.app, GetPooledTransactionsMsg, []common.Hash{next.Hash()}); err != nil {
+		t.Fatalf("retrieval mismatch: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("retrieval blocked by stale request for %v", elapsed)
+	}
+}
+
+// Tests that private transactions are neither synced to new peers, nor served
+// on explicit requests.
+func TestPrivateTransactions64(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+
+	var (
+		private = newTestTransaction(testAccount, 0, 0)
+		public  = newTestTransaction(testAccount, 1, 0)
+	)
+	pool := pm.txpool.(*testTxPool)
+	pool.private = map[common.Hash]bool{private.Hash(): true}
+	pool.AddRemotes([]*types.Transaction{private, public})
+
+	p, _ := newTestPeer("peer", 64, pm, true)
+	defer p.close()
+
+	// Only the public transaction should be announced on connection
+	if err := p2p.ExpectMsg(p.app, NewPooledTransactionHashesMsg, []common.Hash{public.Hash()}); err != nil {
+		t.Fatalf("transaction sync mismatch: %v", err)
+	}
+	// Requesting the private transaction explicitly should not reveal it
+	if err := p2p.Send(p.app, GetPooledTransactionsMsg, []common.Hash{private.Hash(), public.Hash()}); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	if err := p2p.ExpectMsg(p.app, PooledTransactionsMsg, []*types.Transaction{public}); err != nil {
+		t.Fatalf("pooled transactions mismatch: %v", err)
+	}
+}
+
+// Tests that responses on protocol versions with request IDs are routed to the
+// waiting requesters, and that the requests fail if no response arrives.
+func TestRequestDispatch65(t *testing.T) {
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+
+	p := newPeer(tst65, p2p.NewPeer(discover.NodeID{}, "peer", nil), net)
+
+	// request dispatches a header request, checking its envelope on the remote side
+	request := func(id uint64, sink chan *response, timeout time.Duration) {
+		errc := make(chan error, 1)
+		go func() { errc <- p.requestHeadersByNumber(1, 1, 0, false, sink, timeout) }()
+
+		query := &getBlockHeadersData{Origin: hashOrNumber{Number: 1}, Amount: 1}
+		if err := p2p.ExpectMsg(app, GetBlockHeadersMsg, []interface{}{id, query}); err != nil {
+			t.Fatalf("request %d mismatch: %v", id, err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("request %d failed: %v", id, err)
+		}
+	}
+	// A response should be routed to the sink of its request
+	sink := make(chan *response, 1)
+	request(0, sink, time.Second)
+
+	headers := []*types.Header{{Number: big.NewInt(1)}}
+	if _, consumed, err := p.resolve(0, BlockHeadersMsg, headers); !consumed || err != nil {
+		t.Fatalf("response not routed: consumed %v, err %v", consumed, err)
+	}
+	if res := <-sink; res.Err != nil || len(res.Data.([]*types.Header)) != 1 {
+		t.Fatalf("response mismatch: have %v, err %v", res.Data, res.Err)
+	}
+	// Duplicate responses should be discarded, mismatching ones rejected
+	if _, consumed, err := p.resolve(0, BlockHeadersMsg, headers); !consumed || err != nil {
+		t.Fatalf("duplicate response not discarded: consumed %v, err %v", consumed, err)
+	}
+	request(1, sink, time.Second)
+	if _, _, err := p.resolve(1, BlockBodiesMsg, blockBodiesData{}); err == nil {
+		t.Fatalf("mismatching response accepted")
+	}
+	// Unanswered requests should time out, or fail when the peer is closed
+	if res := <-sink; res.Err != errRequestTimeout {
+		t.Fatalf("timeout error mismatch: have %v, want %v", res.Err, errRequestTimeout)
+	}
+	request(2, sink, time.Minute)
+	p.closeRequests()
+	if res := <-sink; res.Err != errPeerClosed {
+		t.Fatalf("close error mismatch: have %v, want %v", res.Err, errPeerClosed)
+	}
+	if err := p.requestHeadersByNumber(1, 1, 0, false, sink, time.Second); err != errPeerClosed {
+		t.Fatalf("request on closed peer error mismatch: have %v, want %v", err, errPeerClosed)
+	}
+}
+
+// Tests that responses without a sink are routed to the component that issued
+// their request, and that a downloader request supersedes its pending previous
+// one so that a late response to the latter is discarded.
+func TestRequestRoute65(t *testing.T) {
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+
+	p := newPeer(tst65, p2p.NewPeer(discover.NodeID{}, "peer", nil), net)
+	defer p.closeRequests()
+
+	// request issues a request, discarding its envelope on the remote side
+	request := func(id uint64, fetch func() error) {
+		errc := make(chan error, 1)
+		go func() { errc <- fetch() }()
+
+		msg, err := app.ReadMsg()
+		if err != nil {
+			t.Fatalf("request %d: failed to read: %v", id, err)
+		}
+		var envelope requestEnvelope
+		if err := msg.Decode(&envelope); err != nil || envelope.ID != id {
+			t.Fatalf("request %d: envelope mismatch: have %d, err %v", id, envelope.ID, err)
+		}
+		msg.Discard()
+		if err := <-errc; err != nil {
+			t.Fatalf("request %d failed: %v", id, err)
+		}
+	}
+	hashes := []common.Hash{{0x01}}
+
+	request(0, func() error { return p.RequestOneHeader(common.Hash{0x01}) })
+	request(1, func() error { return p.RequestBodies(hashes) })
+	request(2, func() error { return p.RequestAnnouncedBodies(hashes) })
+	request(3, func() error { return p.RequestHeadersByNumber(1, 1, 0, false) })
+	request(4, func() error { return p.RequestHeadersByNumber(1, 1, 0, false) })
+
+	tests := []struct {
+		id       uint64
+		code     uint64
+		route    requestRoute
+		consumed bool
+	}{
+		{0, BlockHeadersMsg, routeFetcher, false},
+		{1, BlockBodiesMsg, routeDownloader, false},
+		{2, BlockBodiesMsg, routeFetcher, false},
+		{3, BlockHeadersMsg, routeByContent, true}, // Superseded by request 4
+		{4, BlockHeadersMsg, routeDownloader, false},
+	}
+	for _, tt := range tests {
+		route, consumed, err := p.resolve(tt.id, tt.code, nil)
+		if err != nil {
+			t.Fatalf("response %d: failed to resolve: %v", tt.id, err)
+		}
+		if route != tt.route || consumed != tt.consumed {
+			t.Errorf("response %d: routing mismatch: have %v/%v, want %v/%v", tt.id, route, consumed, tt.route, tt.consumed)
+		}
+	}
+}
+
 // Tests that the custom union field encoder and decoder works correctly.
 func TestGetBlockHeadersDataEncodeDecode(t *testing.T) {
 	// Create a "random" hash for testing
@@ -0,0 +1,548 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package tst
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/common/hexutil"
+	"github.com/utchain/go-utchain/core/state"
+	"github.com/utchain/go-utchain/core/vm"
+	"github.com/utchain/go-utchain/crypto"
+	"github.com/utchain/go-utchain/tst/tracers"
+)
+
+// traceAction is the action part of a flat trace. Depending on the trace type
+// it describes a call, a contract creation, a self destruct or a block reward.
+type traceAction struct {
+	CallType      string          `json:"callType,omitempty"`
+	From          *common.Address `json:"from,omitempty"`
+	To            *common.Address `json:"to,omitempty"`
+	Gas           *hexutil.Uint64 `json:"gas,omitempty"`
+	Input         *hexutil.Bytes  `json:"input,omitempty"`
+	Init          *hexutil.Bytes  `json:"init,omitempty"`
+	Value         *hexutil.Big    `json:"value,omitempty"`
+	Address       *common.Address `json:"address,omitempty"`
+	RefundAddress *common.Address `json:"refundAddress,omitempty"`
+	Balance       *hexutil.Big    `json:"balance,omitempty"`
+	Author        *common.Address `json:"author,omitempty"`
+	RewardType    string          `json:"rewardType,omitempty"`
+}
+
+// traceResult is the outcome of a successful call or contract creation.
+type traceResult struct {
+	GasUsed *hexutil.Uint64 `json:"gasUsed,omitempty"`
+	Output  *hexutil.Bytes  `json:"output,omitempty"`
+	Address *common.Address `json:"address,omitempty"`
+	Code    *hexutil.Bytes  `json:"code,omitempty"`
+}
+
+// flatTrace is a single entry of the flat trace format, positioned within the
+// call tree of its transaction by its trace address.
+type flatTrace struct {
+	Action              *traceAction `json:"action"`
+	BlockHash           *common.Hash `json:"blockHash,omitempty"`
+	BlockNumber         *uint64      `json:"blockNumber,omitempty"`
+	Error               string       `json:"error,omitempty"`
+	Result              *traceResult `json:"result"`
+	Subtraces           int          `json:"subtraces"`
+	TraceAddress        []int        `json:"traceAddress"`
+	TransactionHash     *common.Hash `json:"transactionHash"`
+	TransactionPosition *uint64      `json:"transactionPosition"`
+	Type                string       `json:"type"`
+}
+
+// from returns the originating address of the trace, if it has one.
+func (t *flatTrace) from() *common.Address {
+	if t.Type == "suicide" {
+		return t.Action.Address
+	}
+	return t.Action.From
+}
+
+// to returns the receiving address of the trace, if it has one.
+func (t *flatTrace) to() *common.Address {
+	switch t.Type {
+	case "create":
+		if t.Result != nil {
+			return t.Result.Address
+		}
+		return nil
+	case "suicide":
+		return t.Action.RefundAddress
+	case "reward":
+		return t.Action.Author
+	}
+	return t.Action.To
+}
+
+// flatCallTracer is an EVM tracer collecting all the calls, contract creations
+// and self destructs of a transaction into the flat trace format. The call tree
+// is gathered by the native call tracer, in its detailed mode.
+type flatCallTracer struct {
+	*tracers.CallTracer
+}
+
+// newFlatCallTracer creates a tracer gathering the flat traces of a transaction.
+func newFlatCallTracer() *flatCallTracer {
+	return &flatCallTracer{tracers.NewCallTracer(true)}
+}
+
+// traces returns the flat traces of the transaction, ordered as a depth first
+// walk of its call tree.
+func (t *flatCallTracer) traces() []*flatTrace {
+	root, err := t.Result()
+	if err != nil || root.Type == "" {
+		// Nothing was executed, not even the outer call
+		return nil
+	}
+	return flattenFrame(root, []int{}, nil)
+}
+
+// flatTraceType returns the type of the flat trace of a call frame.
+func flatTraceType(frame *tracers.CallFrame) string {
+	switch frame.Type {
+	case "CREATE":
+		return "create"
+	case "SELFDESTRUCT":
+		return "suicide"
+	}
+	return "call"
+}
+
+// flattenFrame appends the trace of a frame and all of its inner frames to the
+// given list, in the order they were executed.
+func flattenFrame(frame *tracers.CallFrame, address []int, traces []*flatTrace) []*flatTrace {
+	trace := &flatTrace{
+		Action:       new(traceAction),
+		Subtraces:    len(frame.Calls),
+		TraceAddress: address,
+		Type:         flatTraceType(frame),
+	}
+	if frame.Error != "" {
+		trace.Error = traceError(frame.Error)
+	}
+	switch trace.Type {
+	case "create":
+		trace.Action.From, trace.Action.Gas, trace.Action.Init, trace.Action.Value = frame.From, frame.Gas, frame.Input, frame.Value
+		if trace.Error == "" {
+			trace.Result = &traceResult{GasUsed: frame.GasUsed, Address: frame.To, Code: frame.Output}
+		}
+	case "suicide":
+		trace.Action.Address, trace.Action.RefundAddress, trace.Action.Balance = frame.From, frame.To, frame.Value
+	default:
+		trace.Action.CallType = strings.ToLower(frame.Type)
+		trace.Action.From, trace.Action.To, trace.Action.Gas = frame.From, frame.To, frame.Gas
+		trace.Action.Input, trace.Action.Value = frame.Input, frame.Value
+		if trace.Error == "" {
+			trace.Result = &traceResult{GasUsed: frame.GasUsed, Output: frame.Output}
+		}
+	}
+	traces = append(traces, trace)
+
+	for i, call := range frame.Calls {
+		traces = flattenFrame(call, append(append([]int{}, address...), i), traces)
+	}
+	return traces
+}
+
+// traceError converts the error of a call frame into the message reported in
+// flat traces.
+func traceError(msg string) string {
+	switch {
+	case msg == vm.ErrOutOfGas.Error(), msg == vm.ErrCodeStoreOutOfGas.Error(), msg == "gas uint64 overflow":
+		return "Out of gas"
+	case msg == "execution reverted", msg == "evm: execution reverted":
+		return "Reverted"
+	case msg == "internal failure":
+		return "Internal failure"
+	case msg == "evm: write protection":
+		return "Mutable call in static context"
+	case msg == "evm: return data out of bounds":
+		return "Out of bounds"
+	case strings.HasPrefix(msg, "invalid jump destination"):
+		return "Bad jump destination"
+	case strings.HasPrefix(msg, "invalid opcode"):
+		return "Bad instruction"
+	case strings.HasPrefix(msg, "stack underflow"):
+		return "Stack underflow"
+	case strings.HasPrefix(msg, "stack limit reached"):
+		return "Out of stack"
+	default:
+		return msg
+	}
+}
+
+// stateDiffTracer is an EVM tracer collecting the accounts and storage slots a
+// transaction may have modified, to be diffed once it finishes.
+type stateDiffTracer struct {
+	accounts map[common.Address]map[common.Hash]struct{}
+}
+
+// newStateDiffTracer creates a tracer collecting the state a transaction may
+// have modified.
+func newStateDiffTracer() *stateDiffTracer {
+	return &stateDiffTracer{accounts: make(map[common.Address]map[common.Hash]struct{})}
+}
+
+// touch marks an account as possibly modified.
+func (t *stateDiffTracer) touch(addr common.Address) {
+	if _, ok := t.accounts[addr]; !ok {
+		t.accounts[addr] = make(map[common.Hash]struct{})
+	}
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *stateDiffTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.touch(from)
+	t.touch(to)
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *stateDiffTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if err != nil {
+		return nil
+	}
+	switch op {
+	case vm.CREATE:
+		from := contract.Address()
+		t.touch(from)
+		t.touch(crypto.CreateAddress(from, env.StateDB.GetNonce(from)))
+	case vm.CALL, vm.CALLCODE:
+		t.touch(common.BigToAddress(stack.Back(1)))
+	case vm.SELFDESTRUCT:
+		t.touch(contract.Address())
+		t.touch(common.BigToAddress(stack.Back(0)))
+	case vm.SSTORE:
+		t.touch(contract.Address())
+		t.accounts[contract.Address()][common.BigToHash(stack.Back(0))] = struct{}{}
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *stateDiffTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *stateDiffTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+// stateDiffChange is the before and after value of a modified field.
+type stateDiffChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// stateDiffAccount is the change of an account caused by a transaction. Each
+// field is either "=" if unchanged, or a single entry map keyed by "+" if it
+// was created, "-" if it was deleted and "*" if it was modified.
+type stateDiffAccount struct {
+	Balance interface{}                 `json:"balance"`
+	Code    interface{}                 `json:"code"`
+	Nonce   interface{}                 `json:"nonce"`
+	Storage map[common.Hash]interface{} `json:"storage"`
+}
+
+// diffField reports the change of a single field between two states.
+func diffField(existed, exists bool, from, to interface{}, equal bool) interface{} {
+	switch {
+	case !existed:
+		return map[string]interface{}{"+": to}
+	case !exists:
+		return map[string]interface{}{"-": from}
+	case equal:
+		return "="
+	default:
+		return map[string]interface{}{"*": &stateDiffChange{From: from, To: to}}
+	}
+}
+
+// diff compares the accounts collected by the tracer between the state before
+// the transaction and the finalised state after it, and returns the modified
+// ones.
+func (t *stateDiffTracer) diff(pre, post *state.StateDB) map[common.Address]*stateDiffAccount {
+	diffs := make(map[common.Address]*stateDiffAccount)
+	for addr, slots := range t.accounts {
+		existed, exists := pre.Exist(addr), post.Exist(addr)
+		if !existed && !exists {
+			continue
+		}
+		var (
+			preBalance, postBalance = pre.GetBalance(addr), post.GetBalance(addr)
+			preNonce, postNonce     = pre.GetNonce(addr), post.GetNonce(addr)
+			preCode, postCode       = pre.GetCode(addr), post.GetCode(addr)
+
+			modified = existed != exists
+			storage  = make(map[common.Hash]interface{})
+		)
+		for key := range slots {
+			var from, to common.Hash
+			if existed {
+				from = pre.GetState(addr, key)
+			}
+			if exists {
+				to = post.GetState(addr, key)
+			}
+			switch {
+			case from == to:
+				continue
+			case !existed:
+				storage[key] = map[string]interface{}{"+": to}
+			case !exists:
+				storage[key] = map[string]interface{}{"-": from}
+			default:
+				storage[key] = map[string]interface{}{"*": &stateDiffChange{From: from, To: to}}
+			}
+			modified = true
+		}
+		if preBalance.Cmp(postBalance) != 0 || preNonce != postNonce || !bytes.Equal(preCode, postCode) {
+			modified = true
+		}
+		if !modified {
+			continue
+		}
+		diffs[addr] = &stateDiffAccount{
+			Balance: diffField(existed, exists, (*hexutil.Big)(preBalance), (*hexutil.Big)(postBalance), preBalance.Cmp(postBalance) == 0),
+			Code:    diffField(existed, exists, hexutil.Bytes(preCode), hexutil.Bytes(postCode), bytes.Equal(preCode, postCode)),
+			Nonce:   diffField(existed, exists, hexutil.Uint64(preNonce), hexutil.Uint64(postNonce), preNonce == postNonce),
+			Storage: storage,
+		}
+	}
+	return diffs
+}
+
+// vmTrace is the virtual machine execution trace of a single call frame.
+type vmTrace struct {
+	Code hexutil.Bytes `json:"code"`
+	Ops  []*vmTraceOp  `json:"ops"`
+}
+
+// vmTraceOp is a single executed instruction along with its effects.
+type vmTraceOp struct {
+	Cost uint64     `json:"cost"`
+	Ex   *vmTraceEx `json:"ex"`
+	Pc   uint64     `json:"pc"`
+	Sub  *vmTrace   `json:"sub"`
+
+	push    int        // Number of stack items pushed by the instruction
+	memory  *vm.Memory // Memory of the executing frame, if written to
+	memOff  uint64     // Offset of the memory written by the instruction
+	memSize uint64     // Size of the memory written by the instruction
+}
+
+// vmTraceEx is the outcome of an executed instruction.
+type vmTraceEx struct {
+	Mem   *vmTraceMem    `json:"mem"`
+	Push  []*hexutil.Big `json:"push"`
+	Store *vmTraceStore  `json:"store"`
+	Used  uint64         `json:"used"`
+}
+
+// vmTraceMem is a memory region written by an instruction.
+type vmTraceMem struct {
+	Data hexutil.Bytes `json:"data"`
+	Off  uint64        `json:"off"`
+}
+
+// vmTraceStore is a storage slot written by an instruction.
+type vmTraceStore struct {
+	Key *hexutil.Big `json:"key"`
+	Val *hexutil.Big `json:"val"`
+}
+
+// vmTracer is an EVM tracer collecting the executed instructions of every call
+// frame of a transaction, along with their effects on the stack, memory and
+// storage.
+type vmTracer struct {
+	frames  []*vmTrace   // Call frames currently being executed
+	pending []*vmTraceOp // Last instruction of each frame, waiting for its effects
+	root    *vmTrace     // Outermost call frame of the transaction
+}
+
+// newVMTracer creates a tracer collecting the instructions of a transaction.
+func newVMTracer() *vmTracer {
+	return &vmTracer{}
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *vmTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *vmTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if err != nil {
+		return nil
+	}
+	// Enter any new call frame, or leave the finished ones
+	if depth > len(t.frames) {
+		frame := &vmTrace{Code: contract.Code, Ops: []*vmTraceOp{}}
+		if len(t.frames) == 0 {
+			t.root = frame
+		} else if parent := t.pending[len(t.pending)-1]; parent != nil {
+			parent.Sub = frame
+		}
+		t.frames = append(t.frames, frame)
+		t.pending = append(t.pending, nil)
+	}
+	for depth < len(t.frames) {
+		t.frames = t.frames[:len(t.frames)-1]
+		t.pending = t.pending[:len(t.pending)-1]
+	}
+	// Complete the previous instruction of the frame with its effects
+	if prev := t.pending[depth-1]; prev != nil {
+		prev.Ex.Used = gas
+		if n := len(stack.Data()); prev.push <= n {
+			for _, item := range stack.Data()[n-prev.push:] {
+				prev.Ex.Push = append(prev.Ex.Push, (*hexutil.Big)(new(big.Int).Set(item)))
+			}
+		}
+		if prev.memory != nil {
+			prev.Ex.Mem = &vmTraceMem{
+				Data: tracers.MemorySlice(prev.memory, prev.memOff, prev.memSize),
+				Off:  prev.memOff,
+			}
+		}
+	}
+	// Gather the current instruction, its effects completed by the next one
+	current := &vmTraceOp{
+		Cost: cost,
+		Ex:   &vmTraceEx{Push: []*hexutil.Big{}, Used: gas - cost},
+		Pc:   pc,
+		push: stackPushes(op),
+	}
+	switch op {
+	case vm.MSTORE:
+		current.memory, current.memOff, current.memSize = memory, stack.Back(0).Uint64(), 32
+	case vm.MSTORE8:
+		current.memory, current.memOff, current.memSize = memory, stack.Back(0).Uint64(), 1
+	case vm.CALLDATACOPY, vm.CODECOPY, vm.RETURNDATACOPY:
+		current.memory, current.memOff, current.memSize = memory, stack.Back(0).Uint64(), stack.Back(2).Uint64()
+	case vm.EXTCODECOPY:
+		current.memory, current.memOff, current.memSize = memory, stack.Back(1).Uint64(), stack.Back(3).Uint64()
+	case vm.CALL, vm.CALLCODE:
+		current.memory, current.memOff, current.memSize = memory, stack.Back(5).Uint64(), stack.Back(6).Uint64()
+	case vm.DELEGATECALL, vm.STATICCALL:
+		current.memory, current.memOff, current.memSize = memory, stack.Back(4).Uint64(), stack.Back(5).Uint64()
+	case vm.SSTORE:
+		current.Ex.Store = &vmTraceStore{
+			Key: (*hexutil.Big)(new(big.Int).Set(stack.Back(0))),
+			Val: (*hexutil.Big)(new(big.Int).Set(stack.Back(1))),
+		}
+	}
+	frame := t.frames[depth-1]
+	frame.Ops = append(frame.Ops, current)
+	t.pending[depth-1] = current
+
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *vmTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *vmTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+// trace returns the execution trace of the outermost call frame, or an empty
+// one if no code was executed.
+func (t *vmTracer) trace() *vmTrace {
+	if t.root == nil {
+		return &vmTrace{Code: hexutil.Bytes{}, Ops: []*vmTraceOp{}}
+	}
+	return t.root
+}
+
+// stackPushes returns the number of stack items to report as pushed by an
+// instruction. Duplications and swaps report the whole affected range.
+func stackPushes(op vm.OpCode) int {
+	switch {
+	case op >= vm.PUSH1 && op <= vm.PUSH32:
+		return 1
+	case op >= vm.DUP1 && op <= vm.DUP16:
+		return int(op-vm.DUP1) + 2
+	case op >= vm.SWAP1 && op <= vm.SWAP16:
+		return int(op-vm.SWAP1) + 2
+	case op >= vm.LOG0 && op <= vm.LOG4:
+		return 0
+	}
+	switch op {
+	case vm.STOP, vm.POP, vm.MSTORE, vm.MSTORE8, vm.SSTORE, vm.JUMP, vm.JUMPI, vm.JUMPDEST,
+		vm.CALLDATACOPY, vm.CODECOPY, vm.EXTCODECOPY, vm.RETURNDATACOPY,
+		vm.RETURN, vm.REVERT, vm.SELFDESTRUCT:
+		return 0
+	}
+	return 1
+}
+
+// traceMux is an EVM tracer forwarding all events to multiple tracers, so that
+// several trace types can be gathered from a single execution.
+type traceMux []vm.Tracer
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t traceMux) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	for _, tracer := range t {
+		if err := tracer.CaptureStart(from, to, create, input, gas, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t traceMux) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	for _, tracer := range t {
+		if err := tracer.CaptureState(env, pc, op, gas, cost, memory, stack, contract, depth, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t traceMux) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	for _, tracer := range t {
+		if err := tracer.CaptureFault(env, pc, op, gas, cost, memory, stack, contract, depth, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t traceMux) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	for _, tracer := range t {
+		if err := tracer.CaptureEnd(output, gasUsed, d, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
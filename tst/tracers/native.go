@@ -0,0 +1,72 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core/vm"
+)
+
+// Interface is implemented by every tracer of this package, both the JavaScript
+// and the native ones: an EVM tracer producing a JSON result which can also be
+// interrupted from the outside.
+type Interface interface {
+	vm.Tracer
+
+	// GetResult returns the JSON encoded result of the trace, or any error the
+	// tracer ran into.
+	GetResult() (json.RawMessage, error)
+
+	// Stop terminates the tracing at the first opportune moment.
+	Stop(err error)
+}
+
+// TxStartCapturer is implemented by tracers needing to inspect the state before
+// the traced message modifies it, including the purchase of its gas and the
+// increase of the sender nonce. CaptureTxStart has to be called right before
+// the message is applied, to is nil for contract creations.
+type TxStartCapturer interface {
+	CaptureTxStart(db vm.StateDB, from common.Address, to *common.Address, coinbase common.Address)
+}
+
+// natives contains the constructors of all the built in native tracers by name.
+// Each produces the same output as its JavaScript counterpart, without paying
+// for the duktape VM on every executed opcode.
+var natives = map[string]func(config json.RawMessage) (Interface, error){
+	"nativeCallTracer":     newCallTracer,
+	"nativePrestateTracer": newPrestateTracer,
+}
+
+// errNoConfig is returned if a tracer not accepting any configuration is given
+// one anyway.
+var errNoConfig = errors.New("tracer does not accept a configuration")
+
+// Create instantiates a tracer: a native one if code names one, otherwise a
+// JavaScript one as per New. The config is passed to native tracers only.
+func Create(code string, config json.RawMessage) (Interface, error) {
+	if ctor, ok := natives[code]; ok {
+		return ctor(config)
+	}
+	tracer, err := New(code)
+	if err != nil {
+		return nil, err
+	}
+	return tracer, nil
+}
@@ -0,0 +1,365 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/common/hexutil"
+	"github.com/utchain/go-utchain/core/vm"
+)
+
+// CallFrame is a single call reported by the native call tracer. The fields
+// are ordered and omitted exactly as by call_tracer.js.
+type CallFrame struct {
+	Type    string          `json:"type"`
+	From    *common.Address `json:"from,omitempty"`
+	To      *common.Address `json:"to,omitempty"`
+	Value   *hexutil.Big    `json:"value,omitempty"`
+	Gas     *hexutil.Uint64 `json:"gas,omitempty"`
+	GasUsed *hexutil.Uint64 `json:"gasUsed,omitempty"`
+	Input   *hexutil.Bytes  `json:"input,omitempty"`
+	Output  *hexutil.Bytes  `json:"output,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Time    string          `json:"time,omitempty"`
+	Calls   []*CallFrame    `json:"calls,omitempty"`
+
+	gasIn      uint64                 // Gas available before the call instruction
+	gasCost    uint64                 // Gas charged by the call instruction itself
+	outOff     uint64                 // Memory offset to read the call output from
+	outLen     uint64                 // Memory length of the call output
+	precompile vm.PrecompiledContract // Precompiled contract being called, if any
+}
+
+// CallTracer is the native version of call_tracer.js, extracting all the
+// internal calls made by a transaction.
+type CallTracer struct {
+	detailed  bool         // Whether to report the details left out by call_tracer.js
+	callstack []*CallFrame // Current recursive call stack of the EVM execution
+	descended bool         // Whether we've just descended into an inner call
+
+	root   CallFrame // Outer transaction context gathered at start and end
+	ctxErr error     // Error the outer transaction ended with
+
+	err       error  // Error, if one has occurred
+	interrupt uint32 // Atomic flag to signal execution interruption
+	reason    error  // Textual reason for the interruption
+}
+
+// NewCallTracer creates a native call tracer. Unless detailed, it reports the
+// same call tree as call_tracer.js. Otherwise the calls to precompiled contracts,
+// the gas and the full output of calls not executing any code, the value of
+// delegate and static calls and the parties of self destructs are reported too.
+func NewCallTracer(detailed bool) *CallTracer {
+	return &CallTracer{detailed: detailed, callstack: []*CallFrame{{}}}
+}
+
+// newCallTracer creates a native call tracer reporting what call_tracer.js does.
+func newCallTracer(config json.RawMessage) (Interface, error) {
+	if len(config) > 0 && string(config) != "null" {
+		return nil, errNoConfig
+	}
+	return NewCallTracer(false), nil
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *CallTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *CallTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.root.Type = "CALL"
+	if create {
+		t.root.Type = "CREATE"
+	}
+	t.root.From, t.root.To = &from, &to
+	t.root.Input = (*hexutil.Bytes)(&input)
+	t.root.Gas = (*hexutil.Uint64)(&gas)
+	t.root.Value = (*hexutil.Big)(value)
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *CallTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.err != nil {
+		return nil
+	}
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		t.err = t.reason
+		return nil
+	}
+	// Capture any errors immediately
+	if err != nil {
+		t.fault(err)
+		return nil
+	}
+	// If we've just descended into an inner call, retrieve its true allowance.
+	// Calls to plain accounts never descend, their gas is left out.
+	if t.descended {
+		if depth >= len(t.callstack) {
+			t.callstack[len(t.callstack)-1].Gas = (*hexutil.Uint64)(&gas)
+		}
+		t.descended = false
+	}
+	// If we've just returned from an inner call, gather its results
+	if depth == len(t.callstack)-1 {
+		t.exit(env, gas, memory, stack)
+	}
+	switch op {
+	case vm.CREATE:
+		// A new contract is being created, add to the call stack
+		from := contract.Address()
+		input := hexutil.Bytes(MemorySlice(memory, stack.Back(1).Uint64(), stack.Back(2).Uint64()))
+
+		call := &CallFrame{
+			Type:    op.String(),
+			From:    &from,
+			Input:   &input,
+			Value:   (*hexutil.Big)(new(big.Int).Set(stack.Back(0))),
+			gasIn:   gas,
+			gasCost: cost,
+		}
+		if t.detailed {
+			// All but one 64th of the remaining gas is passed along since EIP-150,
+			// overridden by the true allowance if the init code runs
+			allowance := gas - cost
+			if env.ChainConfig().IsEIP150(env.BlockNumber) {
+				allowance -= allowance / 64
+			}
+			call.Gas = (*hexutil.Uint64)(&allowance)
+		}
+		t.callstack = append(t.callstack, call)
+		t.descended = true
+
+	case vm.SELFDESTRUCT:
+		// A contract is being self destructed, gather that as a subcall too
+		call := &CallFrame{Type: op.String()}
+		if t.detailed {
+			var (
+				addr   = contract.Address()
+				refund = common.BigToAddress(stack.Back(0))
+			)
+			call.From, call.To = &addr, &refund
+			call.Value = (*hexutil.Big)(new(big.Int).Set(env.StateDB.GetBalance(addr)))
+		}
+		parent := t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, call)
+
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		// Skip any pre-compile invocations unless detailed, those are just fancy opcodes
+		to := common.BigToAddress(stack.Back(1))
+
+		var precompile vm.PrecompiledContract
+		if t.detailed {
+			precompiles := vm.PrecompiledContractsHomestead
+			if env.ChainConfig().IsByzantium(env.BlockNumber) {
+				precompiles = vm.PrecompiledContractsByzantium
+			}
+			precompile = precompiles[to]
+		} else if _, ok := vm.PrecompiledContractsByzantium[to]; ok {
+			return nil
+		}
+		off := 1
+		if op == vm.DELEGATECALL || op == vm.STATICCALL {
+			off = 0
+		}
+		from := contract.Address()
+		input := hexutil.Bytes(MemorySlice(memory, stack.Back(2+off).Uint64(), stack.Back(3+off).Uint64()))
+
+		call := &CallFrame{
+			Type:       op.String(),
+			From:       &from,
+			To:         &to,
+			Input:      &input,
+			gasIn:      gas,
+			gasCost:    cost,
+			outOff:     stack.Back(4 + off).Uint64(),
+			outLen:     stack.Back(5 + off).Uint64(),
+			precompile: precompile,
+		}
+		switch {
+		case op == vm.CALL || op == vm.CALLCODE:
+			call.Value = (*hexutil.Big)(new(big.Int).Set(stack.Back(2)))
+		case t.detailed && op == vm.DELEGATECALL:
+			call.Value = (*hexutil.Big)(new(big.Int).Set(contract.Value()))
+		case t.detailed:
+			call.Value = new(hexutil.Big)
+		}
+		t.callstack = append(t.callstack, call)
+		t.descended = true
+
+	case vm.RETURN, vm.REVERT:
+		// The current call is returning, gather its full output if detailed
+		if t.detailed && depth == len(t.callstack) && len(t.callstack) > 1 {
+			output := hexutil.Bytes(MemorySlice(memory, stack.Back(0).Uint64(), stack.Back(1).Uint64()))
+			t.callstack[len(t.callstack)-1].Output = &output
+		}
+		if op == vm.REVERT {
+			t.callstack[len(t.callstack)-1].Error = "execution reverted"
+		}
+	}
+	return nil
+}
+
+// exit pops off the last call once execution is back in its caller, and gathers
+// the results of the call.
+func (t *CallTracer) exit(env *vm.EVM, gas uint64, memory *vm.Memory, stack *vm.Stack) {
+	// Pop off the last call and get the execution results
+	call := t.callstack[len(t.callstack)-1]
+	t.callstack = t.callstack[:len(t.callstack)-1]
+
+	switch {
+	case call.Type == vm.CREATE.String():
+		// If the call was a CREATE, retrieve the contract address and output code
+		used := hexutil.Uint64(call.gasIn - call.gasCost - gas)
+		call.GasUsed = &used
+
+		if ret := stack.Back(0); ret.Sign() != 0 {
+			to := common.BigToAddress(ret)
+			code := hexutil.Bytes(env.StateDB.GetCode(to))
+			call.To, call.Output = &to, &code
+		} else if call.Error == "" {
+			call.Error = "internal failure"
+		}
+
+	case call.Gas != nil:
+		// If the call was a contract call, retrieve the gas usage and output
+		used := hexutil.Uint64(call.gasIn - call.gasCost + uint64(*call.Gas) - gas)
+		call.GasUsed = &used
+
+		if ret := stack.Back(0); ret.Sign() != 0 {
+			if !t.detailed {
+				output := hexutil.Bytes(MemorySlice(memory, call.outOff, call.outLen))
+				call.Output = &output
+			}
+		} else if call.Error == "" {
+			call.Error = "internal failure"
+		}
+
+	case t.detailed:
+		// Calls not executing any code are either precompiles or plain transfers,
+		// their allowance is derived from the gas handed back to the caller
+		var used uint64
+		if call.precompile != nil {
+			used = call.precompile.RequiredGas(*call.Input)
+		}
+		allowance := gas + call.gasCost - call.gasIn + used
+		call.Gas, call.GasUsed = (*hexutil.Uint64)(&allowance), (*hexutil.Uint64)(&used)
+
+		if ret := stack.Back(0); ret.Sign() != 0 {
+			var output hexutil.Bytes
+			if call.precompile != nil {
+				output, _ = call.precompile.Run(*call.Input)
+			}
+			call.Output = &output
+		} else if call.precompile != nil {
+			call.Error = vm.ErrOutOfGas.Error()
+		} else {
+			call.Error = "internal failure"
+		}
+	}
+	// Inject the call into the previous one
+	parent := t.callstack[len(t.callstack)-1]
+	parent.Calls = append(parent.Calls, call)
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *CallTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.err == nil {
+		t.fault(err)
+	}
+	return nil
+}
+
+// fault handles the failure of the currently executing call.
+func (t *CallTracer) fault(err error) {
+	// If the topmost call already reverted, don't handle the additional fault again
+	if t.callstack[len(t.callstack)-1].Error != "" {
+		return
+	}
+	// Pop off the just failed call, consuming all its available gas
+	call := t.callstack[len(t.callstack)-1]
+	t.callstack = t.callstack[:len(t.callstack)-1]
+
+	call.Error = err.Error()
+	if call.Gas != nil {
+		call.GasUsed = call.Gas
+	}
+	// Flatten the failed call into its parent, or leave it if it was the last
+	if len(t.callstack) > 0 {
+		parent := t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, call)
+		return
+	}
+	t.callstack = append(t.callstack, call)
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *CallTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	t.root.Output = (*hexutil.Bytes)(&output)
+	t.root.GasUsed = (*hexutil.Uint64)(&gasUsed)
+	t.root.Time = d.String()
+	t.ctxErr = err
+	return nil
+}
+
+// Result returns the call tree of the transaction, or any error the tracing ran
+// into. The type of the outer call is empty if nothing was executed.
+func (t *CallTracer) Result() (*CallFrame, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	result := t.root
+	result.Calls = t.callstack[0].Calls
+
+	if t.callstack[0].Error != "" {
+		result.Error = t.callstack[0].Error
+	} else if t.ctxErr != nil {
+		result.Error = t.ctxErr.Error()
+	}
+	if result.Error != "" {
+		result.Output = nil
+	}
+	return &result, nil
+}
+
+// GetResult returns the JSON encoded call tree of the transaction, or any
+// error the tracing ran into.
+func (t *CallTracer) GetResult() (json.RawMessage, error) {
+	result, err := t.Result()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+// MemorySlice returns a copy of the requested range of memory, or an empty slice
+// if the range is out of bounds.
+func MemorySlice(memory *vm.Memory, offset, size uint64) []byte {
+	end := offset + size
+	if end < offset || uint64(memory.Len()) < end {
+		return nil
+	}
+	return memory.Get(int64(offset), int64(size))
+}
@@ -0,0 +1,286 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/common/hexutil"
+	"github.com/utchain/go-utchain/core/vm"
+	"github.com/utchain/go-utchain/crypto"
+)
+
+// prestateAccount is the state of an account before the traced transaction, as
+// reported by prestate_tracer.js.
+type prestateAccount struct {
+	Balance *hexutil.Big                `json:"balance"`
+	Nonce   uint64                      `json:"nonce"`
+	Code    hexutil.Bytes               `json:"code"`
+	Storage map[common.Hash]common.Hash `json:"storage"`
+
+	existed bool // Whether the account existed before the transaction
+}
+
+// poststateAccount is the modified part of an account after the traced
+// transaction, reported in diff mode only.
+type poststateAccount struct {
+	Balance *hexutil.Big                `json:"balance,omitempty"`
+	Nonce   *uint64                     `json:"nonce,omitempty"`
+	Code    *hexutil.Bytes              `json:"code,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+}
+
+// prestateDiff is the result of the prestate tracer in diff mode.
+type prestateDiff struct {
+	Pre  map[common.Address]*prestateAccount  `json:"pre"`
+	Post map[common.Address]*poststateAccount `json:"post"`
+}
+
+// prestateTracerConfig is the configuration accepted by the native prestate
+// tracer.
+type prestateTracerConfig struct {
+	DiffMode bool `json:"diffMode"` // Report the modified accounts before and after the transaction
+}
+
+// prestateTracer is the native version of prestate_tracer.js, collecting the
+// state needed to replay a transaction locally. By default its output matches
+// the JavaScript tracer's. In diff mode it reports only the accounts and slots
+// the transaction modified, both before and after it.
+type prestateTracer struct {
+	config   prestateTracerConfig
+	prestate map[common.Address]*prestateAccount
+	db       vm.StateDB // State database of the traced execution, once known
+	started  bool       // Whether the first opcode was executed
+
+	from   common.Address // Sender of the outer transaction
+	to     common.Address // Recipient of the outer transaction
+	value  *big.Int       // Value transferred by the outer transaction
+	create bool           // Whether the outer transaction creates a contract
+
+	err       error  // Error, if one has occurred
+	interrupt uint32 // Atomic flag to signal execution interruption
+	reason    error  // Textual reason for the interruption
+}
+
+// newPrestateTracer creates a native prestate tracer.
+func newPrestateTracer(config json.RawMessage) (Interface, error) {
+	tracer := &prestateTracer{
+		prestate: make(map[common.Address]*prestateAccount),
+	}
+	if len(config) > 0 {
+		if err := json.Unmarshal(config, &tracer.config); err != nil {
+			return nil, err
+		}
+	}
+	return tracer, nil
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *prestateTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
+
+// CaptureTxStart implements TxStartCapturer to record the sender, recipient and
+// coinbase of the transaction before its gas is bought and its value moved. This
+// is only done in diff mode, the default output mirrors prestate_tracer.js which
+// looks the accounts up during execution.
+func (t *prestateTracer) CaptureTxStart(db vm.StateDB, from common.Address, to *common.Address, coinbase common.Address) {
+	t.db = db
+	if !t.config.DiffMode {
+		return
+	}
+	t.lookupAccount(from)
+	if to != nil {
+		t.lookupAccount(*to)
+	} else {
+		t.lookupAccount(crypto.CreateAddress(from, db.GetNonce(from)))
+	}
+	t.lookupAccount(coinbase)
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *prestateTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.from, t.to, t.value, t.create = from, to, value, create
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *prestateTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.err != nil {
+		return nil
+	}
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		t.err = t.reason
+		return nil
+	}
+	// Without the start of the transaction captured, only the accounts accessed
+	// during execution can be tracked
+	if t.db == nil {
+		t.db = env.StateDB
+	}
+	// Add the current account if we just started tracing. Its balance includes
+	// the value sent along with the message, which is fixed up in GetResult
+	if !t.started {
+		t.started = true
+		t.lookupAccount(contract.Address())
+	}
+	// Whenever new state is accessed, add it to the prestate
+	switch op {
+	case vm.EXTCODECOPY, vm.EXTCODESIZE, vm.BALANCE:
+		t.lookupAccount(common.BigToAddress(stack.Back(0)))
+	case vm.CREATE:
+		from := contract.Address()
+		t.lookupAccount(crypto.CreateAddress(from, t.db.GetNonce(from)))
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		t.lookupAccount(common.BigToAddress(stack.Back(1)))
+	case vm.SSTORE, vm.SLOAD:
+		t.lookupStorage(contract.Address(), common.BigToHash(stack.Back(0)))
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *prestateTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *prestateTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+// lookupAccount injects the specified account into the prestate.
+func (t *prestateTracer) lookupAccount(addr common.Address) {
+	if _, ok := t.prestate[addr]; ok {
+		return
+	}
+	t.prestate[addr] = &prestateAccount{
+		Balance: (*hexutil.Big)(new(big.Int).Set(t.db.GetBalance(addr))),
+		Nonce:   t.db.GetNonce(addr),
+		Code:    t.db.GetCode(addr),
+		Storage: make(map[common.Hash]common.Hash),
+		existed: t.db.Exist(addr),
+	}
+}
+
+// lookupStorage injects the specified storage entry of the given account into
+// the prestate. Empty slots are only tracked in diff mode, and only reported
+// there if modified.
+func (t *prestateTracer) lookupStorage(addr common.Address, key common.Hash) {
+	t.lookupAccount(addr)
+	if _, ok := t.prestate[addr].Storage[key]; ok {
+		return
+	}
+	if val := t.db.GetState(addr, key); val != (common.Hash{}) || t.config.DiffMode {
+		t.prestate[addr].Storage[key] = val
+	}
+}
+
+// GetResult returns the JSON encoded prestate of the transaction, or in diff
+// mode the modified state before and after it.
+func (t *prestateTracer) GetResult() (json.RawMessage, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	if !t.config.DiffMode {
+		if t.db != nil && t.value != nil {
+			t.unwindOuterCall()
+		}
+		return json.Marshal(t.prestate)
+	}
+	return json.Marshal(t.diff())
+}
+
+// unwindOuterCall moves the value of the outer transaction back from the
+// recipient to the sender and decrements the sender's nonce, as both accounts
+// were looked up after the transaction started.
+func (t *prestateTracer) unwindOuterCall() {
+	t.lookupAccount(t.from)
+	t.lookupAccount(t.to)
+
+	fromBal := t.prestate[t.from].Balance.ToInt()
+	toBal := t.prestate[t.to].Balance.ToInt()
+
+	t.prestate[t.to].Balance = (*hexutil.Big)(new(big.Int).Sub(toBal, t.value))
+	t.prestate[t.from].Balance = (*hexutil.Big)(new(big.Int).Add(fromBal, t.value))
+	t.prestate[t.from].Nonce--
+
+	// Any existing state of a created contract would have caused the
+	// transaction to be rejected as invalid in the first place
+	if t.create {
+		delete(t.prestate, t.to)
+	}
+}
+
+// diff assembles the accounts modified by the transaction, with their fields
+// and storage slots before and after it.
+func (t *prestateTracer) diff() *prestateDiff {
+	var (
+		pre  = make(map[common.Address]*prestateAccount)
+		post = make(map[common.Address]*poststateAccount)
+	)
+	for addr, account := range t.prestate {
+		// Deleted accounts only show up before the transaction
+		if t.db.HasSuicided(addr) || !t.db.Exist(addr) {
+			if account.existed {
+				pre[addr] = account
+			}
+			continue
+		}
+		var (
+			modified bool
+			changes  = &poststateAccount{Storage: make(map[common.Hash]common.Hash)}
+		)
+		if balance := t.db.GetBalance(addr); balance.Cmp(account.Balance.ToInt()) != 0 {
+			changes.Balance, modified = (*hexutil.Big)(new(big.Int).Set(balance)), true
+		}
+		if nonce := t.db.GetNonce(addr); nonce != account.Nonce {
+			changes.Nonce, modified = &nonce, true
+		}
+		if code := t.db.GetCode(addr); !bytes.Equal(code, account.Code) {
+			changes.Code, modified = (*hexutil.Bytes)(&code), true
+		}
+		for key, val := range account.Storage {
+			current := t.db.GetState(addr, key)
+			if current != val {
+				modified = true
+				if current != (common.Hash{}) {
+					changes.Storage[key] = current
+				}
+			}
+			// Only modified, non-empty slots are reported before the transaction
+			if current == val || val == (common.Hash{}) {
+				delete(account.Storage, key)
+			}
+		}
+		if !modified {
+			continue
+		}
+		post[addr] = changes
+		if account.existed {
+			pre[addr] = account
+		}
+	}
+	return &prestateDiff{Pre: pre, Post: post}
+}
@@ -18,6 +18,7 @@ package tracers
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"math/big"
 	"path/filepath"
@@ -116,79 +117,188 @@ type callTracerTest struct {
 	Result  *callTrace    `json:"result"`
 }
 
-// Iterates over all the input-output datasets in the tracer test harness and
-// runs the JavaScript tracers against them.
-func TestCallTracer(t *testing.T) {
+// runTracerTest executes the transaction of a tracer test against its prestate
+// with the given tracer attached, returning the trace result.
+func runTracerTest(test *callTracerTest, name string, config json.RawMessage) (json.RawMessage, error) {
+	// Configure a blockchain with the given prestate
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(common.FromHex(test.Input), tx); err != nil {
+		return nil, fmt.Errorf("failed to parse testcase input: %v", err)
+	}
+	signer := types.MakeSigner(test.Genesis.Config, new(big.Int).SetUint64(uint64(test.Context.Number)))
+	origin, _ := signer.Sender(tx)
+
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Origin:      origin,
+		Coinbase:    test.Context.Miner,
+		BlockNumber: new(big.Int).SetUint64(uint64(test.Context.Number)),
+		Time:        new(big.Int).SetUint64(uint64(test.Context.Time)),
+		Difficulty:  (*big.Int)(test.Context.Difficulty),
+		GasLimit:    uint64(test.Context.GasLimit),
+		GasPrice:    tx.GasPrice(),
+	}
+	db, _ := tstdb.NewMemDatabase()
+	statedb := tests.MakePreState(db, test.Genesis.Alloc)
+
+	// Create the tracer, the EVM environment and run it
+	tracer, err := Create(name, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tracer: %v", err)
+	}
+	evm := vm.NewEVM(context, statedb, test.Genesis.Config, vm.Config{Debug: true, Tracer: tracer})
+
+	msg, err := tx.AsMessage(signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare transaction for tracing: %v", err)
+	}
+	if capturer, ok := tracer.(TxStartCapturer); ok {
+		capturer.CaptureTxStart(statedb, msg.From(), msg.To(), context.Coinbase)
+	}
+	st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+	if _, _, _, err = st.TransitionDb(); err != nil {
+		return nil, fmt.Errorf("failed to execute transaction: %v", err)
+	}
+	// Retrieve the trace result
+	res, err := tracer.GetResult()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve trace result: %v", err)
+	}
+	return res, nil
+}
+
+// loadTracerTests reads all the call tracer test cases from the test harness.
+func loadTracerTests(t *testing.T) map[string]*callTracerTest {
 	files, err := ioutil.ReadDir("testdata")
 	if err != nil {
 		t.Fatalf("failed to retrieve tracer test suite: %v", err)
 	}
+	tests := make(map[string]*callTracerTest)
 	for _, file := range files {
 		if !strings.HasPrefix(file.Name(), "call_tracer_") {
 			continue
 		}
-		file := file // capture range variable
-		t.Run(camel(strings.TrimSuffix(strings.TrimPrefix(file.Name(), "call_tracer_"), ".json")), func(t *testing.T) {
+		// Call tracer test found, read if from disk
+		blob, err := ioutil.ReadFile(filepath.Join("testdata", file.Name()))
+		if err != nil {
+			t.Fatalf("failed to read testcase: %v", err)
+		}
+		test := new(callTracerTest)
+		if err := json.Unmarshal(blob, test); err != nil {
+			t.Fatalf("failed to parse testcase: %v", err)
+		}
+		tests[camel(strings.TrimSuffix(strings.TrimPrefix(file.Name(), "call_tracer_"), ".json"))] = test
+	}
+	return tests
+}
+
+// Iterates over all the input-output datasets in the tracer test harness and
+// runs both the JavaScript and the native call tracers against them.
+func TestCallTracer(t *testing.T) {
+	for name, test := range loadTracerTests(t) {
+		for _, tracer := range []string{"callTracer", "nativeCallTracer"} {
+			test, tracer := test, tracer // capture range variables
+			t.Run(tracer+"/"+name, func(t *testing.T) {
+				t.Parallel()
+
+				res, err := runTracerTest(test, tracer, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ret := new(callTrace)
+				if err := json.Unmarshal(res, ret); err != nil {
+					t.Fatalf("failed to unmarshal trace result: %v", err)
+				}
+				if !reflect.DeepEqual(ret, test.Result) {
+					t.Fatalf("trace mismatch: have %+v, want %+v", ret, test.Result)
+				}
+			})
+		}
+	}
+}
+
+// Tests that the native prestate tracer reports the same prestate as the
+// JavaScript one for all the datasets in the tracer test harness.
+func TestPrestateTracer(t *testing.T) {
+	for name, test := range loadTracerTests(t) {
+		test := test // capture range variable
+		t.Run(name, func(t *testing.T) {
 			t.Parallel()
 
-			// Call tracer test found, read if from disk
-			blob, err := ioutil.ReadFile(filepath.Join("testdata", file.Name()))
-			if err != nil {
-				t.Fatalf("failed to read testcase: %v", err)
-			}
-			test := new(callTracerTest)
-			if err := json.Unmarshal(blob, test); err != nil {
-				t.Fatalf("failed to parse testcase: %v", err)
+			var results [2]map[common.Address]*prestateAccount
+			for i, tracer := range []string{"prestateTracer", "nativePrestateTracer"} {
+				res, err := runTracerTest(test, tracer, nil)
+				if err != nil {
+					t.Fatalf("%s: %v", tracer, err)
+				}
+				if err := json.Unmarshal(res, &results[i]); err != nil {
+					t.Fatalf("%s: failed to unmarshal trace result: %v", tracer, err)
+				}
 			}
-			// Configure a blockchain with the given prestate
-			tx := new(types.Transaction)
-			if err := rlp.DecodeBytes(common.FromHex(test.Input), tx); err != nil {
-				t.Fatalf("failed to parse testcase input: %v", err)
+			if !reflect.DeepEqual(results[0], results[1]) {
+				t.Fatalf("prestate mismatch: have %v, want %v", results[1], results[0])
 			}
-			signer := types.MakeSigner(test.Genesis.Config, new(big.Int).SetUint64(uint64(test.Context.Number)))
-			origin, _ := signer.Sender(tx)
-
-			context := vm.Context{
-				CanTransfer: core.CanTransfer,
-				Transfer:    core.Transfer,
-				Origin:      origin,
-				Coinbase:    test.Context.Miner,
-				BlockNumber: new(big.Int).SetUint64(uint64(test.Context.Number)),
-				Time:        new(big.Int).SetUint64(uint64(test.Context.Time)),
-				Difficulty:  (*big.Int)(test.Context.Difficulty),
-				GasLimit:    uint64(test.Context.GasLimit),
-				GasPrice:    tx.GasPrice(),
-			}
-			db, _ := tstdb.NewMemDatabase()
-			statedb := tests.MakePreState(db, test.Genesis.Alloc)
+		})
+	}
+}
 
-			// Create the tracer, the EVM environment and run it
-			tracer, err := New("callTracer")
-			if err != nil {
-				t.Fatalf("failed to create call tracer: %v", err)
-			}
-			evm := vm.NewEVM(context, statedb, test.Genesis.Config, vm.Config{Debug: true, Tracer: tracer})
+// Tests that the native prestate tracer in diff mode only reports accounts the
+// transaction modified, with their state both before and after it.
+func TestPrestateTracerDiffMode(t *testing.T) {
+	test := loadTracerTests(t)["simple"]
 
-			msg, err := tx.AsMessage(signer)
-			if err != nil {
-				t.Fatalf("failed to prepare transaction for tracing: %v", err)
-			}
-			st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
-			if _, _, _, err = st.TransitionDb(); err != nil {
-				t.Fatalf("failed to execute transaction: %v", err)
-			}
-			// Retrieve the trace result and compare against the etalon
-			res, err := tracer.GetResult()
-			if err != nil {
-				t.Fatalf("failed to retrieve trace result: %v", err)
-			}
-			ret := new(callTrace)
-			if err := json.Unmarshal(res, ret); err != nil {
-				t.Fatalf("failed to unmarshal trace result: %v", err)
-			}
-			if !reflect.DeepEqual(ret, test.Result) {
-				t.Fatalf("trace mismatch: have %+v, want %+v", ret, test.Result)
+	res, err := runTracerTest(test, "nativePrestateTracer", json.RawMessage(`{"diffMode": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff := new(prestateDiff)
+	if err := json.Unmarshal(res, diff); err != nil {
+		t.Fatalf("failed to unmarshal trace result: %v", err)
+	}
+	if len(diff.Post) == 0 {
+		t.Fatalf("no modified accounts reported")
+	}
+	for addr, post := range diff.Post {
+		pre, ok := diff.Pre[addr]
+		if !ok {
+			continue // created account
+		}
+		for key, val := range post.Storage {
+			if pre.Storage[key] == val {
+				t.Errorf("account %x: unmodified slot %x reported", addr, key)
 			}
-		})
+		}
+		if post.Balance == nil && post.Nonce == nil && post.Code == nil && len(post.Storage) == 0 {
+			t.Errorf("account %x: reported without modifications", addr)
+		}
+	}
+	for addr := range diff.Pre {
+		if _, ok := diff.Post[addr]; !ok {
+			t.Errorf("account %x: deleted in simple call", addr)
+		}
+	}
+	// The sender paid the value and rewound its nonce
+	from, coinbase := test.Result.From, test.Context.Miner
+	if diff.Pre[from] == nil || diff.Post[from] == nil || diff.Post[from].Nonce == nil || *diff.Post[from].Nonce != diff.Pre[from].Nonce+1 {
+		t.Fatalf("sender nonce change missing: pre %+v, post %+v", diff.Pre[from], diff.Post[from])
+	}
+	// The sender paid the fee collected by the coinbase along with the value
+	if have, want := diff.Pre[from].Balance.ToInt(), test.Genesis.Alloc[from].Balance; have.Cmp(want) != 0 {
+		t.Errorf("sender prestate balance mismatch: have %v, want %v", have, want)
+	}
+	if diff.Post[from].Balance == nil || diff.Post[coinbase] == nil || diff.Post[coinbase].Balance == nil {
+		t.Fatalf("fee payment missing: sender %+v, coinbase %+v", diff.Post[from], diff.Post[coinbase])
+	}
+	fee := diff.Post[coinbase].Balance.ToInt()
+	if pre := diff.Pre[coinbase]; pre != nil {
+		fee = new(big.Int).Sub(fee, pre.Balance.ToInt())
+	}
+	if fee.Sign() <= 0 {
+		t.Errorf("coinbase fee not positive: %v", fee)
+	}
+	paid := new(big.Int).Sub(diff.Pre[from].Balance.ToInt(), diff.Post[from].Balance.ToInt())
+	if want := new(big.Int).Add(fee, test.Result.Value.ToInt()); paid.Cmp(want) != 0 {
+		t.Errorf("sender payment mismatch: have %v, want %v (fee %v)", paid, want, fee)
 	}
 }
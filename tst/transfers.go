@@ -0,0 +1,381 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package tst
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/consensus/misc"
+	"github.com/utchain/go-utchain/core"
+	"github.com/utchain/go-utchain/core/state"
+	"github.com/utchain/go-utchain/core/state/pruner"
+	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/core/vm"
+	"github.com/utchain/go-utchain/log"
+	"github.com/utchain/go-utchain/params"
+	"github.com/utchain/go-utchain/tst/tracers"
+	"github.com/utchain/go-utchain/tstdb"
+)
+
+const (
+	// transferSectionSize is the number of blocks in a single internal transfer
+	// index section. It's kept small so that transfers become queryable soon.
+	transferSectionSize = 64
+
+	// transferConfirms is the number of confirmation blocks before a section is
+	// considered final and its internal transfers are indexed.
+	transferConfirms = 16
+
+	// transferThrottling is the time to wait between processing two consecutive
+	// index sections.
+	transferThrottling = 10 * time.Millisecond
+
+	// transferStateFlush is the number of sections after which the regenerated
+	// state is written to disk, bounding the blocks to re-execute after a restart.
+	// Only the most recently flushed state is kept, the older ones are pruned.
+	transferStateFlush = 64
+
+	// transferStateBloom is the size in megabytes of the bloom filter tracking the
+	// flushed state while pruning the older ones.
+	transferStateBloom = 64
+)
+
+// TransferIndexer implements a core.ChainIndexer, re-executing the canonical chain
+// to index the value transfers made by contracts by the addresses taking part in
+// them. The state is regenerated privately, starting off the genesis or the most
+// recent state it flushed, so the index doesn't depend on the pruning mode.
+//
+// The regenerated state lives in its own namespace of the chain database, so it
+// neither inflates the state kept by the garbage collector nor gets deleted by
+// the state pruner. It holds a single full copy of the state, as every flush
+// prunes the previously flushed one, and can be dropped together with the index
+// to reclaim space.
+type TransferIndexer struct {
+	db     tstdb.Database      // database instance to write index data into
+	chain  *core.BlockChain    // blockchain to retrieve and re-execute the blocks of
+	config *params.ChainConfig // chain configuration to re-execute the blocks with
+
+	table    tstdb.Database // namespace of the chain database holding the private state
+	database state.Database // private state database to regenerate the state in
+	statedb  *state.StateDB // state after the last processed block
+	root     common.Hash    // root of the state held referenced in the memory database
+	head     common.Hash    // hash of the last processed block
+
+	section uint64      // section is the section number being processed currently
+	batch   tstdb.Batch // batch of index data written by the current section
+	err     error       // error the current section ran into, if any
+}
+
+// NewTransferIndexer returns a chain indexer that records the value transfers
+// made by contracts on the canonical chain, for lookups by address.
+func NewTransferIndexer(db tstdb.Database, chain *core.BlockChain) *core.ChainIndexer {
+	table := tstdb.NewTable(db, string(core.TransferIndexPrefix))
+	return core.NewChainIndexer(db, table, newTransferIndexer(db, chain), transferSectionSize, transferConfirms, transferThrottling, "transfers")
+}
+
+// newTransferIndexer creates the backend of the internal transfer indexer.
+func newTransferIndexer(db tstdb.Database, chain *core.BlockChain) *TransferIndexer {
+	table := tstdb.NewTable(db, string(core.TransferStatePrefix))
+	return &TransferIndexer{
+		db:       db,
+		chain:    chain,
+		config:   chain.Config(),
+		table:    table,
+		database: state.NewDatabase(table),
+	}
+}
+
+// Reset implements core.ChainIndexerBackend, starting a new internal transfer
+// index section on top of the state of its previous section head.
+func (t *TransferIndexer) Reset(section uint64, lastSectionHead common.Hash) error {
+	t.section, t.batch, t.err = section, t.db.NewBatch(), nil
+
+	// Continue on the state of the last section if we've just processed it
+	if t.statedb != nil && t.head == lastSectionHead {
+		return nil
+	}
+	t.drop()
+	if lastSectionHead == (common.Hash{}) {
+		return nil // Genesis section, the state is set up when processing it
+	}
+	return t.regenerate(lastSectionHead)
+}
+
+// Process implements core.ChainIndexerBackend, re-executing a new block and
+// adding the internal transfers made in it to the index.
+func (t *TransferIndexer) Process(header *types.Header) {
+	if t.err != nil {
+		return
+	}
+	// The genesis block contains no transactions, only set up its state
+	if header.Number.Sign() == 0 {
+		if t.err = t.seed(header.Root); t.err != nil {
+			return
+		}
+		if t.statedb, t.err = state.New(header.Root, t.database); t.err == nil {
+			t.head = header.Hash()
+		}
+		return
+	}
+	block := t.chain.GetBlock(header.Hash(), header.Number.Uint64())
+	if block == nil {
+		t.err = fmt.Errorf("block #%d [%x…] not found", header.Number, header.Hash().Bytes()[:4])
+		return
+	}
+	transfers, err := t.execute(block, true)
+	if err != nil {
+		t.err = err
+		t.drop()
+		return
+	}
+	for addr, list := range transfers {
+		if t.err = core.WriteInternalTransfers(t.batch, addr, block.NumberU64(), block.Hash(), list); t.err != nil {
+			return
+		}
+	}
+	if t.batch.ValueSize() > tstdb.IdealBatchSize {
+		if t.err = t.batch.Write(); t.err != nil {
+			return
+		}
+		t.batch.Reset()
+	}
+	t.head = block.Hash()
+}
+
+// Commit implements core.ChainIndexerBackend, writing out the internal transfers
+// of the section into the database.
+func (t *TransferIndexer) Commit() error {
+	if t.err != nil {
+		return t.err
+	}
+	if err := t.batch.Write(); err != nil {
+		return err
+	}
+	// Persist the regenerated state every now and then to avoid re-executing the
+	// entire chain after a restart
+	if (t.section+1)%transferStateFlush == 0 && t.root != (common.Hash{}) {
+		if err := t.database.TrieDB().Commit(t.root, false); err != nil {
+			return err
+		}
+		// Resuming only ever needs the latest flushed state (or the genesis, which
+		// is seeded anew if needed), delete everything else from the namespace
+		if err := pruner.NewPruner(t.table, transferStateBloom).Prune([]common.Hash{t.root}); err != nil {
+			return err
+		}
+		t.root = common.Hash{}
+	}
+	return nil
+}
+
+// regenerate recreates the state after the given block by re-executing the chain
+// on top of the most recent state available before it.
+func (t *TransferIndexer) regenerate(head common.Hash) error {
+	header := t.chain.GetHeaderByHash(head)
+	if header == nil {
+		return fmt.Errorf("block %x not found", head)
+	}
+	origin := header.Number.Uint64()
+
+	// Find the most recent block with its state available, falling back to the
+	// genesis if no state was flushed yet
+	block := t.chain.GetBlock(head, origin)
+	for block != nil {
+		if block.NumberU64() == 0 {
+			if err := t.seed(block.Root()); err != nil {
+				return err
+			}
+		}
+		statedb, err := state.New(block.Root(), t.database)
+		if err == nil {
+			t.statedb = statedb
+			break
+		}
+		block = t.chain.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	}
+	if block == nil {
+		return fmt.Errorf("no state available to regenerate block #%d", origin)
+	}
+	// Re-execute the blocks up to the requested one without indexing them
+	var (
+		start  = time.Now()
+		logged time.Time
+	)
+	for number := block.NumberU64() + 1; number <= origin; number++ {
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Regenerating state for transfer index", "block", number, "target", origin, "elapsed", time.Since(start))
+			logged = time.Now()
+		}
+		if block = t.chain.GetBlockByNumber(number); block == nil {
+			t.drop()
+			return fmt.Errorf("block #%d not found", number)
+		}
+		if _, err := t.execute(block, false); err != nil {
+			t.drop()
+			return err
+		}
+	}
+	if block.Hash() != head {
+		t.drop()
+		return fmt.Errorf("chain reorged during state regeneration")
+	}
+	t.head = head
+	return nil
+}
+
+// seed copies the genesis state from the chain database into the private state
+// namespace, unless it's already there, to regenerate any later state off it.
+func (t *TransferIndexer) seed(root common.Hash) error {
+	if has, _ := t.table.Has(root.Bytes()); has {
+		return nil
+	}
+	statedb, err := state.New(root, state.NewDatabase(t.db))
+	if err != nil {
+		return err
+	}
+	var (
+		it    = state.NewNodeIterator(statedb)
+		batch = t.table.NewBatch()
+	)
+	for it.Next() {
+		// Embedded trie nodes have no hash and are stored as part of their parents
+		if it.Hash == (common.Hash{}) {
+			continue
+		}
+		blob, err := t.db.Get(it.Hash.Bytes())
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(it.Hash.Bytes(), blob); err != nil {
+			return err
+		}
+		if batch.ValueSize() > tstdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if it.Error != nil {
+		return it.Error
+	}
+	return batch.Write()
+}
+
+// execute re-executes a block on top of the current state, and if requested, it
+// gathers the internal transfers made in it by the addresses taking part.
+func (t *TransferIndexer) execute(block *types.Block, trace bool) (map[common.Address][]*types.InternalTransfer, error) {
+	var (
+		header    = block.Header()
+		receipts  types.Receipts
+		usedGas   = new(uint64)
+		gp        = new(core.GasPool).AddGas(block.GasLimit())
+		transfers = make(map[common.Address][]*types.InternalTransfer)
+	)
+	// Mutate the the block and state according to any hard-fork specs
+	if t.config.DAOForkSupport && t.config.DAOForkBlock != nil && t.config.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(t.statedb)
+	}
+	for i, tx := range block.Transactions() {
+		t.statedb.Prepare(tx.Hash(), block.Hash(), i)
+
+		tracer := tracers.NewCallTracer(true)
+		receipt, _, err := core.ApplyTransaction(t.config, t.chain, nil, gp, t.statedb, header, tx, usedGas, vm.Config{Debug: trace, Tracer: tracer})
+		if err != nil {
+			return nil, fmt.Errorf("tx %x failed: %v", tx.Hash(), err)
+		}
+		receipts = append(receipts, receipt)
+
+		if trace {
+			for _, transfer := range internalTransfers(tracer, tx.Hash()) {
+				transfers[transfer.From] = append(transfers[transfer.From], transfer)
+				transfers[transfer.To] = append(transfers[transfer.To], transfer)
+			}
+		}
+	}
+	t.chain.Engine().Finalize(t.chain, header, t.statedb, block.Transactions(), block.Uncles(), receipts)
+
+	// Commit the state into the private memory database, dropping the previous one
+	root, err := t.statedb.Commit(t.config.IsEIP158(block.Number()))
+	if err != nil {
+		return nil, err
+	}
+	if root != block.Root() {
+		return nil, fmt.Errorf("state root mismatch in block #%d: have %x, want %x", block.NumberU64(), root, block.Root())
+	}
+	if err := t.statedb.Reset(root); err != nil {
+		return nil, err
+	}
+	t.database.TrieDB().Reference(root, common.Hash{})
+	t.database.TrieDB().Dereference(t.root, common.Hash{})
+	t.root = root
+
+	return transfers, nil
+}
+
+// drop discards the regenerated state, releasing it from the memory database.
+func (t *TransferIndexer) drop() {
+	if t.root != (common.Hash{}) {
+		t.database.TrieDB().Dereference(t.root, common.Hash{})
+	}
+	t.statedb, t.root, t.head = nil, common.Hash{}, common.Hash{}
+}
+
+// transferTypes maps the call frame types moving value between the two parties
+// of the frame to the type of the internal transfer.
+var transferTypes = map[string]string{
+	"CALL":         "call",
+	"CREATE":       "create",
+	"SELFDESTRUCT": "suicide",
+}
+
+// internalTransfers returns the value transfers made by contracts within the call
+// tree gathered by a detailed call tracer. Transfers of failed or reverted calls
+// are left out, as are those of a failed transaction.
+func internalTransfers(tracer *tracers.CallTracer, tx common.Hash) []*types.InternalTransfer {
+	root, err := tracer.Result()
+	if err != nil || root.Type == "" || root.Error != "" {
+		return nil
+	}
+	var (
+		transfers []*types.InternalTransfer
+		gather    func(frames []*tracers.CallFrame)
+	)
+	gather = func(frames []*tracers.CallFrame) {
+		for _, frame := range frames {
+			if frame.Error != "" {
+				continue
+			}
+			if typ, ok := transferTypes[frame.Type]; ok {
+				if value := frame.Value.ToInt(); value.Sign() > 0 && *frame.From != *frame.To {
+					transfers = append(transfers, &types.InternalTransfer{
+						TxHash: tx,
+						Type:   typ,
+						From:   *frame.From,
+						To:     *frame.To,
+						Value:  new(big.Int).Set(value),
+					})
+				}
+			}
+			gather(frame.Calls)
+		}
+	}
+	gather(root.Calls)
+	return transfers
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package tst
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/core"
+	"github.com/utchain/go-utchain/core/types"
+	"github.com/utchain/go-utchain/core/vm"
+	"github.com/utchain/go-utchain/crypto"
+	"github.com/utchain/go-utchain/params"
+)
+
+// Tests that the transfer indexer records the value forwarded by contracts for
+// both parties, both when continuing its own state and when regenerating it.
+func TestTransferIndexer(t *testing.T) {
+	var (
+		key, _    = crypto.GenerateKey()
+		sender    = crypto.PubkeyToAddress(key.PublicKey)
+		contract  = common.Address{0xc0}
+		recipient = common.Address{0xee}
+		signer    = types.HomesteadSigner{}
+		txs       []*types.Transaction
+	)
+	// Forward a single wei to the recipient with only the stipend as gas
+	code := []byte{
+		byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 1,
+		byte(vm.PUSH20),
+	}
+	code = append(code, recipient.Bytes()...)
+	code = append(code, byte(vm.PUSH1), 0, byte(vm.CALL), byte(vm.POP), byte(vm.STOP))
+
+	backend := newTestTracerBackend(t, 3, core.GenesisAlloc{
+		sender:   {Balance: big.NewInt(params.Tster)},
+		contract: {Balance: new(big.Int), Code: code},
+	}, func(i int, b *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(sender), contract, big.NewInt(10), 100000, nil, nil), signer, key)
+		b.AddTx(tx)
+		txs = append(txs, tx)
+	})
+	chain := backend.blockchain
+
+	// Index the genesis and the first block, continuing on the same state
+	indexer := newTransferIndexer(backend.chainDb, chain)
+	for number := uint64(0); number < 2; number++ {
+		var prev common.Hash
+		if number > 0 {
+			prev = chain.GetBlockByNumber(number - 1).Hash()
+		}
+		if err := indexer.Reset(number, prev); err != nil {
+			t.Fatalf("section %d: failed to reset indexer: %v", number, err)
+		}
+		indexer.Process(chain.GetHeaderByNumber(number))
+		if err := indexer.Commit(); err != nil {
+			t.Fatalf("section %d: failed to commit index: %v", number, err)
+		}
+	}
+	// The state should be regenerated in the indexer's own namespace
+	if has, _ := indexer.table.Has(chain.Genesis().Root().Bytes()); !has {
+		t.Fatalf("genesis state not seeded into the indexer namespace")
+	}
+	// Index the remaining blocks by a fresh indexer, regenerating the state
+	indexer = newTransferIndexer(backend.chainDb, chain)
+	for number := uint64(2); number <= 3; number++ {
+		if err := indexer.Reset(number, chain.GetBlockByNumber(number-1).Hash()); err != nil {
+			t.Fatalf("section %d: failed to reset indexer: %v", number, err)
+		}
+		indexer.Process(chain.GetHeaderByNumber(number))
+		if err := indexer.Commit(); err != nil {
+			t.Fatalf("section %d: failed to commit index: %v", number, err)
+		}
+	}
+	// Every block should contain the same transfer for both the parties
+	for _, addr := range []common.Address{contract, recipient} {
+		var numbers []uint64
+		err := core.IterateInternalTransfers(backend.chainDb, addr, 0, 3, func(number uint64, hash common.Hash, transfers []*types.InternalTransfer) {
+			if len(transfers) != 1 {
+				t.Fatalf("%x, block #%d: transfer count mismatch: have %d, want 1", addr, number, len(transfers))
+			}
+			transfer := transfers[0]
+			if transfer.TxHash != txs[number-1].Hash() || transfer.Type != "call" || transfer.From != contract || transfer.To != recipient || transfer.Value.Int64() != 1 {
+				t.Errorf("%x, block #%d: transfer mismatch: have %+v", addr, number, transfer)
+			}
+			numbers = append(numbers, number)
+		})
+		if err != nil {
+			t.Fatalf("%x: failed to iterate transfers: %v", addr, err)
+		}
+		if len(numbers) != 3 {
+			t.Errorf("%x: indexed blocks mismatch: have %v, want [1 2 3]", addr, numbers)
+		}
+	}
+	// The sender's own transfers are not internal ones
+	core.IterateInternalTransfers(backend.chainDb, sender, 0, 3, func(number uint64, hash common.Hash, transfers []*types.InternalTransfer) {
+		t.Errorf("sender has internal transfers in block #%d: %v", number, transfers)
+	})
+}
+
+// Tests that flushing the regenerated state to disk prunes the previously
+// flushed one, and that indexing can resume on top of the latest.
+func TestTransferIndexerStateFlush(t *testing.T) {
+	backend := newTestTracerBackend(t, 4, core.GenesisAlloc{}, nil)
+	chain := backend.blockchain
+
+	// Index every block as the last section before a state flush
+	indexer := newTransferIndexer(backend.chainDb, chain)
+	for number := uint64(0); number < 4; number++ {
+		var prev common.Hash
+		if number > 0 {
+			prev = chain.GetBlockByNumber(number - 1).Hash()
+		}
+		section := (number+1)*transferStateFlush - 1
+		if err := indexer.Reset(section, prev); err != nil {
+			t.Fatalf("block #%d: failed to reset indexer: %v", number, err)
+		}
+		indexer.Process(chain.GetHeaderByNumber(number))
+		if err := indexer.Commit(); err != nil {
+			t.Fatalf("block #%d: failed to commit index: %v", number, err)
+		}
+		// Only the state of the last indexed block should be kept
+		for i := uint64(1); i <= number; i++ {
+			root := chain.GetBlockByNumber(i).Root()
+			if has, _ := indexer.table.Has(root.Bytes()); has != (i == number) {
+				t.Errorf("block #%d: state of block #%d presence mismatch: have %v, want %v", number, i, has, i == number)
+			}
+		}
+	}
+	// A fresh indexer should continue on the flushed state without the genesis
+	indexer = newTransferIndexer(backend.chainDb, chain)
+	if err := indexer.Reset(4*transferStateFlush, chain.GetBlockByNumber(3).Hash()); err != nil {
+		t.Fatalf("failed to resume indexer: %v", err)
+	}
+	if has, _ := indexer.table.Has(chain.Genesis().Root().Bytes()); has {
+		t.Errorf("genesis state reseeded despite the flushed state")
+	}
+}
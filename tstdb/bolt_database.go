@@ -0,0 +1,313 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package tstdb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/utchain/go-utchain/common"
+	"github.com/utchain/go-utchain/log"
+)
+
+// boltIteratorChunk is the number of entries a bolt iterator loads in a single
+// read transaction. Long lived read transactions would block the database from
+// growing its memory map, so iteration is done in short chunks.
+const boltIteratorChunk = 1024
+
+var (
+	// boltBucket is the name of the bucket all the data is stored in.
+	boltBucket = []byte("tstdb")
+
+	// boltKeyMarker is prepended to all keys, as BoltDB rejects empty keys which
+	// are valid in all other backends.
+	boltKeyMarker = []byte{0x00}
+
+	// errBoltNotFound is returned if a key is requested that is not found in
+	// the bolt database.
+	errBoltNotFound = errors.New("not found")
+)
+
+// boltKey converts a database key into the key stored in BoltDB.
+func boltKey(key []byte) []byte {
+	return append(append(make([]byte, 0, len(key)+1), boltKeyMarker...), key...)
+}
+
+// BoltDatabase is a key-value store backed by a single BoltDB file. Contrary
+// to LevelDB, BoltDB is a copy-on-write B+tree which needs no compactions,
+// trading write throughput for less write amplification.
+type BoltDatabase struct {
+	fn string   // directory name for reporting
+	db *bolt.DB // BoltDB instance
+
+	log log.Logger // Contextual logger tracking the database path
+}
+
+// NewBoltDatabase returns a BoltDB wrapped object, storing its data file within
+// the given directory.
+func NewBoltDatabase(file string) (*BoltDatabase, error) {
+	logger := log.New("database", file)
+
+	if err := os.MkdirAll(file, 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filepath.Join(file, "bolt.db"), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	logger.Info("Opened bolt database")
+
+	return &BoltDatabase{
+		fn:  file,
+		db:  db,
+		log: logger,
+	}, nil
+}
+
+// Path returns the path to the database directory.
+func (db *BoltDatabase) Path() string {
+	return db.fn
+}
+
+// Put inserts the given value into the database.
+func (db *BoltDatabase) Put(key []byte, value []byte) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(boltKey(key), value)
+	})
+}
+
+// Has retrieves if a key is present in the database.
+func (db *BoltDatabase) Has(key []byte) (bool, error) {
+	var ok bool
+	err := db.db.View(func(tx *bolt.Tx) error {
+		ok = tx.Bucket(boltBucket).Get(boltKey(key)) != nil
+		return nil
+	})
+	return ok, err
+}
+
+// Get returns the given key if it's present.
+func (db *BoltDatabase) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := db.db.View(func(tx *bolt.Tx) error {
+		// Values are only valid during the transaction, copy them out
+		blob := tx.Bucket(boltBucket).Get(boltKey(key))
+		if blob == nil {
+			return errBoltNotFound
+		}
+		value = common.CopyBytes(blob)
+		return nil
+	})
+	return value, err
+}
+
+// Delete removes the key from the database.
+func (db *BoltDatabase) Delete(key []byte) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete(boltKey(key))
+	})
+}
+
+// NewIterator returns an iterator over the keys starting with the given prefix,
+// beginning at the given start key (relative to the prefix).
+func (db *BoltDatabase) NewIterator(prefix []byte, start []byte) Iterator {
+	return &boltIterator{
+		db:     db.db,
+		prefix: boltKey(prefix),
+		next:   boltKey(append(common.CopyBytes(prefix), start...)),
+		pos:    -1,
+	}
+}
+
+// DeleteRange removes all the keys in the range [start, end), or everything from
+// start onwards if end is nil.
+func (db *BoltDatabase) DeleteRange(start, end []byte) error {
+	var limit []byte
+	if end != nil {
+		limit = boltKey(end)
+	}
+	// Deleting through a cursor skips entries, so collect the keys in chunks and
+	// delete them afterwards, each chunk in its own transaction
+	for done := false; !done; {
+		err := db.db.Update(func(tx *bolt.Tx) error {
+			var (
+				bucket = tx.Bucket(boltBucket)
+				keys   [][]byte
+			)
+			c := bucket.Cursor()
+			for k, _ := c.Seek(boltKey(start)); k != nil && (limit == nil || bytes.Compare(k, limit) < 0); k, _ = c.Next() {
+				if len(keys) == boltIteratorChunk {
+					break
+				}
+				keys = append(keys, common.CopyBytes(k))
+			}
+			done = len(keys) < boltIteratorChunk
+			for _, key := range keys {
+				if err := bucket.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes the database file.
+func (db *BoltDatabase) Close() {
+	if err := db.db.Close(); err != nil {
+		db.log.Error("Failed to close database", "err", err)
+		return
+	}
+	db.log.Info("Database closed")
+}
+
+// NewBatch creates a write-only batch which commits all its writes in a single
+// BoltDB transaction.
+func (db *BoltDatabase) NewBatch() Batch {
+	return &boltBatch{db: db.db}
+}
+
+// boltIterator walks a key range of a bolt database, loading the entries in
+// chunks of short lived read transactions.
+type boltIterator struct {
+	db     *bolt.DB
+	prefix []byte // Prefix the iterated keys are restricted to
+	next   []byte // Key to continue the iteration from, nil if exhausted
+
+	entries memEntries // Currently loaded chunk of entries
+	pos     int        // Position of the iterator within the chunk
+	err     error      // Any error encountered while loading a chunk
+}
+
+// Next moves the iterator to the next key/value pair, returning whether the
+// iterator is exhausted.
+func (it *boltIterator) Next() bool {
+	if it.pos+1 < len(it.entries) {
+		it.pos++
+		return true
+	}
+	if it.next == nil || it.err != nil {
+		it.entries, it.pos = nil, -1
+		return false
+	}
+	it.entries, it.pos = it.entries[:0], 0
+	it.err = it.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		for k, v := c.Seek(it.next); k != nil; k, v = c.Next() {
+			if !bytes.HasPrefix(k, it.prefix) {
+				it.next = nil
+				return nil
+			}
+			if len(it.entries) == boltIteratorChunk {
+				it.next = common.CopyBytes(k)
+				return nil
+			}
+			it.entries = append(it.entries, kv{common.CopyBytes(k[len(boltKeyMarker):]), common.CopyBytes(v), false})
+		}
+		it.next = nil
+		return nil
+	})
+	return it.err == nil && len(it.entries) > 0
+}
+
+// Key returns the key of the current key/value pair, or nil if done.
+func (it *boltIterator) Key() []byte {
+	if it.pos < 0 || it.pos >= len(it.entries) {
+		return nil
+	}
+	return it.entries[it.pos].k
+}
+
+// Value returns the value of the current key/value pair, or nil if done.
+func (it *boltIterator) Value() []byte {
+	if it.pos < 0 || it.pos >= len(it.entries) {
+		return nil
+	}
+	return it.entries[it.pos].v
+}
+
+// Error returns any accumulated error.
+func (it *boltIterator) Error() error {
+	return it.err
+}
+
+// Release releases the loaded entries.
+func (it *boltIterator) Release() {
+	it.entries, it.next, it.pos = nil, nil, -1
+}
+
+// boltBatch is a write-only batch that commits its changes into a bolt database
+// in a single transaction when Write is called.
+type boltBatch struct {
+	db     *bolt.DB
+	writes []kv
+	size   int
+}
+
+func (b *boltBatch) Put(key, value []byte) error {
+	b.writes = append(b.writes, kv{boltKey(key), common.CopyBytes(value), false})
+	b.size += len(value)
+	return nil
+}
+
+func (b *boltBatch) Delete(key []byte) error {
+	b.writes = append(b.writes, kv{boltKey(key), nil, true})
+	b.size += 1
+	return nil
+}
+
+func (b *boltBatch) Write() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		for _, kv := range b.writes {
+			if kv.del {
+				if err := bucket.Delete(kv.k); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := bucket.Put(kv.k, kv.v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltBatch) ValueSize() int {
+	return b.size
+}
+
+func (b *boltBatch) Reset() {
+	b.writes = b.writes[:0]
+	b.size = 0
+}
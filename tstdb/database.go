@@ -17,6 +17,9 @@
 package tstdb
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,16 +30,22 @@ import (
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/filter"
-	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 var OpenFileLimit = 64
 
+// FreezerDirKey tracks the directory of the ancient store attached to a database,
+// so it's reattached on every subsequent open, even if not requested explicitly.
+var FreezerDirKey = []byte("AncientDirectory")
+
 type LDBDatabase struct {
 	fn string      // filename for reporting
 	db *leveldb.DB // LevelDB instance
 
+	freezer *Freezer // Ancient store for immutable chain data, nil if not attached
+
 	getTimer       metrics.Timer // Timer for measuring the database get request counts and latencies
 	putTimer       metrics.Timer // Timer for measuring the database put request counts and latencies
 	delTimer       metrics.Timer // Timer for measuring the database delete request counts and latencies
@@ -87,6 +96,47 @@ func NewLDBDatabase(file string, cache int, handles int) (*LDBDatabase, error) {
 	}, nil
 }
 
+// NewLDBDatabaseWithFreezer returns a LevelDB wrapped object with an append-only
+// ancient store attached, which holds the immutable chain data moved out of the
+// key-value store. The directory of the ancient store is recorded in the database.
+func NewLDBDatabaseWithFreezer(file string, cache int, handles int, freezer string) (*LDBDatabase, error) {
+	if abs, err := filepath.Abs(freezer); err == nil {
+		freezer = abs
+	}
+	db, err := NewLDBDatabase(file, cache, handles)
+	if err != nil {
+		return nil, err
+	}
+	frdb, err := NewFreezer(freezer)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	db.freezer = frdb
+
+	// Refuse to continue on an empty ancient store if the chain was frozen elsewhere
+	if prev, _ := db.Get(FreezerDirKey); len(prev) > 0 && string(prev) != freezer {
+		if frozen, _ := frdb.Ancients(); frozen == 0 {
+			if _, err := os.Stat(string(prev)); err == nil {
+				db.Close()
+				return nil, fmt.Errorf("database was frozen into ancient store %s, not %s", prev, freezer)
+			}
+		}
+	}
+	if err := db.Put(FreezerDirKey, []byte(freezer)); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// AttachedFreezer returns the directory of the ancient store attached to the
+// database the last time it was opened with one, or an empty string if none.
+func AttachedFreezer(db Database) string {
+	dir, _ := db.Get(FreezerDirKey)
+	return string(dir)
+}
+
 // Path returns the path to the database directory.
 func (db *LDBDatabase) Path() string {
 	return db.fn
@@ -143,8 +193,34 @@ func (db *LDBDatabase) Delete(key []byte) error {
 	return db.db.Delete(key, nil)
 }
 
-func (db *LDBDatabase) NewIterator() iterator.Iterator {
-	return db.db.NewIterator(nil, nil)
+// NewIterator returns an iterator over the keys starting with the given prefix,
+// beginning at the given start key (relative to the prefix).
+func (db *LDBDatabase) NewIterator(prefix []byte, start []byte) Iterator {
+	r := util.BytesPrefix(prefix)
+	r.Start = append(r.Start, start...)
+	return db.db.NewIterator(r, nil)
+}
+
+// DeleteRange removes all the keys in the range [start, end), or everything from
+// start onwards if end is nil.
+func (db *LDBDatabase) DeleteRange(start, end []byte) error {
+	it := db.db.NewIterator(&util.Range{Start: start, Limit: end}, nil)
+	defer it.Release()
+
+	batch := new(leveldb.Batch)
+	for it.Next() {
+		batch.Delete(it.Key())
+		if len(batch.Dump()) >= IdealBatchSize {
+			if err := db.db.Write(batch, nil); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return db.db.Write(batch, nil)
 }
 
 func (db *LDBDatabase) Close() {
@@ -159,6 +235,11 @@ func (db *LDBDatabase) Close() {
 			db.log.Error("Metrics collection failed", "err", err)
 		}
 	}
+	if db.freezer != nil {
+		if err := db.freezer.Close(); err != nil {
+			db.log.Error("Failed to close ancient database", "err", err)
+		}
+	}
 	err := db.db.Close()
 	if err == nil {
 		db.log.Info("Database closed")
@@ -171,6 +252,63 @@ func (db *LDBDatabase) LDB() *leveldb.DB {
 	return db.db
 }
 
+// HasAncient returns an indicator whether the specified data exists in the
+// attached ancient store.
+func (db *LDBDatabase) HasAncient(kind string, number uint64) (bool, error) {
+	if db.freezer == nil {
+		return false, errNoFreezer
+	}
+	return db.freezer.HasAncient(kind, number)
+}
+
+// Ancient retrieves an ancient binary blob from the attached ancient store.
+func (db *LDBDatabase) Ancient(kind string, number uint64) ([]byte, error) {
+	if db.freezer == nil {
+		return nil, errNoFreezer
+	}
+	return db.freezer.Ancient(kind, number)
+}
+
+// Ancients returns the number of blocks frozen in the attached ancient store.
+func (db *LDBDatabase) Ancients() (uint64, error) {
+	if db.freezer == nil {
+		return 0, errNoFreezer
+	}
+	return db.freezer.Ancients()
+}
+
+// AncientSize returns the on-disk size of the given kind of ancient data.
+func (db *LDBDatabase) AncientSize(kind string) (uint64, error) {
+	if db.freezer == nil {
+		return 0, errNoFreezer
+	}
+	return db.freezer.AncientSize(kind)
+}
+
+// AppendAncient injects the data of a block into the attached ancient store.
+func (db *LDBDatabase) AppendAncient(number uint64, hash, header, body, receipts, td []byte) error {
+	if db.freezer == nil {
+		return errNoFreezer
+	}
+	return db.freezer.AppendAncient(number, hash, header, body, receipts, td)
+}
+
+// TruncateAncients discards all ancient data above the given threshold.
+func (db *LDBDatabase) TruncateAncients(items uint64) error {
+	if db.freezer == nil {
+		return errNoFreezer
+	}
+	return db.freezer.TruncateAncients(items)
+}
+
+// Sync flushes the attached ancient store to disk.
+func (db *LDBDatabase) Sync() error {
+	if db.freezer == nil {
+		return errNoFreezer
+	}
+	return db.freezer.Sync()
+}
+
 // Meter configures the database metrics collectors and
 func (db *LDBDatabase) Meter(prefix string) {
 	// Short circuit metering if the metrics system is disabled
@@ -289,6 +427,12 @@ func (b *ldbBatch) Put(key, value []byte) error {
 	return nil
 }
 
+func (b *ldbBatch) Delete(key []byte) error {
+	b.b.Delete(key)
+	b.size += 1
+	return nil
+}
+
 func (b *ldbBatch) Write() error {
 	return b.db.Write(b.b, nil)
 }
@@ -336,6 +480,39 @@ func (dt *table) Close() {
 	// Do nothing; don't close the underlying DB.
 }
 
+// NewIterator returns an iterator over the table's keys starting with the given
+// prefix, beginning at the given start key. The table prefix is stripped from
+// the iterated keys.
+func (dt *table) NewIterator(prefix []byte, start []byte) Iterator {
+	return &tableIterator{
+		it:     dt.db.NewIterator(append([]byte(dt.prefix), prefix...), start),
+		prefix: dt.prefix,
+	}
+}
+
+// DeleteRange removes all the table's keys in the range [start, end), or every
+// key from start onwards if end is nil.
+func (dt *table) DeleteRange(start, end []byte) error {
+	if end == nil {
+		// Bound the range to the end of the table
+		return dt.db.DeleteRange(append([]byte(dt.prefix), start...), util.BytesPrefix([]byte(dt.prefix)).Limit)
+	}
+	return dt.db.DeleteRange(append([]byte(dt.prefix), start...), append([]byte(dt.prefix), end...))
+}
+
+// tableIterator wraps an iterator of the underlying database, stripping the
+// table prefix from the keys.
+type tableIterator struct {
+	it     Iterator
+	prefix string
+}
+
+func (it *tableIterator) Next() bool    { return it.it.Next() }
+func (it *tableIterator) Key() []byte   { return it.it.Key()[len(it.prefix):] }
+func (it *tableIterator) Value() []byte { return it.it.Value() }
+func (it *tableIterator) Error() error  { return it.it.Error() }
+func (it *tableIterator) Release()      { it.it.Release() }
+
 type tableBatch struct {
 	batch  Batch
 	prefix string
@@ -354,6 +531,10 @@ func (tb *tableBatch) Put(key, value []byte) error {
 	return tb.batch.Put(append([]byte(tb.prefix), key...), value)
 }
 
+func (tb *tableBatch) Delete(key []byte) error {
+	return tb.batch.Delete(append([]byte(tb.prefix), key...))
+}
+
 func (tb *tableBatch) Write() error {
 	return tb.batch.Write()
 }
@@ -20,7 +20,9 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"testing"
@@ -44,6 +46,22 @@ func newTestLDB() (*tstdb.LDBDatabase, func()) {
 	}
 }
 
+func newTestBoltDB() (*tstdb.BoltDatabase, func()) {
+	dirname, err := ioutil.TempDir(os.TempDir(), "tstdb_test_")
+	if err != nil {
+		panic("failed to create test file: " + err.Error())
+	}
+	db, err := tstdb.NewBoltDatabase(dirname)
+	if err != nil {
+		panic("failed to create test database: " + err.Error())
+	}
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dirname)
+	}
+}
+
 var test_values = []string{"", "a", "1251", "\x00123\x00"}
 
 func TestLDB_PutGet(t *testing.T) {
@@ -57,6 +75,12 @@ func TestMemoryDB_PutGet(t *testing.T) {
 	testPutGet(db, t)
 }
 
+func TestBoltDB_PutGet(t *testing.T) {
+	db, remove := newTestBoltDB()
+	defer remove()
+	testPutGet(db, t)
+}
+
 func testPutGet(db tstdb.Database, t *testing.T) {
 	t.Parallel()
 
@@ -135,6 +159,12 @@ func TestMemoryDB_ParallelPutGet(t *testing.T) {
 	testParallelPutGet(db, t)
 }
 
+func TestBoltDB_ParallelPutGet(t *testing.T) {
+	db, remove := newTestBoltDB()
+	defer remove()
+	testParallelPutGet(db, t)
+}
+
 func testParallelPutGet(db tstdb.Database, t *testing.T) {
 	const n = 8
 	var pending sync.WaitGroup
@@ -190,3 +220,233 @@ func testParallelPutGet(db tstdb.Database, t *testing.T) {
 	}
 	pending.Wait()
 }
+
+func TestLDB_Iterator(t *testing.T) {
+	db, remove := newTestLDB()
+	defer remove()
+	testIterator(db, t)
+}
+
+func TestMemoryDB_Iterator(t *testing.T) {
+	db, _ := tstdb.NewMemDatabase()
+	testIterator(db, t)
+}
+
+func TestBoltDB_Iterator(t *testing.T) {
+	db, remove := newTestBoltDB()
+	defer remove()
+	testIterator(db, t)
+}
+
+func TestTable_Iterator(t *testing.T) {
+	db, _ := tstdb.NewMemDatabase()
+	db.Put([]byte("other-key"), []byte("value"))
+	testIterator(tstdb.NewTable(db, "table-"), t)
+}
+
+func testIterator(db tstdb.Database, t *testing.T) {
+	// Insert enough keys to cross any internal chunking of the iterators
+	keys := make(map[string]bool)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key-%05d", i)
+		if i%2 == 0 {
+			key = fmt.Sprintf("alt-%05d", i)
+		}
+		if err := db.Put([]byte(key), []byte("v"+key)); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+		keys[key] = true
+	}
+	tests := []struct {
+		prefix string
+		start  string
+		count  int
+	}{
+		{"", "", 3000},
+		{"key-", "", 1500},
+		{"alt-", "", 1500},
+		{"key-0000", "", 5},
+		{"missing", "", 0},
+		{"key-", "02001", 500},
+		{"key-", "02000", 500},
+		{"", "key-02999", 1},
+		{"alt-", "zzz", 0},
+	}
+	for _, tt := range tests {
+		it := db.NewIterator([]byte(tt.prefix), []byte(tt.start))
+
+		var prev []byte
+		count := 0
+		for it.Next() {
+			key := it.Key()
+			if !keys[string(key)] {
+				t.Fatalf("prefix %q: unexpected key %q", tt.prefix, key)
+			}
+			if !bytes.HasPrefix(key, []byte(tt.prefix)) || bytes.Compare(key, []byte(tt.prefix+tt.start)) < 0 {
+				t.Fatalf("prefix %q: key %q outside of range", tt.prefix, key)
+			}
+			if prev != nil && bytes.Compare(prev, key) >= 0 {
+				t.Fatalf("prefix %q: keys out of order: %q after %q", tt.prefix, key, prev)
+			}
+			if !bytes.Equal(it.Value(), append([]byte("v"), key...)) {
+				t.Fatalf("prefix %q: value mismatch for %q: %q", tt.prefix, key, it.Value())
+			}
+			prev = append(prev[:0], key...)
+			count++
+		}
+		if err := it.Error(); err != nil {
+			t.Fatalf("prefix %q: iteration failed: %v", tt.prefix, err)
+		}
+		it.Release()
+
+		if count != tt.count {
+			t.Errorf("prefix %q: key count mismatch: have %d, want %d", tt.prefix, count, tt.count)
+		}
+	}
+}
+
+func TestLDB_DeleteRange(t *testing.T) {
+	db, remove := newTestLDB()
+	defer remove()
+	testDeleteRange(db, t)
+}
+
+func TestMemoryDB_DeleteRange(t *testing.T) {
+	db, _ := tstdb.NewMemDatabase()
+	testDeleteRange(db, t)
+}
+
+func TestBoltDB_DeleteRange(t *testing.T) {
+	db, remove := newTestBoltDB()
+	defer remove()
+	testDeleteRange(db, t)
+}
+
+func TestTable_DeleteRange(t *testing.T) {
+	db, _ := tstdb.NewMemDatabase()
+	db.Put([]byte("other-key"), []byte("value"))
+	testDeleteRange(tstdb.NewTable(db, "table-"), t)
+
+	if ok, _ := db.Has([]byte("other-key")); !ok {
+		t.Fatalf("key outside of the table deleted")
+	}
+}
+
+func testDeleteRange(db tstdb.Database, t *testing.T) {
+	for i := 0; i < 3000; i++ {
+		if err := db.Put([]byte(fmt.Sprintf("key-%05d", i)), []byte("v")); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+	}
+	count := func() int {
+		it := db.NewIterator(nil, nil)
+		defer it.Release()
+
+		n := 0
+		for it.Next() {
+			n++
+		}
+		return n
+	}
+	if err := db.DeleteRange([]byte("key-00100"), []byte("key-02100")); err != nil {
+		t.Fatalf("range delete failed: %v", err)
+	}
+	if n := count(); n != 1000 {
+		t.Fatalf("key count mismatch after range delete: have %d, want %d", n, 1000)
+	}
+	for _, key := range []string{"key-00099", "key-02100"} {
+		if ok, _ := db.Has([]byte(key)); !ok {
+			t.Fatalf("boundary key %q deleted", key)
+		}
+	}
+	if err := db.DeleteRange([]byte("key-02500"), nil); err != nil {
+		t.Fatalf("open range delete failed: %v", err)
+	}
+	if n := count(); n != 500 {
+		t.Fatalf("key count mismatch after open range delete: have %d, want %d", n, 500)
+	}
+}
+
+func BenchmarkLDB_BatchWrite(b *testing.B) {
+	db, remove := newTestLDB()
+	defer remove()
+	benchmarkBatchWrite(b, db, db.Path())
+}
+
+func BenchmarkBoltDB_BatchWrite(b *testing.B) {
+	db, remove := newTestBoltDB()
+	defer remove()
+	benchmarkBatchWrite(b, db, db.Path())
+}
+
+// benchmarkBatchWrite measures writing random hash keyed values in batches,
+// reporting the disk space used compared to the amount of data written.
+func benchmarkBatchWrite(b *testing.B, db tstdb.Database, dir string) {
+	var (
+		key   = make([]byte, 32)
+		value = make([]byte, 100)
+		batch = db.NewBatch()
+	)
+	b.SetBytes(int64(len(key) + len(value)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rand.Read(key)
+		batch.Put(key, value)
+		if batch.ValueSize() >= tstdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				b.Fatalf("batch write failed: %v", err)
+			}
+			batch.Reset()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		b.Fatalf("batch write failed: %v", err)
+	}
+	b.StopTimer()
+
+	b.Logf("%d bytes written, %d bytes on disk", b.N*(len(key)+len(value)), dirSize(dir))
+}
+
+func BenchmarkLDB_Get(b *testing.B) {
+	db, remove := newTestLDB()
+	defer remove()
+	benchmarkGet(b, db)
+}
+
+func BenchmarkBoltDB_Get(b *testing.B) {
+	db, remove := newTestBoltDB()
+	defer remove()
+	benchmarkGet(b, db)
+}
+
+// benchmarkGet measures random reads of hash keyed values.
+func benchmarkGet(b *testing.B, db tstdb.Database) {
+	keys := make([][]byte, 10000)
+	batch := db.NewBatch()
+	for i := range keys {
+		keys[i] = make([]byte, 32)
+		rand.Read(keys[i])
+		batch.Put(keys[i], make([]byte, 100))
+	}
+	if err := batch.Write(); err != nil {
+		b.Fatalf("batch write failed: %v", err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.Get(keys[i%len(keys)]); err != nil {
+			b.Fatalf("get failed: %v", err)
+		}
+	}
+}
+
+// dirSize returns the total size of the files within a directory.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,456 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package tstdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+	"github.com/utchain/go-utchain/log"
+)
+
+var (
+	// errClosed is returned if an operation attempts to read from or write to
+	// the freezer table after it has already been closed.
+	errClosed = errors.New("closed")
+
+	// errOutOfBounds is returned if the item requested is not contained within
+	// the freezer table.
+	errOutOfBounds = errors.New("out of bounds")
+
+	// errOutOrderInsertion is returned if the user attempts to inject out-of-order
+	// items into the freezer.
+	errOutOrderInsertion = errors.New("the append operation is out-order")
+)
+
+// indexEntrySize is the size of a single entry in a freezer table's index file.
+const indexEntrySize = 6
+
+// indexEntry contains the location of the end of an item in the data files:
+// the number of the data file and the offset right after the item within it.
+type indexEntry struct {
+	filenum uint16 // data file the item is stored in
+	offset  uint32 // offset within the data file where the item ends
+}
+
+// unmarshal parses an index entry from its binary representation.
+func (e *indexEntry) unmarshal(b []byte) {
+	e.filenum = binary.BigEndian.Uint16(b[:2])
+	e.offset = binary.BigEndian.Uint32(b[2:6])
+}
+
+// marshal serializes an index entry into its binary representation.
+func (e *indexEntry) marshal() []byte {
+	b := make([]byte, indexEntrySize)
+	binary.BigEndian.PutUint16(b[:2], e.filenum)
+	binary.BigEndian.PutUint32(b[2:6], e.offset)
+	return b
+}
+
+// freezerTable is an append-only flat file store of a single kind of chain data
+// (e.g. block bodies). Items are addressed by their position in the table, which
+// for chain data is the block number.
+//
+// The table consists of an index file and a sequence of data files. The index
+// starts with a zero entry followed by one entry for every item, denoting the
+// data file and offset where the item ends. An item thus spans from the offset
+// in the previous index entry (or the start of the file, if the data file was
+// switched) up to the offset in its own entry.
+type freezerTable struct {
+	items uint64 // Number of items stored in the table (atomic access)
+
+	noCompression bool   // If true, items are stored raw instead of snappy compressed
+	maxFileSize   uint32 // Max file size for data files
+	name          string // Name of the table, used for the file names
+	path          string // Folder containing the table files
+
+	head   *os.File            // File descriptor for the data head of the table
+	files  map[uint16]*os.File // All opened data files, indexed by file number
+	headId uint16              // Number of the currently active head file
+	index  *os.File            // File descriptor for the index file of the table
+
+	headBytes uint32 // Number of bytes written to the head file
+
+	logger log.Logger   // Logger with the table name embedded
+	lock   sync.RWMutex // Mutex protecting the data file descriptors
+}
+
+// newTable opens a freezer table, creating the data and index files if they
+// are non-existent. Both files are repaired to a consistent state if a previous
+// crash left them torn.
+func newTable(path string, name string, noCompression bool, maxFileSize uint32) (*freezerTable, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	idxName := fmt.Sprintf("%s.ridx", name)
+	if !noCompression {
+		idxName = fmt.Sprintf("%s.cidx", name)
+	}
+	index, err := os.OpenFile(filepath.Join(path, idxName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	tab := &freezerTable{
+		noCompression: noCompression,
+		maxFileSize:   maxFileSize,
+		name:          name,
+		path:          path,
+		files:         make(map[uint16]*os.File),
+		index:         index,
+		logger:        log.New("table", name),
+	}
+	if err := tab.repair(); err != nil {
+		tab.Close()
+		return nil, err
+	}
+	return tab, nil
+}
+
+// repair cross checks the index and the head data file, truncating them to be
+// in sync with each other after a potential crash or partial write.
+func (t *freezerTable) repair() error {
+	stat, err := t.index.Stat()
+	if err != nil {
+		return err
+	}
+	// Create the zero entry for a fresh index, and drop any torn entry at the end
+	if stat.Size() == 0 {
+		if _, err := t.index.Write((&indexEntry{}).marshal()); err != nil {
+			return err
+		}
+		stat, err = t.index.Stat()
+		if err != nil {
+			return err
+		}
+	}
+	offsetsSize := stat.Size()
+	if overflow := offsetsSize % indexEntrySize; overflow != 0 {
+		offsetsSize -= overflow
+		if err := t.index.Truncate(offsetsSize); err != nil {
+			return err
+		}
+	}
+	// Open the head file and truncate whichever of it and the index is ahead
+	lastIndex, err := t.readIndex(offsetsSize - indexEntrySize)
+	if err != nil {
+		return err
+	}
+	if t.head, err = t.openFile(lastIndex.filenum, true); err != nil {
+		return err
+	}
+	t.headId = lastIndex.filenum
+
+	for {
+		stat, err := t.head.Stat()
+		if err != nil {
+			return err
+		}
+		contentSize := stat.Size()
+		if contentSize == int64(lastIndex.offset) {
+			break
+		}
+		if contentSize > int64(lastIndex.offset) {
+			t.logger.Warn("Truncating dangling head", "indexed", lastIndex.offset, "stored", contentSize)
+			if err := t.head.Truncate(int64(lastIndex.offset)); err != nil {
+				return err
+			}
+			break
+		}
+		// The index points past the data, drop the index entry and retry
+		t.logger.Warn("Truncating dangling indexes", "indexed", lastIndex.offset, "stored", contentSize)
+		offsetsSize -= indexEntrySize
+		if err := t.index.Truncate(offsetsSize); err != nil {
+			return err
+		}
+		prev, err := t.readIndex(offsetsSize - indexEntrySize)
+		if err != nil {
+			return err
+		}
+		if prev.filenum != lastIndex.filenum {
+			if err := t.releaseFilesAfter(prev.filenum, true); err != nil {
+				return err
+			}
+			if t.head, err = t.openFile(prev.filenum, true); err != nil {
+				return err
+			}
+			t.headId = prev.filenum
+		}
+		lastIndex = prev
+	}
+	t.headBytes = lastIndex.offset
+	atomic.StoreUint64(&t.items, uint64(offsetsSize/indexEntrySize-1))
+
+	// Open all the older data files for reading
+	for i := uint16(0); i < t.headId; i++ {
+		if _, err := t.openFile(i, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readIndex reads the index entry stored at the given byte offset.
+func (t *freezerTable) readIndex(offset int64) (indexEntry, error) {
+	var (
+		entry indexEntry
+		buf   = make([]byte, indexEntrySize)
+	)
+	if _, err := t.index.ReadAt(buf, offset); err != nil {
+		return entry, err
+	}
+	entry.unmarshal(buf)
+	return entry, nil
+}
+
+// fileName returns the name of the data file with the given number.
+func (t *freezerTable) fileName(num uint16) string {
+	if t.noCompression {
+		return filepath.Join(t.path, fmt.Sprintf("%s.%04d.rdat", t.name, num))
+	}
+	return filepath.Join(t.path, fmt.Sprintf("%s.%04d.cdat", t.name, num))
+}
+
+// openFile opens the data file with the given number, either in append mode if
+// it is to become the head, or read only otherwise.
+func (t *freezerTable) openFile(num uint16, head bool) (*os.File, error) {
+	if f, ok := t.files[num]; ok {
+		if !head {
+			return f, nil
+		}
+		// Reopen the file in append mode, it was opened read only
+		f.Close()
+		delete(t.files, num)
+	}
+	var (
+		f   *os.File
+		err error
+	)
+	if head {
+		f, err = os.OpenFile(t.fileName(num), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	} else {
+		f, err = os.Open(t.fileName(num))
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.files[num] = f
+	return f, nil
+}
+
+// releaseFilesAfter closes all data files with a number higher than num, also
+// deleting them from disk if requested.
+func (t *freezerTable) releaseFilesAfter(num uint16, remove bool) error {
+	for fnum, f := range t.files {
+		if fnum <= num {
+			continue
+		}
+		delete(t.files, fnum)
+		f.Close()
+		if remove {
+			if err := os.Remove(f.Name()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// size returns the total data size of the table, including the index.
+func (t *freezerTable) size() (uint64, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.index == nil || t.head == nil {
+		return 0, errClosed
+	}
+	stat, err := t.index.Stat()
+	if err != nil {
+		return 0, err
+	}
+	total := uint64(stat.Size())
+	for _, f := range t.files {
+		stat, err := f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		total += uint64(stat.Size())
+	}
+	return total, nil
+}
+
+// Items returns the number of items stored in the table.
+func (t *freezerTable) Items() uint64 {
+	return atomic.LoadUint64(&t.items)
+}
+
+// Append injects a binary blob at the end of the freezer table. The item number
+// is only used as a sanity check, it must be the next position in the table.
+func (t *freezerTable) Append(item uint64, blob []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.index == nil || t.head == nil {
+		return errClosed
+	}
+	if atomic.LoadUint64(&t.items) != item {
+		return errOutOrderInsertion
+	}
+	if !t.noCompression {
+		blob = snappy.Encode(nil, blob)
+	}
+	// Roll over to a new data file if the item doesn't fit into the current one
+	bLen := uint32(len(blob))
+	if t.headBytes > 0 && (t.headBytes+bLen < bLen || t.headBytes+bLen > t.maxFileSize) {
+		nextId := t.headId + 1
+		newHead, err := t.openFile(nextId, true)
+		if err != nil {
+			return err
+		}
+		// A crash after a previous roll-over may have left junk in the new file
+		if err := newHead.Truncate(0); err != nil {
+			return err
+		}
+		t.head, t.headId, t.headBytes = newHead, nextId, 0
+	}
+	if _, err := t.head.Write(blob); err != nil {
+		return err
+	}
+	t.headBytes += bLen
+
+	entry := indexEntry{filenum: t.headId, offset: t.headBytes}
+	if _, err := t.index.Write(entry.marshal()); err != nil {
+		return err
+	}
+	atomic.AddUint64(&t.items, 1)
+	return nil
+}
+
+// Retrieve looks up the data offset of an item with the given number and
+// retrieves the raw binary blob from the data file.
+func (t *freezerTable) Retrieve(item uint64) ([]byte, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.index == nil || t.head == nil {
+		return nil, errClosed
+	}
+	if atomic.LoadUint64(&t.items) <= item {
+		return nil, errOutOfBounds
+	}
+	start, err := t.readIndex(int64(item * indexEntrySize))
+	if err != nil {
+		return nil, err
+	}
+	end, err := t.readIndex(int64((item + 1) * indexEntrySize))
+	if err != nil {
+		return nil, err
+	}
+	// The item starts at the beginning of a new file if the previous one ended elsewhere
+	if start.filenum != end.filenum {
+		start.offset = 0
+	}
+	f, ok := t.files[end.filenum]
+	if !ok {
+		return nil, fmt.Errorf("missing data file %d", end.filenum)
+	}
+	blob := make([]byte, end.offset-start.offset)
+	if _, err := f.ReadAt(blob, int64(start.offset)); err != nil {
+		return nil, err
+	}
+	if t.noCompression {
+		return blob, nil
+	}
+	return snappy.Decode(nil, blob)
+}
+
+// truncate discards any items above the provided limit, dropping any data files
+// which became empty.
+func (t *freezerTable) truncate(items uint64) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if atomic.LoadUint64(&t.items) <= items {
+		return nil
+	}
+	t.logger.Warn("Truncating freezer table", "items", atomic.LoadUint64(&t.items), "limit", items)
+	if err := t.index.Truncate(int64(items+1) * indexEntrySize); err != nil {
+		return err
+	}
+	last, err := t.readIndex(int64(items * indexEntrySize))
+	if err != nil {
+		return err
+	}
+	if last.filenum != t.headId {
+		if err := t.releaseFilesAfter(last.filenum, true); err != nil {
+			return err
+		}
+		if t.head, err = t.openFile(last.filenum, true); err != nil {
+			return err
+		}
+		t.headId = last.filenum
+	}
+	if err := t.head.Truncate(int64(last.offset)); err != nil {
+		return err
+	}
+	t.headBytes = last.offset
+	atomic.StoreUint64(&t.items, items)
+	return nil
+}
+
+// Sync pushes any pending data from memory out to disk.
+func (t *freezerTable) Sync() error {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.index == nil || t.head == nil {
+		return errClosed
+	}
+	if err := t.index.Sync(); err != nil {
+		return err
+	}
+	return t.head.Sync()
+}
+
+// Close closes all opened files.
+func (t *freezerTable) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var errs []error
+	if t.index != nil {
+		if err := t.index.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, f := range t.files {
+		if err := f.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	t.index, t.head, t.files = nil, nil, make(map[uint16]*os.File)
+
+	if len(errs) != 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
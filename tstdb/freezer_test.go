@@ -0,0 +1,225 @@
+// Copyright 2018 The go-utchain Authors
+// This file is part of the go-utchain library.
+//
+// The go-utchain library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-utchain library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-utchain library. If not, see <http://www.gnu.org/licenses/>.
+
+package tstdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// getChunk returns a chunk of data of the given size, filled with the given byte.
+func getChunk(size int, b int) []byte {
+	return bytes.Repeat([]byte{byte(b)}, size)
+}
+
+// Tests that items can be appended to and retrieved from a freezer table, also
+// across data file boundaries and table reopens.
+func TestFreezerTableBasics(t *testing.T) {
+	for _, noCompression := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "freezer")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		// Fill the table with items spanning multiple data files
+		table, err := newTable(dir, "test", noCompression, 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 255; i++ {
+			if err := table.Append(uint64(i), getChunk(15, i)); err != nil {
+				t.Fatalf("failed to append item %d: %v", i, err)
+			}
+		}
+		if err := table.Append(300, getChunk(15, 0)); err != errOutOrderInsertion {
+			t.Fatalf("out of order append error mismatch: have %v, want %v", err, errOutOrderInsertion)
+		}
+		table.Close()
+
+		// Reopen the table and check that all items are still accessible
+		if table, err = newTable(dir, "test", noCompression, 50); err != nil {
+			t.Fatal(err)
+		}
+		defer table.Close()
+
+		if items := table.Items(); items != 255 {
+			t.Fatalf("item count mismatch: have %d, want %d", items, 255)
+		}
+		for i := 0; i < 255; i++ {
+			blob, err := table.Retrieve(uint64(i))
+			if err != nil {
+				t.Fatalf("failed to retrieve item %d: %v", i, err)
+			}
+			if !bytes.Equal(blob, getChunk(15, i)) {
+				t.Fatalf("item %d mismatch: have %x, want %x", i, blob, getChunk(15, i))
+			}
+		}
+		if _, err := table.Retrieve(255); err != errOutOfBounds {
+			t.Fatalf("out of bounds retrieval error mismatch: have %v, want %v", err, errOutOfBounds)
+		}
+	}
+}
+
+// Tests that a freezer table with a torn index or data file is repaired on open.
+func TestFreezerTableRepair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	table, err := newTable(dir, "test", true, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 9; i++ {
+		if err := table.Append(uint64(i), getChunk(15, i)); err != nil {
+			t.Fatalf("failed to append item %d: %v", i, err)
+		}
+	}
+	table.Close()
+
+	// Cut a few bytes off the data head, losing the last item
+	head := filepath.Join(dir, "test.0002.rdat")
+	stat, err := os.Stat(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(head, stat.Size()-4); err != nil {
+		t.Fatal(err)
+	}
+	// Leave a partially written index entry behind too
+	index, err := os.OpenFile(filepath.Join(dir, "test.ridx"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Write([]byte{0, 1, 2})
+	index.Close()
+
+	if table, err = newTable(dir, "test", true, 50); err != nil {
+		t.Fatal(err)
+	}
+	if items := table.Items(); items != 8 {
+		t.Fatalf("item count mismatch: have %d, want %d", items, 8)
+	}
+	for i := 0; i < 8; i++ {
+		if blob, err := table.Retrieve(uint64(i)); err != nil || !bytes.Equal(blob, getChunk(15, i)) {
+			t.Fatalf("item %d mismatch: have %x, want %x, err %v", i, blob, getChunk(15, i), err)
+		}
+	}
+	// Make sure the table is usable after the repair
+	if err := table.Append(8, getChunk(15, 0xff)); err != nil {
+		t.Fatalf("failed to append after repair: %v", err)
+	}
+	if blob, _ := table.Retrieve(8); !bytes.Equal(blob, getChunk(15, 0xff)) {
+		t.Fatalf("item 8 mismatch: have %x, want %x", blob, getChunk(15, 0xff))
+	}
+	table.Close()
+}
+
+// Tests that truncating a freezer table drops the items and data files above
+// the limit, and that new items can be appended afterwards.
+func TestFreezerTableTruncate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	table, err := newTable(dir, "test", false, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	for i := 0; i < 30; i++ {
+		if err := table.Append(uint64(i), getChunk(20, i)); err != nil {
+			t.Fatalf("failed to append item %d: %v", i, err)
+		}
+	}
+	if err := table.truncate(3); err != nil {
+		t.Fatalf("failed to truncate table: %v", err)
+	}
+	if items := table.Items(); items != 3 {
+		t.Fatalf("item count mismatch: have %d, want %d", items, 3)
+	}
+	if _, err := table.Retrieve(3); err != errOutOfBounds {
+		t.Fatalf("truncated item retrievable: %v", err)
+	}
+	for i := 3; i < 10; i++ {
+		if err := table.Append(uint64(i), getChunk(20, 0xff-i)); err != nil {
+			t.Fatalf("failed to append item %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		want := getChunk(20, i)
+		if i >= 3 {
+			want = getChunk(20, 0xff-i)
+		}
+		if blob, err := table.Retrieve(uint64(i)); err != nil || !bytes.Equal(blob, want) {
+			t.Fatalf("item %d mismatch: have %x, want %x, err %v", i, blob, want, err)
+		}
+	}
+}
+
+// Tests that the freezer keeps its tables aligned, rolling back tables which
+// are ahead of the others after an interrupted append.
+func TestFreezerRepair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	freezer, err := NewFreezer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(0); i < 5; i++ {
+		blob := getChunk(32, int(i))
+		if err := freezer.AppendAncient(i, blob, blob, blob, blob, blob); err != nil {
+			t.Fatalf("failed to append block %d: %v", i, err)
+		}
+	}
+	// Simulate a crash midway through appending the next block
+	if err := freezer.tables[FreezerHeaderTable].Append(5, getChunk(32, 5)); err != nil {
+		t.Fatal(err)
+	}
+	freezer.Close()
+
+	if freezer, err = NewFreezer(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer freezer.Close()
+
+	if frozen, _ := freezer.Ancients(); frozen != 5 {
+		t.Fatalf("frozen count mismatch: have %d, want %d", frozen, 5)
+	}
+	if ok, _ := freezer.HasAncient(FreezerHeaderTable, 5); ok {
+		t.Fatalf("interrupted block still present")
+	}
+	if blob, err := freezer.Ancient(FreezerBodiesTable, 4); err != nil || !bytes.Equal(blob, getChunk(32, 4)) {
+		t.Fatalf("block 4 body mismatch: have %x, want %x, err %v", blob, getChunk(32, 4), err)
+	}
+	if _, err := freezer.Ancient("unknown", 0); err != errUnknownTable {
+		t.Fatalf("unknown table error mismatch: have %v, want %v", err, errUnknownTable)
+	}
+}
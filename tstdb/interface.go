@@ -25,12 +25,44 @@ type Putter interface {
 	Put(key []byte, value []byte) error
 }
 
+// Deleter wraps the database delete operation supported by both batches and regular databases.
+type Deleter interface {
+	Delete(key []byte) error
+}
+
+// Iterator iterates over a database's key/value pairs in ascending key order.
+// The key and value slices are only valid until the next call to Next.
+type Iterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Error() error
+	Release()
+}
+
+// Iteratee wraps the iterator creation method supported by all databases.
+type Iteratee interface {
+	// NewIterator returns an iterator over the keys starting with the given
+	// prefix, beginning at the given start key (relative to the prefix) or the
+	// first key after it if it doesn't exist.
+	NewIterator(prefix []byte, start []byte) Iterator
+}
+
+// RangeDeleter wraps the range delete operation supported by all databases.
+type RangeDeleter interface {
+	// DeleteRange removes all the keys in the range [start, end). A nil end
+	// deletes everything from start onwards.
+	DeleteRange(start, end []byte) error
+}
+
 // Database wraps all database operations. All methods are safe for concurrent use.
 type Database interface {
 	Putter
+	Deleter
+	Iteratee
+	RangeDeleter
 	Get(key []byte) ([]byte, error)
 	Has(key []byte) (bool, error)
-	Delete(key []byte) error
 	Close()
 	NewBatch() Batch
 }
@@ -39,8 +71,33 @@ type Database interface {
 // when Write is called. Batch cannot be used concurrently.
 type Batch interface {
 	Putter
+	Deleter
 	ValueSize() int // amount of data in the batch
 	Write() error
 	// Reset resets the batch for reuse
 	Reset()
 }
+
+// AncientReader wraps the read methods of an append-only store of immutable
+// chain data, addressed by the kind of the data and the block number.
+type AncientReader interface {
+	HasAncient(kind string, number uint64) (bool, error)
+	Ancient(kind string, number uint64) ([]byte, error)
+	Ancients() (uint64, error)
+	AncientSize(kind string) (uint64, error)
+}
+
+// AncientWriter wraps the write methods of an append-only store of immutable
+// chain data.
+type AncientWriter interface {
+	AppendAncient(number uint64, hash, header, body, receipts, td []byte) error
+	TruncateAncients(items uint64) error
+	Sync() error
+}
+
+// AncientStore contains all the methods required to read from and write to an
+// ancient chain data store.
+type AncientStore interface {
+	AncientReader
+	AncientWriter
+}
@@ -17,9 +17,13 @@
 package tstdb
 
 import (
+	"bytes"
 	"errors"
+	"sort"
+	"strings"
 	"sync"
 
+	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/utchain/go-utchain/common"
 )
 
@@ -96,7 +100,55 @@ func (db *MemDatabase) NewBatch() Batch {
 
 func (db *MemDatabase) Len() int { return len(db.db) }
 
-type kv struct{ k, v []byte }
+// NewIterator returns an iterator over a snapshot of the database content,
+// restricted to the keys starting with the given prefix, beginning at the given
+// start key (relative to the prefix).
+func (db *MemDatabase) NewIterator(prefix []byte, start []byte) Iterator {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var (
+		entries memEntries
+		first   = string(prefix) + string(start)
+	)
+	for key, value := range db.db {
+		if strings.HasPrefix(key, string(prefix)) && key >= first {
+			entries = append(entries, kv{[]byte(key), common.CopyBytes(value), false})
+		}
+	}
+	sort.Sort(entries)
+	return iterator.NewArrayIterator(entries)
+}
+
+// DeleteRange removes all the keys in the range [start, end), or everything from
+// start onwards if end is nil.
+func (db *MemDatabase) DeleteRange(start, end []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for key := range db.db {
+		if key >= string(start) && (end == nil || key < string(end)) {
+			delete(db.db, key)
+		}
+	}
+	return nil
+}
+
+type kv struct {
+	k, v []byte
+	del  bool
+}
+
+// memEntries is a sorted list of key-value pairs, implementing iterator.Array.
+type memEntries []kv
+
+func (e memEntries) Len() int                        { return len(e) }
+func (e memEntries) Less(i, j int) bool              { return bytes.Compare(e[i].k, e[j].k) < 0 }
+func (e memEntries) Swap(i, j int)                   { e[i], e[j] = e[j], e[i] }
+func (e memEntries) Index(i int) (key, value []byte) { return e[i].k, e[i].v }
+func (e memEntries) Search(key []byte) int {
+	return sort.Search(len(e), func(i int) bool { return bytes.Compare(e[i].k, key) >= 0 })
+}
 
 type memBatch struct {
 	db     *MemDatabase
@@ -105,16 +157,26 @@ type memBatch struct {
 }
 
 func (b *memBatch) Put(key, value []byte) error {
-	b.writes = append(b.writes, kv{common.CopyBytes(key), common.CopyBytes(value)})
+	b.writes = append(b.writes, kv{common.CopyBytes(key), common.CopyBytes(value), false})
 	b.size += len(value)
 	return nil
 }
 
+func (b *memBatch) Delete(key []byte) error {
+	b.writes = append(b.writes, kv{common.CopyBytes(key), nil, true})
+	b.size += 1
+	return nil
+}
+
 func (b *memBatch) Write() error {
 	b.db.lock.Lock()
 	defer b.db.lock.Unlock()
 
 	for _, kv := range b.writes {
+		if kv.del {
+			delete(b.db.db, string(kv.k))
+			continue
+		}
 		b.db.db[string(kv.k)] = kv.v
 	}
 	return nil